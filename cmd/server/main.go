@@ -2,16 +2,14 @@ package main
 
 import (
 	"context"
-	"errors"
-	"net/http"
 	"os"
 	"os/signal"
 	"syscall"
 	"time"
 
 	"github.com/ebinskryfon/fileuploader/config"
-    "github.com/ebinskryfon/fileuploader/server"
-    "github.com/ebinskryfon/fileuploader/utils"
+	"github.com/ebinskryfon/fileuploader/server"
+	"github.com/ebinskryfon/fileuploader/utils"
 )
 
 func main() {
@@ -26,23 +24,37 @@ func main() {
 	}
 
 	// Create and start server
-	srv := server.New(cfg, logger)
-	httpServer := &http.Server{
-		Addr:         ":" + cfg.Server.Port,
-		Handler:      srv.Router(),
-		ReadTimeout:  cfg.Server.ReadTimeout,
-		WriteTimeout: cfg.Server.WriteTimeout,
+	srv, err := server.New(cfg, logger)
+	if err != nil {
+		logger.Error("Failed to initialize server: " + err.Error())
+		os.Exit(1)
+	}
+	httpServer, err := server.NewHTTPServer(cfg, srv.Router(), logger)
+	if err != nil {
+		logger.Error("Failed to configure TLS: " + err.Error())
+		os.Exit(1)
 	}
+	redirectServer := server.NewRedirectServer(cfg)
 
 	// Start server in goroutine
 	go func() {
 		logger.Info("Starting server on port " + cfg.Server.Port)
-		if err := httpServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		if err := server.ListenAndServe(httpServer); err != nil {
 			logger.Error("Server failed to start: " + err.Error())
 			os.Exit(1)
 		}
 	}()
 
+	if redirectServer != nil {
+		go func() {
+			logger.Info("Starting HTTPS redirect on port " + cfg.TLS.RedirectPort)
+			if err := server.ListenAndServe(redirectServer); err != nil {
+				logger.Error("Redirect server failed to start: " + err.Error())
+				os.Exit(1)
+			}
+		}()
+	}
+
 	// Wait for interrupt signal to gracefully shutdown
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
@@ -54,6 +66,10 @@ func main() {
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
+	if redirectServer != nil {
+		redirectServer.Shutdown(ctx)
+	}
+	srv.Shutdown(ctx)
 	if err := httpServer.Shutdown(ctx); err != nil {
 		logger.Error("Server forced to shutdown: " + err.Error())
 		os.Exit(1)
@@ -1,11 +1,56 @@
 package config
 
 import (
+	"encoding/json"
+	"fmt"
 	"os"
+	"path/filepath"
+	"slices"
 	"strconv"
+	"strings"
 	"time"
 )
 
+// QuotaLimit bounds the storage a single user may consume. A zero value for
+// either field means that dimension is unlimited.
+type QuotaLimit struct {
+	MaxBytes int64 `yaml:"max_bytes" json:"max_bytes"`
+	MaxFiles int   `yaml:"max_files" json:"max_files"`
+}
+
+// TenantConfig overrides upload settings for a single tenant. Zero values
+// inherit the global setting.
+type TenantConfig struct {
+	AllowedTypes []string      `yaml:"allowed_types"`
+	MaxFileSize  int64         `yaml:"max_file_size"`
+	Quota        *QuotaLimit   `yaml:"quota"`
+	Retention    time.Duration `yaml:"retention"`
+	// MinRetention and RetentionRules add to the global compliance
+	// settings for this tenant.
+	MinRetention   time.Duration   `yaml:"min_retention"`
+	RetentionRules []RetentionRule `yaml:"retention_rules"`
+	// StripMetadataTypes replaces the global list when set; an empty list
+	// turns stripping off for the tenant.
+	StripMetadataTypes []string `yaml:"strip_metadata_types"`
+}
+
+// RetentionRule makes files of a content type undeletable for Duration after
+// upload. ContentType may end in "/*" to match a whole family.
+type RetentionRule struct {
+	ContentType string        `yaml:"content_type"`
+	Duration    time.Duration `yaml:"duration"`
+}
+
+// CertRule maps a verified client certificate to an identity. Match has the
+// form "<field>:<pattern>" where field is one of cn, dns, uri or email and
+// pattern is a path.Match glob. UserID may reference the matched value as
+// "{value}".
+type CertRule struct {
+	Match  string   `yaml:"match"`
+	UserID string   `yaml:"user_id"`
+	Scopes []string `yaml:"scopes"`
+}
+
 type Config struct {
 	Server struct {
 		Port         string        `yaml:"port"`
@@ -16,15 +61,156 @@ type Config struct {
 		MaxFileSize  int64    `yaml:"max_file_size"`
 		AllowedTypes []string `yaml:"allowed_types"`
 		StoragePath  string   `yaml:"storage_path"`
-		ChunkSize    int64    `yaml:"chunk_size"`
+		// StatePath is where the server keeps its own state, such as API
+		// keys and the audit log, apart from uploaded files.
+		StatePath string `yaml:"state_path"`
+		ChunkSize int64  `yaml:"chunk_size"`
+		// Retention is how long files are kept before they expire; zero
+		// keeps them until deleted.
+		Retention time.Duration `yaml:"retention"`
+		// TrashRetention is how long deleted files stay in the trash before
+		// being purged; zero makes deletes permanent.
+		TrashRetention time.Duration `yaml:"trash_retention"`
+		// ExpiryInterval is how often expired files and trash are purged.
+		ExpiryInterval time.Duration `yaml:"expiry_interval"`
+		// ContentTypeAliases maps alternative content type names to the
+		// canonical name, e.g. "image/jpg" to "image/jpeg". They extend the
+		// built-in aliases.
+		ContentTypeAliases map[string]string `yaml:"content_type_aliases"`
+		// ExtensionPolicy decides what happens when a file's extension
+		// doesn't belong to its content type: "reject" (the default),
+		// "rewrite" to replace the extension, or "allow".
+		ExtensionPolicy string `yaml:"extension_policy"`
+		// TypeExtensions lists the extensions allowed for a content type,
+		// replacing the built-in list for that type.
+		TypeExtensions map[string][]string `yaml:"type_extensions"`
+		// BlockedExtensions are never accepted, whatever the policy. Empty
+		// means the built-in list of executable and script extensions.
+		BlockedExtensions []string `yaml:"blocked_extensions"`
+		// StripMetadataTypes lists the image types, such as image/jpeg and
+		// image/png, whose EXIF, XMP and other metadata is removed on upload.
+		StripMetadataTypes []string `yaml:"strip_metadata_types"`
+		// PDFPolicy decides what happens to PDFs with active content,
+		// embedded files, XFA forms or a broken cross-reference table:
+		// "reject" the upload, "flag" it on the file's metadata (the
+		// default), or "allow" it unremarked.
+		PDFPolicy string `yaml:"pdf_policy"`
+		// PDFFindingPolicies overrides PDFPolicy for kinds of finding, such
+		// as "javascript" or "malformed_xref".
+		PDFFindingPolicies map[string]string `yaml:"pdf_finding_policies"`
 	}
 	Auth struct {
 		JWTSecret       string        `yaml:"jwt_secret"`
 		TokenExpiration time.Duration `yaml:"token_expiration"`
+		// PublicKeyFiles are PEM files holding public keys for RS*/PS*/ES*
+		// tokens. Each key's kid is the file name without its extension.
+		PublicKeyFiles      []string      `yaml:"public_key_files"`
+		JWKSURL             string        `yaml:"jwks_url"`
+		JWKSRefreshInterval time.Duration `yaml:"jwks_refresh_interval"`
+		// Issuers and Audience are only enforced when set. The first issuer
+		// is also stamped on tokens issued by this service.
+		Issuers  []string      `yaml:"issuers"`
+		Audience string        `yaml:"audience"`
+		Leeway   time.Duration `yaml:"leeway"`
+		// DefaultScopes are granted to tokens that carry no scope or
+		// permissions claim at all.
+		DefaultScopes []string `yaml:"default_scopes"`
+		// APIKeyStorePath is the JSON file holding hashed API keys.
+		APIKeyStorePath string `yaml:"api_key_store_path"`
+		// RevocationStorePath is the JSON file persisting revoked tokens.
+		RevocationStorePath string `yaml:"revocation_store_path"`
+		// CertRules map client certificates to identities, first match wins.
+		CertRules []CertRule `yaml:"cert_rules"`
+	}
+	TLS struct {
+		Enabled  bool   `yaml:"enabled"`
+		CertFile string `yaml:"cert_file"`
+		KeyFile  string `yaml:"key_file"`
+		// ClientCAFile enables client certificate verification against
+		// the CA bundle it contains.
+		ClientCAFile string `yaml:"client_ca_file"`
+		// ClientAuth is "optional" (verify certificates when presented) or
+		// "require" (reject connections without one).
+		ClientAuth string `yaml:"client_auth"`
+		// MinVersion is "1.2" or "1.3".
+		MinVersion string `yaml:"min_version"`
+		// CipherPolicy is "modern" (forward-secret AEAD suites only) or
+		// "compatible" (Go's default suites). It only affects TLS 1.2.
+		CipherPolicy string `yaml:"cipher_policy"`
+		HTTP2        bool   `yaml:"http2"`
+		// ReloadInterval is how often the certificate files are checked
+		// for rotation.
+		ReloadInterval time.Duration `yaml:"reload_interval"`
+		// RedirectPort, when set, serves plain HTTP redirects to HTTPS.
+		RedirectPort string `yaml:"redirect_port"`
 	}
 	RateLimit struct {
 		RequestsPerMinute int `yaml:"requests_per_minute"`
 	}
+	Quota struct {
+		Default   QuotaLimit            `yaml:"default"`
+		Overrides map[string]QuotaLimit `yaml:"overrides"`
+	}
+	// Compliance holds files write-once: they cannot be deleted, replaced
+	// or expired until their retention lapses.
+	Compliance struct {
+		// MinRetention applies to every file; RetentionRules add longer
+		// periods by content type. The longest matching period wins.
+		MinRetention   time.Duration   `yaml:"min_retention"`
+		RetentionRules []RetentionRule `yaml:"retention_rules"`
+		// AuditLogPath receives a JSON line for every retention and legal
+		// hold change.
+		AuditLogPath string `yaml:"audit_log_path"`
+	}
+	// Search controls background text extraction for full-text search.
+	Search struct {
+		ExtractionWorkers   int   `yaml:"extraction_workers"`
+		ExtractionQueueSize int   `yaml:"extraction_queue_size"`
+		MaxExtractedText    int64 `yaml:"max_extracted_text"`
+	}
+	// Scan sends uploads to a clamd virus scanner. Scanning is off unless
+	// ClamdAddress is set.
+	Scan struct {
+		// ClamdAddress is "tcp://host:port", "unix:///path/to/clamd.sock",
+		// or a bare host:port or socket path.
+		ClamdAddress string        `yaml:"clamd_address"`
+		Timeout      time.Duration `yaml:"timeout"`
+		// Files up to SyncMaxSize are scanned before the upload returns;
+		// larger ones are scanned in the background by Workers.
+		SyncMaxSize int64 `yaml:"sync_max_size"`
+		Workers     int   `yaml:"workers"`
+		QueueSize   int   `yaml:"queue_size"`
+		// QuarantinePath keeps infected content out of normal storage.
+		QuarantinePath string `yaml:"quarantine_path"`
+	}
+	// Images controls the processing of JPEG and PNG uploads.
+	Images struct {
+		// ThumbnailSizes are the longest-edge sizes, in pixels, of the
+		// thumbnails generated for each image.
+		ThumbnailSizes []int `yaml:"thumbnail_sizes"`
+		// MaxPixels is the largest image, in width times height, that will
+		// be decoded. It guards against decompression bombs.
+		MaxPixels          int64 `yaml:"max_pixels"`
+		ThumbnailWorkers   int   `yaml:"thumbnail_workers"`
+		ThumbnailQueueSize int   `yaml:"thumbnail_queue_size"`
+		// MaxDimension caps the width and height of transformed images.
+		MaxDimension int `yaml:"max_dimension"`
+		// CachePath keeps rendered transforms, evicting the least recently
+		// used once they take more than CacheSize bytes.
+		CachePath string `yaml:"cache_path"`
+		CacheSize int64  `yaml:"cache_size"`
+	}
+	// Archives bounds what ZIP uploads, including Office documents stored
+	// as ZIP packages, may expand to.
+	Archives struct {
+		MaxEntries          int   `yaml:"max_entries"`
+		MaxUncompressedSize int64 `yaml:"max_uncompressed_size"`
+		// MaxCompressionRatio is the largest ratio of uncompressed size to
+		// archive size. Archives expanding to under 1MB are exempt.
+		MaxCompressionRatio int `yaml:"max_compression_ratio"`
+	}
+	// Tenants holds per-tenant overrides keyed by tenant ID.
+	Tenants map[string]TenantConfig `yaml:"tenants"`
 }
 
 func Load() (*Config, error) {
@@ -36,23 +222,188 @@ func Load() (*Config, error) {
 	cfg.Server.WriteTimeout = getDurationEnv("WRITE_TIMEOUT", 30*time.Second)
 
 	cfg.Upload.MaxFileSize = getInt64Env("MAX_FILE_SIZE", 25*1024*1024) // 25MB
-	cfg.Upload.AllowedTypes = []string{"image/jpeg", "image/png", "application/pdf"}
+	cfg.Upload.AllowedTypes = []string{"image/jpeg", "image/png", "application/pdf", "application/zip"}
 	cfg.Upload.StoragePath = getEnv("STORAGE_PATH", "./storage")
+	cfg.Upload.StatePath = getEnv("STATE_PATH", "./state")
 	cfg.Upload.ChunkSize = getInt64Env("CHUNK_SIZE", 1024*1024) // 1MB
+	cfg.Upload.Retention = getDurationEnv("RETENTION", 0)
+	cfg.Upload.TrashRetention = getDurationEnv("TRASH_RETENTION", 30*24*time.Hour)
+	cfg.Upload.ExpiryInterval = getDurationEnv("EXPIRY_INTERVAL", 10*time.Minute)
+	cfg.Upload.ContentTypeAliases = getMapEnv("CONTENT_TYPE_ALIASES")
+	cfg.Upload.ExtensionPolicy = getEnv("EXTENSION_POLICY", "reject")
+	cfg.Upload.TypeExtensions = getTypeExtensionsEnv("TYPE_EXTENSIONS")
+	cfg.Upload.BlockedExtensions = getListEnv("BLOCKED_EXTENSIONS", nil)
+	cfg.Upload.StripMetadataTypes = getListEnv("STRIP_METADATA_TYPES", nil)
+	cfg.Upload.PDFPolicy = getEnv("PDF_POLICY", "flag")
+	cfg.Upload.PDFFindingPolicies = getMapEnv("PDF_FINDING_POLICIES")
 
 	cfg.Auth.JWTSecret = getEnv("JWT_SECRET", "your-secret-key-change-in-production")
 	cfg.Auth.TokenExpiration = getDurationEnv("TOKEN_EXPIRATION", 24*time.Hour)
+	cfg.Auth.PublicKeyFiles = getListEnv("JWT_PUBLIC_KEY_FILES", nil)
+	cfg.Auth.JWKSURL = getEnv("JWKS_URL", "")
+	cfg.Auth.JWKSRefreshInterval = getDurationEnv("JWKS_REFRESH_INTERVAL", 10*time.Minute)
+	cfg.Auth.Issuers = getListEnv("JWT_ISSUERS", nil)
+	cfg.Auth.Audience = getEnv("JWT_AUDIENCE", "")
+	cfg.Auth.Leeway = getDurationEnv("JWT_LEEWAY", 30*time.Second)
+	cfg.Auth.DefaultScopes = getListEnv("JWT_DEFAULT_SCOPES", []string{"files:read", "files:write", "files:delete"})
+	cfg.Auth.APIKeyStorePath = getEnv("API_KEY_STORE_PATH", filepath.Join(cfg.Upload.StatePath, "apikeys.json"))
+	cfg.Auth.RevocationStorePath = getEnv("REVOCATION_STORE_PATH", filepath.Join(cfg.Upload.StatePath, "revocations.json"))
+
+	cfg.Auth.CertRules = getCertRulesEnv("MTLS_RULES")
+
+	cfg.TLS.Enabled = getBoolEnv("TLS_ENABLED", false)
+	cfg.TLS.CertFile = getEnv("TLS_CERT_FILE", "")
+	cfg.TLS.KeyFile = getEnv("TLS_KEY_FILE", "")
+	cfg.TLS.ClientCAFile = getEnv("TLS_CLIENT_CA_FILE", "")
+	cfg.TLS.ClientAuth = getEnv("TLS_CLIENT_AUTH", "optional")
+	cfg.TLS.MinVersion = getEnv("TLS_MIN_VERSION", "1.2")
+	cfg.TLS.CipherPolicy = getEnv("TLS_CIPHER_POLICY", "modern")
+	cfg.TLS.HTTP2 = getBoolEnv("TLS_HTTP2", true)
+	cfg.TLS.ReloadInterval = getDurationEnv("TLS_RELOAD_INTERVAL", time.Minute)
+	cfg.TLS.RedirectPort = getEnv("TLS_REDIRECT_PORT", "")
 
 	cfg.RateLimit.RequestsPerMinute = getIntEnv("RATE_LIMIT", 60)
 
+	cfg.Quota.Default.MaxBytes = getInt64Env("QUOTA_MAX_BYTES", 1024*1024*1024) // 1GB
+	cfg.Quota.Default.MaxFiles = getIntEnv("QUOTA_MAX_FILES", 10000)
+	cfg.Quota.Overrides = getQuotaOverridesEnv("QUOTA_OVERRIDES")
+
+	cfg.Compliance.MinRetention = getDurationEnv("COMPLIANCE_MIN_RETENTION", 0)
+	cfg.Compliance.RetentionRules = getRetentionRulesEnv("COMPLIANCE_RETENTION_RULES")
+	cfg.Compliance.AuditLogPath = getEnv("AUDIT_LOG_PATH", filepath.Join(cfg.Upload.StatePath, "audit.log"))
+
+	cfg.Search.ExtractionWorkers = getIntEnv("EXTRACTION_WORKERS", 2)
+	cfg.Search.ExtractionQueueSize = getIntEnv("EXTRACTION_QUEUE_SIZE", 1000)
+	cfg.Search.MaxExtractedText = getInt64Env("MAX_EXTRACTED_TEXT", 1024*1024) // 1MB
+
+	cfg.Scan.ClamdAddress = getEnv("CLAMD_ADDRESS", "")
+	cfg.Scan.Timeout = getDurationEnv("CLAMD_TIMEOUT", time.Minute)
+	cfg.Scan.SyncMaxSize = getInt64Env("SCAN_SYNC_MAX_SIZE", 10*1024*1024) // 10MB
+	cfg.Scan.Workers = getIntEnv("SCAN_WORKERS", 2)
+	cfg.Scan.QueueSize = getIntEnv("SCAN_QUEUE_SIZE", 1000)
+	cfg.Scan.QuarantinePath = getEnv("QUARANTINE_PATH", filepath.Join(cfg.Upload.StatePath, "quarantine"))
+
+	cfg.Images.ThumbnailSizes = getIntListEnv("THUMBNAIL_SIZES", []int{128, 512})
+	cfg.Images.MaxPixels = getInt64Env("IMAGE_MAX_PIXELS", 40*1000*1000)
+	cfg.Images.ThumbnailWorkers = getIntEnv("THUMBNAIL_WORKERS", 2)
+	cfg.Images.ThumbnailQueueSize = getIntEnv("THUMBNAIL_QUEUE_SIZE", 1000)
+	cfg.Images.MaxDimension = getIntEnv("IMAGE_MAX_DIMENSION", 4096)
+	cfg.Images.CachePath = getEnv("IMAGE_CACHE_PATH", filepath.Join(cfg.Upload.StatePath, "image-cache"))
+	cfg.Images.CacheSize = getInt64Env("IMAGE_CACHE_SIZE", 256*1024*1024) // 256MB
+
+	cfg.Archives.MaxEntries = getIntEnv("ARCHIVE_MAX_ENTRIES", 10000)
+	cfg.Archives.MaxUncompressedSize = getInt64Env("ARCHIVE_MAX_UNCOMPRESSED_SIZE", 1024*1024*1024) // 1GB
+	cfg.Archives.MaxCompressionRatio = getIntEnv("ARCHIVE_MAX_COMPRESSION_RATIO", 100)
+
+	tenants, err := loadTenants(getEnv("TENANTS_CONFIG_FILE", ""))
+	if err != nil {
+		return nil, err
+	}
+	cfg.Tenants = tenants
+
 	// Create storage directory if it doesn't exist
 	if err := os.MkdirAll(cfg.Upload.StoragePath, 0755); err != nil {
 		return nil, err
 	}
+	// State includes credentials, so keep it from other users
+	if err := os.MkdirAll(cfg.Upload.StatePath, 0700); err != nil {
+		return nil, err
+	}
 
 	return cfg, nil
 }
 
+// ForTenant returns a copy of the configuration with the tenant's overrides
+// applied, so tenant-scoped services can be built from it unchanged.
+func (c *Config) ForTenant(tenantID string) *Config {
+	tenant, ok := c.Tenants[tenantID]
+	if !ok {
+		return c
+	}
+
+	effective := *c
+	if len(tenant.AllowedTypes) > 0 {
+		effective.Upload.AllowedTypes = tenant.AllowedTypes
+	}
+	if tenant.MaxFileSize > 0 {
+		effective.Upload.MaxFileSize = tenant.MaxFileSize
+	}
+	if tenant.Quota != nil {
+		effective.Quota.Default = *tenant.Quota
+	}
+	if tenant.Retention > 0 {
+		effective.Upload.Retention = tenant.Retention
+	}
+	if tenant.MinRetention > effective.Compliance.MinRetention {
+		effective.Compliance.MinRetention = tenant.MinRetention
+	}
+	if len(tenant.RetentionRules) > 0 {
+		effective.Compliance.RetentionRules = append(slices.Clone(c.Compliance.RetentionRules), tenant.RetentionRules...)
+	}
+	if tenant.StripMetadataTypes != nil {
+		effective.Upload.StripMetadataTypes = tenant.StripMetadataTypes
+	}
+	return &effective
+}
+
+// loadTenants reads per-tenant overrides from a JSON file of the form
+// {"acme": {"allowed_types": [...], "max_file_size": 1048576,
+// "quota": {"max_bytes": 0, "max_files": 0}, "retention": "720h",
+// "min_retention": "8760h", "retention_rules": {"application/pdf": "61320h"}}}.
+func loadTenants(path string) (map[string]TenantConfig, error) {
+	tenants := make(map[string]TenantConfig)
+	if path == "" {
+		return tenants, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read tenants config: %w", err)
+	}
+
+	var raw map[string]struct {
+		AllowedTypes       []string          `json:"allowed_types"`
+		MaxFileSize        int64             `json:"max_file_size"`
+		Quota              *QuotaLimit       `json:"quota"`
+		Retention          string            `json:"retention"`
+		MinRetention       string            `json:"min_retention"`
+		RetentionRules     map[string]string `json:"retention_rules"`
+		StripMetadataTypes []string          `json:"strip_metadata_types"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("failed to decode tenants config: %w", err)
+	}
+
+	for tenantID, t := range raw {
+		tenant := TenantConfig{
+			AllowedTypes:       t.AllowedTypes,
+			MaxFileSize:        t.MaxFileSize,
+			Quota:              t.Quota,
+			StripMetadataTypes: t.StripMetadataTypes,
+		}
+		if t.Retention != "" {
+			if tenant.Retention, err = time.ParseDuration(t.Retention); err != nil {
+				return nil, fmt.Errorf("invalid retention for tenant %s: %w", tenantID, err)
+			}
+		}
+		if t.MinRetention != "" {
+			if tenant.MinRetention, err = time.ParseDuration(t.MinRetention); err != nil {
+				return nil, fmt.Errorf("invalid min_retention for tenant %s: %w", tenantID, err)
+			}
+		}
+		for contentType, value := range t.RetentionRules {
+			duration, err := time.ParseDuration(value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid retention rule %s for tenant %s: %w", contentType, tenantID, err)
+			}
+			tenant.RetentionRules = append(tenant.RetentionRules, RetentionRule{ContentType: contentType, Duration: duration})
+		}
+		tenants[tenantID] = tenant
+	}
+
+	return tenants, nil
+}
+
 func getEnv(key, defaultValue string) string {
 	if value := os.Getenv(key); value != "" {
 		return value
@@ -86,3 +437,149 @@ func getDurationEnv(key string, defaultValue time.Duration) time.Duration {
 	}
 	return defaultValue
 }
+
+func getBoolEnv(key string, defaultValue bool) bool {
+	if value := os.Getenv(key); value != "" {
+		if boolVal, err := strconv.ParseBool(value); err == nil {
+			return boolVal
+		}
+	}
+	return defaultValue
+}
+
+// getListEnv parses a comma-separated list, dropping empty items.
+func getListEnv(key string, defaultValue []string) []string {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// getIntListEnv parses a comma-separated list of integers, skipping
+// malformed items.
+func getIntListEnv(key string, defaultValue []int) []int {
+	value := getListEnv(key, nil)
+	if value == nil {
+		return defaultValue
+	}
+
+	var items []int
+	for _, item := range value {
+		if intVal, err := strconv.Atoi(item); err == nil {
+			items = append(items, intVal)
+		}
+	}
+	return items
+}
+
+// getQuotaOverridesEnv parses per-user quota overrides in the form
+// "user1=bytes:files,user2=bytes:files". Malformed entries are skipped.
+func getQuotaOverridesEnv(key string) map[string]QuotaLimit {
+	overrides := make(map[string]QuotaLimit)
+	value := os.Getenv(key)
+	if value == "" {
+		return overrides
+	}
+
+	for _, entry := range strings.Split(value, ",") {
+		userID, limits, found := strings.Cut(strings.TrimSpace(entry), "=")
+		if !found || userID == "" {
+			continue
+		}
+
+		bytesPart, filesPart, _ := strings.Cut(limits, ":")
+		maxBytes, err := strconv.ParseInt(bytesPart, 10, 64)
+		if err != nil {
+			continue
+		}
+		var maxFiles int
+		if filesPart != "" {
+			if maxFiles, err = strconv.Atoi(filesPart); err != nil {
+				continue
+			}
+		}
+
+		overrides[userID] = QuotaLimit{MaxBytes: maxBytes, MaxFiles: maxFiles}
+	}
+
+	return overrides
+}
+
+// getMapEnv parses entries in the form "key=value,key2=value2". Malformed
+// entries are skipped.
+func getMapEnv(key string) map[string]string {
+	entries := make(map[string]string)
+	for _, entry := range getListEnv(key, nil) {
+		name, value, found := strings.Cut(entry, "=")
+		if !found {
+			continue
+		}
+		entries[strings.TrimSpace(name)] = strings.TrimSpace(value)
+	}
+	return entries
+}
+
+// getTypeExtensionsEnv parses extensions by content type in the form
+// "text/csv=csv|tsv,text/markdown=md". Malformed entries are skipped.
+func getTypeExtensionsEnv(key string) map[string][]string {
+	extensions := make(map[string][]string)
+	for contentType, list := range getMapEnv(key) {
+		for _, extension := range strings.Split(list, "|") {
+			if extension = strings.TrimSpace(extension); extension != "" {
+				extensions[contentType] = append(extensions[contentType], extension)
+			}
+		}
+	}
+	return extensions
+}
+
+// getRetentionRulesEnv parses retention rules in the form
+// "application/pdf=61320h,image/*=8760h". Malformed entries are skipped.
+func getRetentionRulesEnv(key string) []RetentionRule {
+	var rules []RetentionRule
+	for _, entry := range getListEnv(key, nil) {
+		contentType, value, found := strings.Cut(entry, "=")
+		if !found {
+			continue
+		}
+		duration, err := time.ParseDuration(strings.TrimSpace(value))
+		if err != nil || duration <= 0 {
+			continue
+		}
+		rules = append(rules, RetentionRule{ContentType: strings.TrimSpace(contentType), Duration: duration})
+	}
+	return rules
+}
+
+// getCertRulesEnv parses client certificate rules in the form
+// "cn:batch-*=svc-{value}|files:read files:write;uri:spiffe://mesh/*={value}|files:read".
+// Malformed entries are skipped.
+func getCertRulesEnv(key string) []CertRule {
+	var rules []CertRule
+	for _, entry := range strings.Split(os.Getenv(key), ";") {
+		match, target, found := strings.Cut(strings.TrimSpace(entry), "=")
+		if !found || match == "" {
+			continue
+		}
+
+		userID, scopes, _ := strings.Cut(target, "|")
+		if userID == "" {
+			continue
+		}
+
+		rules = append(rules, CertRule{
+			Match:  match,
+			UserID: userID,
+			Scopes: strings.Fields(scopes),
+		})
+	}
+	return rules
+}
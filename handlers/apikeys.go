@@ -0,0 +1,77 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/ebinskryfon/fileuploader/models"
+	"github.com/ebinskryfon/fileuploader/services"
+	"github.com/ebinskryfon/fileuploader/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+type APIKeyHandler struct {
+	apiKeyService *services.APIKeyService
+	logger        *utils.Logger
+}
+
+func NewAPIKeyHandler(apiKeyService *services.APIKeyService, logger *utils.Logger) *APIKeyHandler {
+	return &APIKeyHandler{
+		apiKeyService: apiKeyService,
+		logger:        logger,
+	}
+}
+
+func (h *APIKeyHandler) Create(c *gin.Context) {
+	var req models.CreateAPIKeyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		h.respondWithError(c, models.NewAppError(http.StatusBadRequest, "Invalid request body", err))
+		return
+	}
+
+	response, appError := h.apiKeyService.Create(req, c.GetString("userID"))
+	if appError != nil {
+		h.respondWithError(c, appError)
+		return
+	}
+
+	h.logger.Info("API key created", map[string]interface{}{
+		"key_id":     response.ID,
+		"prefix":     response.Prefix,
+		"owner":      response.UserID,
+		"scopes":     response.Scopes,
+		"created_by": response.CreatedBy,
+	})
+
+	c.JSON(http.StatusCreated, response)
+}
+
+func (h *APIKeyHandler) List(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"keys": h.apiKeyService.List()})
+}
+
+func (h *APIKeyHandler) Revoke(c *gin.Context) {
+	keyID := c.Param("id")
+	if appError := h.apiKeyService.Revoke(keyID); appError != nil {
+		h.respondWithError(c, appError)
+		return
+	}
+
+	h.logger.Info("API key revoked", map[string]interface{}{
+		"key_id":     keyID,
+		"revoked_by": c.GetString("userID"),
+	})
+
+	c.Status(http.StatusNoContent)
+}
+
+func (h *APIKeyHandler) respondWithError(c *gin.Context, appError *models.AppError) {
+	response := models.ErrorResponse{
+		Error:   appError.Message,
+		Code:    appError.Code,
+		Message: appError.Message,
+		Reason:  appError.Reason,
+		Details: appError.Details,
+	}
+	c.JSON(appError.Code, response)
+}
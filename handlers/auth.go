@@ -0,0 +1,123 @@
+package handlers
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/ebinskryfon/fileuploader/models"
+	"github.com/ebinskryfon/fileuploader/services"
+	"github.com/ebinskryfon/fileuploader/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+type AuthHandler struct {
+	authService *services.AuthService
+	logger      *utils.Logger
+}
+
+func NewAuthHandler(authService *services.AuthService, logger *utils.Logger) *AuthHandler {
+	return &AuthHandler{
+		authService: authService,
+		logger:      logger,
+	}
+}
+
+// Logout revokes the token used to make the request.
+func (h *AuthHandler) Logout(c *gin.Context) {
+	value, exists := c.Get("claims")
+	claims, ok := value.(*services.Claims)
+	if !exists || !ok {
+		h.respondWithError(c, models.NewAppError(http.StatusBadRequest, "Only JWT sessions can be logged out", nil))
+		return
+	}
+
+	var err error
+	if claims.ID != "" {
+		var expiresAt time.Time
+		if claims.ExpiresAt != nil {
+			expiresAt = claims.ExpiresAt.Time
+		}
+		err = h.authService.RevokeToken(claims.ID, expiresAt)
+	} else {
+		// Tokens without a jti can only be revoked by cutting off the user
+		err = h.authService.RevokeUserTokens(claims.TenantID, claims.UserID, time.Now())
+	}
+	if err != nil {
+		h.logger.Error("Failed to revoke token", map[string]interface{}{
+			"user_id":   claims.UserID,
+			"tenant_id": claims.TenantID,
+			"error":     err.Error(),
+		})
+		h.respondWithError(c, models.ErrInternalServer)
+		return
+	}
+
+	h.logger.Info("User logged out", map[string]interface{}{
+		"user_id":   claims.UserID,
+		"tenant_id": claims.TenantID,
+		"jti":       claims.ID,
+	})
+
+	c.Status(http.StatusNoContent)
+}
+
+// Revoke revokes a single token by jti, or all of a user's tokens issued
+// before a cutoff.
+func (h *AuthHandler) Revoke(c *gin.Context) {
+	var req models.RevocationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		h.respondWithError(c, models.NewAppError(http.StatusBadRequest, "Invalid request body", err))
+		return
+	}
+
+	var err error
+	switch {
+	case req.JTI != "" && req.UserID == "":
+		var expiresAt time.Time
+		if req.ExpiresAt != nil {
+			expiresAt = *req.ExpiresAt
+		}
+		err = h.authService.RevokeToken(req.JTI, expiresAt)
+	case req.UserID != "" && req.JTI == "":
+		issuedBefore := time.Now()
+		if req.IssuedBefore != nil {
+			issuedBefore = *req.IssuedBefore
+		}
+		err = h.authService.RevokeUserTokens(req.TenantID, req.UserID, issuedBefore)
+	default:
+		h.respondWithError(c, models.NewAppError(http.StatusBadRequest, "Specify exactly one of jti or user_id", nil))
+		return
+	}
+
+	if err != nil {
+		h.logger.Error("Failed to revoke tokens", map[string]interface{}{
+			"jti":       req.JTI,
+			"user_id":   req.UserID,
+			"tenant_id": req.TenantID,
+			"error":     err.Error(),
+		})
+		h.respondWithError(c, models.ErrInternalServer)
+		return
+	}
+
+	h.logger.Info("Tokens revoked", map[string]interface{}{
+		"jti":        req.JTI,
+		"user_id":    req.UserID,
+		"tenant_id":  req.TenantID,
+		"revoked_by": c.GetString("userID"),
+	})
+
+	c.Status(http.StatusNoContent)
+}
+
+func (h *AuthHandler) respondWithError(c *gin.Context, appError *models.AppError) {
+	response := models.ErrorResponse{
+		Error:   appError.Message,
+		Code:    appError.Code,
+		Message: appError.Message,
+		Reason:  appError.Reason,
+		Details: appError.Details,
+	}
+	c.JSON(appError.Code, response)
+}
@@ -0,0 +1,23 @@
+package handlers
+
+import (
+	"github.com/ebinskryfon/fileuploader/models"
+	"github.com/ebinskryfon/fileuploader/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// principalFromContext returns the caller identity set by AuthMiddleware.
+func principalFromContext(c *gin.Context) (models.Principal, bool) {
+	userID := c.GetString("userID")
+	if userID == "" {
+		return models.Principal{}, false
+	}
+
+	return models.Principal{
+		UserID:      userID,
+		Groups:      c.GetStringSlice("groups"),
+		TenantID:    c.GetString("tenantID"),
+		TenantAdmin: services.HasScope(c.GetStringSlice("scopes"), services.ScopeTenantAdmin),
+	}, true
+}
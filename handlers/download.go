@@ -1,7 +1,12 @@
 package handlers
 
 import (
+	"io"
+	"mime"
 	"net/http"
+	"path"
+	"strconv"
+	"strings"
 	"fmt"
 	"github.com/ebinskryfon/fileuploader/models"
 	"github.com/ebinskryfon/fileuploader/services"
@@ -22,8 +27,8 @@ func NewDownloadHandler(uploadService *services.UploadService, logger *utils.Log
 }
 
 func (h *DownloadHandler) GetFile(c *gin.Context) {
-	userID := c.GetString("userID")
-	if userID == "" {
+	principal, ok := principalFromContext(c)
+	if !ok {
 		h.respondWithError(c, models.ErrUnauthorized)
 		return
 	}
@@ -34,13 +39,208 @@ func (h *DownloadHandler) GetFile(c *gin.Context) {
 		return
 	}
 
-	file, metadata, appError := h.uploadService.GetFile(fileID, userID)
+	h.serveFile(c, principal, fileID)
+}
+
+// GetByPath serves the file at a path relative to the caller's root, e.g.
+// /api/v1/fs/reports/2026/q3.pdf. Paths naming a folder list its contents.
+func (h *DownloadHandler) GetByPath(c *gin.Context) {
+	principal, ok := principalFromContext(c)
+	if !ok {
+		h.respondWithError(c, models.ErrUnauthorized)
+		return
+	}
+
+	folderID, fileID, appError := h.uploadService.ResolvePath(principal, c.Param("path"))
+	if appError != nil {
+		h.respondWithError(c, appError)
+		return
+	}
+
+	if fileID == "" {
+		contents, appError := h.uploadService.GetFolderContents(folderID, principal)
+		if appError != nil {
+			h.respondWithError(c, appError)
+			return
+		}
+		c.JSON(http.StatusOK, contents)
+		return
+	}
+
+	h.serveFile(c, principal, fileID)
+}
+
+// GetVersion serves one version of a file.
+func (h *DownloadHandler) GetVersion(c *gin.Context) {
+	principal, ok := principalFromContext(c)
+	if !ok {
+		h.respondWithError(c, models.ErrUnauthorized)
+		return
+	}
+
+	version, err := strconv.Atoi(c.Param("version"))
+	if err != nil || version < 1 {
+		h.respondWithError(c, models.NewAppError(http.StatusBadRequest, "Invalid version", err))
+		return
+	}
+
+	file, metadata, appError := h.uploadService.GetVersion(c.Param("id"), version, principal)
+	if appError != nil {
+		h.respondWithError(c, appError)
+		return
+	}
+	defer file.Close()
+
+	h.writeFile(c, file, metadata)
+}
+
+// GetThumbnail serves a thumbnail of an image, e.g.
+// /api/v1/files/:id/thumbnail?size=128. Without a size the smallest
+// configured thumbnail is served.
+func (h *DownloadHandler) GetThumbnail(c *gin.Context) {
+	principal, ok := principalFromContext(c)
+	if !ok {
+		h.respondWithError(c, models.ErrUnauthorized)
+		return
+	}
+
+	size := 0
+	if value := c.Query("size"); value != "" {
+		var err error
+		if size, err = strconv.Atoi(value); err != nil || size < 1 {
+			h.respondWithError(c, models.NewAppError(http.StatusBadRequest, "Invalid thumbnail size", err))
+			return
+		}
+	}
+
+	file, thumbnail, appError := h.uploadService.GetThumbnail(c.Param("id"), size, principal)
+	if appError != nil {
+		h.respondWithError(c, appError)
+		return
+	}
+	defer file.Close()
+
+	h.writeDerived(c, file, thumbnail)
+}
+
+// ListEntries lists the members of a ZIP file.
+func (h *DownloadHandler) ListEntries(c *gin.Context) {
+	principal, ok := principalFromContext(c)
+	if !ok {
+		h.respondWithError(c, models.ErrUnauthorized)
+		return
+	}
+
+	entries, appError := h.uploadService.ListArchiveEntries(c.Param("id"), principal)
+	if appError != nil {
+		h.respondWithError(c, appError)
+		return
+	}
+
+	response := models.ArchiveEntryListResponse{Entries: entries}
+	for _, entry := range entries {
+		response.Size += entry.Size
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// GetEntry streams one member of a ZIP file, e.g.
+// /api/v1/files/:id/entries/docs/readme.txt.
+func (h *DownloadHandler) GetEntry(c *gin.Context) {
+	principal, ok := principalFromContext(c)
+	if !ok {
+		h.respondWithError(c, models.ErrUnauthorized)
+		return
+	}
+
+	name := strings.TrimPrefix(c.Param("path"), "/")
+	if name == "" {
+		h.respondWithError(c, models.NewAppError(http.StatusBadRequest, "Entry path required", nil))
+		return
+	}
+
+	file, entry, appError := h.uploadService.GetArchiveEntry(c.Param("id"), name, principal)
 	if appError != nil {
 		h.respondWithError(c, appError)
 		return
 	}
 	defer file.Close()
 
+	// The member's type is only a guess from its name, so never sniff
+	contentType := mime.TypeByExtension(path.Ext(entry.Name))
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	// Entry names come from the archive, so let mime quote or encode them
+	c.Header("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": path.Base(entry.Name)}))
+	c.Header("X-Content-Type-Options", "nosniff")
+	c.DataFromReader(http.StatusOK, entry.Size, contentType, file, nil)
+}
+
+func (h *DownloadHandler) serveFile(c *gin.Context, principal models.Principal, fileID string) {
+	// Metadata stays readable while the content is blocked by a virus scan
+	if c.GetHeader("Accept") == "application/json" {
+		metadata, appError := h.uploadService.GetFileMetadata(fileID, principal)
+		if appError != nil {
+			h.respondWithError(c, appError)
+			return
+		}
+		c.JSON(http.StatusOK, metadata)
+		return
+	}
+
+	transform, appError := parseImageTransform(c)
+	if appError != nil {
+		h.respondWithError(c, appError)
+		return
+	}
+	if !transform.IsZero() {
+		file, variant, appError := h.uploadService.TransformImage(fileID, transform, principal)
+		if appError != nil {
+			h.respondWithError(c, appError)
+			return
+		}
+		defer file.Close()
+
+		h.writeDerived(c, file, variant)
+		return
+	}
+
+	file, metadata, appError := h.uploadService.GetFile(fileID, principal)
+	if appError != nil {
+		h.respondWithError(c, appError)
+		return
+	}
+	defer file.Close()
+
+	h.writeFile(c, file, metadata)
+}
+
+// parseImageTransform reads the w, h, fit, format and q query parameters,
+// e.g. ?w=400&h=300&fit=cover&format=png.
+func parseImageTransform(c *gin.Context) (models.ImageTransform, *models.AppError) {
+	transform := models.ImageTransform{
+		Fit:    c.Query("fit"),
+		Format: c.Query("format"),
+	}
+
+	for _, param := range []struct {
+		name   string
+		target *int
+	}{{"w", &transform.Width}, {"h", &transform.Height}, {"q", &transform.Quality}} {
+		if value := c.Query(param.name); value != "" {
+			parsed, err := strconv.Atoi(value)
+			if err != nil || parsed < 1 {
+				return models.ImageTransform{}, models.NewAppError(http.StatusBadRequest, "Invalid "+param.name, err)
+			}
+			*param.target = parsed
+		}
+	}
+
+	return transform, nil
+}
+
+func (h *DownloadHandler) writeFile(c *gin.Context, file io.Reader, metadata models.FileMetadata) {
 	// Check Accept header to determine response format
 	acceptHeader := c.GetHeader("Accept")
 	if acceptHeader == "application/json" {
@@ -49,6 +249,10 @@ func (h *DownloadHandler) GetFile(c *gin.Context) {
 		return
 	}
 
+	if h.notModified(c, metadata.Checksum) {
+		return
+	}
+
 	// Return file content
 	c.Header("Content-Type", metadata.ContentType)
 	c.Header("Content-Disposition", "attachment; filename=\""+metadata.OriginalName+"\"")
@@ -58,11 +262,41 @@ func (h *DownloadHandler) GetFile(c *gin.Context) {
 	c.DataFromReader(http.StatusOK, metadata.Size, metadata.ContentType, file, nil)
 }
 
+// writeDerived serves an object generated from a file, such as a thumbnail.
+func (h *DownloadHandler) writeDerived(c *gin.Context, file io.Reader, derived models.DerivedObject) {
+	if h.notModified(c, derived.SourceChecksum+"-"+derived.Name) {
+		return
+	}
+
+	c.Header("Content-Disposition", "inline")
+	c.DataFromReader(http.StatusOK, derived.Size, derived.ContentType, file, nil)
+}
+
+// notModified sets the caching headers for content identified by tag and
+// answers 304 Not Modified when the client already holds it. Clients must
+// revalidate every time, since access may have been revoked.
+func (h *DownloadHandler) notModified(c *gin.Context, tag string) bool {
+	etag := `"` + tag + `"`
+	c.Header("ETag", etag)
+	c.Header("Cache-Control", "private, no-cache")
+
+	for _, candidate := range strings.Split(c.GetHeader("If-None-Match"), ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == etag || candidate == "*" {
+			c.Status(http.StatusNotModified)
+			return true
+		}
+	}
+	return false
+}
+
 func (h *DownloadHandler) respondWithError(c *gin.Context, appError *models.AppError) {
 	response := models.ErrorResponse{
 		Error:   appError.Message,
 		Code:    appError.Code,
 		Message: appError.Message,
+		Reason:  appError.Reason,
+		Details: appError.Details,
 	}
 	c.JSON(appError.Code, response)
 }
@@ -0,0 +1,274 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/ebinskryfon/fileuploader/models"
+	"github.com/ebinskryfon/fileuploader/services"
+	"github.com/ebinskryfon/fileuploader/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+type FileHandler struct {
+	uploadService *services.UploadService
+	logger        *utils.Logger
+}
+
+func NewFileHandler(uploadService *services.UploadService, logger *utils.Logger) *FileHandler {
+	return &FileHandler{
+		uploadService: uploadService,
+		logger:        logger,
+	}
+}
+
+func (h *FileHandler) ListFiles(c *gin.Context) {
+	principal, ok := principalFromContext(c)
+	if !ok {
+		h.respondWithError(c, models.ErrUnauthorized)
+		return
+	}
+
+	filters, appError := parsePropertyFilters(c)
+	if appError != nil {
+		h.respondWithError(c, appError)
+		return
+	}
+
+	files, appError := h.uploadService.ListFiles(principal, filters...)
+	if appError != nil {
+		h.respondWithError(c, appError)
+		return
+	}
+
+	c.JSON(http.StatusOK, models.FileListResponse{Files: files})
+}
+
+func (h *FileHandler) ListShared(c *gin.Context) {
+	principal, ok := principalFromContext(c)
+	if !ok {
+		h.respondWithError(c, models.ErrUnauthorized)
+		return
+	}
+
+	filters, appError := parsePropertyFilters(c)
+	if appError != nil {
+		h.respondWithError(c, appError)
+		return
+	}
+
+	files, appError := h.uploadService.ListSharedWithMe(principal, filters...)
+	if appError != nil {
+		h.respondWithError(c, appError)
+		return
+	}
+
+	folders, appError := h.uploadService.ListSharedFolders(principal)
+	if appError != nil {
+		h.respondWithError(c, appError)
+		return
+	}
+
+	c.JSON(http.StatusOK, models.FileListResponse{Files: files, Folders: folders})
+}
+
+// ListTenantFiles lists every file in the tenant admin's tenant.
+func (h *FileHandler) ListTenantFiles(c *gin.Context) {
+	principal, ok := principalFromContext(c)
+	if !ok {
+		h.respondWithError(c, models.ErrUnauthorized)
+		return
+	}
+
+	filters, appError := parsePropertyFilters(c)
+	if appError != nil {
+		h.respondWithError(c, appError)
+		return
+	}
+
+	files, appError := h.uploadService.ListTenantFiles(principal, filters...)
+	if appError != nil {
+		h.respondWithError(c, appError)
+		return
+	}
+
+	c.JSON(http.StatusOK, models.FileListResponse{Files: files})
+}
+
+// parsePropertyFilters reads the repeated property query parameter, such as
+// ?property=image.width>=800&property=image.color_model=gray.
+func parsePropertyFilters(c *gin.Context) ([]models.PropertyFilter, *models.AppError) {
+	var filters []models.PropertyFilter
+	for _, expression := range c.QueryArray("property") {
+		filter, appError := services.ParsePropertyFilter(expression)
+		if appError != nil {
+			return nil, appError
+		}
+		filters = append(filters, filter)
+	}
+	return filters, nil
+}
+
+func (h *FileHandler) DeleteFile(c *gin.Context) {
+	principal, ok := principalFromContext(c)
+	if !ok {
+		h.respondWithError(c, models.ErrUnauthorized)
+		return
+	}
+
+	fileID := c.Param("id")
+	if fileID == "" {
+		h.respondWithError(c, models.NewAppError(http.StatusBadRequest, "File ID required", nil))
+		return
+	}
+
+	if appError := h.uploadService.DeleteFile(fileID, principal); appError != nil {
+		h.respondWithError(c, appError)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// UpdateFile renames a file and changes its description, tags and custom
+// metadata.
+func (h *FileHandler) UpdateFile(c *gin.Context) {
+	principal, ok := principalFromContext(c)
+	if !ok {
+		h.respondWithError(c, models.ErrUnauthorized)
+		return
+	}
+
+	var req models.UpdateFileRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		h.respondWithError(c, models.NewAppError(http.StatusBadRequest, "Invalid request body", err))
+		return
+	}
+
+	metadata, appError := h.uploadService.UpdateFile(c.Param("id"), principal, req)
+	if appError != nil {
+		h.respondWithError(c, appError)
+		return
+	}
+
+	c.JSON(http.StatusOK, metadata)
+}
+
+func (h *FileHandler) GrantPermission(c *gin.Context) {
+	principal, ok := principalFromContext(c)
+	if !ok {
+		h.respondWithError(c, models.ErrUnauthorized)
+		return
+	}
+
+	var req models.GrantPermissionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		h.respondWithError(c, models.NewAppError(http.StatusBadRequest, "Invalid request body", err))
+		return
+	}
+
+	permissions, appError := h.uploadService.GrantPermission(c.Param("id"), principal, req)
+	if appError != nil {
+		h.respondWithError(c, appError)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"permissions": permissions})
+}
+
+// RevokePermission removes a grant identified by the type and id query
+// parameters, e.g. ?type=group&id=finance.
+func (h *FileHandler) RevokePermission(c *gin.Context) {
+	principal, ok := principalFromContext(c)
+	if !ok {
+		h.respondWithError(c, models.ErrUnauthorized)
+		return
+	}
+
+	granteeType, granteeID := c.Query("type"), c.Query("id")
+	if granteeType == "" || granteeID == "" {
+		h.respondWithError(c, models.NewAppError(http.StatusBadRequest, "type and id query parameters are required", nil))
+		return
+	}
+
+	permissions, appError := h.uploadService.RevokePermission(c.Param("id"), principal, granteeType, granteeID)
+	if appError != nil {
+		h.respondWithError(c, appError)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"permissions": permissions})
+}
+
+// SetRetention sets an explicit retention date on a file. Retention can
+// only be extended.
+func (h *FileHandler) SetRetention(c *gin.Context) {
+	principal, ok := principalFromContext(c)
+	if !ok {
+		h.respondWithError(c, models.ErrUnauthorized)
+		return
+	}
+
+	var req models.SetRetentionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		h.respondWithError(c, models.NewAppError(http.StatusBadRequest, "Invalid request body", err))
+		return
+	}
+
+	metadata, appError := h.uploadService.SetRetention(c.Param("id"), req.RetainUntil, principal)
+	if appError != nil {
+		h.respondWithError(c, appError)
+		return
+	}
+
+	c.JSON(http.StatusOK, metadata)
+}
+
+func (h *FileHandler) PlaceLegalHold(c *gin.Context) {
+	principal, ok := principalFromContext(c)
+	if !ok {
+		h.respondWithError(c, models.ErrUnauthorized)
+		return
+	}
+
+	var req models.LegalHoldRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		h.respondWithError(c, models.NewAppError(http.StatusBadRequest, "Invalid request body", err))
+		return
+	}
+
+	metadata, appError := h.uploadService.PlaceLegalHold(c.Param("id"), req.Reason, principal)
+	if appError != nil {
+		h.respondWithError(c, appError)
+		return
+	}
+
+	c.JSON(http.StatusOK, metadata)
+}
+
+func (h *FileHandler) ReleaseLegalHold(c *gin.Context) {
+	principal, ok := principalFromContext(c)
+	if !ok {
+		h.respondWithError(c, models.ErrUnauthorized)
+		return
+	}
+
+	metadata, appError := h.uploadService.ReleaseLegalHold(c.Param("id"), principal)
+	if appError != nil {
+		h.respondWithError(c, appError)
+		return
+	}
+
+	c.JSON(http.StatusOK, metadata)
+}
+
+func (h *FileHandler) respondWithError(c *gin.Context, appError *models.AppError) {
+	response := models.ErrorResponse{
+		Error:   appError.Message,
+		Code:    appError.Code,
+		Message: appError.Message,
+		Reason:  appError.Reason,
+		Details: appError.Details,
+	}
+	c.JSON(appError.Code, response)
+}
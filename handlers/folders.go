@@ -0,0 +1,156 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/ebinskryfon/fileuploader/models"
+	"github.com/ebinskryfon/fileuploader/services"
+	"github.com/ebinskryfon/fileuploader/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+type FolderHandler struct {
+	uploadService *services.UploadService
+	logger        *utils.Logger
+}
+
+func NewFolderHandler(uploadService *services.UploadService, logger *utils.Logger) *FolderHandler {
+	return &FolderHandler{
+		uploadService: uploadService,
+		logger:        logger,
+	}
+}
+
+func (h *FolderHandler) Create(c *gin.Context) {
+	principal, ok := principalFromContext(c)
+	if !ok {
+		h.respondWithError(c, models.ErrUnauthorized)
+		return
+	}
+
+	var req models.CreateFolderRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		h.respondWithError(c, models.NewAppError(http.StatusBadRequest, "Invalid request body", err))
+		return
+	}
+
+	folder, appError := h.uploadService.CreateFolder(principal, req)
+	if appError != nil {
+		h.respondWithError(c, appError)
+		return
+	}
+
+	c.JSON(http.StatusCreated, folder)
+}
+
+// Get lists a folder's contents, or the caller's root when no ID is given.
+func (h *FolderHandler) Get(c *gin.Context) {
+	principal, ok := principalFromContext(c)
+	if !ok {
+		h.respondWithError(c, models.ErrUnauthorized)
+		return
+	}
+
+	contents, appError := h.uploadService.GetFolderContents(c.Param("id"), principal)
+	if appError != nil {
+		h.respondWithError(c, appError)
+		return
+	}
+
+	c.JSON(http.StatusOK, contents)
+}
+
+func (h *FolderHandler) Update(c *gin.Context) {
+	principal, ok := principalFromContext(c)
+	if !ok {
+		h.respondWithError(c, models.ErrUnauthorized)
+		return
+	}
+
+	var req models.UpdateFolderRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		h.respondWithError(c, models.NewAppError(http.StatusBadRequest, "Invalid request body", err))
+		return
+	}
+
+	folder, appError := h.uploadService.UpdateFolder(c.Param("id"), principal, req)
+	if appError != nil {
+		h.respondWithError(c, appError)
+		return
+	}
+
+	c.JSON(http.StatusOK, folder)
+}
+
+func (h *FolderHandler) Delete(c *gin.Context) {
+	principal, ok := principalFromContext(c)
+	if !ok {
+		h.respondWithError(c, models.ErrUnauthorized)
+		return
+	}
+
+	if appError := h.uploadService.DeleteFolder(c.Param("id"), principal); appError != nil {
+		h.respondWithError(c, appError)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+func (h *FolderHandler) GrantPermission(c *gin.Context) {
+	principal, ok := principalFromContext(c)
+	if !ok {
+		h.respondWithError(c, models.ErrUnauthorized)
+		return
+	}
+
+	var req models.GrantPermissionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		h.respondWithError(c, models.NewAppError(http.StatusBadRequest, "Invalid request body", err))
+		return
+	}
+
+	permissions, appError := h.uploadService.GrantFolderPermission(c.Param("id"), principal, req)
+	if appError != nil {
+		h.respondWithError(c, appError)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"permissions": permissions})
+}
+
+// RevokePermission removes a grant identified by the type and id query
+// parameters, e.g. ?type=group&id=finance.
+func (h *FolderHandler) RevokePermission(c *gin.Context) {
+	principal, ok := principalFromContext(c)
+	if !ok {
+		h.respondWithError(c, models.ErrUnauthorized)
+		return
+	}
+
+	granteeType, granteeID := c.Query("type"), c.Query("id")
+	if granteeType == "" || granteeID == "" {
+		h.respondWithError(c, models.NewAppError(http.StatusBadRequest, "type and id query parameters are required", nil))
+		return
+	}
+
+	permissions, appError := h.uploadService.RevokeFolderPermission(c.Param("id"), principal, granteeType, granteeID)
+	if appError != nil {
+		h.respondWithError(c, appError)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"permissions": permissions})
+}
+
+func (h *FolderHandler) respondWithError(c *gin.Context, appError *models.AppError) {
+	response := models.ErrorResponse{
+		Error:   appError.Message,
+		Code:    appError.Code,
+		Message: appError.Message,
+		Reason:  appError.Reason,
+		Details: appError.Details,
+	}
+	c.JSON(appError.Code, response)
+}
@@ -12,25 +12,45 @@ import (
 )
 
 type Middleware struct {
-	authService *services.AuthService
-	logger      *utils.Logger
-	rateLimiter map[string][]time.Time // Simple in-memory rate limiter
-	rateLimit   int
+	authService     *services.AuthService
+	apiKeyService   *services.APIKeyService
+	certAuthService *services.CertAuthService
+	logger          *utils.Logger
+	rateLimiter     map[string][]time.Time // Simple in-memory rate limiter
+	rateLimit       int
 }
 
-func NewMiddleware(authService *services.AuthService, logger *utils.Logger, rateLimit int) *Middleware {
+func NewMiddleware(authService *services.AuthService, apiKeyService *services.APIKeyService, certAuthService *services.CertAuthService, logger *utils.Logger, rateLimit int) *Middleware {
 	return &Middleware{
-		authService: authService,
-		logger:      logger,
-		rateLimiter: make(map[string][]time.Time),
-		rateLimit:   rateLimit,
+		authService:     authService,
+		apiKeyService:   apiKeyService,
+		certAuthService: certAuthService,
+		logger:          logger,
+		rateLimiter:     make(map[string][]time.Time),
+		rateLimit:       rateLimit,
 	}
 }
 
 func (m *Middleware) AuthMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		authHeader := c.GetHeader("Authorization")
+
+		// API keys may arrive as "ApiKey <key>" or in X-API-Key
+		if apiKey := c.GetHeader("X-API-Key"); apiKey != "" {
+			m.authenticateAPIKey(c, apiKey)
+			return
+		}
+		if scheme, apiKey, found := strings.Cut(authHeader, " "); found && scheme == "ApiKey" {
+			m.authenticateAPIKey(c, apiKey)
+			return
+		}
+
 		if authHeader == "" {
+			// Fall back to a verified client certificate, if any
+			if tls := c.Request.TLS; tls != nil && len(tls.VerifiedChains) > 0 {
+				m.authenticateCertificate(c)
+				return
+			}
 			m.respondWithError(c, models.ErrUnauthorized)
 			return
 		}
@@ -53,8 +73,66 @@ func (m *Middleware) AuthMiddleware() gin.HandlerFunc {
 			return
 		}
 
-		// Set user ID in context
+		// Set user ID, tenant, groups and granted scopes in context
 		c.Set("userID", claims.UserID)
+		c.Set("tenantID", claims.TenantID)
+		c.Set("groups", claims.Groups)
+		c.Set("scopes", m.authService.Scopes(claims))
+		c.Set("claims", claims)
+		c.Next()
+	}
+}
+
+func (m *Middleware) authenticateAPIKey(c *gin.Context, key string) {
+	apiKey, err := m.apiKeyService.Validate(key)
+	if err != nil {
+		m.logger.Warn("Invalid API key", map[string]interface{}{
+			"error": err.Error(),
+			"ip":    c.ClientIP(),
+		})
+		m.respondWithError(c, models.ErrUnauthorized)
+		return
+	}
+
+	c.Set("userID", apiKey.UserID)
+	c.Set("tenantID", apiKey.TenantID)
+	c.Set("scopes", apiKey.Scopes)
+	c.Set("apiKeyID", apiKey.ID)
+	c.Next()
+}
+
+func (m *Middleware) authenticateCertificate(c *gin.Context) {
+	cert := c.Request.TLS.VerifiedChains[0][0]
+	userID, scopes, ok := m.certAuthService.Identify(cert)
+	if !ok {
+		m.logger.Warn("No identity rule matches client certificate", map[string]interface{}{
+			"subject": cert.Subject.String(),
+			"ip":      c.ClientIP(),
+		})
+		m.respondWithError(c, models.ErrUnauthorized)
+		return
+	}
+
+	c.Set("userID", userID)
+	c.Set("scopes", scopes)
+	c.Next()
+}
+
+// RequireScope rejects requests whose credentials were not granted scope.
+// It must run after AuthMiddleware.
+func (m *Middleware) RequireScope(scope string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		scopes := c.GetStringSlice("scopes")
+		if !services.HasScope(scopes, scope) {
+			m.logger.Warn("Insufficient scope", map[string]interface{}{
+				"user_id":  c.GetString("userID"),
+				"required": scope,
+				"granted":  scopes,
+				"path":     c.Request.URL.Path,
+			})
+			m.respondWithError(c, models.ErrForbidden)
+			return
+		}
 		c.Next()
 	}
 }
@@ -122,7 +200,7 @@ func (m *Middleware) CORSMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		c.Header("Access-Control-Allow-Origin", "*")
 		c.Header("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-		c.Header("Access-Control-Allow-Headers", "Content-Type, Authorization")
+		c.Header("Access-Control-Allow-Headers", "Content-Type, Authorization, X-API-Key")
 
 		if c.Request.Method == "OPTIONS" {
 			c.AbortWithStatus(204)
@@ -138,6 +216,8 @@ func (m *Middleware) respondWithError(c *gin.Context, appError *models.AppError)
 		Error:   appError.Message,
 		Code:    appError.Code,
 		Message: appError.Message,
+		Reason:  appError.Reason,
+		Details: appError.Details,
 	}
 	c.JSON(appError.Code, response)
 	c.Abort()
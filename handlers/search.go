@@ -0,0 +1,123 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/ebinskryfon/fileuploader/models"
+	"github.com/ebinskryfon/fileuploader/services"
+	"github.com/ebinskryfon/fileuploader/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+type SearchHandler struct {
+	uploadService *services.UploadService
+	logger        *utils.Logger
+}
+
+func NewSearchHandler(uploadService *services.UploadService, logger *utils.Logger) *SearchHandler {
+	return &SearchHandler{
+		uploadService: uploadService,
+		logger:        logger,
+	}
+}
+
+// Search finds files by the q, content, type, from, to, min_size, max_size
+// and limit query parameters.
+func (h *SearchHandler) Search(c *gin.Context) {
+	principal, ok := principalFromContext(c)
+	if !ok {
+		h.respondWithError(c, models.ErrUnauthorized)
+		return
+	}
+
+	query := models.SearchQuery{
+		Query:       c.Query("q"),
+		Content:     c.Query("content"),
+		ContentType: c.Query("type"),
+	}
+
+	var appError *models.AppError
+	if query.From, appError = parseSearchTime(c.Query("from"), "from", false); appError != nil {
+		h.respondWithError(c, appError)
+		return
+	}
+	if query.To, appError = parseSearchTime(c.Query("to"), "to", true); appError != nil {
+		h.respondWithError(c, appError)
+		return
+	}
+
+	for _, param := range []struct {
+		name   string
+		target *int64
+	}{{"min_size", &query.MinSize}, {"max_size", &query.MaxSize}} {
+		if value := c.Query(param.name); value != "" {
+			parsed, err := strconv.ParseInt(value, 10, 64)
+			if err != nil || parsed < 0 {
+				h.respondWithError(c, models.NewAppError(http.StatusBadRequest, "Invalid "+param.name, err))
+				return
+			}
+			*param.target = parsed
+		}
+	}
+
+	if value := c.Query("limit"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed <= 0 {
+			h.respondWithError(c, models.NewAppError(http.StatusBadRequest, "Invalid limit", err))
+			return
+		}
+		query.Limit = parsed
+	}
+
+	response, appError := h.uploadService.Search(principal, query)
+	if appError != nil {
+		h.respondWithError(c, appError)
+		return
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// RebuildIndex rebuilds the search index from storage.
+func (h *SearchHandler) RebuildIndex(c *gin.Context) {
+	indexed, appError := h.uploadService.RebuildSearchIndex()
+	if appError != nil {
+		h.respondWithError(c, appError)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"indexed": indexed})
+}
+
+// parseSearchTime accepts an RFC 3339 timestamp or a date. A date used as
+// the end of a range covers the whole day.
+func parseSearchTime(value, name string, endOfDay bool) (*time.Time, *models.AppError) {
+	if value == "" {
+		return nil, nil
+	}
+	if parsed, err := time.Parse(time.RFC3339, value); err == nil {
+		return &parsed, nil
+	}
+	parsed, err := time.Parse(time.DateOnly, value)
+	if err != nil {
+		return nil, models.NewAppError(http.StatusBadRequest, "Invalid "+name+": use RFC 3339 or YYYY-MM-DD", err)
+	}
+	if endOfDay {
+		parsed = parsed.Add(24*time.Hour - time.Nanosecond)
+	}
+	return &parsed, nil
+}
+
+func (h *SearchHandler) respondWithError(c *gin.Context, appError *models.AppError) {
+	response := models.ErrorResponse{
+		Error:   appError.Message,
+		Code:    appError.Code,
+		Message: appError.Message,
+		Reason:  appError.Reason,
+		Details: appError.Details,
+	}
+	c.JSON(appError.Code, response)
+}
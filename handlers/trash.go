@@ -0,0 +1,97 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/ebinskryfon/fileuploader/models"
+	"github.com/ebinskryfon/fileuploader/services"
+	"github.com/ebinskryfon/fileuploader/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+type TrashHandler struct {
+	uploadService *services.UploadService
+	logger        *utils.Logger
+}
+
+func NewTrashHandler(uploadService *services.UploadService, logger *utils.Logger) *TrashHandler {
+	return &TrashHandler{
+		uploadService: uploadService,
+		logger:        logger,
+	}
+}
+
+func (h *TrashHandler) List(c *gin.Context) {
+	principal, ok := principalFromContext(c)
+	if !ok {
+		h.respondWithError(c, models.ErrUnauthorized)
+		return
+	}
+
+	files, appError := h.uploadService.ListTrash(principal)
+	if appError != nil {
+		h.respondWithError(c, appError)
+		return
+	}
+
+	c.JSON(http.StatusOK, models.FileListResponse{Files: files})
+}
+
+func (h *TrashHandler) Restore(c *gin.Context) {
+	principal, ok := principalFromContext(c)
+	if !ok {
+		h.respondWithError(c, models.ErrUnauthorized)
+		return
+	}
+
+	metadata, appError := h.uploadService.RestoreFromTrash(c.Param("id"), principal)
+	if appError != nil {
+		h.respondWithError(c, appError)
+		return
+	}
+
+	c.JSON(http.StatusOK, metadata)
+}
+
+func (h *TrashHandler) Purge(c *gin.Context) {
+	principal, ok := principalFromContext(c)
+	if !ok {
+		h.respondWithError(c, models.ErrUnauthorized)
+		return
+	}
+
+	if appError := h.uploadService.PurgeFromTrash(c.Param("id"), principal); appError != nil {
+		h.respondWithError(c, appError)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+func (h *TrashHandler) Empty(c *gin.Context) {
+	principal, ok := principalFromContext(c)
+	if !ok {
+		h.respondWithError(c, models.ErrUnauthorized)
+		return
+	}
+
+	purged, appError := h.uploadService.EmptyTrash(principal)
+	if appError != nil {
+		h.respondWithError(c, appError)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"purged": purged})
+}
+
+func (h *TrashHandler) respondWithError(c *gin.Context, appError *models.AppError) {
+	response := models.ErrorResponse{
+		Error:   appError.Message,
+		Code:    appError.Code,
+		Message: appError.Message,
+		Reason:  appError.Reason,
+		Details: appError.Details,
+	}
+	c.JSON(appError.Code, response)
+}
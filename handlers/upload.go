@@ -1,6 +1,7 @@
 package handlers
 
 import (
+	"encoding/json"
 	"net/http"
 
 	"github.com/ebinskryfon/fileuploader/models"
@@ -23,8 +24,8 @@ func NewUploadHandler(uploadService *services.UploadService, logger *utils.Logge
 }
 
 func (h *UploadHandler) Upload(c *gin.Context) {
-	userID := c.GetString("userID")
-	if userID == "" {
+	principal, ok := principalFromContext(c)
+	if !ok {
 		h.respondWithError(c, models.ErrUnauthorized)
 		return
 	}
@@ -33,7 +34,7 @@ func (h *UploadHandler) Upload(c *gin.Context) {
 	file, fileHeader, err := c.Request.FormFile("file")
 	if err != nil {
 		h.logger.Warn("Failed to parse form file", map[string]interface{}{
-			"user_id": userID,
+			"user_id": principal.UserID,
 			"error":   err.Error(),
 		})
 		h.respondWithError(c, models.NewAppError(http.StatusBadRequest, "No file provided", err))
@@ -41,8 +42,22 @@ func (h *UploadHandler) Upload(c *gin.Context) {
 	}
 	defer file.Close()
 
+	options := models.UploadOptions{
+		FolderID:    c.PostForm("folder_id"),
+		Path:        c.PostForm("path"),
+		Description: c.PostForm("description"),
+	}
+	if appError := parseFormMap(c, "tags", &options.Tags); appError != nil {
+		h.respondWithError(c, appError)
+		return
+	}
+	if appError := parseFormMap(c, "metadata", &options.Metadata); appError != nil {
+		h.respondWithError(c, appError)
+		return
+	}
+
 	// Upload file
-	response, appError := h.uploadService.UploadFile(fileHeader, userID)
+	response, appError := h.uploadService.UploadFile(fileHeader, principal, options)
 	if appError != nil {
 		h.respondWithError(c, appError)
 		return
@@ -51,11 +66,26 @@ func (h *UploadHandler) Upload(c *gin.Context) {
 	c.JSON(http.StatusOK, response)
 }
 
+// parseFormMap decodes a form field holding a JSON object of strings, such
+// as tags={"project":"apollo"}. A missing field leaves target nil.
+func parseFormMap(c *gin.Context, field string, target *map[string]string) *models.AppError {
+	value := c.PostForm(field)
+	if value == "" {
+		return nil
+	}
+	if err := json.Unmarshal([]byte(value), target); err != nil {
+		return models.NewAppError(http.StatusBadRequest, field+" must be a JSON object of strings", err)
+	}
+	return nil
+}
+
 func (h *UploadHandler) respondWithError(c *gin.Context, appError *models.AppError) {
 	response := models.ErrorResponse{
 		Error:   appError.Message,
 		Code:    appError.Code,
 		Message: appError.Message,
+		Reason:  appError.Reason,
+		Details: appError.Details,
 	}
 	c.JSON(appError.Code, response)
 }
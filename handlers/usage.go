@@ -0,0 +1,50 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/ebinskryfon/fileuploader/models"
+	"github.com/ebinskryfon/fileuploader/services"
+	"github.com/ebinskryfon/fileuploader/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+type UsageHandler struct {
+	uploadService *services.UploadService
+	logger        *utils.Logger
+}
+
+func NewUsageHandler(uploadService *services.UploadService, logger *utils.Logger) *UsageHandler {
+	return &UsageHandler{
+		uploadService: uploadService,
+		logger:        logger,
+	}
+}
+
+func (h *UsageHandler) GetUsage(c *gin.Context) {
+	principal, ok := principalFromContext(c)
+	if !ok {
+		h.respondWithError(c, models.ErrUnauthorized)
+		return
+	}
+
+	usage, appError := h.uploadService.GetUsage(principal)
+	if appError != nil {
+		h.respondWithError(c, appError)
+		return
+	}
+
+	c.JSON(http.StatusOK, usage)
+}
+
+func (h *UsageHandler) respondWithError(c *gin.Context, appError *models.AppError) {
+	response := models.ErrorResponse{
+		Error:   appError.Message,
+		Code:    appError.Code,
+		Message: appError.Message,
+		Reason:  appError.Reason,
+		Details: appError.Details,
+	}
+	c.JSON(appError.Code, response)
+}
@@ -0,0 +1,141 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/ebinskryfon/fileuploader/models"
+	"github.com/ebinskryfon/fileuploader/services"
+	"github.com/ebinskryfon/fileuploader/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+type VersionHandler struct {
+	uploadService *services.UploadService
+	logger        *utils.Logger
+}
+
+func NewVersionHandler(uploadService *services.UploadService, logger *utils.Logger) *VersionHandler {
+	return &VersionHandler{
+		uploadService: uploadService,
+		logger:        logger,
+	}
+}
+
+// ReplaceContent uploads new content for an existing file as its next
+// version.
+func (h *VersionHandler) ReplaceContent(c *gin.Context) {
+	principal, ok := principalFromContext(c)
+	if !ok {
+		h.respondWithError(c, models.ErrUnauthorized)
+		return
+	}
+
+	file, fileHeader, err := c.Request.FormFile("file")
+	if err != nil {
+		h.logger.Warn("Failed to parse form file", map[string]interface{}{
+			"user_id": principal.UserID,
+			"error":   err.Error(),
+		})
+		h.respondWithError(c, models.NewAppError(http.StatusBadRequest, "No file provided", err))
+		return
+	}
+	defer file.Close()
+
+	response, appError := h.uploadService.ReplaceContent(c.Param("id"), fileHeader, principal)
+	if appError != nil {
+		h.respondWithError(c, appError)
+		return
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+func (h *VersionHandler) List(c *gin.Context) {
+	principal, ok := principalFromContext(c)
+	if !ok {
+		h.respondWithError(c, models.ErrUnauthorized)
+		return
+	}
+
+	versions, appError := h.uploadService.ListVersions(c.Param("id"), principal)
+	if appError != nil {
+		h.respondWithError(c, appError)
+		return
+	}
+
+	c.JSON(http.StatusOK, models.FileVersionListResponse{Versions: versions})
+}
+
+func (h *VersionHandler) Restore(c *gin.Context) {
+	principal, ok := principalFromContext(c)
+	if !ok {
+		h.respondWithError(c, models.ErrUnauthorized)
+		return
+	}
+
+	version, err := strconv.Atoi(c.Param("version"))
+	if err != nil || version < 1 {
+		h.respondWithError(c, models.NewAppError(http.StatusBadRequest, "Invalid version", err))
+		return
+	}
+
+	response, appError := h.uploadService.RestoreVersion(c.Param("id"), version, principal)
+	if appError != nil {
+		h.respondWithError(c, appError)
+		return
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// Prune deletes earlier versions selected by the keep and/or older_than
+// query parameters, e.g. ?keep=5&older_than=720h.
+func (h *VersionHandler) Prune(c *gin.Context) {
+	principal, ok := principalFromContext(c)
+	if !ok {
+		h.respondWithError(c, models.ErrUnauthorized)
+		return
+	}
+
+	keep := -1
+	if value := c.Query("keep"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 0 {
+			h.respondWithError(c, models.NewAppError(http.StatusBadRequest, "Invalid keep", err))
+			return
+		}
+		keep = parsed
+	}
+
+	var olderThan time.Duration
+	if value := c.Query("older_than"); value != "" {
+		parsed, err := time.ParseDuration(value)
+		if err != nil || parsed <= 0 {
+			h.respondWithError(c, models.NewAppError(http.StatusBadRequest, "Invalid older_than", err))
+			return
+		}
+		olderThan = parsed
+	}
+
+	versions, appError := h.uploadService.PruneVersions(c.Param("id"), principal, keep, olderThan)
+	if appError != nil {
+		h.respondWithError(c, appError)
+		return
+	}
+
+	c.JSON(http.StatusOK, models.FileVersionListResponse{Versions: versions})
+}
+
+func (h *VersionHandler) respondWithError(c *gin.Context, appError *models.AppError) {
+	response := models.ErrorResponse{
+		Error:   appError.Message,
+		Code:    appError.Code,
+		Message: appError.Message,
+		Reason:  appError.Reason,
+		Details: appError.Details,
+	}
+	c.JSON(appError.Code, response)
+}
@@ -0,0 +1,18 @@
+package models
+
+import "time"
+
+// ArchiveEntry describes one member of a ZIP archive.
+type ArchiveEntry struct {
+	Name           string    `json:"name"`
+	Size           int64     `json:"size"`
+	CompressedSize int64     `json:"compressed_size"`
+	Modified       time.Time `json:"modified"`
+	Directory      bool      `json:"directory,omitempty"`
+}
+
+type ArchiveEntryListResponse struct {
+	Entries []ArchiveEntry `json:"entries"`
+	// Size is the total uncompressed size of the entries.
+	Size int64 `json:"size"`
+}
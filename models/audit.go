@@ -0,0 +1,21 @@
+package models
+
+import "time"
+
+const (
+	AuditRetentionSet      = "retention.set"
+	AuditLegalHoldPlaced   = "legal_hold.placed"
+	AuditLegalHoldReleased = "legal_hold.released"
+	AuditChangeBlocked     = "change.blocked"
+	AuditFilePurged        = "file.purged"
+)
+
+// AuditEvent is one entry in the compliance audit log.
+type AuditEvent struct {
+	Time     time.Time         `json:"time"`
+	Action   string            `json:"action"`
+	FileID   string            `json:"file_id"`
+	TenantID string            `json:"tenant_id,omitempty"`
+	UserID   string            `json:"user_id,omitempty"`
+	Details  map[string]string `json:"details,omitempty"`
+}
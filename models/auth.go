@@ -0,0 +1,16 @@
+package models
+
+import (
+	"time"
+)
+
+// RevocationRequest revokes either a single token by JTI or every token for
+// a user in TenantID (the default tenant if empty) issued before
+// IssuedBefore (defaulting to now).
+type RevocationRequest struct {
+	JTI          string     `json:"jti,omitempty"`
+	ExpiresAt    *time.Time `json:"expires_at,omitempty"`
+	TenantID     string     `json:"tenant_id,omitempty"`
+	UserID       string     `json:"user_id,omitempty"`
+	IssuedBefore *time.Time `json:"issued_before,omitempty"`
+}
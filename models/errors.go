@@ -8,7 +8,12 @@ import (
 type AppError struct {
 	Code    int    `json:"code"`
 	Message string `json:"message"`
-	Err     error  `json:"-"`
+	// Reason and Details explain validation failures in machine-readable
+	// form, e.g. reason "content_mismatch" with the declared and detected
+	// types.
+	Reason  string            `json:"reason,omitempty"`
+	Details map[string]string `json:"details,omitempty"`
+	Err     error             `json:"-"`
 }
 
 func (e *AppError) Error() string {
@@ -26,12 +31,58 @@ func NewAppError(code int, message string, err error) *AppError {
 	}
 }
 
+// NewValidationError reports a file that failed validation for reason.
+func NewValidationError(reason, message string, details map[string]string) *AppError {
+	return &AppError{
+		Code:    http.StatusBadRequest,
+		Message: message,
+		Reason:  reason,
+		Details: details,
+	}
+}
+
+// Validation failure reasons.
+const (
+	ReasonTypeNotAllowed      = "type_not_allowed"
+	ReasonUnreadable          = "unreadable"
+	ReasonUnrecognizedContent = "unrecognized_content"
+	ReasonContentMismatch     = "content_mismatch"
+	ReasonCharsetMismatch     = "charset_mismatch"
+	ReasonInvalidContainer    = "invalid_container"
+	ReasonExtensionBlocked    = "extension_blocked"
+	ReasonDoubleExtension     = "double_extension"
+	ReasonExtensionMismatch   = "extension_mismatch"
+	ReasonInfected            = "infected"
+	ReasonInvalidImage        = "invalid_image"
+	ReasonActiveContent       = "active_content"
+	ReasonArchiveLimit        = "archive_limit"
+	ReasonUnsafeArchiveEntry  = "unsafe_archive_entry"
+)
+
 var (
 	ErrUnauthorized      = NewAppError(http.StatusUnauthorized, "Unauthorized", nil)
-	ErrInvalidFileType   = NewAppError(http.StatusBadRequest, "Invalid file type", nil)
+	ErrForbidden         = NewAppError(http.StatusForbidden, "Insufficient scope", nil)
+	ErrPermissionDenied  = NewAppError(http.StatusForbidden, "Permission denied", nil)
+	ErrInvalidFileType   = NewValidationError(ReasonTypeNotAllowed, "Invalid file type", nil)
 	ErrFileTooLarge      = NewAppError(http.StatusBadRequest, "File too large", nil)
 	ErrFileNotFound      = NewAppError(http.StatusNotFound, "File not found", nil)
+	ErrFolderNotFound    = NewAppError(http.StatusNotFound, "Folder not found", nil)
+	ErrVersionNotFound   = NewAppError(http.StatusNotFound, "Version not found", nil)
+	ErrFolderExists      = NewAppError(http.StatusConflict, "A folder with that name already exists", nil)
+	ErrFolderTooDeep     = NewAppError(http.StatusBadRequest, "Folders cannot be nested that deep", nil)
+	ErrLegalHold         = NewAppError(http.StatusLocked, "File is under legal hold", nil)
+	ErrScanPending       = NewAppError(http.StatusConflict, "File is waiting for a virus scan", nil)
+	ErrScanFailed        = NewAppError(http.StatusConflict, "File could not be scanned for viruses", nil)
+	ErrFileQuarantined   = NewAppError(http.StatusForbidden, "File is quarantined as infected", nil)
+	ErrScanUnavailable   = NewAppError(http.StatusServiceUnavailable, "Virus scanner unavailable", nil)
+	ErrNoThumbnail       = NewAppError(http.StatusNotFound, "Thumbnails are only available for JPEG and PNG images", nil)
+	ErrImageTooLarge     = NewAppError(http.StatusUnprocessableEntity, "Image is too large to process", nil)
+	ErrNotAnImage        = NewAppError(http.StatusBadRequest, "Only JPEG and PNG images can be transformed", nil)
+	ErrNotAnArchive      = NewAppError(http.StatusBadRequest, "File is not a ZIP archive", nil)
+	ErrEntryNotFound     = NewAppError(http.StatusNotFound, "Archive entry not found", nil)
 	ErrInternalServer    = NewAppError(http.StatusInternalServerError, "Internal server error", nil)
 	ErrBadRequest        = NewAppError(http.StatusBadRequest, "Bad request", nil)
 	ErrRateLimitExceeded = NewAppError(http.StatusTooManyRequests, "Rate limit exceeded", nil)
+	ErrQuotaExceeded     = NewAppError(http.StatusInsufficientStorage, "Storage quota exceeded", nil)
+	ErrFileCountExceeded = NewAppError(http.StatusInsufficientStorage, "File count quota exceeded", nil)
 )
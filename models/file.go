@@ -4,6 +4,25 @@ import (
 	"time"
 )
 
+const (
+	ACLTypeUser  = "user"
+	ACLTypeGroup = "group"
+
+	PermissionRead      = "read"
+	PermissionReadWrite = "read+write"
+
+	// Virus scan outcomes. Only clean content, or content uploaded while
+	// scanning was off, can be downloaded.
+	ScanStatusPending  = "pending_scan"
+	ScanStatusClean    = "clean"
+	ScanStatusInfected = "infected"
+	ScanStatusFailed   = "scan_failed"
+
+	DerivedKindThumbnail = "thumbnail"
+	DerivedKindTransform = "transform"
+	DerivedKindManifest  = "manifest"
+)
+
 type FileMetadata struct {
 	ID           string    `json:"id"`
 	OriginalName string    `json:"original_name"`
@@ -12,7 +31,135 @@ type FileMetadata struct {
 	UploadTime   time.Time `json:"upload_time"`
 	URL          string    `json:"url"`
 	Checksum     string    `json:"checksum"`
-	UserID       string    `json:"user_id"`
+	// OriginalChecksum is the checksum of the content as uploaded when
+	// metadata was stripped from it before storing.
+	OriginalChecksum string `json:"original_checksum,omitempty"`
+	UserID           string `json:"user_id"`
+	// Description, Tags and Metadata are set by users at upload or later
+	// through an update. Tags label files for search; Metadata holds
+	// arbitrary application data.
+	Description string            `json:"description,omitempty"`
+	Tags        map[string]string `json:"tags,omitempty"`
+	Metadata    map[string]string `json:"metadata,omitempty"`
+	TenantID    string            `json:"tenant_id,omitempty"`
+	FolderID    string            `json:"folder_id,omitempty"`
+	ExpiresAt   *time.Time        `json:"expires_at,omitempty"`
+	// DeletedAt is set while the file is in its owner's trash; PurgeAt is
+	// when it will be permanently deleted.
+	DeletedAt   *time.Time `json:"deleted_at,omitempty"`
+	DeletedBy   string     `json:"deleted_by,omitempty"`
+	PurgeAt     *time.Time `json:"purge_at,omitempty"`
+	Permissions []ACLEntry `json:"permissions,omitempty"`
+	// Version numbers the current content, starting at 1. UploadedBy is who
+	// uploaded it, which may differ from the owner for shared files.
+	Version    int    `json:"version,omitempty"`
+	UploadedBy string `json:"uploaded_by,omitempty"`
+	// Versions records earlier content, oldest first.
+	Versions []FileVersion `json:"versions,omitempty"`
+	// RetainUntil and LegalHold make the file write-once: it cannot be
+	// deleted, replaced or expired while either is in force.
+	RetainUntil *time.Time `json:"retain_until,omitempty"`
+	LegalHold   *LegalHold `json:"legal_hold,omitempty"`
+	// ScanStatus is the outcome of the virus scan of the current content,
+	// and ScanSignature names the malware found. Empty when scanning is off.
+	ScanStatus    string     `json:"scan_status,omitempty"`
+	ScanSignature string     `json:"scan_signature,omitempty"`
+	ScannedAt     *time.Time `json:"scanned_at,omitempty"`
+	// Derived lists objects generated from the current content, such as
+	// thumbnails.
+	Derived []DerivedObject `json:"derived,omitempty"`
+	// Properties are technical details read from the current content.
+	Properties *FileProperties `json:"properties,omitempty"`
+	// Findings are the risky content flagged by inspection of the current
+	// content; content the policy rejects is never stored.
+	Findings []ContentFinding `json:"findings,omitempty"`
+}
+
+// DerivedObject describes an object generated from a file's content and
+// stored alongside it. It is stale once SourceChecksum no longer matches the
+// file's checksum.
+type DerivedObject struct {
+	Name           string    `json:"name"`
+	Kind           string    `json:"kind"`
+	ContentType    string    `json:"content_type"`
+	Size           int64     `json:"size"`
+	Width          int       `json:"width,omitempty"`
+	Height         int       `json:"height,omitempty"`
+	SourceChecksum string    `json:"source_checksum"`
+	CreatedAt      time.Time `json:"created_at"`
+}
+
+// LegalHold records who placed a hold on a file and why.
+type LegalHold struct {
+	Reason   string    `json:"reason"`
+	PlacedBy string    `json:"placed_by"`
+	PlacedAt time.Time `json:"placed_at"`
+}
+
+// UpdateFileRequest changes a file's user-editable metadata. Omitted fields
+// are left alone. Tags and Metadata are merged into the existing entries; a
+// null value removes that key.
+type UpdateFileRequest struct {
+	Name        *string            `json:"name,omitempty"`
+	Description *string            `json:"description,omitempty"`
+	Tags        map[string]*string `json:"tags,omitempty"`
+	Metadata    map[string]*string `json:"metadata,omitempty"`
+}
+
+type SetRetentionRequest struct {
+	RetainUntil time.Time `json:"retain_until" binding:"required"`
+}
+
+type LegalHoldRequest struct {
+	Reason string `json:"reason" binding:"required"`
+}
+
+// StoredSize is the space the file takes including its earlier versions.
+func (m FileMetadata) StoredSize() int64 {
+	size := m.Size
+	for _, version := range m.Versions {
+		size += version.Size
+	}
+	return size
+}
+
+// FileVersion describes one revision of a file's content.
+type FileVersion struct {
+	Version     int       `json:"version"`
+	Size        int64     `json:"size"`
+	ContentType string    `json:"content_type"`
+	Checksum    string    `json:"checksum"`
+	UploadedBy  string    `json:"uploaded_by"`
+	UploadTime  time.Time `json:"upload_time"`
+	ScanStatus  string    `json:"scan_status,omitempty"`
+	// OriginalChecksum is set when metadata was stripped from the content.
+	OriginalChecksum string           `json:"original_checksum,omitempty"`
+	Properties       *FileProperties  `json:"properties,omitempty"`
+	Findings         []ContentFinding `json:"findings,omitempty"`
+}
+
+type FileVersionListResponse struct {
+	Versions []FileVersion `json:"versions"`
+}
+
+// ACLEntry grants a user or group access to a file owned by someone else.
+type ACLEntry struct {
+	Type       string    `json:"type"`
+	ID         string    `json:"id"`
+	Permission string    `json:"permission"`
+	GrantedBy  string    `json:"granted_by"`
+	GrantedAt  time.Time `json:"granted_at"`
+}
+
+type GrantPermissionRequest struct {
+	Type       string `json:"type" binding:"required"`
+	ID         string `json:"id" binding:"required"`
+	Permission string `json:"permission" binding:"required"`
+}
+
+type FileListResponse struct {
+	Files   []FileMetadata `json:"files"`
+	Folders []Folder       `json:"folders,omitempty"`
 }
 
 type UploadResponse struct {
@@ -22,10 +169,31 @@ type UploadResponse struct {
 	ContentType string    `json:"content_type"`
 	UploadTime  time.Time `json:"upload_time"`
 	Checksum    string    `json:"checksum"`
+	// OriginalChecksum is set when metadata was stripped from the upload.
+	OriginalChecksum string `json:"original_checksum,omitempty"`
+	FolderID         string `json:"folder_id,omitempty"`
+	Version          int    `json:"version,omitempty"`
+	ScanStatus       string `json:"scan_status,omitempty"`
+	// Findings are risky content the PDF policy flagged.
+	Findings []ContentFinding `json:"findings,omitempty"`
+}
+
+// UsageResponse reports a user's storage consumption against their quota.
+// Remaining values are -1 when the corresponding limit is unlimited.
+type UsageResponse struct {
+	UserID         string `json:"user_id"`
+	UsedBytes      int64  `json:"used_bytes"`
+	RemainingBytes int64  `json:"remaining_bytes"`
+	MaxBytes       int64  `json:"max_bytes"`
+	FileCount      int    `json:"file_count"`
+	RemainingFiles int    `json:"remaining_files"`
+	MaxFiles       int    `json:"max_files"`
 }
 
 type ErrorResponse struct {
-	Error   string `json:"error"`
-	Code    int    `json:"code"`
-	Message string `json:"message"`
+	Error   string            `json:"error"`
+	Code    int               `json:"code"`
+	Message string            `json:"message"`
+	Reason  string            `json:"reason,omitempty"`
+	Details map[string]string `json:"details,omitempty"`
 }
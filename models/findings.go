@@ -0,0 +1,23 @@
+package models
+
+// Kinds of content finding.
+const (
+	FindingJavaScript    = "javascript"
+	FindingLaunch        = "launch"
+	FindingOpenAction    = "open_action"
+	FindingEmbeddedFile  = "embedded_file"
+	FindingXFA           = "xfa"
+	FindingMalformedXref = "malformed_xref"
+	// FindingLimitExceeded means inspection stopped before reading the
+	// whole file, so anything could be in the rest.
+	FindingLimitExceeded = "limit_exceeded"
+)
+
+// ContentFinding is something risky found in a file's content by
+// inspection, such as a PDF that runs JavaScript when opened.
+type ContentFinding struct {
+	Kind string `json:"kind"`
+	// Object is the PDF object the finding was first seen in, if any.
+	Object int    `json:"object,omitempty"`
+	Detail string `json:"detail,omitempty"`
+}
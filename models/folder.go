@@ -0,0 +1,49 @@
+package models
+
+import (
+	"time"
+)
+
+// Folder groups files and other folders. Access granted on a folder extends
+// to everything beneath it.
+type Folder struct {
+	ID          string     `json:"id"`
+	Name        string     `json:"name"`
+	ParentID    string     `json:"parent_id,omitempty"`
+	UserID      string     `json:"user_id"`
+	TenantID    string     `json:"tenant_id,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+	Permissions []ACLEntry `json:"permissions,omitempty"`
+}
+
+type CreateFolderRequest struct {
+	Name     string `json:"name" binding:"required"`
+	ParentID string `json:"parent_id,omitempty"`
+}
+
+// UpdateFolderRequest renames and/or moves a folder. A ParentID of "" moves
+// the folder to the caller's root.
+type UpdateFolderRequest struct {
+	Name     *string `json:"name,omitempty"`
+	ParentID *string `json:"parent_id,omitempty"`
+}
+
+// FolderContents lists a folder's direct children. Folder is nil for the
+// caller's root.
+type FolderContents struct {
+	Folder  *Folder        `json:"folder,omitempty"`
+	Folders []Folder       `json:"folders"`
+	Files   []FileMetadata `json:"files"`
+}
+
+// UploadOptions carries optional upload form fields. FolderID and Path are
+// mutually exclusive; Path names a folder relative to the caller's root and
+// missing folders along it are created.
+type UploadOptions struct {
+	FolderID    string
+	Path        string
+	Description string
+	Tags        map[string]string
+	Metadata    map[string]string
+}
@@ -0,0 +1,31 @@
+package models
+
+// Ways an image is fitted to a requested width and height.
+const (
+	// FitContain scales the image to fit within the box, keeping its
+	// aspect ratio.
+	FitContain = "contain"
+	// FitCover scales the image to cover the box, keeping its aspect
+	// ratio, and crops the overflow equally from both sides.
+	FitCover = "cover"
+	// FitFill stretches the image to exactly the box.
+	FitFill = "fill"
+)
+
+// ImageTransform describes a rendered variant of an image. Zero-valued
+// fields keep the original: no Width or Height keeps the size, no Format
+// keeps the type, and no Quality uses the default.
+type ImageTransform struct {
+	Width  int
+	Height int
+	Fit    string
+	// Format is "jpeg" or "png".
+	Format string
+	// Quality is the JPEG quality from 1 to 100. PNG output ignores it.
+	Quality int
+}
+
+// IsZero reports whether the transform asks for nothing at all.
+func (t ImageTransform) IsZero() bool {
+	return t == ImageTransform{}
+}
@@ -0,0 +1,10 @@
+package models
+
+// Principal is the authenticated caller a request acts on behalf of.
+type Principal struct {
+	UserID   string
+	Groups   []string
+	TenantID string
+	// TenantAdmin can read every file in its own tenant, and only there.
+	TenantAdmin bool
+}
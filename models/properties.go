@@ -0,0 +1,51 @@
+package models
+
+import "time"
+
+// FileProperties describes a file's content, as read from the file itself
+// when it was uploaded. Only the section for the file's type is set.
+type FileProperties struct {
+	Image    *ImageProperties    `json:"image,omitempty"`
+	PDF      *PDFProperties      `json:"pdf,omitempty"`
+	Document *DocumentProperties `json:"document,omitempty"`
+}
+
+type ImageProperties struct {
+	Width  int `json:"width"`
+	Height int `json:"height"`
+	// ColorModel is "ycbcr", "cmyk", "gray", "rgba", "paletted" or the like.
+	ColorModel string `json:"color_model"`
+}
+
+type PDFProperties struct {
+	Pages int `json:"pages"`
+	// Title and Author come from the document information dictionary and
+	// are unavailable when the PDF is encrypted.
+	Title     string `json:"title,omitempty"`
+	Author    string `json:"author,omitempty"`
+	Encrypted bool   `json:"encrypted"`
+}
+
+// DocumentProperties holds the core properties of an Office document and
+// the number of words in its text.
+type DocumentProperties struct {
+	Title          string     `json:"title,omitempty"`
+	Subject        string     `json:"subject,omitempty"`
+	Creator        string     `json:"creator,omitempty"`
+	Keywords       string     `json:"keywords,omitempty"`
+	Description    string     `json:"description,omitempty"`
+	LastModifiedBy string     `json:"last_modified_by,omitempty"`
+	Revision       string     `json:"revision,omitempty"`
+	Created        *time.Time `json:"created,omitempty"`
+	Modified       *time.Time `json:"modified,omitempty"`
+	Words          int        `json:"words"`
+}
+
+// PropertyFilter matches files by one of their properties, named by section
+// and field as in "image.width" or "pdf.encrypted".
+type PropertyFilter struct {
+	Property string
+	// Operator is one of =, !=, <, <=, >, >= and ~ (contains).
+	Operator string
+	Value    string
+}
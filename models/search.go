@@ -0,0 +1,30 @@
+package models
+
+import "time"
+
+// SearchQuery finds files by words in their name, tags and description.
+// Zero-valued filters are ignored.
+type SearchQuery struct {
+	Query string
+	// Content matches words in the text extracted from documents.
+	Content string
+	// ContentType is an exact type or a family such as "image/*".
+	ContentType string
+	From        *time.Time
+	To          *time.Time
+	MinSize     int64
+	MaxSize     int64
+	Limit       int
+}
+
+type SearchResult struct {
+	File  FileMetadata `json:"file"`
+	Score float64      `json:"score"`
+}
+
+// SearchResponse holds the best matches, up to the query limit, and the
+// total number of matching files the caller can read.
+type SearchResponse struct {
+	Results []SearchResult `json:"results"`
+	Total   int            `json:"total"`
+}
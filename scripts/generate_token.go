@@ -10,6 +10,7 @@ import (
 
 type Claims struct {
 	UserID string `json:"user_id"`
+	Scope  string `json:"scope,omitempty"`
 	jwt.RegisteredClaims
 }
 
@@ -18,14 +19,17 @@ func main() {
 	userID := "dev-user-123"
 	secret := "your-secret-key-change-in-production"
 	expiration := 24 * time.Hour
+	scope := "files:read files:write files:delete"
 
 	// Create claims
 	claims := &Claims{
 		UserID: userID,
+		Scope:  scope,
 		RegisteredClaims: jwt.RegisteredClaims{
 			ExpiresAt: jwt.NewNumericDate(time.Now().Add(expiration)),
 			IssuedAt:  jwt.NewNumericDate(time.Now()),
 			Issuer:    "fileuploader-service",
+			Audience:  jwt.ClaimStrings{"fileuploader"},
 		},
 	}
 
@@ -38,6 +42,7 @@ func main() {
 
 	fmt.Println("Generated JWT Token for development:")
 	fmt.Println("User ID:", userID)
+	fmt.Println("Scope:", scope)
 	fmt.Println("Expires:", claims.ExpiresAt.Time.Format(time.RFC3339))
 	fmt.Println()
 	fmt.Println("Token:")
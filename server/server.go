@@ -3,7 +3,6 @@ package server
 
 import (
 	"context"
-	"errors"
 	"fmt"
 	"net/http"
 	"os"
@@ -24,23 +23,66 @@ type Server struct {
 	logger  *utils.Logger
 	storage storage.StorageInterface
 	router  *gin.Engine
+	// stopExpiry stops the background purge of expired files
+	stopExpiry func()
+	// stopExtraction stops the background text extraction for search
+	stopExtraction func()
+	// stopScanning stops the background virus scans of large uploads
+	stopScanning func()
+	// stopThumbnails stops the background thumbnail generation
+	stopThumbnails func()
 }
 
-func New(cfg *config.Config, logger *utils.Logger) *Server {
+func New(cfg *config.Config, logger *utils.Logger) (*Server, error) {
 	// Initialize storage
 	localStorage := storage.NewLocalStorage(cfg.Upload.StoragePath)
 
 	// Initialize services
-	authService := services.NewAuthService(cfg)
-	uploadService := services.NewUploadService(cfg, localStorage, logger)
+	keySet, err := services.NewKeySet(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load token verification keys: %w", err)
+	}
+	revocations, err := services.NewFileRevocationStore(cfg.Auth.RevocationStorePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load token revocations: %w", err)
+	}
+	authService := services.NewAuthService(cfg).WithKeySet(keySet).WithRevocations(revocations)
+	apiKeyService, err := services.NewAPIKeyService(cfg, logger)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load API keys: %w", err)
+	}
+	auditLog := services.NewAuditLog(cfg.Compliance.AuditLogPath, logger)
+	uploadService := services.NewUploadService(cfg, localStorage, logger).WithAuditLog(auditLog)
+	if cfg.Scan.ClamdAddress != "" {
+		if err := os.MkdirAll(cfg.Scan.QuarantinePath, 0700); err != nil {
+			return nil, fmt.Errorf("failed to create quarantine directory: %w", err)
+		}
+		scanner := services.NewClamdScanner(cfg.Scan.ClamdAddress, cfg.Scan.Timeout)
+		uploadService.WithScanner(scanner, storage.NewLocalStorage(cfg.Scan.QuarantinePath))
+	}
+
+	transformCache, err := services.NewTransformCache(cfg.Images.CachePath, cfg.Images.CacheSize)
+	if err != nil {
+		return nil, err
+	}
+	uploadService.WithTransformCache(transformCache)
 
 	// Initialize handlers
 	uploadHandler := handlers.NewUploadHandler(uploadService, logger)
 	downloadHandler := handlers.NewDownloadHandler(uploadService, logger)
+	fileHandler := handlers.NewFileHandler(uploadService, logger)
+	folderHandler := handlers.NewFolderHandler(uploadService, logger)
+	versionHandler := handlers.NewVersionHandler(uploadService, logger)
+	trashHandler := handlers.NewTrashHandler(uploadService, logger)
+	searchHandler := handlers.NewSearchHandler(uploadService, logger)
+	usageHandler := handlers.NewUsageHandler(uploadService, logger)
+	apiKeyHandler := handlers.NewAPIKeyHandler(apiKeyService, logger)
+	authHandler := handlers.NewAuthHandler(authService, logger)
 	healthHandler := handlers.NewHealthHandler()
 
 	// Initialize middleware
-	middleware := handlers.NewMiddleware(authService, logger, cfg.RateLimit.RequestsPerMinute)
+	certAuthService := services.NewCertAuthService(cfg)
+	middleware := handlers.NewMiddleware(authService, apiKeyService, certAuthService, logger, cfg.RateLimit.RequestsPerMinute)
 
 	// Setup Gin
 	gin.SetMode(gin.ReleaseMode)
@@ -60,8 +102,52 @@ func New(cfg *config.Config, logger *utils.Logger) *Server {
 	api.Use(middleware.AuthMiddleware())
 	api.Use(middleware.RateLimitMiddleware())
 	{
-		api.POST("/upload", uploadHandler.Upload)
-		api.GET("/files/:id", downloadHandler.GetFile)
+		api.POST("/upload", middleware.RequireScope(services.ScopeFilesWrite), uploadHandler.Upload)
+		api.GET("/files/:id", middleware.RequireScope(services.ScopeFilesRead), downloadHandler.GetFile)
+		api.GET("/files", middleware.RequireScope(services.ScopeFilesRead), fileHandler.ListFiles)
+		api.PATCH("/files/:id", middleware.RequireScope(services.ScopeFilesWrite), fileHandler.UpdateFile)
+		api.DELETE("/files/:id", middleware.RequireScope(services.ScopeFilesDelete), fileHandler.DeleteFile)
+		api.GET("/files/:id/thumbnail", middleware.RequireScope(services.ScopeFilesRead), downloadHandler.GetThumbnail)
+		api.GET("/files/:id/entries", middleware.RequireScope(services.ScopeFilesRead), downloadHandler.ListEntries)
+		api.GET("/files/:id/entries/*path", middleware.RequireScope(services.ScopeFilesRead), downloadHandler.GetEntry)
+		api.PUT("/files/:id/content", middleware.RequireScope(services.ScopeFilesWrite), versionHandler.ReplaceContent)
+		api.GET("/files/:id/versions", middleware.RequireScope(services.ScopeFilesRead), versionHandler.List)
+		api.GET("/files/:id/versions/:version", middleware.RequireScope(services.ScopeFilesRead), downloadHandler.GetVersion)
+		api.POST("/files/:id/versions/:version/restore", middleware.RequireScope(services.ScopeFilesWrite), versionHandler.Restore)
+		api.DELETE("/files/:id/versions", middleware.RequireScope(services.ScopeFilesDelete), versionHandler.Prune)
+		api.PUT("/files/:id/retention", middleware.RequireScope(services.ScopeFilesWrite), fileHandler.SetRetention)
+		api.POST("/files/:id/permissions", middleware.RequireScope(services.ScopeFilesWrite), fileHandler.GrantPermission)
+		api.DELETE("/files/:id/permissions", middleware.RequireScope(services.ScopeFilesWrite), fileHandler.RevokePermission)
+		api.POST("/folders", middleware.RequireScope(services.ScopeFilesWrite), folderHandler.Create)
+		api.GET("/folders", middleware.RequireScope(services.ScopeFilesRead), folderHandler.Get)
+		api.GET("/folders/:id", middleware.RequireScope(services.ScopeFilesRead), folderHandler.Get)
+		api.PATCH("/folders/:id", middleware.RequireScope(services.ScopeFilesWrite), folderHandler.Update)
+		api.DELETE("/folders/:id", middleware.RequireScope(services.ScopeFilesDelete), folderHandler.Delete)
+		api.POST("/folders/:id/permissions", middleware.RequireScope(services.ScopeFilesWrite), folderHandler.GrantPermission)
+		api.DELETE("/folders/:id/permissions", middleware.RequireScope(services.ScopeFilesWrite), folderHandler.RevokePermission)
+		api.GET("/fs/*path", middleware.RequireScope(services.ScopeFilesRead), downloadHandler.GetByPath)
+		api.GET("/trash", middleware.RequireScope(services.ScopeFilesRead), trashHandler.List)
+		api.POST("/trash/:id/restore", middleware.RequireScope(services.ScopeFilesWrite), trashHandler.Restore)
+		api.DELETE("/trash/:id", middleware.RequireScope(services.ScopeFilesDelete), trashHandler.Purge)
+		api.DELETE("/trash", middleware.RequireScope(services.ScopeFilesDelete), trashHandler.Empty)
+		api.GET("/shared", middleware.RequireScope(services.ScopeFilesRead), fileHandler.ListShared)
+		api.GET("/search", middleware.RequireScope(services.ScopeFilesRead), searchHandler.Search)
+		api.GET("/usage", middleware.RequireScope(services.ScopeFilesRead), usageHandler.GetUsage)
+		api.POST("/auth/logout", authHandler.Logout)
+		api.GET("/tenant/files", middleware.RequireScope(services.ScopeTenantAdmin), fileHandler.ListTenantFiles)
+	}
+
+	// Administrative routes
+	admin := api.Group("/admin")
+	admin.Use(middleware.RequireScope(services.ScopeAdmin))
+	{
+		admin.POST("/apikeys", apiKeyHandler.Create)
+		admin.GET("/apikeys", apiKeyHandler.List)
+		admin.DELETE("/apikeys/:id", apiKeyHandler.Revoke)
+		admin.POST("/revocations", authHandler.Revoke)
+		admin.POST("/files/:id/legal-hold", fileHandler.PlaceLegalHold)
+		admin.DELETE("/files/:id/legal-hold", fileHandler.ReleaseLegalHold)
+		admin.POST("/search/rebuild", searchHandler.RebuildIndex)
 	}
 
 	// Direct file access (backward compatibility)
@@ -69,15 +155,19 @@ func New(cfg *config.Config, logger *utils.Logger) *Server {
 	files.Use(middleware.AuthMiddleware())
 	files.Use(middleware.RateLimitMiddleware())
 	{
-		files.GET("/:id", downloadHandler.GetFile)
+		files.GET("/:id", middleware.RequireScope(services.ScopeFilesRead), downloadHandler.GetFile)
 	}
 
 	return &Server{
-		config:  cfg,
-		logger:  logger,
-		storage: localStorage,
-		router:  router,
-	}
+		config:         cfg,
+		logger:         logger,
+		storage:        localStorage,
+		router:         router,
+		stopExpiry:     uploadService.StartExpiry(cfg.Upload.ExpiryInterval),
+		stopExtraction: uploadService.StartExtraction(cfg.Search.ExtractionWorkers),
+		stopScanning:   uploadService.StartScanning(cfg.Scan.Workers),
+		stopThumbnails: uploadService.StartThumbnails(cfg.Images.ThumbnailWorkers),
+	}, nil
 }
 
 func (s *Server) Router() *gin.Engine {
@@ -95,24 +185,36 @@ func (s *Server) Start(addr string) error {
 // 2. Add a Shutdown method to Server
 func (s *Server) Shutdown(ctx context.Context) error {
 	s.logger.Info("Shutting down server...")
-	// Add any cleanup logic here
+	s.stopExpiry()
+	s.stopExtraction()
+	s.stopScanning()
+	s.stopThumbnails()
 	return nil
 }
 
 // 3. Consider adding a Run method that handles the full server lifecycle
 func (s *Server) Run(addr string) error {
-	httpServer := &http.Server{
-		Addr:    addr,
-		Handler: s.router,
+	httpServer, err := NewHTTPServer(s.config, s.router, s.logger)
+	if err != nil {
+		return err
 	}
+	httpServer.Addr = addr
+	redirectServer := NewRedirectServer(s.config)
 
-	// Start server in goroutine
-	errChan := make(chan error, 1)
+	// Start servers in goroutines
+	errChan := make(chan error, 2)
 	go func() {
-		if err := httpServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		if err := ListenAndServe(httpServer); err != nil {
 			errChan <- err
 		}
 	}()
+	if redirectServer != nil {
+		go func() {
+			if err := ListenAndServe(redirectServer); err != nil {
+				errChan <- err
+			}
+		}()
+	}
 
 	// Wait for interrupt signal or server error
 	quit := make(chan os.Signal, 1)
@@ -129,5 +231,12 @@ func (s *Server) Run(addr string) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
+	if redirectServer != nil {
+		redirectServer.Shutdown(ctx)
+	}
+	s.stopExpiry()
+	s.stopExtraction()
+	s.stopScanning()
+	s.stopThumbnails()
 	return httpServer.Shutdown(ctx)
 }
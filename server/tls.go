@@ -0,0 +1,238 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/ebinskryfon/fileuploader/config"
+	"github.com/ebinskryfon/fileuploader/utils"
+)
+
+// modernCipherSuites are the TLS 1.2 suites allowed by the "modern" policy:
+// ECDHE key exchange with AEAD ciphers only. TLS 1.3 suites are not
+// configurable and are always enabled.
+var modernCipherSuites = []uint16{
+	tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+	tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+	tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+	tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+	tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256,
+	tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256,
+}
+
+// CertReloader serves the certificate from disk and picks up rotated files
+// without a restart. The files are checked at most once per interval during
+// handshakes; if a reload fails (for example because only one of the pair
+// has been replaced so far) the previous certificate keeps being served.
+type CertReloader struct {
+	certFile string
+	keyFile  string
+	interval time.Duration
+	logger   *utils.Logger
+
+	mu        sync.Mutex
+	cert      *tls.Certificate
+	modTime   time.Time
+	checkedAt time.Time
+}
+
+func NewCertReloader(certFile, keyFile string, interval time.Duration, logger *utils.Logger) (*CertReloader, error) {
+	r := &CertReloader{
+		certFile: certFile,
+		keyFile:  keyFile,
+		interval: interval,
+		logger:   logger,
+	}
+	if err := r.load(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *CertReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.interval > 0 && time.Since(r.checkedAt) >= r.interval {
+		r.checkedAt = time.Now()
+		if modTime, err := r.latestModTime(); err == nil && modTime.After(r.modTime) {
+			if err := r.loadLocked(); err != nil {
+				r.logger.Warn("Failed to reload TLS certificate", map[string]interface{}{
+					"cert_file": r.certFile,
+					"error":     err.Error(),
+				})
+			} else {
+				r.logger.Info("Reloaded TLS certificate", map[string]interface{}{
+					"cert_file": r.certFile,
+				})
+			}
+		}
+	}
+
+	return r.cert, nil
+}
+
+func (r *CertReloader) load() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.loadLocked()
+}
+
+// loadLocked reads the key pair from disk. Callers must hold r.mu.
+func (r *CertReloader) loadLocked() error {
+	modTime, err := r.latestModTime()
+	if err != nil {
+		return err
+	}
+
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return fmt.Errorf("failed to load TLS certificate: %w", err)
+	}
+
+	r.cert = &cert
+	r.modTime = modTime
+	r.checkedAt = time.Now()
+	return nil
+}
+
+func (r *CertReloader) latestModTime() (time.Time, error) {
+	var latest time.Time
+	for _, path := range []string{r.certFile, r.keyFile} {
+		info, err := os.Stat(path)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("failed to stat %s: %w", path, err)
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest, nil
+}
+
+// TLSConfig builds the server TLS configuration, or returns nil when TLS is
+// disabled. When a client CA bundle is configured, client certificates are
+// verified against it and surface to AuthMiddleware as identities.
+func TLSConfig(cfg *config.Config, logger *utils.Logger) (*tls.Config, error) {
+	if !cfg.TLS.Enabled {
+		return nil, nil
+	}
+
+	reloader, err := NewCertReloader(cfg.TLS.CertFile, cfg.TLS.KeyFile, cfg.TLS.ReloadInterval, logger)
+	if err != nil {
+		return nil, err
+	}
+
+	tlsConfig := &tls.Config{
+		GetCertificate: reloader.GetCertificate,
+	}
+
+	switch cfg.TLS.MinVersion {
+	case "1.2", "":
+		tlsConfig.MinVersion = tls.VersionTLS12
+	case "1.3":
+		tlsConfig.MinVersion = tls.VersionTLS13
+	default:
+		return nil, fmt.Errorf("unsupported TLS min version: %s", cfg.TLS.MinVersion)
+	}
+
+	switch cfg.TLS.CipherPolicy {
+	case "modern", "":
+		tlsConfig.CipherSuites = modernCipherSuites
+	case "compatible":
+	default:
+		return nil, fmt.Errorf("unknown TLS cipher policy: %s", cfg.TLS.CipherPolicy)
+	}
+
+	if cfg.TLS.ClientCAFile != "" {
+		pem, err := os.ReadFile(cfg.TLS.ClientCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read client CA bundle: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", cfg.TLS.ClientCAFile)
+		}
+		tlsConfig.ClientCAs = pool
+
+		switch cfg.TLS.ClientAuth {
+		case "require":
+			tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+		case "optional", "":
+			tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+		default:
+			return nil, fmt.Errorf("unknown client auth mode: %s", cfg.TLS.ClientAuth)
+		}
+	}
+
+	return tlsConfig, nil
+}
+
+// NewHTTPServer builds the main HTTP server for handler, configured for TLS
+// and HTTP/2 according to cfg.
+func NewHTTPServer(cfg *config.Config, handler http.Handler, logger *utils.Logger) (*http.Server, error) {
+	tlsConfig, err := TLSConfig(cfg, logger)
+	if err != nil {
+		return nil, err
+	}
+
+	protocols := new(http.Protocols)
+	protocols.SetHTTP1(true)
+	protocols.SetHTTP2(tlsConfig != nil && cfg.TLS.HTTP2)
+
+	return &http.Server{
+		Addr:         ":" + cfg.Server.Port,
+		Handler:      handler,
+		ReadTimeout:  cfg.Server.ReadTimeout,
+		WriteTimeout: cfg.Server.WriteTimeout,
+		TLSConfig:    tlsConfig,
+		Protocols:    protocols,
+	}, nil
+}
+
+// NewRedirectServer returns a plain HTTP server that redirects every request
+// to the HTTPS listener, or nil when TLS or the redirect port is not enabled.
+func NewRedirectServer(cfg *config.Config) *http.Server {
+	if !cfg.TLS.Enabled || cfg.TLS.RedirectPort == "" {
+		return nil
+	}
+
+	httpsPort := cfg.Server.Port
+	return &http.Server{
+		Addr:              ":" + cfg.TLS.RedirectPort,
+		ReadHeaderTimeout: 10 * time.Second,
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			host, _, err := net.SplitHostPort(r.Host)
+			if err != nil {
+				host = r.Host
+			}
+			target := "https://" + net.JoinHostPort(host, httpsPort) + r.URL.RequestURI()
+			if httpsPort == "443" {
+				target = "https://" + host + r.URL.RequestURI()
+			}
+			http.Redirect(w, r, target, http.StatusPermanentRedirect)
+		}),
+	}
+}
+
+// ListenAndServe serves httpServer over TLS when it has a TLS config and over
+// plain HTTP otherwise.
+func ListenAndServe(httpServer *http.Server) error {
+	var err error
+	if httpServer.TLSConfig != nil {
+		err = httpServer.ListenAndServeTLS("", "")
+	} else {
+		err = httpServer.ListenAndServe()
+	}
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,237 @@
+package services
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ebinskryfon/fileuploader/config"
+	"github.com/ebinskryfon/fileuploader/models"
+	"github.com/ebinskryfon/fileuploader/utils"
+)
+
+const (
+	apiKeyPrefix = "fu"
+	// lastUsedPersistInterval bounds how often last-used updates hit disk.
+	lastUsedPersistInterval = time.Minute
+)
+
+var validScopes = []string{ScopeFilesRead, ScopeFilesWrite, ScopeFilesDelete, ScopeTenantAdmin, ScopeAdmin}
+
+type apiKeyRecord struct {
+	models.APIKey
+	Hash string `json:"hash"`
+}
+
+// APIKeyService manages API keys for clients that cannot mint JWTs. Keys
+// have the form fu_<prefix>_<secret>; only a SHA-256 hash of the full key is
+// stored, and the prefix is used to find the record to compare against.
+type APIKeyService struct {
+	path   string
+	logger *utils.Logger
+
+	mu        sync.Mutex
+	keys      map[string]*apiKeyRecord // by prefix
+	persisted time.Time
+}
+
+func NewAPIKeyService(cfg *config.Config, logger *utils.Logger) (*APIKeyService, error) {
+	s := &APIKeyService{
+		path:   cfg.Auth.APIKeyStorePath,
+		logger: logger,
+		keys:   make(map[string]*apiKeyRecord),
+	}
+
+	data, err := os.ReadFile(s.path)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read API key store: %v", err)
+	}
+
+	var records []*apiKeyRecord
+	if err := json.Unmarshal(data, &records); err != nil {
+		return nil, fmt.Errorf("failed to decode API key store: %v", err)
+	}
+	for _, record := range records {
+		s.keys[record.Prefix] = record
+	}
+
+	return s, nil
+}
+
+func (s *APIKeyService) Create(req models.CreateAPIKeyRequest, createdBy string) (*models.CreateAPIKeyResponse, *models.AppError) {
+	if len(req.Scopes) == 0 {
+		return nil, models.NewAppError(http.StatusBadRequest, "At least one scope is required", nil)
+	}
+	for _, scope := range req.Scopes {
+		if !slices.Contains(validScopes, scope) {
+			return nil, models.NewAppError(http.StatusBadRequest, "Unknown scope: "+scope, nil)
+		}
+	}
+	if req.ExpiresAt != nil && !req.ExpiresAt.After(time.Now()) {
+		return nil, models.NewAppError(http.StatusBadRequest, "Expiry must be in the future", nil)
+	}
+
+	prefix, err := randomToken(6, hex.EncodeToString)
+	if err != nil {
+		return nil, models.ErrInternalServer
+	}
+	secret, err := randomToken(32, base64.RawURLEncoding.EncodeToString)
+	if err != nil {
+		return nil, models.ErrInternalServer
+	}
+	key := apiKeyPrefix + "_" + prefix + "_" + secret
+
+	record := &apiKeyRecord{
+		APIKey: models.APIKey{
+			ID:        utils.GenerateUUID(),
+			Name:      req.Name,
+			Prefix:    prefix,
+			UserID:    req.UserID,
+			TenantID:  req.TenantID,
+			Scopes:    req.Scopes,
+			CreatedAt: time.Now().UTC(),
+			CreatedBy: createdBy,
+			ExpiresAt: req.ExpiresAt,
+		},
+		Hash: hashAPIKey(key),
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.keys[prefix] = record
+	if err := s.persistLocked(); err != nil {
+		delete(s.keys, prefix)
+		s.logger.Error("Failed to persist API key", map[string]interface{}{
+			"key_id": record.ID,
+			"error":  err.Error(),
+		})
+		return nil, models.ErrInternalServer
+	}
+
+	return &models.CreateAPIKeyResponse{APIKey: record.APIKey, Key: key}, nil
+}
+
+func (s *APIKeyService) List() []models.APIKey {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	keys := make([]models.APIKey, 0, len(s.keys))
+	for _, record := range s.keys {
+		keys = append(keys, record.APIKey)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].CreatedAt.Before(keys[j].CreatedAt)
+	})
+	return keys
+}
+
+func (s *APIKeyService) Revoke(id string) *models.AppError {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, record := range s.keys {
+		if record.ID != id {
+			continue
+		}
+		if record.RevokedAt == nil {
+			now := time.Now().UTC()
+			record.RevokedAt = &now
+			if err := s.persistLocked(); err != nil {
+				record.RevokedAt = nil
+				s.logger.Error("Failed to persist API key revocation", map[string]interface{}{
+					"key_id": id,
+					"error":  err.Error(),
+				})
+				return models.ErrInternalServer
+			}
+		}
+		return nil
+	}
+
+	return models.NewAppError(http.StatusNotFound, "API key not found", nil)
+}
+
+// Validate returns the key record for a presented API key, recording its
+// use. Unknown, revoked and expired keys are rejected.
+func (s *APIKeyService) Validate(key string) (*models.APIKey, error) {
+	parts := strings.SplitN(key, "_", 3)
+	if len(parts) != 3 || parts[0] != apiKeyPrefix {
+		return nil, fmt.Errorf("malformed API key")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	record, ok := s.keys[parts[1]]
+	if !ok || subtle.ConstantTimeCompare([]byte(record.Hash), []byte(hashAPIKey(key))) != 1 {
+		return nil, fmt.Errorf("unknown API key")
+	}
+	if record.RevokedAt != nil {
+		return nil, fmt.Errorf("API key %s is revoked", record.ID)
+	}
+
+	now := time.Now().UTC()
+	if record.ExpiresAt != nil && now.After(*record.ExpiresAt) {
+		return nil, fmt.Errorf("API key %s has expired", record.ID)
+	}
+
+	record.LastUsedAt = &now
+	if now.Sub(s.persisted) > lastUsedPersistInterval {
+		if err := s.persistLocked(); err != nil {
+			s.logger.Warn("Failed to persist API key usage", map[string]interface{}{
+				"key_id": record.ID,
+				"error":  err.Error(),
+			})
+		}
+	}
+
+	apiKey := record.APIKey
+	return &apiKey, nil
+}
+
+// persistLocked atomically rewrites the key store. Callers must hold s.mu.
+func (s *APIKeyService) persistLocked() error {
+	records := make([]*apiKeyRecord, 0, len(s.keys))
+	for _, record := range s.keys {
+		records = append(records, record)
+	}
+
+	data, err := json.MarshalIndent(records, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := utils.WriteFileAtomic(s.path, data, 0600); err != nil {
+		return err
+	}
+	s.persisted = time.Now()
+	return nil
+}
+
+func hashAPIKey(key string) string {
+	hash := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(hash[:])
+}
+
+func randomToken(size int, encode func([]byte) string) (string, error) {
+	buf := make([]byte, size)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return encode(buf), nil
+}
@@ -0,0 +1,337 @@
+package services
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/fs"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ebinskryfon/fileuploader/config"
+	"github.com/ebinskryfon/fileuploader/models"
+)
+
+const (
+	defaultMaxArchiveEntries          = 10000
+	defaultMaxArchiveUncompressedSize = 1 << 30
+	defaultMaxCompressionRatio        = 100
+
+	// minRatioCheckedSize exempts small archives from the compression ratio
+	// limit: highly compressible files are common, and can't do harm
+	// unless they expand to something large.
+	minRatioCheckedSize = 1 << 20
+
+	// manifestName is the derived object holding a ZIP file's entries.
+	manifestName = "manifest"
+)
+
+// ArchiveLimits bound what a ZIP archive may expand to. Zero values use the
+// defaults.
+type ArchiveLimits struct {
+	MaxEntries          int
+	MaxUncompressedSize int64
+	MaxCompressionRatio int
+}
+
+// NewArchiveLimits reads the archive limits from cfg.
+func NewArchiveLimits(cfg *config.Config) ArchiveLimits {
+	limits := ArchiveLimits{
+		MaxEntries:          cfg.Archives.MaxEntries,
+		MaxUncompressedSize: cfg.Archives.MaxUncompressedSize,
+		MaxCompressionRatio: cfg.Archives.MaxCompressionRatio,
+	}
+	if limits.MaxEntries <= 0 {
+		limits.MaxEntries = defaultMaxArchiveEntries
+	}
+	if limits.MaxUncompressedSize <= 0 {
+		limits.MaxUncompressedSize = defaultMaxArchiveUncompressedSize
+	}
+	if limits.MaxCompressionRatio <= 0 {
+		limits.MaxCompressionRatio = defaultMaxCompressionRatio
+	}
+	return limits
+}
+
+// isZipFamily reports whether contentType is stored as a ZIP archive.
+func isZipFamily(contentType string) bool {
+	return contentType == contentTypeZip || isZipContainerType(contentType)
+}
+
+// InspectArchive walks a ZIP archive's central directory, which is size
+// bytes long, and returns its entries. Archives with too many entries, that
+// expand too far, or with entries that could escape an extraction directory
+// are rejected. Nothing is decompressed: readers of the entries fail if an
+// entry turns out larger than its declared size.
+func InspectArchive(r io.ReaderAt, size int64, limits ArchiveLimits) ([]models.ArchiveEntry, *models.AppError) {
+	archive, err := zip.NewReader(r, size)
+	if errors.Is(err, zip.ErrInsecurePath) {
+		return nil, models.NewValidationError(models.ReasonUnsafeArchiveEntry, "Archive contains an entry outside the archive", nil)
+	}
+	if err != nil {
+		return nil, models.NewValidationError(models.ReasonInvalidContainer, "File is not a valid ZIP archive", nil)
+	}
+
+	if len(archive.File) > limits.MaxEntries {
+		return nil, models.NewValidationError(models.ReasonArchiveLimit, "Archive has too many entries",
+			map[string]string{"limit": "entries", "max": strconv.Itoa(limits.MaxEntries)})
+	}
+
+	var total uint64
+	for _, file := range archive.File {
+		if !safeArchivePath(file.Name) {
+			return nil, models.NewValidationError(models.ReasonUnsafeArchiveEntry, "Archive contains an entry outside the archive",
+				map[string]string{"entry": file.Name})
+		}
+		if file.Mode()&fs.ModeSymlink != 0 {
+			return nil, models.NewValidationError(models.ReasonUnsafeArchiveEntry, "Archive contains a symbolic link",
+				map[string]string{"entry": file.Name})
+		}
+
+		// Checked entry by entry so the total can't overflow
+		total += file.UncompressedSize64
+		if file.UncompressedSize64 > uint64(limits.MaxUncompressedSize) || total > uint64(limits.MaxUncompressedSize) {
+			return nil, models.NewValidationError(models.ReasonArchiveLimit, "Archive expands to more than the allowed size",
+				map[string]string{"limit": "uncompressed_size", "max": strconv.FormatInt(limits.MaxUncompressedSize, 10)})
+		}
+	}
+
+	if total > minRatioCheckedSize && size > 0 && total/uint64(size) >= uint64(limits.MaxCompressionRatio) {
+		return nil, models.NewValidationError(models.ReasonArchiveLimit, "Archive is compressed more than the allowed ratio",
+			map[string]string{"limit": "compression_ratio", "max": strconv.Itoa(limits.MaxCompressionRatio)})
+	}
+
+	return archiveManifest(archive), nil
+}
+
+// safeArchivePath reports whether an entry name stays inside the directory
+// it would be extracted to, on Unix and Windows alike.
+func safeArchivePath(name string) bool {
+	name = strings.ReplaceAll(name, `\`, "/")
+	if name == "" || strings.HasPrefix(name, "/") || strings.ContainsRune(name, 0) {
+		return false
+	}
+	// Drive letters, as in C:/Windows
+	if len(name) >= 2 && name[1] == ':' {
+		return false
+	}
+	return !slices.Contains(strings.Split(name, "/"), "..")
+}
+
+func archiveManifest(archive *zip.Reader) []models.ArchiveEntry {
+	entries := make([]models.ArchiveEntry, 0, len(archive.File))
+	for _, file := range archive.File {
+		entries = append(entries, models.ArchiveEntry{
+			Name:           file.Name,
+			Size:           int64(file.UncompressedSize64),
+			CompressedSize: int64(file.CompressedSize64),
+			Modified:       file.Modified.UTC(),
+			Directory:      file.Mode().IsDir(),
+		})
+	}
+	return entries
+}
+
+// recordManifest stores the entries of a ZIP file as a derived object. The
+// manifest can be rebuilt from the content, so failures are only logged.
+// The caller must hold the tenant's contentMu.
+func (u *UploadService) recordManifest(tenant *tenantServices, metadata *models.FileMetadata, content []byte) {
+	if tenant.validation.Canonical(metadata.ContentType) != contentTypeZip {
+		return
+	}
+
+	entries, appError := InspectArchive(bytes.NewReader(content), int64(len(content)), tenant.validation.archives)
+	if appError != nil {
+		u.logger.Warn("Failed to record archive manifest", map[string]interface{}{
+			"file_id":   metadata.ID,
+			"tenant_id": tenant.id,
+			"error":     appError.Message,
+		})
+		return
+	}
+
+	data, err := json.Marshal(entries)
+	if err == nil {
+		err = tenant.storage.StoreDerived(metadata.ID, manifestName, bytes.NewReader(data))
+	}
+	if err == nil {
+		metadata.Derived = slices.DeleteFunc(metadata.Derived, func(d models.DerivedObject) bool {
+			return d.Name == manifestName
+		})
+		metadata.Derived = append(metadata.Derived, models.DerivedObject{
+			Name:           manifestName,
+			Kind:           models.DerivedKindManifest,
+			ContentType:    "application/json",
+			Size:           int64(len(data)),
+			SourceChecksum: metadata.Checksum,
+			CreatedAt:      time.Now().UTC(),
+		})
+		err = tenant.storage.UpdateMetadata(metadata.ID, *metadata)
+	}
+	if err != nil {
+		u.logger.Warn("Failed to record archive manifest", map[string]interface{}{
+			"file_id":   metadata.ID,
+			"tenant_id": tenant.id,
+			"error":     err.Error(),
+		})
+	}
+}
+
+// ListArchiveEntries returns the entries of a ZIP file.
+func (u *UploadService) ListArchiveEntries(fileID string, principal models.Principal) ([]models.ArchiveEntry, *models.AppError) {
+	tenant, metadata, appError := u.authorizeArchive(fileID, principal)
+	if appError != nil {
+		return nil, appError
+	}
+
+	if slices.ContainsFunc(metadata.Derived, func(d models.DerivedObject) bool {
+		return d.Name == manifestName && d.SourceChecksum == metadata.Checksum
+	}) {
+		file, err := tenant.storage.RetrieveDerived(fileID, manifestName)
+		if err == nil {
+			var entries []models.ArchiveEntry
+			err = json.NewDecoder(file).Decode(&entries)
+			file.Close()
+			if err == nil {
+				return entries, nil
+			}
+		}
+		u.logger.Warn("Stored archive manifest unreadable, rebuilding", map[string]interface{}{
+			"file_id":   fileID,
+			"tenant_id": tenant.id,
+			"error":     err.Error(),
+		})
+	}
+
+	archive, closer, appError := u.openArchive(tenant, fileID)
+	if appError != nil {
+		return nil, appError
+	}
+	defer closer.Close()
+	return archiveManifest(archive), nil
+}
+
+// GetArchiveEntry streams a single member of a ZIP file, decompressing only
+// that member.
+func (u *UploadService) GetArchiveEntry(fileID, name string, principal models.Principal) (io.ReadCloser, models.ArchiveEntry, *models.AppError) {
+	tenant, _, appError := u.authorizeArchive(fileID, principal)
+	if appError != nil {
+		return nil, models.ArchiveEntry{}, appError
+	}
+
+	archive, closer, appError := u.openArchive(tenant, fileID)
+	if appError != nil {
+		return nil, models.ArchiveEntry{}, appError
+	}
+
+	index := slices.IndexFunc(archive.File, func(file *zip.File) bool {
+		return file.Name == name && !file.Mode().IsDir() && file.Mode()&fs.ModeSymlink == 0
+	})
+	if index < 0 || !safeArchivePath(name) {
+		closer.Close()
+		return nil, models.ArchiveEntry{}, models.ErrEntryNotFound
+	}
+	file := archive.File[index]
+
+	reader, err := file.Open()
+	if err != nil {
+		closer.Close()
+		u.logger.Warn("Failed to open archive entry", map[string]interface{}{
+			"file_id":   fileID,
+			"tenant_id": tenant.id,
+			"entry":     name,
+			"error":     err.Error(),
+		})
+		return nil, models.ArchiveEntry{}, models.NewAppError(http.StatusUnprocessableEntity, "Archive entry cannot be read", err)
+	}
+
+	entry := models.ArchiveEntry{
+		Name:           file.Name,
+		Size:           int64(file.UncompressedSize64),
+		CompressedSize: int64(file.CompressedSize64),
+		Modified:       file.Modified.UTC(),
+	}
+	return &archiveEntryReader{ReadCloser: reader, archive: closer}, entry, nil
+}
+
+// authorizeArchive checks that principal may read fileID and that it is a
+// ZIP file whose content may be served.
+func (u *UploadService) authorizeArchive(fileID string, principal models.Principal) (*tenantServices, models.FileMetadata, *models.AppError) {
+	tenant, appError := u.tenant(principal.TenantID)
+	if appError != nil {
+		return nil, models.FileMetadata{}, appError
+	}
+
+	metadata, appError := u.authorize(tenant, fileID, principal, accessRead)
+	if appError != nil {
+		return nil, models.FileMetadata{}, appError
+	}
+
+	if appError := scanBlock(metadata.ScanStatus); appError != nil {
+		return nil, models.FileMetadata{}, appError
+	}
+
+	if tenant.validation.Canonical(metadata.ContentType) != contentTypeZip {
+		return nil, models.FileMetadata{}, models.ErrNotAnArchive
+	}
+	return tenant, metadata, nil
+}
+
+// openArchive opens a stored ZIP file for random access. The closer releases
+// the stored content.
+func (u *UploadService) openArchive(tenant *tenantServices, fileID string) (*zip.Reader, io.Closer, *models.AppError) {
+	file, _, err := tenant.storage.Retrieve(fileID)
+	if err != nil {
+		return nil, nil, models.ErrFileNotFound
+	}
+
+	var readerAt io.ReaderAt
+	var size int64
+	if stored, ok := file.(interface {
+		io.ReaderAt
+		io.Seeker
+	}); ok {
+		readerAt = stored
+		size, err = stored.Seek(0, io.SeekEnd)
+	} else {
+		// Storage without random access; fall back to memory
+		var data []byte
+		data, err = io.ReadAll(file)
+		readerAt, size = bytes.NewReader(data), int64(len(data))
+	}
+	if err != nil {
+		file.Close()
+		return nil, nil, models.ErrInternalServer
+	}
+
+	archive, err := zip.NewReader(readerAt, size)
+	if err != nil && !errors.Is(err, zip.ErrInsecurePath) {
+		file.Close()
+		u.logger.Error("Stored archive unreadable", map[string]interface{}{
+			"file_id":   fileID,
+			"tenant_id": tenant.id,
+			"error":     err.Error(),
+		})
+		return nil, nil, models.ErrInternalServer
+	}
+	return archive, file, nil
+}
+
+// archiveEntryReader closes the stored archive along with the entry.
+type archiveEntryReader struct {
+	io.ReadCloser
+	archive io.Closer
+}
+
+func (r *archiveEntryReader) Close() error {
+	err := r.ReadCloser.Close()
+	if archiveErr := r.archive.Close(); err == nil {
+		err = archiveErr
+	}
+	return err
+}
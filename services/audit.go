@@ -0,0 +1,64 @@
+package services
+
+import (
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/ebinskryfon/fileuploader/models"
+	"github.com/ebinskryfon/fileuploader/utils"
+)
+
+// AuditLog appends compliance events to a file, one JSON object per line.
+// The file is only ever appended to so earlier entries can't be rewritten
+// through the service.
+type AuditLog struct {
+	path   string
+	logger *utils.Logger
+
+	mu sync.Mutex
+}
+
+func NewAuditLog(path string, logger *utils.Logger) *AuditLog {
+	return &AuditLog{
+		path:   path,
+		logger: logger,
+	}
+}
+
+// Record appends event to the log. Failures are logged rather than returned
+// so an unwritable audit log is visible without failing the request that
+// triggered it.
+func (a *AuditLog) Record(event models.AuditEvent) {
+	if event.Time.IsZero() {
+		event.Time = time.Now().UTC()
+	}
+
+	data, err := json.Marshal(event)
+	if err == nil {
+		err = a.append(append(data, '\n'))
+	}
+	if err != nil {
+		a.logger.Error("Failed to write audit log", map[string]interface{}{
+			"action":  event.Action,
+			"file_id": event.FileID,
+			"error":   err.Error(),
+		})
+	}
+}
+
+func (a *AuditLog) append(line []byte) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	file, err := os.OpenFile(a.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(line); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
@@ -1,59 +1,206 @@
 package services
 
 import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
 	"fmt"
+	"slices"
+	"strings"
 	"time"
 
 	"github.com/ebinskryfon/fileuploader/config"
+	"github.com/ebinskryfon/fileuploader/utils"
 
 	"github.com/golang-jwt/jwt/v5"
 )
 
+const (
+	ScopeFilesRead   = "files:read"
+	ScopeFilesWrite  = "files:write"
+	ScopeFilesDelete = "files:delete"
+	ScopeTenantAdmin = "tenant:admin"
+	ScopeAdmin       = "admin"
+)
+
 type AuthService struct {
-	secret     []byte
-	expiration time.Duration
+	secret        []byte
+	expiration    time.Duration
+	keys          *KeySet
+	revocations   RevocationStore
+	issuers       []string
+	audience      string
+	leeway        time.Duration
+	defaultScopes []string
 }
 
 type Claims struct {
 	UserID string `json:"user_id"`
+	// TenantID selects the organization whose files the caller works with.
+	// Tokens without one act in the default tenant.
+	TenantID string `json:"tenant_id,omitempty"`
+	// Scope is a space-delimited list as in RFC 8693; Permissions is the
+	// array form some identity providers emit instead.
+	Scope       string   `json:"scope,omitempty"`
+	Permissions []string `json:"permissions,omitempty"`
+	Groups      []string `json:"groups,omitempty"`
 	jwt.RegisteredClaims
 }
 
 func NewAuthService(cfg *config.Config) *AuthService {
 	return &AuthService{
-		secret:     []byte(cfg.Auth.JWTSecret),
-		expiration: cfg.Auth.TokenExpiration,
+		secret:        []byte(cfg.Auth.JWTSecret),
+		expiration:    cfg.Auth.TokenExpiration,
+		issuers:       cfg.Auth.Issuers,
+		audience:      cfg.Auth.Audience,
+		leeway:        cfg.Auth.Leeway,
+		defaultScopes: cfg.Auth.DefaultScopes,
 	}
 }
 
-func (a *AuthService) GenerateToken(userID string) (string, error) {
+// WithKeySet enables verification of RS*, PS* and ES* tokens against keys.
+func (a *AuthService) WithKeySet(keys *KeySet) *AuthService {
+	a.keys = keys
+	return a
+}
+
+// WithRevocations makes ValidateToken reject tokens revoked in store.
+func (a *AuthService) WithRevocations(store RevocationStore) *AuthService {
+	a.revocations = store
+	return a
+}
+
+func (a *AuthService) GenerateToken(userID string, scopes ...string) (string, error) {
 	claims := &Claims{
 		UserID: userID,
+		Scope:  strings.Join(scopes, " "),
 		RegisteredClaims: jwt.RegisteredClaims{
 			ExpiresAt: jwt.NewNumericDate(time.Now().Add(a.expiration)),
 			IssuedAt:  jwt.NewNumericDate(time.Now()),
+			ID:        utils.GenerateUUID(),
 		},
 	}
+	if len(a.issuers) > 0 {
+		claims.Issuer = a.issuers[0]
+	}
+	if a.audience != "" {
+		claims.Audience = jwt.ClaimStrings{a.audience}
+	}
 
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
 	return token.SignedString(a.secret)
 }
 
 func (a *AuthService) ValidateToken(tokenString string) (*Claims, error) {
-	token, err := jwt.ParseWithClaims(tokenString, &Claims{}, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+	options := []jwt.ParserOption{jwt.WithLeeway(a.leeway), jwt.WithIssuedAt()}
+	if a.audience != "" {
+		options = append(options, jwt.WithAudience(a.audience))
+	}
+
+	token, err := jwt.ParseWithClaims(tokenString, &Claims{}, a.keyFunc, options...)
+
+	if err != nil {
+		return nil, err
+	}
+
+	claims, ok := token.Claims.(*Claims)
+	if !ok || !token.Valid {
+		return nil, fmt.Errorf("invalid token")
+	}
+
+	if len(a.issuers) > 0 && !slices.Contains(a.issuers, claims.Issuer) {
+		return nil, fmt.Errorf("unexpected issuer: %q", claims.Issuer)
+	}
+
+	if a.revocations != nil {
+		var issuedAt time.Time
+		if claims.IssuedAt != nil {
+			issuedAt = claims.IssuedAt.Time
+		}
+		if a.revocations.IsRevoked(claims.ID, claims.TenantID, claims.UserID, issuedAt) {
+			return nil, fmt.Errorf("token has been revoked")
 		}
+	}
+
+	return claims, nil
+}
+
+// RevokeToken revokes a single token by jti until it would have expired.
+func (a *AuthService) RevokeToken(jti string, expiresAt time.Time) error {
+	if a.revocations == nil {
+		return fmt.Errorf("token revocation is not configured")
+	}
+	return a.revocations.RevokeToken(jti, expiresAt)
+}
+
+// RevokeUserTokens revokes every token for userID in tenantID issued before
+// the cutoff.
+func (a *AuthService) RevokeUserTokens(tenantID, userID string, issuedBefore time.Time) error {
+	if a.revocations == nil {
+		return fmt.Errorf("token revocation is not configured")
+	}
+	return a.revocations.RevokeUser(tenantID, userID, issuedBefore)
+}
+
+// Scopes returns the scopes granted by claims. Tokens that carry neither a
+// scope nor a permissions claim receive the configured default scopes.
+func (a *AuthService) Scopes(claims *Claims) []string {
+	if claims.Scope == "" && claims.Permissions == nil {
+		return a.defaultScopes
+	}
+	return append(strings.Fields(claims.Scope), claims.Permissions...)
+}
+
+// HasScope reports whether granted satisfies required. The admin scope
+// satisfies every requirement.
+func HasScope(granted []string, required string) bool {
+	return slices.Contains(granted, required) || slices.Contains(granted, ScopeAdmin)
+}
+
+// keyFunc selects the verification key for a token. HMAC tokens use the
+// shared secret; asymmetric tokens are matched against the key set by kid and
+// key type, so a public key can never be used as an HMAC secret.
+func (a *AuthService) keyFunc(token *jwt.Token) (interface{}, error) {
+	switch token.Method.(type) {
+	case *jwt.SigningMethodHMAC:
 		return a.secret, nil
-	})
+	case *jwt.SigningMethodRSA, *jwt.SigningMethodRSAPSS, *jwt.SigningMethodECDSA:
+		if a.keys == nil || a.keys.Empty() {
+			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+		}
+	default:
+		return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+	}
 
+	kid, _ := token.Header["kid"].(string)
+	candidates, err := a.keys.Lookup(kid)
 	if err != nil {
 		return nil, err
 	}
 
-	if claims, ok := token.Claims.(*Claims); ok && token.Valid {
-		return claims, nil
+	var keySet jwt.VerificationKeySet
+	for _, key := range candidates {
+		if keyMatchesMethod(key, token.Method) {
+			keySet.Keys = append(keySet.Keys, key)
+		}
 	}
+	if len(keySet.Keys) == 0 {
+		return nil, fmt.Errorf("no key matches signing method %v", token.Header["alg"])
+	}
+
+	return keySet, nil
+}
 
-	return nil, fmt.Errorf("invalid token")
+func keyMatchesMethod(key crypto.PublicKey, method jwt.SigningMethod) bool {
+	switch key.(type) {
+	case *rsa.PublicKey:
+		switch method.(type) {
+		case *jwt.SigningMethodRSA, *jwt.SigningMethodRSAPSS:
+			return true
+		}
+	case *ecdsa.PublicKey:
+		_, ok := method.(*jwt.SigningMethodECDSA)
+		return ok
+	}
+	return false
 }
@@ -0,0 +1,61 @@
+package services
+
+import (
+	"crypto/x509"
+	"path"
+	"strings"
+
+	"github.com/ebinskryfon/fileuploader/config"
+)
+
+// CertAuthService maps verified client certificates to identities using the
+// configured rules. Rules are evaluated in order and the first match wins.
+type CertAuthService struct {
+	rules []config.CertRule
+}
+
+func NewCertAuthService(cfg *config.Config) *CertAuthService {
+	return &CertAuthService{
+		rules: cfg.Auth.CertRules,
+	}
+}
+
+// Identify returns the user ID and scopes for cert, or ok=false when no rule
+// matches. The certificate must already have been verified by the TLS stack.
+func (s *CertAuthService) Identify(cert *x509.Certificate) (userID string, scopes []string, ok bool) {
+	for _, rule := range s.rules {
+		field, pattern, found := strings.Cut(rule.Match, ":")
+		if !found {
+			continue
+		}
+
+		for _, value := range certValues(cert, field) {
+			if matched, err := path.Match(pattern, value); err == nil && matched {
+				return strings.ReplaceAll(rule.UserID, "{value}", value), rule.Scopes, true
+			}
+		}
+	}
+	return "", nil, false
+}
+
+func certValues(cert *x509.Certificate, field string) []string {
+	switch field {
+	case "cn":
+		if cert.Subject.CommonName == "" {
+			return nil
+		}
+		return []string{cert.Subject.CommonName}
+	case "dns":
+		return cert.DNSNames
+	case "email":
+		return cert.EmailAddresses
+	case "uri":
+		values := make([]string, 0, len(cert.URIs))
+		for _, uri := range cert.URIs {
+			values = append(values, uri.String())
+		}
+		return values
+	default:
+		return nil
+	}
+}
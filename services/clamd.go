@@ -0,0 +1,127 @@
+package services
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+)
+
+// clamdChunkSize is how much content goes in each INSTREAM chunk.
+const clamdChunkSize = 64 * 1024
+
+// ScanResult is a virus scanner's verdict on some content.
+type ScanResult struct {
+	Infected bool
+	// Signature names the malware found in infected content.
+	Signature string
+}
+
+// Scanner checks content for malware.
+type Scanner interface {
+	Scan(r io.Reader) (ScanResult, error)
+}
+
+// ClamdScanner scans content with a ClamAV daemon using its INSTREAM
+// command.
+type ClamdScanner struct {
+	network string
+	address string
+	timeout time.Duration
+}
+
+// NewClamdScanner creates a scanner for the clamd at address, which is
+// "tcp://host:port", "unix:///path/to/socket", or a bare host:port or
+// absolute socket path. Each scan must finish within timeout.
+func NewClamdScanner(address string, timeout time.Duration) *ClamdScanner {
+	network := "tcp"
+	switch {
+	case strings.HasPrefix(address, "unix://"):
+		network, address = "unix", strings.TrimPrefix(address, "unix://")
+	case strings.HasPrefix(address, "tcp://"):
+		address = strings.TrimPrefix(address, "tcp://")
+	case strings.HasPrefix(address, "/"):
+		network = "unix"
+	}
+
+	return &ClamdScanner{
+		network: network,
+		address: address,
+		timeout: timeout,
+	}
+}
+
+// Scan streams r to clamd and returns its verdict.
+func (s *ClamdScanner) Scan(r io.Reader) (ScanResult, error) {
+	conn, err := net.DialTimeout(s.network, s.address, s.timeout)
+	if err != nil {
+		return ScanResult{}, fmt.Errorf("failed to connect to clamd: %w", err)
+	}
+	defer conn.Close()
+
+	if s.timeout > 0 {
+		conn.SetDeadline(time.Now().Add(s.timeout))
+	}
+
+	// clamd stops reading and replies early when the stream exceeds its
+	// size limit, so a failed write may still have a reply to explain it
+	writeErr := writeInstream(conn, r)
+
+	reply, err := bufio.NewReader(conn).ReadString(0)
+	if err != nil && (err != io.EOF || reply == "") {
+		if writeErr != nil {
+			return ScanResult{}, fmt.Errorf("failed to send content to clamd: %w", writeErr)
+		}
+		return ScanResult{}, fmt.Errorf("failed to read clamd reply: %w", err)
+	}
+	return parseClamdReply(reply)
+}
+
+// writeInstream sends the INSTREAM command followed by r as length-prefixed
+// chunks and the terminating zero-length chunk.
+func writeInstream(w io.Writer, r io.Reader) error {
+	if _, err := io.WriteString(w, "zINSTREAM\x00"); err != nil {
+		return err
+	}
+
+	chunk := make([]byte, 4+clamdChunkSize)
+	for {
+		n, err := r.Read(chunk[4:])
+		if n > 0 {
+			binary.BigEndian.PutUint32(chunk, uint32(n))
+			if _, err := w.Write(chunk[:4+n]); err != nil {
+				return err
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err := w.Write([]byte{0, 0, 0, 0})
+	return err
+}
+
+// parseClamdReply reads replies such as "stream: OK" and
+// "stream: Eicar-Signature FOUND".
+func parseClamdReply(reply string) (ScanResult, error) {
+	reply = strings.TrimSpace(strings.TrimRight(reply, "\x00"))
+	verdict := strings.TrimPrefix(reply, "stream: ")
+
+	switch {
+	case verdict == "OK":
+		return ScanResult{}, nil
+	case strings.HasSuffix(verdict, " FOUND"):
+		return ScanResult{Infected: true, Signature: strings.TrimSuffix(verdict, " FOUND")}, nil
+	case reply == "":
+		return ScanResult{}, errors.New("empty reply from clamd")
+	}
+	return ScanResult{}, fmt.Errorf("clamd: %s", reply)
+}
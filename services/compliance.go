@@ -0,0 +1,216 @@
+package services
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/ebinskryfon/fileuploader/models"
+)
+
+var errFileChanged = errors.New("file changed before it could be removed")
+
+// retainUntil returns when content of contentType uploaded at uploadTime may
+// first be deleted: the longest of the tenant's minimum retention and every
+// matching rule. It returns nil when nothing applies.
+func (t *tenantServices) retainUntil(contentType string, uploadTime time.Time) *time.Time {
+	retention := t.minRetention
+	for _, rule := range t.retentionRules {
+		if matchesContentType(rule.ContentType, contentType) && rule.Duration > retention {
+			retention = rule.Duration
+		}
+	}
+	if retention <= 0 {
+		return nil
+	}
+	retainUntil := uploadTime.Add(retention)
+	return &retainUntil
+}
+
+// matchesContentType reports whether contentType matches pattern, which is
+// either an exact type or a family such as "image/*".
+func matchesContentType(pattern, contentType string) bool {
+	if family, ok := strings.CutSuffix(pattern, "/*"); ok {
+		return strings.HasPrefix(contentType, family+"/")
+	}
+	return strings.EqualFold(pattern, contentType)
+}
+
+// fileLock returns why a file can't be deleted, replaced or expired at now,
+// or nil when nothing holds it.
+func fileLock(metadata models.FileMetadata, now time.Time) *models.AppError {
+	if metadata.LegalHold != nil {
+		return models.ErrLegalHold
+	}
+	if metadata.RetainUntil != nil && metadata.RetainUntil.After(now) {
+		return models.NewAppError(http.StatusLocked, "File is under retention until "+metadata.RetainUntil.UTC().Format(time.RFC3339), nil)
+	}
+	return nil
+}
+
+// checkLock fails operation on a locked file, recording the attempt in the
+// audit log.
+func (u *UploadService) checkLock(tenant *tenantServices, metadata models.FileMetadata, principal models.Principal, operation string) *models.AppError {
+	appError := fileLock(metadata, time.Now())
+	if appError == nil {
+		return nil
+	}
+
+	u.recordAudit(models.AuditEvent{
+		Action:   models.AuditChangeBlocked,
+		FileID:   metadata.ID,
+		TenantID: tenant.id,
+		UserID:   principal.UserID,
+		Details: map[string]string{
+			"operation": operation,
+			"reason":    appError.Message,
+		},
+	})
+	return appError
+}
+
+// recordAudit writes event to the audit log, if there is one, and the
+// service log.
+func (u *UploadService) recordAudit(event models.AuditEvent) {
+	if u.audit != nil {
+		u.audit.Record(event)
+	}
+
+	u.logger.Info("Audit event", map[string]interface{}{
+		"action":    event.Action,
+		"file_id":   event.FileID,
+		"tenant_id": event.TenantID,
+		"user_id":   event.UserID,
+		"details":   event.Details,
+	})
+}
+
+// SetRetention sets an explicit retention date on a file. Only the owner may
+// set it, and retention can only ever be extended.
+func (u *UploadService) SetRetention(fileID string, retainUntil time.Time, principal models.Principal) (*models.FileMetadata, *models.AppError) {
+	tenant, appError := u.tenant(principal.TenantID)
+	if appError != nil {
+		return nil, appError
+	}
+
+	if _, appError := u.authorize(tenant, fileID, principal, accessOwner); appError != nil {
+		return nil, appError
+	}
+
+	if !retainUntil.After(time.Now()) {
+		return nil, models.NewAppError(http.StatusBadRequest, "retain_until must be in the future", nil)
+	}
+
+	tenant.contentMu.Lock()
+	defer tenant.contentMu.Unlock()
+
+	// Re-read under the lock so a concurrent change isn't lost
+	metadata, err := tenant.storage.GetMetadata(fileID)
+	if err != nil {
+		return nil, models.ErrFileNotFound
+	}
+
+	if metadata.RetainUntil != nil && retainUntil.Before(*metadata.RetainUntil) {
+		return nil, models.NewAppError(http.StatusConflict, "Retention can only be extended", nil)
+	}
+
+	details := map[string]string{"retain_until": retainUntil.UTC().Format(time.RFC3339)}
+	if metadata.RetainUntil != nil {
+		details["previous"] = metadata.RetainUntil.Format(time.RFC3339)
+	}
+	retainUntil = retainUntil.UTC()
+	metadata.RetainUntil = &retainUntil
+	if appError := u.saveMetadata(tenant, metadata, principal); appError != nil {
+		return nil, appError
+	}
+
+	u.recordAudit(models.AuditEvent{
+		Action:   models.AuditRetentionSet,
+		FileID:   fileID,
+		TenantID: tenant.id,
+		UserID:   principal.UserID,
+		Details:  details,
+	})
+
+	visible := visibleMetadata(metadata, principal)
+	return &visible, nil
+}
+
+// PlaceLegalHold stops a file in the administrator's tenant from being
+// deleted, replaced or expired until the hold is released. Files in the
+// trash can be held too.
+func (u *UploadService) PlaceLegalHold(fileID, reason string, principal models.Principal) (*models.FileMetadata, *models.AppError) {
+	return u.updateLegalHold(fileID, principal, models.AuditLegalHoldPlaced, func(metadata *models.FileMetadata) (map[string]string, *models.AppError) {
+		if metadata.LegalHold != nil {
+			return nil, models.NewAppError(http.StatusConflict, "File is already under legal hold", nil)
+		}
+		metadata.LegalHold = &models.LegalHold{
+			Reason:   reason,
+			PlacedBy: principal.UserID,
+			PlacedAt: time.Now().UTC(),
+		}
+		return map[string]string{"reason": reason}, nil
+	})
+}
+
+// ReleaseLegalHold lifts the legal hold on a file. Any retention still in
+// force continues to apply.
+func (u *UploadService) ReleaseLegalHold(fileID string, principal models.Principal) (*models.FileMetadata, *models.AppError) {
+	return u.updateLegalHold(fileID, principal, models.AuditLegalHoldReleased, func(metadata *models.FileMetadata) (map[string]string, *models.AppError) {
+		if metadata.LegalHold == nil {
+			return nil, models.NewAppError(http.StatusConflict, "File is not under legal hold", nil)
+		}
+		details := map[string]string{
+			"reason":    metadata.LegalHold.Reason,
+			"placed_by": metadata.LegalHold.PlacedBy,
+			"placed_at": metadata.LegalHold.PlacedAt.Format(time.RFC3339),
+		}
+		metadata.LegalHold = nil
+		return details, nil
+	})
+}
+
+// updateLegalHold applies change to a file's metadata, saves it and records
+// action in the audit log. Holds are placed by administrators, so the file
+// is loaded without an access check.
+func (u *UploadService) updateLegalHold(fileID string, principal models.Principal, action string, change func(*models.FileMetadata) (map[string]string, *models.AppError)) (*models.FileMetadata, *models.AppError) {
+	tenant, appError := u.tenant(principal.TenantID)
+	if appError != nil {
+		return nil, appError
+	}
+
+	tenant.contentMu.Lock()
+	defer tenant.contentMu.Unlock()
+
+	if !tenant.storage.Exists(fileID) {
+		return nil, models.ErrFileNotFound
+	}
+	metadata, err := tenant.storage.GetMetadata(fileID)
+	if err != nil {
+		u.logger.Error("Failed to get file metadata", map[string]interface{}{
+			"file_id": fileID,
+			"user_id": principal.UserID,
+			"error":   err.Error(),
+		})
+		return nil, models.ErrFileNotFound
+	}
+
+	details, appError := change(&metadata)
+	if appError != nil {
+		return nil, appError
+	}
+	if appError := u.saveMetadata(tenant, metadata, principal); appError != nil {
+		return nil, appError
+	}
+
+	u.recordAudit(models.AuditEvent{
+		Action:   action,
+		FileID:   fileID,
+		TenantID: tenant.id,
+		UserID:   principal.UserID,
+		Details:  details,
+	})
+
+	return &metadata, nil
+}
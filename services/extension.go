@@ -0,0 +1,184 @@
+package services
+
+import (
+	"slices"
+	"strings"
+
+	"github.com/ebinskryfon/fileuploader/models"
+)
+
+// Extension policies, chosen by the EXTENSION_POLICY setting.
+const (
+	ExtensionPolicyReject  = "reject"
+	ExtensionPolicyRewrite = "rewrite"
+	ExtensionPolicyAllow   = "allow"
+)
+
+// defaultTypeExtensions lists the extensions a file of each content type
+// may have, preferred extension first.
+var defaultTypeExtensions = map[string][]string{
+	"image/jpeg":         {"jpg", "jpeg", "jpe", "jfif"},
+	"image/png":          {"png"},
+	"image/gif":          {"gif"},
+	"image/webp":         {"webp"},
+	"image/bmp":          {"bmp"},
+	"image/tiff":         {"tif", "tiff"},
+	"application/pdf":    {"pdf"},
+	"text/plain":         {"txt", "text", "log"},
+	"text/csv":           {"csv"},
+	"text/markdown":      {"md", "markdown"},
+	"application/zip":    {"zip"},
+	"application/msword": {"doc", "dot"},
+	contentTypeDOCX:      {"docx"},
+	"application/vnd.openxmlformats-officedocument.wordprocessingml.template": {"dotx"},
+	"application/vnd.ms-excel": {"xls"},
+	"application/vnd.openxmlformats-officedocument.spreadsheetml.sheet":         {"xlsx"},
+	"application/vnd.ms-powerpoint":                                             {"ppt"},
+	"application/vnd.openxmlformats-officedocument.presentationml.presentation": {"pptx"},
+	"application/vnd.oasis.opendocument.text":                                   {"odt"},
+	"application/vnd.oasis.opendocument.spreadsheet":                            {"ods"},
+	"application/vnd.oasis.opendocument.presentation":                           {"odp"},
+}
+
+// defaultBlockedExtensions are executables and scripts a client might run
+// straight from a download.
+var defaultBlockedExtensions = []string{
+	"exe", "com", "bat", "cmd", "scr", "pif", "msi", "msp", "dll", "cpl",
+	"hta", "js", "jse", "vbs", "vbe", "wsf", "wsh", "ps1", "psm1", "lnk",
+	"jar", "sh", "reg", "app",
+}
+
+// ExtensionPolicy checks that file names carry an extension belonging to the
+// file's content type, and never an executable one.
+type ExtensionPolicy struct {
+	mode       string
+	extensions map[string][]string
+	types      map[string]string
+	blocked    map[string]bool
+}
+
+// NewExtensionPolicy creates a policy applying mode to mismatched
+// extensions. typeExtensions replaces the built-in extensions of the types it
+// lists, and blocked replaces the built-in blocked extensions when not empty.
+func NewExtensionPolicy(mode string, typeExtensions map[string][]string, blocked []string) *ExtensionPolicy {
+	if mode != ExtensionPolicyRewrite && mode != ExtensionPolicyAllow {
+		mode = ExtensionPolicyReject
+	}
+
+	p := &ExtensionPolicy{
+		mode:       mode,
+		extensions: make(map[string][]string),
+		types:      make(map[string]string),
+		blocked:    make(map[string]bool),
+	}
+	for contentType, extensions := range defaultTypeExtensions {
+		p.extensions[contentType] = extensions
+	}
+	for contentType, extensions := range typeExtensions {
+		normalized := make([]string, 0, len(extensions))
+		for _, extension := range extensions {
+			normalized = append(normalized, normalizeExtension(extension))
+		}
+		p.extensions[baseContentType(contentType)] = normalized
+	}
+
+	// Map extensions back to types in a stable order, so a shared
+	// extension always guesses the same type
+	contentTypes := make([]string, 0, len(p.extensions))
+	for contentType := range p.extensions {
+		contentTypes = append(contentTypes, contentType)
+	}
+	slices.Sort(contentTypes)
+	for _, contentType := range contentTypes {
+		for _, extension := range p.extensions[contentType] {
+			if _, ok := p.types[extension]; !ok {
+				p.types[extension] = contentType
+			}
+		}
+	}
+
+	if len(blocked) == 0 {
+		blocked = defaultBlockedExtensions
+	}
+	for _, extension := range blocked {
+		p.blocked[normalizeExtension(extension)] = true
+	}
+
+	return p
+}
+
+// TypeFor guesses a content type from a file name's extension, returning
+// application/octet-stream for unknown extensions.
+func (p *ExtensionPolicy) TypeFor(name string) string {
+	extensions := fileExtensions(name)
+	if len(extensions) == 0 {
+		return contentTypeOctetStream
+	}
+	if contentType, ok := p.types[extensions[len(extensions)-1]]; ok {
+		return contentType
+	}
+	return contentTypeOctetStream
+}
+
+// Check applies the policy to a file named name with the canonical content
+// type contentType, returning the name to store it under.
+func (p *ExtensionPolicy) Check(name, contentType string) (string, *models.AppError) {
+	// Windows drops trailing dots and spaces, so "x.exe." runs as "x.exe"
+	name = strings.TrimRight(name, ". ")
+
+	extensions := fileExtensions(name)
+	if len(extensions) > 0 {
+		last := extensions[len(extensions)-1]
+		for i, extension := range extensions {
+			if !p.blocked[extension] {
+				continue
+			}
+			details := map[string]string{"extension": extension}
+			if len(extensions) > 1 && (i < len(extensions)-1 || p.types[extensions[i-1]] != "") {
+				// e.g. "invoice.pdf.exe" or "setup.exe.pdf"
+				details["file_name"] = name
+				return "", models.NewValidationError(models.ReasonDoubleExtension, "File name has a disguised executable extension", details)
+			}
+			return "", models.NewValidationError(models.ReasonExtensionBlocked, "File extension ."+extension+" is not allowed", details)
+		}
+
+		allowed := p.extensions[contentType]
+		if len(allowed) == 0 || slices.Contains(allowed, last) || p.mode == ExtensionPolicyAllow {
+			return name, nil
+		}
+		if p.mode == ExtensionPolicyReject {
+			return "", models.NewValidationError(models.ReasonExtensionMismatch, "File extension does not match its content type", map[string]string{
+				"extension":    last,
+				"content_type": contentType,
+				"allowed":      strings.Join(allowed, ","),
+			})
+		}
+		// Split the original name rather than reuse last, whose length
+		// lower-casing may have changed
+		return name[:strings.LastIndex(name, ".")+1] + allowed[0], nil
+	}
+
+	// Files without an extension get one when rewriting
+	if allowed := p.extensions[contentType]; p.mode == ExtensionPolicyRewrite && len(allowed) > 0 {
+		return name + "." + allowed[0], nil
+	}
+	return name, nil
+}
+
+// fileExtensions returns the lower-cased dot-separated extensions of name,
+// ignoring the leading dot of hidden files.
+func fileExtensions(name string) []string {
+	parts := strings.Split(strings.ToLower(strings.TrimLeft(name, ".")), ".")
+	if len(parts) < 2 {
+		return nil
+	}
+	extensions := parts[1:]
+	for i := range extensions {
+		extensions[i] = strings.TrimSpace(extensions[i])
+	}
+	return extensions
+}
+
+func normalizeExtension(extension string) string {
+	return strings.ToLower(strings.TrimPrefix(strings.TrimSpace(extension), "."))
+}
@@ -0,0 +1,418 @@
+package services
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/zlib"
+	"encoding/xml"
+	"errors"
+	"io"
+	"mime"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+const (
+	contentTypeDOCX = "application/vnd.openxmlformats-officedocument.wordprocessingml.document"
+
+	wordprocessingNamespace = "http://schemas.openxmlformats.org/wordprocessingml/2006/main"
+)
+
+var errUnsupportedExtraction = errors.New("text extraction is not supported for this content type")
+
+// textExtractors pull plain text out of documents, writing at most limit
+// bytes of it.
+var textExtractors = map[string]func(data []byte, limit int64) (string, error){
+	"text/plain":      extractPlainText,
+	"application/pdf": extractPDFText,
+	contentTypeDOCX:   extractDOCXText,
+}
+
+// CanExtractText reports whether ExtractText supports contentType.
+func CanExtractText(contentType string) bool {
+	_, ok := textExtractors[baseContentType(contentType)]
+	return ok
+}
+
+// ExtractText returns up to limit bytes of the plain text in a text, PDF or
+// DOCX document. PDF text is only found when it is drawn with standard
+// encodings in uncompressed or Flate-compressed content streams.
+func ExtractText(contentType string, data []byte, limit int64) (string, error) {
+	extract, ok := textExtractors[baseContentType(contentType)]
+	if !ok {
+		return "", errUnsupportedExtraction
+	}
+	return extract(data, limit)
+}
+
+// baseContentType strips parameters such as charset from a content type.
+func baseContentType(contentType string) string {
+	if mediaType, _, err := mime.ParseMediaType(contentType); err == nil {
+		return mediaType
+	}
+	return strings.ToLower(strings.TrimSpace(contentType))
+}
+
+// textWriter accumulates extracted text up to a byte limit.
+type textWriter struct {
+	builder strings.Builder
+	limit   int64
+}
+
+func (w *textWriter) full() bool {
+	return int64(w.builder.Len()) >= w.limit
+}
+
+func (w *textWriter) WriteString(s string) {
+	if remaining := w.limit - int64(w.builder.Len()); remaining > 0 {
+		if int64(len(s)) > remaining {
+			s = strings.ToValidUTF8(s[:remaining], "")
+		}
+		w.builder.WriteString(s)
+	}
+}
+
+// separate starts a new word unless the text already ends with a space.
+func (w *textWriter) separate(separator string) {
+	text := w.builder.String()
+	if text != "" && !strings.HasSuffix(text, " ") && !strings.HasSuffix(text, "\n") {
+		w.WriteString(separator)
+	}
+}
+
+// String returns the text without the trailing separator.
+func (w *textWriter) String() string {
+	return strings.TrimRight(w.builder.String(), " \n")
+}
+
+func extractPlainText(data []byte, limit int64) (string, error) {
+	text := &textWriter{limit: limit}
+	text.WriteString(strings.ToValidUTF8(string(data), " "))
+	return text.String(), nil
+}
+
+// extractDOCXText reads the paragraphs of word/document.xml.
+func extractDOCXText(data []byte, limit int64) (string, error) {
+	archive, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return "", err
+	}
+
+	var document *zip.File
+	for _, file := range archive.File {
+		if file.Name == "word/document.xml" {
+			document = file
+			break
+		}
+	}
+	if document == nil {
+		return "", errors.New("word/document.xml not found")
+	}
+
+	reader, err := document.Open()
+	if err != nil {
+		return "", err
+	}
+	defer reader.Close()
+
+	// XML markup outweighs the text it holds, but not without bound
+	decoder := xml.NewDecoder(io.LimitReader(reader, 16*limit))
+	text := &textWriter{limit: limit}
+	inText := false
+	for !text.full() {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return text.String(), err
+		}
+
+		switch t := token.(type) {
+		case xml.StartElement:
+			if t.Name.Space != wordprocessingNamespace {
+				continue
+			}
+			switch t.Name.Local {
+			case "t":
+				inText = true
+			case "tab":
+				text.WriteString(" ")
+			case "br":
+				text.WriteString("\n")
+			}
+		case xml.EndElement:
+			if t.Name.Space != wordprocessingNamespace {
+				continue
+			}
+			switch t.Name.Local {
+			case "t":
+				inText = false
+			case "p":
+				text.separate("\n")
+			}
+		case xml.CharData:
+			if inText {
+				text.WriteString(string(t))
+			}
+		}
+	}
+
+	return text.String(), nil
+}
+
+var pdfFilterPattern = regexp.MustCompile(`/Filter\s*(\[[^\]]*\]|/\w+)`)
+
+// extractPDFText finds the text drawn by the content streams in a PDF. The
+// streams share one pdfBudget, and extraction keeps what it found once that
+// runs out.
+func extractPDFText(data []byte, limit int64) (string, error) {
+	text := &textWriter{limit: limit}
+	budget := newPDFBudget()
+
+	offset := 0
+	for !text.full() && budget.remaining > 0 && !budget.expired() {
+		index := bytes.Index(data[offset:], []byte("stream"))
+		if index < 0 {
+			break
+		}
+		keyword := offset + index
+		offset = keyword + len("stream")
+		if bytes.HasSuffix(data[:keyword], []byte("end")) {
+			continue
+		}
+
+		start := offset
+		if bytes.HasPrefix(data[start:], []byte("\r\n")) {
+			start += 2
+		} else if bytes.HasPrefix(data[start:], []byte("\n")) {
+			start++
+		} else {
+			continue
+		}
+		end := bytes.Index(data[start:], []byte("endstream"))
+		if end < 0 {
+			break
+		}
+		stream := data[start : start+end]
+		offset = start + end + len("endstream")
+
+		// The stream dictionary runs from the object header to the keyword
+		header := max(keyword-4096, 0)
+		if objIndex := bytes.LastIndex(data[header:keyword], []byte("obj")); objIndex >= 0 {
+			header += objIndex
+		}
+		dictionary := string(data[header:keyword])
+		if strings.Contains(dictionary, "/Image") || strings.Contains(dictionary, "/FontFile") {
+			continue
+		}
+
+		content, ok := decodePDFStream(dictionary, stream, min(16*limit, budget.remaining))
+		budget.remaining -= int64(len(content))
+		if !ok {
+			continue
+		}
+		scanPDFContent(content, text)
+	}
+
+	return text.String(), nil
+}
+
+// decodePDFStream undoes a stream's filter. Only unfiltered and Flate
+// streams are supported.
+func decodePDFStream(dictionary string, stream []byte, limit int64) ([]byte, bool) {
+	filter := pdfFilterPattern.FindStringSubmatch(dictionary)
+	if filter == nil {
+		return stream, true
+	}
+	if strings.Trim(filter[1], "[] \r\n") != "/FlateDecode" {
+		return nil, false
+	}
+
+	reader, err := zlib.NewReader(bytes.NewReader(stream))
+	if err != nil {
+		return nil, false
+	}
+	defer reader.Close()
+
+	// Streams are often truncated slightly by the end-of-line before
+	// endstream, so keep whatever decompressed before an error
+	content, _ := io.ReadAll(io.LimitReader(reader, limit))
+	return content, len(content) > 0
+}
+
+// scanPDFContent writes the strings shown by the text operators in a
+// content stream.
+func scanPDFContent(content []byte, text *textWriter) {
+	var operands []string
+	inArray := false
+
+	for i := 0; i < len(content) && !text.full(); {
+		c := content[i]
+		switch {
+		case c == '%':
+			for i < len(content) && content[i] != '\n' && content[i] != '\r' {
+				i++
+			}
+		case c == '(':
+			var s string
+			s, i = readPDFLiteral(content, i+1)
+			operands = append(operands, s)
+		case c == '<' && i+1 < len(content) && content[i+1] == '<':
+			// Dictionary, such as marked-content properties
+			i += 2
+		case c == '<':
+			end := bytes.IndexByte(content[i:], '>')
+			if end < 0 {
+				return
+			}
+			operands = append(operands, decodePDFHex(content[i+1:i+end]))
+			i += end + 1
+		case c == '[':
+			inArray = true
+			i++
+		case c == ']':
+			inArray = false
+			i++
+		case isPDFDelimiter(c) || isPDFSpace(c):
+			i++
+		default:
+			start := i
+			for i < len(content) && !isPDFDelimiter(content[i]) && !isPDFSpace(content[i]) {
+				i++
+			}
+			token := string(content[start:i])
+
+			if number, err := strconv.ParseFloat(token, 64); err == nil {
+				// Large negative kerning in a TJ array separates words
+				if inArray && number < -200 {
+					operands = append(operands, " ")
+				}
+				continue
+			}
+
+			switch token {
+			case "Tj", "TJ":
+				text.WriteString(strings.Join(operands, ""))
+			case "'", "\"":
+				text.separate("\n")
+				text.WriteString(strings.Join(operands, ""))
+			case "Td", "TD", "T*", "Tm":
+				text.separate(" ")
+			case "ET":
+				text.separate("\n")
+			}
+			operands = operands[:0]
+		}
+	}
+}
+
+// readPDFLiteral reads a literal string starting after its opening
+// parenthesis and returns it with the index just past its end.
+func readPDFLiteral(content []byte, i int) (string, int) {
+	var s []byte
+	depth := 1
+	for ; i < len(content); i++ {
+		c := content[i]
+		switch c {
+		case '(':
+			depth++
+		case ')':
+			depth--
+			if depth == 0 {
+				return decodePDFString(s), i + 1
+			}
+		case '\\':
+			i++
+			if i >= len(content) {
+				break
+			}
+			switch e := content[i]; e {
+			case 'n':
+				c = '\n'
+			case 'r':
+				c = '\r'
+			case 't':
+				c = '\t'
+			case 'b':
+				c = '\b'
+			case 'f':
+				c = '\f'
+			case '\r', '\n':
+				// Line continuation
+				if e == '\r' && i+1 < len(content) && content[i+1] == '\n' {
+					i++
+				}
+				continue
+			default:
+				if e >= '0' && e <= '7' {
+					value := 0
+					for n := 0; n < 3 && i < len(content) && content[i] >= '0' && content[i] <= '7'; n++ {
+						value = value*8 + int(content[i]-'0')
+						i++
+					}
+					i--
+					c = byte(value)
+				} else {
+					c = e
+				}
+			}
+		}
+		s = append(s, c)
+	}
+	return decodePDFString(s), i
+}
+
+func decodePDFHex(hex []byte) string {
+	var digits []byte
+	for _, c := range hex {
+		if !isPDFSpace(c) {
+			digits = append(digits, c)
+		}
+	}
+	if len(digits)%2 == 1 {
+		digits = append(digits, '0')
+	}
+
+	s := make([]byte, 0, len(digits)/2)
+	for i := 0; i < len(digits); i += 2 {
+		value, err := strconv.ParseUint(string(digits[i:i+2]), 16, 8)
+		if err != nil {
+			return ""
+		}
+		s = append(s, byte(value))
+	}
+	return decodePDFString(s)
+}
+
+// decodePDFString converts a UTF-16BE string with a byte order mark, or
+// otherwise treats bytes as Latin-1, which PDFDocEncoding mostly matches.
+func decodePDFString(s []byte) string {
+	if len(s) >= 2 && s[0] == 0xFE && s[1] == 0xFF {
+		units := make([]uint16, 0, len(s)/2)
+		for i := 2; i+1 < len(s); i += 2 {
+			units = append(units, uint16(s[i])<<8|uint16(s[i+1]))
+		}
+		return string(utf16.Decode(units))
+	}
+	if utf8.Valid(s) {
+		return string(s)
+	}
+
+	runes := make([]rune, 0, len(s))
+	for _, b := range s {
+		runes = append(runes, rune(b))
+	}
+	return string(runes)
+}
+
+func isPDFSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\r' || c == '\n' || c == '\f' || c == 0
+}
+
+func isPDFDelimiter(c byte) bool {
+	return strings.IndexByte("()<>[]{}/%", c) >= 0
+}
@@ -0,0 +1,115 @@
+package services
+
+import (
+	"io"
+	"sync"
+
+	"github.com/ebinskryfon/fileuploader/models"
+	"github.com/ebinskryfon/fileuploader/utils"
+)
+
+const (
+	defaultExtractionQueueSize = 1000
+	defaultMaxExtractedText    = 1024 * 1024
+)
+
+// extractionJob asks for the text of one version of a file's content.
+type extractionJob struct {
+	tenant   *tenantServices
+	metadata models.FileMetadata
+}
+
+// queueExtraction schedules text extraction for a file without blocking.
+// When the queue is full the job is dropped; rebuilding the search index
+// queues it again.
+func (u *UploadService) queueExtraction(tenant *tenantServices, metadata models.FileMetadata) {
+	if !CanExtractText(metadata.ContentType) {
+		return
+	}
+
+	select {
+	case u.extraction <- extractionJob{tenant: tenant, metadata: metadata}:
+	default:
+		u.logger.Warn("Text extraction queue full, skipping file", map[string]interface{}{
+			"file_id":   metadata.ID,
+			"tenant_id": tenant.id,
+		})
+	}
+}
+
+// StartExtraction runs workers that extract document text for full-text
+// search until the returned stop function is called.
+func (u *UploadService) StartExtraction(workers int) (stop func()) {
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case job := <-u.extraction:
+					u.extractText(job)
+				case <-done:
+					return
+				}
+			}
+		}()
+	}
+
+	return func() {
+		close(done)
+		wg.Wait()
+	}
+}
+
+// extractText extracts and indexes the text of a job's file, provided its
+// content hasn't changed since the job was queued.
+func (u *UploadService) extractText(job extractionJob) {
+	metadata := job.metadata
+	file, _, err := job.tenant.storage.Retrieve(metadata.ID)
+	if err != nil {
+		// Most likely deleted since
+		return
+	}
+	data, err := io.ReadAll(file)
+	file.Close()
+	if err != nil {
+		u.logger.Error("Failed to read file for text extraction", map[string]interface{}{
+			"file_id":   metadata.ID,
+			"tenant_id": job.tenant.id,
+			"error":     err.Error(),
+		})
+		return
+	}
+	if utils.CalculateChecksum(data) != metadata.Checksum {
+		// Replaced since; the new content has its own job
+		return
+	}
+
+	limit := u.config.Search.MaxExtractedText
+	if limit <= 0 {
+		limit = defaultMaxExtractedText
+	}
+	text, err := ExtractText(metadata.ContentType, data, limit)
+	if err != nil {
+		u.logger.Warn("Failed to extract text", map[string]interface{}{
+			"file_id":      metadata.ID,
+			"tenant_id":    job.tenant.id,
+			"content_type": metadata.ContentType,
+			"error":        err.Error(),
+		})
+		if text == "" {
+			return
+		}
+	}
+
+	job.tenant.search.IndexContent(metadata.ID, metadata.Checksum, text)
+
+	u.logger.Debug("Text extracted", map[string]interface{}{
+		"file_id":    metadata.ID,
+		"tenant_id":  job.tenant.id,
+		"text_bytes": len(text),
+	})
+}
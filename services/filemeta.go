@@ -0,0 +1,156 @@
+package services
+
+import (
+	"fmt"
+	"maps"
+	"net/http"
+	"regexp"
+	"unicode/utf8"
+
+	"github.com/ebinskryfon/fileuploader/models"
+	"github.com/ebinskryfon/fileuploader/utils"
+)
+
+// Limits on user-editable metadata, so it stays small enough to load with
+// every listing.
+const (
+	maxFileNameLength    = 255
+	maxDescriptionLength = 2048
+	maxTags              = 50
+	maxTagValueLength    = 256
+	maxMetadataEntries   = 50
+	maxMetadataValue     = 1024
+	maxMetadataKeyLength = 64
+)
+
+var metadataKeyPattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.:-]*$`)
+
+// UpdateFile renames a file and changes its description, tags and custom
+// metadata. Changes need write access to the file.
+func (u *UploadService) UpdateFile(fileID string, principal models.Principal, req models.UpdateFileRequest) (*models.FileMetadata, *models.AppError) {
+	tenant, appError := u.tenant(principal.TenantID)
+	if appError != nil {
+		return nil, appError
+	}
+
+	if _, appError := u.authorize(tenant, fileID, principal, accessWrite); appError != nil {
+		return nil, appError
+	}
+
+	tenant.contentMu.Lock()
+	defer tenant.contentMu.Unlock()
+
+	// Re-read under the lock so concurrent changes aren't lost
+	metadata, err := tenant.storage.GetMetadata(fileID)
+	if err != nil {
+		return nil, models.ErrFileNotFound
+	}
+
+	if req.Name != nil {
+		name, appError := validateFileName(*req.Name)
+		if appError != nil {
+			return nil, appError
+		}
+		if name, appError = tenant.validation.ValidateFileName(name, metadata.ContentType); appError != nil {
+			return nil, appError
+		}
+		metadata.OriginalName = name
+	}
+	if req.Description != nil {
+		if appError := validateDescription(*req.Description); appError != nil {
+			return nil, appError
+		}
+		metadata.Description = *req.Description
+	}
+	metadata.Tags = mergeEntries(metadata.Tags, req.Tags)
+	if appError := validateEntries("tag", metadata.Tags, maxTags, maxTagValueLength); appError != nil {
+		return nil, appError
+	}
+	metadata.Metadata = mergeEntries(metadata.Metadata, req.Metadata)
+	if appError := validateEntries("metadata", metadata.Metadata, maxMetadataEntries, maxMetadataValue); appError != nil {
+		return nil, appError
+	}
+
+	if appError := u.saveMetadata(tenant, metadata, principal); appError != nil {
+		return nil, appError
+	}
+
+	u.logger.Info("File metadata updated", map[string]interface{}{
+		"file_id":   fileID,
+		"user_id":   principal.UserID,
+		"file_name": metadata.OriginalName,
+	})
+
+	visible := visibleMetadata(metadata, principal)
+	return &visible, nil
+}
+
+// validateUploadOptions checks the metadata supplied with an upload.
+func validateUploadOptions(options models.UploadOptions) *models.AppError {
+	if appError := validateDescription(options.Description); appError != nil {
+		return appError
+	}
+	if appError := validateEntries("tag", options.Tags, maxTags, maxTagValueLength); appError != nil {
+		return appError
+	}
+	return validateEntries("metadata", options.Metadata, maxMetadataEntries, maxMetadataValue)
+}
+
+// validateFileName sanitizes a new name for a file.
+func validateFileName(name string) (string, *models.AppError) {
+	name = utils.SanitizeFileName(name)
+	if name == "" || name == "." {
+		return "", models.NewAppError(http.StatusBadRequest, "Invalid file name", nil)
+	}
+	if len(name) > maxFileNameLength {
+		return "", models.NewAppError(http.StatusBadRequest, fmt.Sprintf("File name must be at most %d bytes", maxFileNameLength), nil)
+	}
+	return name, nil
+}
+
+func validateDescription(description string) *models.AppError {
+	if utf8.RuneCountInString(description) > maxDescriptionLength {
+		return models.NewAppError(http.StatusBadRequest, fmt.Sprintf("Description must be at most %d characters", maxDescriptionLength), nil)
+	}
+	return nil
+}
+
+// validateEntries checks a tag or metadata map against its limits.
+func validateEntries(kind string, entries map[string]string, maxEntries, maxValueLength int) *models.AppError {
+	if len(entries) > maxEntries {
+		return models.NewAppError(http.StatusBadRequest, fmt.Sprintf("At most %d %s entries are allowed", maxEntries, kind), nil)
+	}
+	for key, value := range entries {
+		if len(key) > maxMetadataKeyLength || !metadataKeyPattern.MatchString(key) {
+			return models.NewAppError(http.StatusBadRequest, fmt.Sprintf("Invalid %s key %q", kind, key), nil)
+		}
+		if utf8.RuneCountInString(value) > maxValueLength {
+			return models.NewAppError(http.StatusBadRequest, fmt.Sprintf("Value of %s %q must be at most %d characters", kind, key, maxValueLength), nil)
+		}
+	}
+	return nil
+}
+
+// mergeEntries applies a merge patch to entries: keys with a nil value are
+// removed and the rest set. It returns nil rather than an empty map.
+func mergeEntries(entries map[string]string, patch map[string]*string) map[string]string {
+	if len(patch) == 0 {
+		return entries
+	}
+
+	merged := maps.Clone(entries)
+	if merged == nil {
+		merged = make(map[string]string, len(patch))
+	}
+	for key, value := range patch {
+		if value == nil {
+			delete(merged, key)
+			continue
+		}
+		merged[key] = *value
+	}
+	if len(merged) == 0 {
+		return nil
+	}
+	return merged
+}
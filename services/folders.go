@@ -0,0 +1,738 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/ebinskryfon/fileuploader/models"
+	"github.com/ebinskryfon/fileuploader/utils"
+)
+
+// maxFolderDepth is how deeply folders may nest. It also bounds ancestor
+// walks so a corrupted hierarchy can't loop.
+const maxFolderDepth = 64
+
+// folderChain returns a folder followed by its ancestors up to the root.
+func (u *UploadService) folderChain(tenant *tenantServices, folderID string) ([]models.Folder, error) {
+	var chain []models.Folder
+	for id := folderID; id != ""; {
+		if len(chain) == maxFolderDepth {
+			return nil, fmt.Errorf("folder hierarchy too deep at %s", id)
+		}
+		folder, err := tenant.storage.GetFolder(id)
+		if err != nil {
+			return nil, err
+		}
+		chain = append(chain, folder)
+		id = folder.ParentID
+	}
+	return chain, nil
+}
+
+// contentsAccess resolves the caller's access to everything inside the first
+// folder of chain. Owning any ancestor allows changing its contents, and
+// grants on any ancestor apply to everything beneath it.
+func contentsAccess(chain []models.Folder, principal models.Principal) accessLevel {
+	level := accessNone
+	for _, folder := range chain {
+		if folder.UserID == principal.UserID {
+			return accessWrite
+		}
+		level = max(level, aclAccess(folder.Permissions, principal))
+	}
+	return level
+}
+
+// inheritedAccess returns the access a file in folderID inherits from the
+// folder hierarchy.
+func (u *UploadService) inheritedAccess(tenant *tenantServices, folderID string, principal models.Principal) accessLevel {
+	chain, err := u.folderChain(tenant, folderID)
+	if err != nil {
+		u.logger.Warn("Failed to resolve folder hierarchy", map[string]interface{}{
+			"folder_id": folderID,
+			"user_id":   principal.UserID,
+			"error":     err.Error(),
+		})
+		return accessNone
+	}
+	return contentsAccess(chain, principal)
+}
+
+// authorizeFolder loads a folder with its ancestors and checks that principal
+// has at least the required access to it. Like authorize, folders the caller
+// cannot see are reported as not found.
+func (u *UploadService) authorizeFolder(tenant *tenantServices, folderID string, principal models.Principal, required accessLevel) ([]models.Folder, *models.AppError) {
+	chain, err := u.folderChain(tenant, folderID)
+	if err != nil {
+		if !errors.Is(err, fs.ErrNotExist) {
+			u.logger.Error("Failed to get folder", map[string]interface{}{
+				"folder_id": folderID,
+				"user_id":   principal.UserID,
+				"error":     err.Error(),
+			})
+		}
+		return nil, models.ErrFolderNotFound
+	}
+
+	level := contentsAccess(chain, principal)
+	if chain[0].UserID == principal.UserID {
+		level = accessOwner
+	}
+	if principal.TenantAdmin && level < accessRead {
+		level = accessRead
+	}
+	if level < required {
+		u.logger.Warn("Unauthorized folder access attempt", map[string]interface{}{
+			"folder_id":    folderID,
+			"user_id":      principal.UserID,
+			"folder_owner": chain[0].UserID,
+		})
+		if level == accessNone {
+			return nil, models.ErrFolderNotFound
+		}
+		return nil, models.ErrPermissionDenied
+	}
+
+	return chain, nil
+}
+
+// visibleFolder hides the ACL from callers who don't own the folder.
+func visibleFolder(folder models.Folder, principal models.Principal) models.Folder {
+	if folder.UserID != principal.UserID {
+		folder.Permissions = nil
+	}
+	return folder
+}
+
+func validateFolderName(name string) (string, *models.AppError) {
+	name = strings.TrimSpace(name)
+	if name == "" || name == "." || name == ".." || len(name) > 255 || strings.ContainsAny(name, `/\`) {
+		return "", models.NewAppError(http.StatusBadRequest, "Invalid folder name", nil)
+	}
+	return name, nil
+}
+
+// findChild returns the folder named name directly under parentID. Root
+// folders belong to their owner, so at the root only ownerID's are searched.
+func findChild(folders []models.Folder, parentID, ownerID, name string) (models.Folder, bool) {
+	for _, folder := range folders {
+		if folder.ParentID == parentID && folder.Name == name && (parentID != "" || folder.UserID == ownerID) {
+			return folder, true
+		}
+	}
+	return models.Folder{}, false
+}
+
+// subtreeHeight counts the levels of folders from folderID down to its
+// deepest descendant, folderID included.
+func subtreeHeight(folders []models.Folder, folderID string) int {
+	children := make(map[string][]string)
+	for _, folder := range folders {
+		children[folder.ParentID] = append(children[folder.ParentID], folder.ID)
+	}
+
+	height := 0
+	for level := []string{folderID}; len(level) > 0 && height <= maxFolderDepth; height++ {
+		var next []string
+		for _, id := range level {
+			next = append(next, children[id]...)
+		}
+		level = next
+	}
+	return height
+}
+
+func (u *UploadService) CreateFolder(principal models.Principal, req models.CreateFolderRequest) (*models.Folder, *models.AppError) {
+	tenant, appError := u.tenant(principal.TenantID)
+	if appError != nil {
+		return nil, appError
+	}
+
+	name, appError := validateFolderName(req.Name)
+	if appError != nil {
+		return nil, appError
+	}
+
+	if req.ParentID != "" {
+		chain, appError := u.authorizeFolder(tenant, req.ParentID, principal, accessWrite)
+		if appError != nil {
+			return nil, appError
+		}
+		if len(chain) >= maxFolderDepth {
+			return nil, models.ErrFolderTooDeep
+		}
+	}
+
+	tenant.folderMu.Lock()
+	defer tenant.folderMu.Unlock()
+
+	folder, appError := u.createFolderLocked(tenant, principal, req.ParentID, name)
+	if appError != nil {
+		return nil, appError
+	}
+
+	visible := visibleFolder(folder, principal)
+	return &visible, nil
+}
+
+// createFolderLocked stores a new folder after checking that its name is
+// free. Callers must hold tenant.folderMu.
+func (u *UploadService) createFolderLocked(tenant *tenantServices, principal models.Principal, parentID, name string) (models.Folder, *models.AppError) {
+	folders, err := tenant.storage.ListFolders()
+	if err != nil {
+		u.logger.Error("Failed to list folders", map[string]interface{}{
+			"user_id": principal.UserID,
+			"error":   err.Error(),
+		})
+		return models.Folder{}, models.ErrInternalServer
+	}
+	if _, exists := findChild(folders, parentID, principal.UserID, name); exists {
+		return models.Folder{}, models.ErrFolderExists
+	}
+
+	return u.addFolder(tenant, principal, parentID, name)
+}
+
+// addFolder stores a new folder whose name is known to be free. Callers
+// must hold tenant.folderMu.
+func (u *UploadService) addFolder(tenant *tenantServices, principal models.Principal, parentID, name string) (models.Folder, *models.AppError) {
+	now := time.Now().UTC()
+	folder := models.Folder{
+		ID:        utils.GenerateUUID(),
+		Name:      name,
+		ParentID:  parentID,
+		UserID:    principal.UserID,
+		TenantID:  principal.TenantID,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	if appError := u.saveFolder(tenant, folder, principal); appError != nil {
+		return models.Folder{}, appError
+	}
+
+	u.logger.Info("Folder created", map[string]interface{}{
+		"folder_id": folder.ID,
+		"parent_id": parentID,
+		"user_id":   principal.UserID,
+	})
+
+	return folder, nil
+}
+
+// GetFolderContents lists a folder's direct children. An empty folderID
+// lists the caller's root.
+func (u *UploadService) GetFolderContents(folderID string, principal models.Principal) (*models.FolderContents, *models.AppError) {
+	tenant, appError := u.tenant(principal.TenantID)
+	if appError != nil {
+		return nil, appError
+	}
+
+	contents := &models.FolderContents{
+		Folders: make([]models.Folder, 0),
+		Files:   make([]models.FileMetadata, 0),
+	}
+	if folderID != "" {
+		chain, appError := u.authorizeFolder(tenant, folderID, principal, accessRead)
+		if appError != nil {
+			return nil, appError
+		}
+		folder := visibleFolder(chain[0], principal)
+		contents.Folder = &folder
+	}
+
+	// Everything below a readable folder is readable; the root only holds
+	// the caller's own entries.
+	inFolder := func(parentID, ownerID string) bool {
+		return parentID == folderID && (folderID != "" || ownerID == principal.UserID)
+	}
+
+	folders, err := tenant.storage.ListFolders()
+	if err != nil {
+		u.logger.Error("Failed to list folders", map[string]interface{}{
+			"folder_id": folderID,
+			"user_id":   principal.UserID,
+			"error":     err.Error(),
+		})
+		return nil, models.ErrInternalServer
+	}
+	for _, folder := range folders {
+		if inFolder(folder.ParentID, folder.UserID) {
+			contents.Folders = append(contents.Folders, visibleFolder(folder, principal))
+		}
+	}
+
+	files, err := tenant.storage.List()
+	if err != nil {
+		u.logger.Error("Failed to list files", map[string]interface{}{
+			"folder_id": folderID,
+			"user_id":   principal.UserID,
+			"error":     err.Error(),
+		})
+		return nil, models.ErrInternalServer
+	}
+	for _, metadata := range files {
+		if metadata.DeletedAt == nil && inFolder(metadata.FolderID, metadata.UserID) {
+			contents.Files = append(contents.Files, visibleMetadata(metadata, principal))
+		}
+	}
+
+	slices.SortFunc(contents.Folders, func(a, b models.Folder) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+	slices.SortFunc(contents.Files, func(a, b models.FileMetadata) int {
+		return strings.Compare(a.OriginalName, b.OriginalName)
+	})
+
+	return contents, nil
+}
+
+// UpdateFolder renames and/or moves a folder. Moving requires write access
+// to the destination, and only a folder's owner may move it to their root.
+func (u *UploadService) UpdateFolder(folderID string, principal models.Principal, req models.UpdateFolderRequest) (*models.Folder, *models.AppError) {
+	tenant, appError := u.tenant(principal.TenantID)
+	if appError != nil {
+		return nil, appError
+	}
+
+	tenant.folderMu.Lock()
+	defer tenant.folderMu.Unlock()
+
+	chain, appError := u.authorizeFolder(tenant, folderID, principal, accessWrite)
+	if appError != nil {
+		return nil, appError
+	}
+	folder := chain[0]
+
+	if req.Name != nil {
+		name, appError := validateFolderName(*req.Name)
+		if appError != nil {
+			return nil, appError
+		}
+		folder.Name = name
+	}
+
+	// Depth of the folder's new parent, if it moves
+	destinationDepth := -1
+	if req.ParentID != nil && *req.ParentID != folder.ParentID {
+		parentID := *req.ParentID
+		if parentID == "" {
+			if folder.UserID != principal.UserID {
+				return nil, models.ErrPermissionDenied
+			}
+			destinationDepth = 0
+		} else {
+			destination, appError := u.authorizeFolder(tenant, parentID, principal, accessWrite)
+			if appError != nil {
+				return nil, appError
+			}
+			for _, ancestor := range destination {
+				if ancestor.ID == folder.ID {
+					return nil, models.NewAppError(http.StatusBadRequest, "Cannot move a folder into itself", nil)
+				}
+			}
+			destinationDepth = len(destination)
+		}
+		folder.ParentID = parentID
+	}
+
+	folders, err := tenant.storage.ListFolders()
+	if err != nil {
+		u.logger.Error("Failed to list folders", map[string]interface{}{
+			"folder_id": folderID,
+			"user_id":   principal.UserID,
+			"error":     err.Error(),
+		})
+		return nil, models.ErrInternalServer
+	}
+	if existing, exists := findChild(folders, folder.ParentID, folder.UserID, folder.Name); exists && existing.ID != folder.ID {
+		return nil, models.ErrFolderExists
+	}
+	if destinationDepth >= 0 && destinationDepth+subtreeHeight(folders, folder.ID) > maxFolderDepth {
+		return nil, models.ErrFolderTooDeep
+	}
+
+	folder.UpdatedAt = time.Now().UTC()
+	if appError := u.saveFolder(tenant, folder, principal); appError != nil {
+		return nil, appError
+	}
+
+	u.logger.Info("Folder updated", map[string]interface{}{
+		"folder_id": folder.ID,
+		"parent_id": folder.ParentID,
+		"name":      folder.Name,
+		"user_id":   principal.UserID,
+	})
+
+	visible := visibleFolder(folder, principal)
+	return &visible, nil
+}
+
+// DeleteFolder deletes a folder with all its subfolders, moving the files in
+// them to the trash.
+func (u *UploadService) DeleteFolder(folderID string, principal models.Principal) *models.AppError {
+	tenant, appError := u.tenant(principal.TenantID)
+	if appError != nil {
+		return appError
+	}
+
+	tenant.folderMu.Lock()
+	defer tenant.folderMu.Unlock()
+
+	if _, appError := u.authorizeFolder(tenant, folderID, principal, accessWrite); appError != nil {
+		return appError
+	}
+
+	folders, err := tenant.storage.ListFolders()
+	if err != nil {
+		u.logger.Error("Failed to list folders", map[string]interface{}{
+			"folder_id": folderID,
+			"user_id":   principal.UserID,
+			"error":     err.Error(),
+		})
+		return models.ErrInternalServer
+	}
+
+	// Collect the subtree breadth-first
+	subtree := []string{folderID}
+	for i := 0; i < len(subtree); i++ {
+		for _, folder := range folders {
+			if folder.ParentID == subtree[i] {
+				subtree = append(subtree, folder.ID)
+			}
+		}
+	}
+
+	files, err := tenant.storage.List()
+	if err != nil {
+		u.logger.Error("Failed to list files", map[string]interface{}{
+			"folder_id": folderID,
+			"user_id":   principal.UserID,
+			"error":     err.Error(),
+		})
+		return models.ErrInternalServer
+	}
+
+	var contents []models.FileMetadata
+	for _, metadata := range files {
+		if metadata.FolderID == "" || metadata.DeletedAt != nil || !slices.Contains(subtree, metadata.FolderID) {
+			continue
+		}
+		// Fail before changing anything if any file can't be deleted
+		if appError := u.checkLock(tenant, metadata, principal, "delete"); appError != nil {
+			return appError
+		}
+		contents = append(contents, metadata)
+	}
+
+	removed := 0
+	for _, metadata := range contents {
+		if err := u.deleteFile(tenant, metadata.ID, principal); err != nil {
+			var appError *models.AppError
+			if errors.As(err, &appError) {
+				return appError
+			}
+			u.logger.Error("Failed to delete file in folder", map[string]interface{}{
+				"file_id":   metadata.ID,
+				"folder_id": folderID,
+				"user_id":   principal.UserID,
+				"error":     err.Error(),
+			})
+			return models.ErrInternalServer
+		}
+		removed++
+	}
+
+	// Deepest folders first so a failure never orphans a subtree
+	for i := len(subtree) - 1; i >= 0; i-- {
+		if err := tenant.storage.DeleteFolder(subtree[i]); err != nil {
+			u.logger.Error("Failed to delete folder", map[string]interface{}{
+				"folder_id": subtree[i],
+				"user_id":   principal.UserID,
+				"error":     err.Error(),
+			})
+			return models.ErrInternalServer
+		}
+	}
+
+	u.logger.Info("Folder deleted", map[string]interface{}{
+		"folder_id":     folderID,
+		"user_id":       principal.UserID,
+		"folders":       len(subtree),
+		"files_deleted": removed,
+	})
+
+	return nil
+}
+
+func (u *UploadService) GrantFolderPermission(folderID string, principal models.Principal, req models.GrantPermissionRequest) ([]models.ACLEntry, *models.AppError) {
+	if appError := validateGrant(req, principal); appError != nil {
+		return nil, appError
+	}
+
+	tenant, appError := u.tenant(principal.TenantID)
+	if appError != nil {
+		return nil, appError
+	}
+
+	tenant.folderMu.Lock()
+	defer tenant.folderMu.Unlock()
+
+	chain, appError := u.authorizeFolder(tenant, folderID, principal, accessOwner)
+	if appError != nil {
+		return nil, appError
+	}
+	folder := chain[0]
+
+	folder.Permissions = grantACL(folder.Permissions, req, principal)
+	if appError := u.saveFolder(tenant, folder, principal); appError != nil {
+		return nil, appError
+	}
+
+	u.logger.Info("Folder permission granted", map[string]interface{}{
+		"folder_id":  folderID,
+		"user_id":    principal.UserID,
+		"grantee":    req.Type + ":" + req.ID,
+		"permission": req.Permission,
+	})
+
+	return folder.Permissions, nil
+}
+
+func (u *UploadService) RevokeFolderPermission(folderID string, principal models.Principal, granteeType, granteeID string) ([]models.ACLEntry, *models.AppError) {
+	tenant, appError := u.tenant(principal.TenantID)
+	if appError != nil {
+		return nil, appError
+	}
+
+	tenant.folderMu.Lock()
+	defer tenant.folderMu.Unlock()
+
+	chain, appError := u.authorizeFolder(tenant, folderID, principal, accessOwner)
+	if appError != nil {
+		return nil, appError
+	}
+	folder := chain[0]
+
+	var found bool
+	folder.Permissions, found = revokeACL(folder.Permissions, granteeType, granteeID)
+	if !found {
+		return nil, models.NewAppError(http.StatusNotFound, "Permission not found", nil)
+	}
+
+	if appError := u.saveFolder(tenant, folder, principal); appError != nil {
+		return nil, appError
+	}
+
+	u.logger.Info("Folder permission revoked", map[string]interface{}{
+		"folder_id": folderID,
+		"user_id":   principal.UserID,
+		"grantee":   granteeType + ":" + granteeID,
+	})
+
+	return folder.Permissions, nil
+}
+
+// ListSharedFolders returns folders other users have shared with principal
+// directly or through one of its groups.
+func (u *UploadService) ListSharedFolders(principal models.Principal) ([]models.Folder, *models.AppError) {
+	tenant, appError := u.tenant(principal.TenantID)
+	if appError != nil {
+		return nil, appError
+	}
+
+	folders, err := tenant.storage.ListFolders()
+	if err != nil {
+		u.logger.Error("Failed to list folders", map[string]interface{}{
+			"user_id": principal.UserID,
+			"error":   err.Error(),
+		})
+		return nil, models.ErrInternalServer
+	}
+
+	result := make([]models.Folder, 0)
+	for _, folder := range folders {
+		if folder.UserID != principal.UserID && aclAccess(folder.Permissions, principal) > accessNone {
+			result = append(result, visibleFolder(folder, principal))
+		}
+	}
+
+	slices.SortFunc(result, func(a, b models.Folder) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+
+	return result, nil
+}
+
+// ResolvePath looks up a slash-separated path relative to the caller's root.
+// It returns the folder ID when the path names a folder, or the file ID when
+// the last segment names a file. When a folder holds several files with the
+// same name, the newest wins.
+func (u *UploadService) ResolvePath(principal models.Principal, path string) (folderID, fileID string, appError *models.AppError) {
+	tenant, appError := u.tenant(principal.TenantID)
+	if appError != nil {
+		return "", "", appError
+	}
+
+	folders, err := tenant.storage.ListFolders()
+	if err != nil {
+		u.logger.Error("Failed to list folders", map[string]interface{}{
+			"user_id": principal.UserID,
+			"error":   err.Error(),
+		})
+		return "", "", models.ErrInternalServer
+	}
+
+	segments := splitPath(path)
+	for i, segment := range segments {
+		if folder, ok := findChild(folders, folderID, principal.UserID, segment); ok {
+			folderID = folder.ID
+			continue
+		}
+		if i < len(segments)-1 {
+			return "", "", models.ErrFileNotFound
+		}
+
+		fileID, appError := u.findFile(tenant, principal, folderID, segment)
+		if appError != nil {
+			return "", "", appError
+		}
+		if _, appError := u.authorize(tenant, fileID, principal, accessRead); appError != nil {
+			return "", "", appError
+		}
+		return "", fileID, nil
+	}
+
+	if folderID != "" {
+		if _, appError := u.authorizeFolder(tenant, folderID, principal, accessRead); appError != nil {
+			return "", "", appError
+		}
+	}
+	return folderID, "", nil
+}
+
+// findFile returns the newest file named name directly in folderID.
+func (u *UploadService) findFile(tenant *tenantServices, principal models.Principal, folderID, name string) (string, *models.AppError) {
+	files, err := tenant.storage.List()
+	if err != nil {
+		u.logger.Error("Failed to list files", map[string]interface{}{
+			"folder_id": folderID,
+			"user_id":   principal.UserID,
+			"error":     err.Error(),
+		})
+		return "", models.ErrInternalServer
+	}
+
+	var found *models.FileMetadata
+	for i, metadata := range files {
+		if metadata.FolderID != folderID || metadata.OriginalName != name || metadata.DeletedAt != nil {
+			continue
+		}
+		if folderID == "" && metadata.UserID != principal.UserID {
+			continue
+		}
+		if found == nil || metadata.UploadTime.After(found.UploadTime) {
+			found = &files[i]
+		}
+	}
+	if found == nil {
+		return "", models.ErrFileNotFound
+	}
+	return found.ID, nil
+}
+
+// uploadTarget is where an upload goes: an existing folder, or a path whose
+// missing folders are created only once the upload is accepted.
+type uploadTarget struct {
+	folderID string
+	path     []string
+}
+
+// resolveUploadTarget checks where an upload goes without creating anything.
+func (u *UploadService) resolveUploadTarget(tenant *tenantServices, principal models.Principal, options models.UploadOptions) (uploadTarget, *models.AppError) {
+	switch {
+	case options.FolderID != "" && options.Path != "":
+		return uploadTarget{}, models.NewAppError(http.StatusBadRequest, "Specify either folder_id or path, not both", nil)
+	case options.FolderID != "":
+		if _, appError := u.authorizeFolder(tenant, options.FolderID, principal, accessWrite); appError != nil {
+			return uploadTarget{}, appError
+		}
+		return uploadTarget{folderID: options.FolderID}, nil
+	case options.Path == "":
+		return uploadTarget{}, nil
+	}
+
+	segments := splitPath(options.Path)
+	if len(segments) > maxFolderDepth {
+		return uploadTarget{}, models.ErrFolderTooDeep
+	}
+	for _, segment := range segments {
+		if _, appError := validateFolderName(segment); appError != nil {
+			return uploadTarget{}, appError
+		}
+	}
+	return uploadTarget{path: segments}, nil
+}
+
+// uploadFolder returns the folder an accepted upload goes in, creating any
+// missing folders along the target's path.
+func (u *UploadService) uploadFolder(tenant *tenantServices, principal models.Principal, target uploadTarget) (string, *models.AppError) {
+	if len(target.path) == 0 {
+		return target.folderID, nil
+	}
+
+	tenant.folderMu.Lock()
+	defer tenant.folderMu.Unlock()
+
+	folders, err := tenant.storage.ListFolders()
+	if err != nil {
+		u.logger.Error("Failed to list folders", map[string]interface{}{
+			"user_id": principal.UserID,
+			"error":   err.Error(),
+		})
+		return "", models.ErrInternalServer
+	}
+
+	folderID := ""
+	for i, segment := range target.path {
+		if folder, ok := findChild(folders, folderID, principal.UserID, segment); ok {
+			folderID = folder.ID
+			continue
+		}
+
+		// The rest of the path can't exist yet, so needs no more lookups
+		for _, segment := range target.path[i:] {
+			folder, appError := u.addFolder(tenant, principal, folderID, segment)
+			if appError != nil {
+				return "", appError
+			}
+			folderID = folder.ID
+		}
+		break
+	}
+
+	return folderID, nil
+}
+
+func (u *UploadService) saveFolder(tenant *tenantServices, folder models.Folder, principal models.Principal) *models.AppError {
+	if err := tenant.storage.StoreFolder(folder); err != nil {
+		u.logger.Error("Failed to store folder", map[string]interface{}{
+			"folder_id": folder.ID,
+			"user_id":   principal.UserID,
+			"error":     err.Error(),
+		})
+		return models.ErrInternalServer
+	}
+	return nil
+}
+
+// splitPath splits a slash-separated path, ignoring empty segments.
+func splitPath(path string) []string {
+	return strings.FieldsFunc(path, func(r rune) bool {
+		return r == '/'
+	})
+}
@@ -0,0 +1,149 @@
+package services
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/jpeg"
+	"image/png"
+)
+
+const (
+	defaultMaxImagePixels = 40 * 1000 * 1000
+	defaultJPEGQuality    = 85
+)
+
+var (
+	errImageTooLarge    = errors.New("image exceeds the pixel limit")
+	errUnsupportedImage = errors.New("unsupported image type")
+)
+
+// imageCodecs decode and encode the image types that can be processed.
+var imageCodecs = map[string]struct {
+	decodeConfig func(data []byte) (image.Config, error)
+	decode       func(data []byte) (image.Image, error)
+}{
+	"image/jpeg": {
+		decodeConfig: func(data []byte) (image.Config, error) { return jpeg.DecodeConfig(bytes.NewReader(data)) },
+		decode:       func(data []byte) (image.Image, error) { return jpeg.Decode(bytes.NewReader(data)) },
+	},
+	"image/png": {
+		decodeConfig: func(data []byte) (image.Config, error) { return png.DecodeConfig(bytes.NewReader(data)) },
+		decode:       func(data []byte) (image.Image, error) { return png.Decode(bytes.NewReader(data)) },
+	},
+}
+
+// CanProcessImage reports whether images of contentType can be decoded,
+// resized and encoded.
+func CanProcessImage(contentType string) bool {
+	_, ok := imageCodecs[baseContentType(contentType)]
+	return ok
+}
+
+// DecodeImage decodes a JPEG or PNG image. The header is checked first so an
+// image over maxPixels is rejected before any pixels are allocated.
+func DecodeImage(contentType string, data []byte, maxPixels int64) (image.Image, error) {
+	codec, ok := imageCodecs[baseContentType(contentType)]
+	if !ok {
+		return nil, errUnsupportedImage
+	}
+	if maxPixels <= 0 {
+		maxPixels = defaultMaxImagePixels
+	}
+
+	config, err := codec.decodeConfig(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read image header: %w", err)
+	}
+	if config.Width <= 0 || config.Height <= 0 {
+		return nil, errors.New("image has no pixels")
+	}
+	if int64(config.Width)*int64(config.Height) > maxPixels {
+		return nil, errImageTooLarge
+	}
+
+	img, err := codec.decode(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode image: %w", err)
+	}
+	return img, nil
+}
+
+// EncodeImage encodes img as JPEG, at quality from 1 to 100, or as PNG.
+func EncodeImage(img image.Image, contentType string, quality int) ([]byte, error) {
+	var buf bytes.Buffer
+	switch baseContentType(contentType) {
+	case "image/jpeg":
+		if quality < 1 || quality > 100 {
+			quality = defaultJPEGQuality
+		}
+		if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: quality}); err != nil {
+			return nil, err
+		}
+	case "image/png":
+		if err := png.Encode(&buf, img); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, errUnsupportedImage
+	}
+	return buf.Bytes(), nil
+}
+
+// fitWithin returns the size of a width by height image scaled down to fit
+// within a box of maxWidth by maxHeight, keeping its aspect ratio. Images
+// that already fit keep their size.
+func fitWithin(width, height, maxWidth, maxHeight int) (int, int) {
+	if width <= maxWidth && height <= maxHeight {
+		return width, height
+	}
+	if width*maxHeight > height*maxWidth {
+		return maxWidth, max(1, height*maxWidth/width)
+	}
+	return max(1, width*maxHeight/height), maxHeight
+}
+
+// resizeImage scales img to width by height. Each output pixel averages the
+// source pixels it covers, which keeps downscaled images free of the
+// aliasing nearest-neighbour sampling causes.
+func resizeImage(img image.Image, width, height int) *image.RGBA {
+	bounds := img.Bounds()
+	src, ok := img.(*image.RGBA)
+	if !ok || bounds.Min != (image.Point{}) {
+		// Averaging premultiplied colors weighs transparent pixels properly
+		src = image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+		draw.Draw(src, src.Bounds(), img, bounds.Min, draw.Src)
+	}
+	srcWidth, srcHeight := src.Bounds().Dx(), src.Bounds().Dy()
+
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		y0 := y * srcHeight / height
+		y1 := max((y+1)*srcHeight/height, y0+1)
+		for x := 0; x < width; x++ {
+			x0 := x * srcWidth / width
+			x1 := max((x+1)*srcWidth/width, x0+1)
+
+			var r, g, b, a, n uint64
+			for sy := y0; sy < y1; sy++ {
+				row := src.Pix[sy*src.Stride+x0*4 : sy*src.Stride+x1*4]
+				for i := 0; i < len(row); i += 4 {
+					r += uint64(row[i])
+					g += uint64(row[i+1])
+					b += uint64(row[i+2])
+					a += uint64(row[i+3])
+					n++
+				}
+			}
+
+			i := y*dst.Stride + x*4
+			dst.Pix[i] = uint8(r / n)
+			dst.Pix[i+1] = uint8(g / n)
+			dst.Pix[i+2] = uint8(b / n)
+			dst.Pix[i+3] = uint8(a / n)
+		}
+	}
+	return dst
+}
@@ -0,0 +1,428 @@
+package services
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"maps"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/ebinskryfon/fileuploader/models"
+)
+
+// PDF policies, chosen by the PDF_POLICY setting.
+const (
+	PDFPolicyReject = "reject"
+	PDFPolicyFlag   = "flag"
+	PDFPolicyAllow  = "allow"
+)
+
+// pdfObjectPrefix matches the "N G obj" that begins an indirect object.
+var pdfObjectPrefix = regexp.MustCompile(`^(\d+)\s+(\d+)\s+obj\b`)
+
+// PDFPolicy decides what happens to each kind of finding in an uploaded
+// PDF.
+type PDFPolicy struct {
+	mode      string
+	overrides map[string]string
+}
+
+// NewPDFPolicy creates a policy applying mode to every kind of finding
+// except those overrides name. An unset mode flags findings; one that isn't
+// recognized rejects them. Files too costly to inspect fully are rejected
+// whatever the mode, unless an override says otherwise.
+func NewPDFPolicy(mode string, overrides map[string]string) *PDFPolicy {
+	p := &PDFPolicy{mode: pdfPolicyMode(mode), overrides: make(map[string]string)}
+	for kind, override := range overrides {
+		p.overrides[kind] = pdfPolicyMode(override)
+	}
+	return p
+}
+
+func pdfPolicyMode(mode string) string {
+	switch mode = strings.ToLower(strings.TrimSpace(mode)); mode {
+	case "":
+		return PDFPolicyFlag
+	case PDFPolicyFlag, PDFPolicyAllow:
+		return mode
+	}
+	return PDFPolicyReject
+}
+
+// Mode returns the policy for a kind of finding.
+func (p *PDFPolicy) Mode(kind string) string {
+	if mode, ok := p.overrides[kind]; ok {
+		return mode
+	}
+	if kind == models.FindingLimitExceeded {
+		return PDFPolicyReject
+	}
+	return p.mode
+}
+
+// Inspects reports whether any finding would be acted on, so PDFs need
+// inspecting at all.
+func (p *PDFPolicy) Inspects() bool {
+	if p.mode != PDFPolicyAllow {
+		return true
+	}
+	for _, mode := range p.overrides {
+		if mode != PDFPolicyAllow {
+			return true
+		}
+	}
+	return false
+}
+
+// Apply splits findings into those the policy rejects and those it flags.
+// Allowed findings are dropped.
+func (p *PDFPolicy) Apply(findings []models.ContentFinding) (rejected, flagged []models.ContentFinding) {
+	for _, finding := range findings {
+		switch p.Mode(finding.Kind) {
+		case PDFPolicyReject:
+			rejected = append(rejected, finding)
+		case PDFPolicyFlag:
+			flagged = append(flagged, finding)
+		}
+	}
+	return rejected, flagged
+}
+
+// InspectPDF parses a PDF's object structure, looking for JavaScript,
+// launch and open actions, embedded files and XFA forms, and checks its
+// cross-reference table. Each kind of finding is reported once, for the
+// first object it appears in. A file that runs out of budget is reported
+// with what was found before inspection stopped.
+func InspectPDF(data []byte) []models.ContentFinding {
+	budget := newPDFBudget()
+	doc, err := parsePDF(data, budget)
+	limited := errors.Is(err, errPDFBudget) || errors.Is(err, errPDFTimeout)
+	if err != nil && !limited {
+		return []models.ContentFinding{{Kind: models.FindingMalformedXref, Detail: err.Error()}}
+	}
+
+	var findings []models.ContentFinding
+	seen := make(map[string]bool)
+	record := func(num int) func(pdfDict) {
+		return func(dict pdfDict) {
+			for _, kind := range pdfDictFindings(dict) {
+				if !seen[kind] {
+					seen[kind] = true
+					findings = append(findings, models.ContentFinding{Kind: kind, Object: num})
+				}
+			}
+		}
+	}
+	for _, num := range slices.Sorted(maps.Keys(doc.objects)) {
+		visitPDFDicts(doc.objects[num], 0, record(num))
+	}
+	// Trailers may hold actions too
+	visitPDFDicts(doc.trailer, 0, record(0))
+
+	if !limited {
+		var problem string
+		problem, err = checkPDFXref(data, budget)
+		if problem != "" {
+			findings = append(findings, models.ContentFinding{Kind: models.FindingMalformedXref, Detail: problem})
+		}
+	}
+	if err != nil {
+		findings = append(findings, models.ContentFinding{Kind: models.FindingLimitExceeded, Detail: err.Error()})
+	}
+	return findings
+}
+
+// pdfDictFindings returns the kinds of finding a single dictionary shows.
+func pdfDictFindings(dict pdfDict) []string {
+	var kinds []string
+	_, js := dict["JS"]
+	// A JavaScript entry is the document-level name tree of scripts
+	_, names := dict["JavaScript"]
+	if js || names || dict["S"] == pdfName("JavaScript") {
+		kinds = append(kinds, models.FindingJavaScript)
+	}
+	if dict["S"] == pdfName("Launch") {
+		kinds = append(kinds, models.FindingLaunch)
+	}
+	if _, ok := dict["OpenAction"]; ok {
+		kinds = append(kinds, models.FindingOpenAction)
+	}
+	_, ef := dict["EF"]
+	_, embedded := dict["EmbeddedFiles"]
+	if ef || embedded || dict["Type"] == pdfName("EmbeddedFile") {
+		kinds = append(kinds, models.FindingEmbeddedFile)
+	}
+	if _, ok := dict["XFA"]; ok {
+		kinds = append(kinds, models.FindingXFA)
+	}
+	return kinds
+}
+
+// visitPDFDicts calls visit for every dictionary in value, however deeply
+// nested.
+func visitPDFDicts(value any, depth int, visit func(pdfDict)) {
+	if depth > maxPDFDepth {
+		return
+	}
+	switch v := value.(type) {
+	case pdfDict:
+		visit(v)
+		for _, item := range v {
+			visitPDFDicts(item, depth+1, visit)
+		}
+	case pdfArray:
+		for _, item := range v {
+			visitPDFDicts(item, depth+1, visit)
+		}
+	case *pdfStream:
+		visitPDFDicts(v.dict, depth, visit)
+	}
+}
+
+// checkPDFXref follows the chain of cross-reference sections from the last
+// startxref, checking that every object they locate is where they say. It
+// returns what is wrong, or "" when the chain is intact. Cross-reference
+// streams are charged to budget; running out of it, or of time, stops the
+// walk with errPDFBudget or errPDFTimeout.
+func checkPDFXref(data []byte, budget *pdfBudget) (string, error) {
+	index := bytes.LastIndex(data, []byte("startxref"))
+	if index < 0 {
+		return "no startxref", nil
+	}
+	p := &pdfParser{data: data, pos: index + len("startxref")}
+	value, err := p.parseValue(0)
+	offset, ok := value.(float64)
+	if err != nil || !ok {
+		return "startxref has no offset", nil
+	}
+
+	visited := make(map[int]bool)
+	for next := int(offset); ; {
+		if budget.expired() {
+			return "", errPDFTimeout
+		}
+		if next < 0 || next >= len(data) {
+			return fmt.Sprintf("cross-reference offset %d is outside the file", next), nil
+		}
+		if visited[next] {
+			return "cross-reference sections form a loop", nil
+		}
+		visited[next] = true
+
+		var trailer pdfDict
+		var problem string
+		if bytes.HasPrefix(data[next:], []byte("xref")) {
+			trailer, problem = checkPDFXrefTable(data, next)
+		} else {
+			trailer, problem, err = checkPDFXrefStream(data, next, budget)
+		}
+		if problem != "" || err != nil {
+			return problem, err
+		}
+		// Hybrid files keep a cross-reference stream alongside the table
+		if stream, ok := trailer["XRefStm"].(float64); ok {
+			if _, problem, err := checkPDFXrefStream(data, int(stream), budget); problem != "" || err != nil {
+				return problem, err
+			}
+		}
+
+		prev, ok := trailer["Prev"].(float64)
+		if !ok {
+			return "", nil
+		}
+		next = int(prev)
+	}
+}
+
+// checkPDFXrefTable checks a classic cross-reference table and returns the
+// trailer that follows it.
+func checkPDFXrefTable(data []byte, offset int) (pdfDict, string) {
+	malformed := fmt.Sprintf("cross-reference table at offset %d is malformed", offset)
+
+	p := &pdfParser{data: data, pos: offset + len("xref")}
+	for {
+		p.skipSpace()
+		if bytes.HasPrefix(data[p.pos:], []byte("trailer")) {
+			break
+		}
+		first, err1 := strconv.Atoi(p.parseToken())
+		p.skipSpace()
+		count, err2 := strconv.Atoi(p.parseToken())
+		if err1 != nil || err2 != nil || first < 0 || count < 0 || count > maxPDFObjects {
+			return nil, malformed
+		}
+
+		for i := 0; i < count; i++ {
+			p.skipSpace()
+			entryOffset, err1 := strconv.Atoi(p.parseToken())
+			p.skipSpace()
+			_, err2 := strconv.Atoi(p.parseToken())
+			p.skipSpace()
+			kind := p.parseToken()
+			if err1 != nil || err2 != nil || (kind != "n" && kind != "f") {
+				return nil, malformed
+			}
+			if kind == "n" && !pdfObjectAt(data, entryOffset, first+i) {
+				return nil, fmt.Sprintf("object %d is not at offset %d", first+i, entryOffset)
+			}
+		}
+	}
+
+	p.pos += len("trailer")
+	value, err := p.parseValue(0)
+	trailer, ok := value.(pdfDict)
+	if err != nil || !ok {
+		return nil, fmt.Sprintf("cross-reference table at offset %d has no trailer", offset)
+	}
+	return trailer, ""
+}
+
+// checkPDFXrefStream checks a cross-reference stream and returns its
+// dictionary, which serves as the trailer. The error is errPDFBudget when
+// decoding the stream would exceed budget.
+func checkPDFXrefStream(data []byte, offset int, budget *pdfBudget) (pdfDict, string, error) {
+	notXref := fmt.Sprintf("offset %d is not a cross-reference section", offset)
+	if offset < 0 || offset >= len(data) {
+		return nil, notXref, nil
+	}
+	match := pdfObjectPrefix.FindIndex(data[offset:min(offset+64, len(data))])
+	if match == nil {
+		return nil, notXref, nil
+	}
+	p := &pdfParser{data: data, pos: offset + match[1]}
+	value, err := p.parseObject()
+	stream, ok := value.(*pdfStream)
+	if err != nil || !ok || stream.dict["Type"] != pdfName("XRef") {
+		return nil, notXref, nil
+	}
+
+	malformed := fmt.Sprintf("cross-reference stream at offset %d is malformed", offset)
+	entries, err := decodePDFStreamData(stream, budget)
+	if errors.Is(err, errPDFBudget) {
+		return nil, "", err
+	}
+	if err != nil {
+		return nil, malformed, nil
+	}
+
+	// W gives the width of the type, offset and generation fields
+	widths, ok := stream.dict["W"].(pdfArray)
+	if !ok || len(widths) != 3 {
+		return nil, malformed, nil
+	}
+	var w [3]int
+	rowWidth := 0
+	for i, width := range widths {
+		n, ok := width.(float64)
+		if !ok || n < 0 || n > 8 {
+			return nil, malformed, nil
+		}
+		w[i] = int(n)
+		rowWidth += w[i]
+	}
+	if rowWidth == 0 {
+		return nil, malformed, nil
+	}
+
+	// Index lists the object ranges the rows cover, by default all of them
+	sections, ok := stream.dict["Index"].(pdfArray)
+	if !ok {
+		sections = pdfArray{0.0, stream.dict["Size"]}
+	}
+	if len(sections)%2 != 0 {
+		return nil, malformed, nil
+	}
+	row := 0
+	for i := 0; i < len(sections); i += 2 {
+		first, ok1 := sections[i].(float64)
+		count, ok2 := sections[i+1].(float64)
+		if !ok1 || !ok2 || first < 0 || count < 0 || count > maxPDFObjects {
+			return nil, malformed, nil
+		}
+		for j := 0; j < int(count); j++ {
+			if (row+1)*rowWidth > len(entries) {
+				return nil, malformed, nil
+			}
+			fields := entries[row*rowWidth:]
+			row++
+
+			kind := 1
+			if w[0] > 0 {
+				kind = int(readXrefField(fields[:w[0]]))
+			}
+			if kind != 1 {
+				continue
+			}
+			entryOffset := readXrefField(fields[w[0] : w[0]+w[1]])
+			if num := int(first) + j; !pdfObjectAt(data, int(entryOffset), num) {
+				return nil, fmt.Sprintf("object %d is not at offset %d", num, entryOffset), nil
+			}
+		}
+	}
+	return stream.dict, "", nil
+}
+
+// readXrefField reads a big-endian cross-reference stream field.
+func readXrefField(field []byte) uint64 {
+	var value uint64
+	for _, b := range field {
+		value = value<<8 | uint64(b)
+	}
+	return value
+}
+
+// pdfObjectAt reports whether object num begins at offset.
+func pdfObjectAt(data []byte, offset, num int) bool {
+	if offset < 0 || offset >= len(data) {
+		return false
+	}
+	match := pdfObjectPrefix.FindSubmatch(data[offset:min(offset+64, len(data))])
+	if match == nil {
+		return false
+	}
+	found, err := strconv.Atoi(string(match[1]))
+	return err == nil && found == num
+}
+
+// inspectContent applies the tenant's PDF policy to content, rejecting it or
+// returning the findings to record on its metadata.
+func (u *UploadService) inspectContent(tenant *tenantServices, metadata models.FileMetadata, content []byte) ([]models.ContentFinding, *models.AppError) {
+	if tenant.validation.Canonical(metadata.ContentType) != "application/pdf" || !tenant.pdfPolicy.Inspects() {
+		return nil, nil
+	}
+
+	rejected, flagged := tenant.pdfPolicy.Apply(InspectPDF(content))
+	if len(rejected) > 0 {
+		kinds := make([]string, 0, len(rejected))
+		for _, finding := range rejected {
+			kinds = append(kinds, finding.Kind)
+		}
+		u.logger.Warn("PDF rejected by content policy", map[string]interface{}{
+			"file_id":   metadata.ID,
+			"file_name": metadata.OriginalName,
+			"user_id":   metadata.UploadedBy,
+			"tenant_id": tenant.id,
+			"findings":  strings.Join(kinds, ","),
+		})
+		return nil, models.NewValidationError(models.ReasonActiveContent, "PDF contains content that is not allowed",
+			map[string]string{"findings": strings.Join(kinds, ",")})
+	}
+
+	if len(flagged) > 0 {
+		kinds := make([]string, 0, len(flagged))
+		for _, finding := range flagged {
+			kinds = append(kinds, finding.Kind)
+		}
+		u.logger.Info("PDF flagged by content policy", map[string]interface{}{
+			"file_id":   metadata.ID,
+			"file_name": metadata.OriginalName,
+			"user_id":   metadata.UploadedBy,
+			"tenant_id": tenant.id,
+			"findings":  strings.Join(kinds, ","),
+		})
+	}
+	return flagged, nil
+}
@@ -0,0 +1,323 @@
+package services
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ebinskryfon/fileuploader/config"
+)
+
+// minJWKSRefetch throttles refetches triggered by unknown key IDs so that
+// tokens with garbage kids cannot hammer the identity provider. It is also
+// the first backoff after a failed refresh, doubling with each further
+// failure up to the refresh interval.
+const minJWKSRefetch = 30 * time.Second
+
+// maxJWKSSize bounds the JWKS response read from the identity provider.
+const maxJWKSSize = 1 << 20
+
+// KeySet holds the public keys used to verify asymmetrically signed tokens.
+// Keys come from static PEM files and/or a JWKS endpoint; the JWKS is cached
+// and refreshed periodically, and refetched early when a token references an
+// unknown kid so that newly rotated keys are picked up. Fetches happen
+// outside the lock and are shared by concurrent callers, so a slow or down
+// identity provider never holds up tokens signed with cached keys.
+type KeySet struct {
+	static          map[string]crypto.PublicKey
+	jwksURL         string
+	refreshInterval time.Duration
+	client          *http.Client
+
+	mu        sync.Mutex
+	remote    map[string]crypto.PublicKey
+	fetchedAt time.Time
+	missedAt  time.Time
+	// retryAt is when refreshes may be tried again after failures.
+	retryAt  time.Time
+	failures int
+	fetching *jwksFetch
+}
+
+// jwksFetch is a JWKS fetch in progress; err is set before done is closed.
+type jwksFetch struct {
+	done chan struct{}
+	err  error
+}
+
+type jwks struct {
+	Keys []jwk `json:"keys"`
+}
+
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+func NewKeySet(cfg *config.Config) (*KeySet, error) {
+	ks := &KeySet{
+		static:          make(map[string]crypto.PublicKey),
+		jwksURL:         cfg.Auth.JWKSURL,
+		refreshInterval: cfg.Auth.JWKSRefreshInterval,
+		client:          &http.Client{Timeout: 10 * time.Second},
+		remote:          make(map[string]crypto.PublicKey),
+	}
+
+	for _, path := range cfg.Auth.PublicKeyFiles {
+		key, err := loadPublicKeyFile(path)
+		if err != nil {
+			return nil, err
+		}
+		kid := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+		ks.static[kid] = key
+	}
+
+	if ks.jwksURL != "" {
+		if err := ks.refresh(); err != nil {
+			return nil, err
+		}
+	}
+
+	return ks, nil
+}
+
+// Empty reports whether the set has no way of producing keys.
+func (ks *KeySet) Empty() bool {
+	return len(ks.static) == 0 && ks.jwksURL == ""
+}
+
+// Lookup returns the candidate keys for a token. With a kid only the matching
+// key is returned; without one every known key is a candidate, which lets
+// tokens from single-key issuers verify during rotation.
+func (ks *KeySet) Lookup(kid string) ([]crypto.PublicKey, error) {
+	if key, ok := ks.static[kid]; ok && kid != "" {
+		return []crypto.PublicKey{key}, nil
+	}
+
+	ks.mu.Lock()
+	if ks.jwksURL != "" && time.Since(ks.fetchedAt) > ks.refreshInterval && time.Now().After(ks.retryAt) {
+		// Refresh in the background; the cached keys serve meanwhile
+		if fetch, started := ks.startFetchLocked(); started {
+			go ks.runFetch(fetch)
+		}
+	}
+
+	if kid == "" {
+		keys := make([]crypto.PublicKey, 0, len(ks.static)+len(ks.remote))
+		for _, key := range ks.static {
+			keys = append(keys, key)
+		}
+		for _, key := range ks.remote {
+			keys = append(keys, key)
+		}
+		ks.mu.Unlock()
+		return keys, nil
+	}
+
+	key, ok := ks.remote[kid]
+	refetch := !ok && ks.jwksURL != "" && time.Since(ks.missedAt) > minJWKSRefetch && time.Now().After(ks.retryAt)
+	if refetch {
+		ks.missedAt = time.Now()
+	}
+	ks.mu.Unlock()
+
+	if ok {
+		return []crypto.PublicKey{key}, nil
+	}
+	if refetch {
+		// The key may have just been rotated in, so wait for the new set
+		if err := ks.refresh(); err != nil {
+			return nil, err
+		}
+		ks.mu.Lock()
+		key, ok = ks.remote[kid]
+		ks.mu.Unlock()
+		if ok {
+			return []crypto.PublicKey{key}, nil
+		}
+	}
+
+	return nil, fmt.Errorf("unknown key id: %s", kid)
+}
+
+// refresh fetches the JWKS, or waits for the fetch already in progress.
+func (ks *KeySet) refresh() error {
+	ks.mu.Lock()
+	fetch, started := ks.startFetchLocked()
+	ks.mu.Unlock()
+
+	if started {
+		ks.runFetch(fetch)
+	}
+	<-fetch.done
+	return fetch.err
+}
+
+// startFetchLocked returns the fetch in progress, or a new one the caller
+// must run when started is true. Callers must hold ks.mu.
+func (ks *KeySet) startFetchLocked() (fetch *jwksFetch, started bool) {
+	if ks.fetching != nil {
+		return ks.fetching, false
+	}
+	ks.fetching = &jwksFetch{done: make(chan struct{})}
+	return ks.fetching, true
+}
+
+// runFetch fetches the JWKS without holding ks.mu, then swaps in the new
+// keys, or backs off further refreshes if the fetch failed.
+func (ks *KeySet) runFetch(fetch *jwksFetch) {
+	keys, err := ks.fetchKeys()
+
+	ks.mu.Lock()
+	if err == nil {
+		ks.remote = keys
+		ks.fetchedAt = time.Now()
+		ks.retryAt = time.Time{}
+		ks.failures = 0
+	} else {
+		backoff := min(minJWKSRefetch<<min(ks.failures, 16), max(ks.refreshInterval, minJWKSRefetch))
+		ks.retryAt = time.Now().Add(backoff)
+		ks.failures++
+	}
+	ks.fetching = nil
+	ks.mu.Unlock()
+
+	fetch.err = err
+	close(fetch.done)
+}
+
+// fetchKeys downloads the JWKS and returns its usable signing keys.
+func (ks *KeySet) fetchKeys() (map[string]crypto.PublicKey, error) {
+	resp, err := ks.client.Get(ks.jwksURL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch JWKS: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to fetch JWKS: unexpected status %d", resp.StatusCode)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxJWKSSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch JWKS: %v", err)
+	}
+	if len(data) > maxJWKSSize {
+		return nil, fmt.Errorf("failed to fetch JWKS: response larger than %d bytes", maxJWKSSize)
+	}
+
+	var set jwks
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, fmt.Errorf("failed to decode JWKS: %v", err)
+	}
+
+	keys := make(map[string]crypto.PublicKey)
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		key, err := k.publicKey()
+		if err != nil {
+			// Skip keys we can't use rather than rejecting the whole set
+			continue
+		}
+		keys[k.Kid] = key
+	}
+	return keys, nil
+}
+
+func (k jwk) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBigInt(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve: %s", k.Crv)
+		}
+		x, err := decodeBigInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBigInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		if !curve.IsOnCurve(x, y) {
+			return nil, fmt.Errorf("invalid EC point for key %s", k.Kid)
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	default:
+		return nil, fmt.Errorf("unsupported key type: %s", k.Kty)
+	}
+}
+
+func decodeBigInt(value string) (*big.Int, error) {
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid key parameter: %v", err)
+	}
+	return new(big.Int).SetBytes(data), nil
+}
+
+func loadPublicKeyFile(path string) (crypto.PublicKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read public key %s: %v", path, err)
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM data in %s", path)
+	}
+
+	switch block.Type {
+	case "PUBLIC KEY":
+		return x509.ParsePKIXPublicKey(block.Bytes)
+	case "RSA PUBLIC KEY":
+		return x509.ParsePKCS1PublicKey(block.Bytes)
+	case "CERTIFICATE":
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse certificate %s: %v", path, err)
+		}
+		return cert.PublicKey, nil
+	default:
+		return nil, fmt.Errorf("unsupported PEM block %q in %s", block.Type, path)
+	}
+}
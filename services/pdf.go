@@ -0,0 +1,563 @@
+package services
+
+import (
+	"bytes"
+	"compress/zlib"
+	"errors"
+	"io"
+	"regexp"
+	"slices"
+	"strconv"
+	"time"
+)
+
+const (
+	// maxPDFStream bounds how much a single stream may decompress to.
+	maxPDFStream = 16 << 20
+	// maxPDFDecompressed bounds how much all of a file's streams together
+	// may decompress to, so packing in more streams can't multiply the work.
+	maxPDFDecompressed = 64 << 20
+	// maxPDFParseTime bounds how long reading one file may take, whatever
+	// its structure makes the parser do.
+	maxPDFParseTime = 5 * time.Second
+	// maxPDFDepth bounds the nesting of arrays and dictionaries.
+	maxPDFDepth = 64
+	// maxPDFObjects bounds the objects read from one file.
+	maxPDFObjects = 1 << 20
+)
+
+var (
+	errPDFSyntax  = errors.New("malformed PDF object")
+	errPDFBudget  = errors.New("PDF streams decompress to more than the allowed size")
+	errPDFTimeout = errors.New("PDF took too long to parse")
+
+	pdfObjectPattern = regexp.MustCompile(`(\d+)\s+(\d+)\s+obj\b`)
+	pdfHeader        = []byte("%PDF-")
+)
+
+// PDF values are represented as:
+//
+//	null               nil
+//	boolean            bool
+//	number             float64
+//	string             string, decoded to UTF-8
+//	name               pdfName, without the slash
+//	array              pdfArray
+//	dictionary         pdfDict
+//	stream             *pdfStream
+//	indirect reference pdfRef
+type (
+	pdfName  string
+	pdfArray []any
+	pdfDict  map[pdfName]any
+	pdfRef   struct{ num, gen int }
+)
+
+// pdfStream is a stream object. Data is still encoded by the dictionary's
+// filters.
+type pdfStream struct {
+	dict pdfDict
+	data []byte
+}
+
+// pdfBudget limits the work spent on one file: the decompression left,
+// shared by all its streams, and when parsing must give up.
+type pdfBudget struct {
+	remaining int64
+	deadline  time.Time
+}
+
+func newPDFBudget() *pdfBudget {
+	return &pdfBudget{remaining: maxPDFDecompressed, deadline: time.Now().Add(maxPDFParseTime)}
+}
+
+func (b *pdfBudget) expired() bool {
+	return time.Now().After(b.deadline)
+}
+
+// pdfDocument is the object structure of a PDF, read by scanning the file
+// for objects rather than trusting its cross-reference table.
+type pdfDocument struct {
+	objects map[int]any
+	// trailer merges every trailer and cross-reference stream dictionary,
+	// later ones taking precedence as incremental updates do.
+	trailer pdfDict
+	// errors counts objects that could not be parsed.
+	errors int
+}
+
+// parsePDF reads every object in data, including those packed into object
+// streams. It stops with errPDFBudget once the streams have decompressed to
+// more than budget allows, or errPDFTimeout once its deadline passes, and
+// then returns the objects read so far along with the error.
+func parsePDF(data []byte, budget *pdfBudget) (*pdfDocument, error) {
+	if !bytes.HasPrefix(data, pdfHeader) {
+		return nil, errors.New("not a PDF")
+	}
+
+	doc := &pdfDocument{objects: make(map[int]any), trailer: make(pdfDict)}
+	var objectStreams []*pdfStream
+
+	// One parser for the whole scan, so it can remember where it has
+	// searched for the end of streams
+	p := &pdfParser{data: data}
+	for offset := 0; offset < len(data) && len(doc.objects) < maxPDFObjects; {
+		if budget.expired() {
+			return doc, errPDFTimeout
+		}
+		match := pdfObjectPattern.FindSubmatchIndex(data[offset:])
+		if match == nil {
+			break
+		}
+		num, _ := strconv.Atoi(string(data[offset+match[2] : offset+match[3]]))
+		start := offset + match[1]
+		// The object number must begin a token
+		if begin := offset + match[0]; begin > 0 && !isPDFSpace(data[begin-1]) && !isPDFDelimiter(data[begin-1]) {
+			offset = start
+			continue
+		}
+
+		p.pos = start
+		value, err := p.parseObject()
+		if err != nil {
+			doc.errors++
+			offset = start
+			continue
+		}
+		offset = p.pos
+
+		doc.objects[num] = value
+		if stream, ok := value.(*pdfStream); ok {
+			switch stream.dict["Type"] {
+			case pdfName("ObjStm"):
+				objectStreams = append(objectStreams, stream)
+			case pdfName("XRef"):
+				doc.mergeTrailer(stream.dict)
+			}
+		}
+	}
+
+	for offset := 0; ; {
+		if budget.expired() {
+			return doc, errPDFTimeout
+		}
+		index := bytes.Index(data[offset:], []byte("trailer"))
+		if index < 0 {
+			break
+		}
+		p := &pdfParser{data: data, pos: offset + index + len("trailer")}
+		offset = p.pos
+		if value, err := p.parseValue(0); err == nil {
+			if dict, ok := value.(pdfDict); ok {
+				doc.mergeTrailer(dict)
+			}
+		}
+	}
+
+	for _, stream := range objectStreams {
+		if err := doc.readObjectStream(stream, budget); err != nil {
+			return doc, err
+		}
+	}
+
+	if len(doc.objects) == 0 {
+		return nil, errors.New("no PDF objects found")
+	}
+	return doc, nil
+}
+
+func (doc *pdfDocument) mergeTrailer(dict pdfDict) {
+	for key, value := range dict {
+		doc.trailer[key] = value
+	}
+}
+
+// readObjectStream adds the objects packed in an object stream. Objects
+// defined directly in the file take precedence. Only running out of budget
+// or time is an error; malformed streams are counted and skipped.
+func (doc *pdfDocument) readObjectStream(stream *pdfStream, budget *pdfBudget) error {
+	data, err := decodePDFStreamData(stream, budget)
+	if errors.Is(err, errPDFBudget) {
+		return err
+	}
+	if err != nil {
+		doc.errors++
+		return nil
+	}
+	count, _ := stream.dict["N"].(float64)
+	first, _ := stream.dict["First"].(float64)
+	if count <= 0 || first <= 0 || int(first) > len(data) {
+		doc.errors++
+		return nil
+	}
+
+	header := &pdfParser{data: data[:int(first)]}
+	for i := 0; i < int(count) && len(doc.objects) < maxPDFObjects; i++ {
+		if budget.expired() {
+			return errPDFTimeout
+		}
+		num, err1 := header.parseValue(0)
+		offset, err2 := header.parseValue(0)
+		n, ok1 := num.(float64)
+		o, ok2 := offset.(float64)
+		if err1 != nil || err2 != nil || !ok1 || !ok2 || int(first+o) > len(data) {
+			doc.errors++
+			return nil
+		}
+		if _, ok := doc.objects[int(n)]; ok {
+			continue
+		}
+		p := &pdfParser{data: data, pos: int(first + o)}
+		value, err := p.parseValue(0)
+		if err != nil {
+			doc.errors++
+			continue
+		}
+		doc.objects[int(n)] = value
+	}
+	return nil
+}
+
+// resolve follows indirect references to the object they name.
+func (doc *pdfDocument) resolve(value any) any {
+	for i := 0; i < 32; i++ {
+		ref, ok := value.(pdfRef)
+		if !ok {
+			return value
+		}
+		value = doc.objects[ref.num]
+	}
+	return nil
+}
+
+// dict resolves value to a dictionary, taking a stream's dictionary.
+func (doc *pdfDocument) dict(value any) pdfDict {
+	switch v := doc.resolve(value).(type) {
+	case pdfDict:
+		return v
+	case *pdfStream:
+		return v.dict
+	}
+	return nil
+}
+
+// decodePDFStreamData undoes a stream's filter, charging what it
+// decompresses to budget. Only unfiltered and Flate streams, optionally with
+// a PNG predictor, are supported.
+func decodePDFStreamData(stream *pdfStream, budget *pdfBudget) ([]byte, error) {
+	filter := stream.dict["Filter"]
+	if filters, ok := filter.(pdfArray); ok && len(filters) == 1 {
+		filter = filters[0]
+	}
+	switch filter {
+	case nil:
+		return stream.data, nil
+	case pdfName("FlateDecode"):
+	default:
+		return nil, errors.New("unsupported PDF stream filter")
+	}
+
+	if budget.remaining <= 0 {
+		return nil, errPDFBudget
+	}
+	reader, err := zlib.NewReader(bytes.NewReader(stream.data))
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	// Keep whatever decompressed before a truncation error
+	limit := min(maxPDFStream, budget.remaining)
+	content, _ := io.ReadAll(io.LimitReader(reader, limit))
+	budget.remaining -= int64(len(content))
+	if int64(len(content)) == limit && limit < maxPDFStream {
+		// Stopped by the budget rather than the stream's own limit
+		if _, err := io.ReadFull(reader, make([]byte, 1)); err == nil {
+			return nil, errPDFBudget
+		}
+	}
+	if len(content) == 0 {
+		return nil, errors.New("empty PDF stream")
+	}
+
+	params, _ := stream.dict["DecodeParms"].(pdfDict)
+	if predictor, _ := params["Predictor"].(float64); predictor >= 10 {
+		columns, ok := params["Columns"].(float64)
+		if !ok {
+			columns = 1
+		}
+		return undoPNGPredictor(content, int(columns))
+	} else if predictor > 1 {
+		return nil, errors.New("unsupported PDF stream predictor")
+	}
+	return content, nil
+}
+
+// undoPNGPredictor reverses PNG row filters, as cross-reference streams
+// use. Each row is a filter type byte followed by columns bytes.
+func undoPNGPredictor(data []byte, columns int) ([]byte, error) {
+	if columns <= 0 || columns > maxPDFStream {
+		return nil, errors.New("invalid PDF predictor columns")
+	}
+
+	out := make([]byte, 0, len(data))
+	previous := make([]byte, columns)
+	for len(data) > columns {
+		filter, row := data[0], slices.Clone(data[1:columns+1])
+		data = data[columns+1:]
+		for i := range row {
+			var left, upperLeft byte
+			if i > 0 {
+				left, upperLeft = row[i-1], previous[i-1]
+			}
+			switch filter {
+			case 0:
+			case 1:
+				row[i] += left
+			case 2:
+				row[i] += previous[i]
+			case 3:
+				row[i] += byte((int(left) + int(previous[i])) / 2)
+			case 4:
+				row[i] += paeth(left, previous[i], upperLeft)
+			default:
+				return nil, errors.New("invalid PNG predictor")
+			}
+		}
+		out = append(out, row...)
+		previous = row
+	}
+	return out, nil
+}
+
+func paeth(a, b, c byte) byte {
+	p := int(a) + int(b) - int(c)
+	pa, pb, pc := abs(p-int(a)), abs(p-int(b)), abs(p-int(c))
+	switch {
+	case pa <= pb && pa <= pc:
+		return a
+	case pb <= pc:
+		return b
+	}
+	return c
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+// pdfParser reads PDF values from data starting at pos.
+type pdfParser struct {
+	data []byte
+	pos  int
+	// endstreamFrom and endstreamAt remember the last search for
+	// "endstream": where it started and what it found, -1 for nothing.
+	endstreamFrom, endstreamAt int
+	searchedEndstream          bool
+}
+
+// nextEndstream returns the offset of the first "endstream" at or after
+// start, or -1. It reuses the previous search where that answers the
+// question, so a file of streams that never end is scanned once rather than
+// once per stream.
+func (p *pdfParser) nextEndstream(start int) int {
+	if p.searchedEndstream && start >= p.endstreamFrom && (p.endstreamAt < 0 || start <= p.endstreamAt) {
+		return p.endstreamAt
+	}
+	p.searchedEndstream, p.endstreamFrom, p.endstreamAt = true, start, -1
+	if index := bytes.Index(p.data[start:], []byte("endstream")); index >= 0 {
+		p.endstreamAt = start + index
+	}
+	return p.endstreamAt
+}
+
+// parseObject reads the body of an indirect object after "obj", including
+// a stream's data.
+func (p *pdfParser) parseObject() (any, error) {
+	value, err := p.parseValue(0)
+	if err != nil {
+		return nil, err
+	}
+
+	dict, ok := value.(pdfDict)
+	p.skipSpace()
+	if !ok || !bytes.HasPrefix(p.data[p.pos:], []byte("stream")) {
+		return value, nil
+	}
+
+	p.pos += len("stream")
+	if bytes.HasPrefix(p.data[p.pos:], []byte("\r\n")) {
+		p.pos += 2
+	} else if p.pos < len(p.data) && (p.data[p.pos] == '\n' || p.data[p.pos] == '\r') {
+		p.pos++
+	}
+	start := p.pos
+
+	// Trust /Length when it lands on endstream, otherwise search for it
+	if length, ok := dict["Length"].(float64); ok && length >= 0 && start+int(length) <= len(p.data) {
+		end := start + int(length)
+		rest := bytes.TrimLeft(p.data[end:min(end+32, len(p.data))], " \t\r\n")
+		if bytes.HasPrefix(rest, []byte("endstream")) {
+			p.pos = end
+			p.skipSpace()
+			p.pos += len("endstream")
+			return &pdfStream{dict: dict, data: p.data[start:end]}, nil
+		}
+	}
+	end := p.nextEndstream(start)
+	if end < 0 {
+		return nil, errPDFSyntax
+	}
+	p.pos = end + len("endstream")
+	data := bytes.TrimRight(p.data[start:end], "\r\n")
+	return &pdfStream{dict: dict, data: data}, nil
+}
+
+func (p *pdfParser) skipSpace() {
+	for p.pos < len(p.data) {
+		c := p.data[p.pos]
+		if c == '%' {
+			for p.pos < len(p.data) && p.data[p.pos] != '\n' && p.data[p.pos] != '\r' {
+				p.pos++
+			}
+			continue
+		}
+		if !isPDFSpace(c) {
+			return
+		}
+		p.pos++
+	}
+}
+
+// parseValue reads one value, which may be an indirect reference.
+func (p *pdfParser) parseValue(depth int) (any, error) {
+	if depth > maxPDFDepth {
+		return nil, errPDFSyntax
+	}
+	p.skipSpace()
+	if p.pos >= len(p.data) {
+		return nil, errPDFSyntax
+	}
+
+	switch c := p.data[p.pos]; {
+	case c == '/':
+		return p.parseName(), nil
+	case c == '(':
+		var s string
+		s, p.pos = readPDFLiteral(p.data, p.pos+1)
+		return s, nil
+	case c == '<' && p.pos+1 < len(p.data) && p.data[p.pos+1] == '<':
+		return p.parseDict(depth)
+	case c == '<':
+		end := bytes.IndexByte(p.data[p.pos:], '>')
+		if end < 0 {
+			return nil, errPDFSyntax
+		}
+		s := decodePDFHex(p.data[p.pos+1 : p.pos+end])
+		p.pos += end + 1
+		return s, nil
+	case c == '[':
+		p.pos++
+		var array pdfArray
+		for {
+			p.skipSpace()
+			if p.pos >= len(p.data) {
+				return nil, errPDFSyntax
+			}
+			if p.data[p.pos] == ']' {
+				p.pos++
+				return array, nil
+			}
+			value, err := p.parseValue(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			array = append(array, value)
+		}
+	case isPDFDelimiter(c):
+		return nil, errPDFSyntax
+	}
+
+	token := p.parseToken()
+	switch token {
+	case "true":
+		return true, nil
+	case "false":
+		return false, nil
+	case "null":
+		return nil, nil
+	}
+	number, err := strconv.ParseFloat(token, 64)
+	if err != nil {
+		return nil, errPDFSyntax
+	}
+
+	// "12 0 R" is a reference
+	saved := p.pos
+	p.skipSpace()
+	if gen, err := strconv.Atoi(p.parseToken()); err == nil && gen >= 0 {
+		p.skipSpace()
+		if p.parseToken() == "R" && number == float64(int(number)) {
+			return pdfRef{num: int(number), gen: gen}, nil
+		}
+	}
+	p.pos = saved
+	return number, nil
+}
+
+func (p *pdfParser) parseDict(depth int) (any, error) {
+	p.pos += 2
+	dict := make(pdfDict)
+	for {
+		p.skipSpace()
+		if p.pos+1 >= len(p.data) {
+			return nil, errPDFSyntax
+		}
+		if p.data[p.pos] == '>' && p.data[p.pos+1] == '>' {
+			p.pos += 2
+			return dict, nil
+		}
+		if p.data[p.pos] != '/' {
+			return nil, errPDFSyntax
+		}
+		key := p.parseName()
+		value, err := p.parseValue(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		dict[key] = value
+	}
+}
+
+// parseName reads a name, decoding #xx escapes.
+func (p *pdfParser) parseName() pdfName {
+	p.pos++
+	token := p.parseToken()
+	if !bytes.ContainsRune([]byte(token), '#') {
+		return pdfName(token)
+	}
+
+	var name []byte
+	for i := 0; i < len(token); i++ {
+		if token[i] == '#' && i+2 < len(token) {
+			if value, err := strconv.ParseUint(token[i+1:i+3], 16, 8); err == nil {
+				name = append(name, byte(value))
+				i += 2
+				continue
+			}
+		}
+		name = append(name, token[i])
+	}
+	return pdfName(name)
+}
+
+// parseToken reads up to the next delimiter or space.
+func (p *pdfParser) parseToken() string {
+	start := p.pos
+	for p.pos < len(p.data) && !isPDFSpace(p.data[p.pos]) && !isPDFDelimiter(p.data[p.pos]) {
+		p.pos++
+	}
+	return string(p.data[start:p.pos])
+}
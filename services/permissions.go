@@ -0,0 +1,282 @@
+package services
+
+import (
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/ebinskryfon/fileuploader/models"
+)
+
+type accessLevel int
+
+const (
+	accessNone accessLevel = iota
+	accessRead
+	accessWrite
+	accessOwner
+)
+
+// accessFor resolves the caller's access to a file from ownership and the
+// file's ACL. The highest matching grant wins.
+func accessFor(metadata models.FileMetadata, principal models.Principal) accessLevel {
+	if metadata.UserID == principal.UserID {
+		return accessOwner
+	}
+	return aclAccess(metadata.Permissions, principal)
+}
+
+// aclAccess returns the highest access the entries grant to principal.
+func aclAccess(entries []models.ACLEntry, principal models.Principal) accessLevel {
+	level := accessNone
+	for _, entry := range entries {
+		matches := (entry.Type == models.ACLTypeUser && entry.ID == principal.UserID) ||
+			(entry.Type == models.ACLTypeGroup && slices.Contains(principal.Groups, entry.ID))
+		if !matches {
+			continue
+		}
+		if entry.Permission == models.PermissionReadWrite {
+			return accessWrite
+		}
+		level = accessRead
+	}
+	return level
+}
+
+// authorize loads a file's metadata and checks that principal has at least
+// the required access. Files the caller cannot see are reported as not
+// found so their existence is not revealed.
+func (u *UploadService) authorize(tenant *tenantServices, fileID string, principal models.Principal, required accessLevel) (models.FileMetadata, *models.AppError) {
+	if !tenant.storage.Exists(fileID) {
+		return models.FileMetadata{}, models.ErrFileNotFound
+	}
+
+	metadata, err := tenant.storage.GetMetadata(fileID)
+	if err != nil {
+		u.logger.Error("Failed to get file metadata", map[string]interface{}{
+			"file_id": fileID,
+			"user_id": principal.UserID,
+			"error":   err.Error(),
+		})
+		return models.FileMetadata{}, models.ErrFileNotFound
+	}
+	if metadata.DeletedAt != nil {
+		// Trashed files are only reachable through the trash endpoints
+		return models.FileMetadata{}, models.ErrFileNotFound
+	}
+
+	level := accessFor(metadata, principal)
+	if level < required && metadata.FolderID != "" {
+		level = max(level, u.inheritedAccess(tenant, metadata.FolderID, principal))
+	}
+	if principal.TenantAdmin && level < accessRead {
+		// Tenant storage is isolated, so every file here is in the admin's tenant
+		level = accessRead
+	}
+	if level < required {
+		u.logger.Warn("Unauthorized file access attempt", map[string]interface{}{
+			"file_id":    fileID,
+			"user_id":    principal.UserID,
+			"file_owner": metadata.UserID,
+		})
+		if level == accessNone {
+			return models.FileMetadata{}, models.ErrFileNotFound // Don't reveal file exists
+		}
+		return models.FileMetadata{}, models.ErrPermissionDenied
+	}
+
+	return metadata, nil
+}
+
+// visibleMetadata hides the ACL from callers who don't own the file.
+func visibleMetadata(metadata models.FileMetadata, principal models.Principal) models.FileMetadata {
+	if metadata.UserID != principal.UserID {
+		metadata.Permissions = nil
+	}
+	return metadata
+}
+
+// validateGrant checks a share request made by an owner.
+func validateGrant(req models.GrantPermissionRequest, principal models.Principal) *models.AppError {
+	if req.Type != models.ACLTypeUser && req.Type != models.ACLTypeGroup {
+		return models.NewAppError(http.StatusBadRequest, "Permission type must be user or group", nil)
+	}
+	if req.Permission != models.PermissionRead && req.Permission != models.PermissionReadWrite {
+		return models.NewAppError(http.StatusBadRequest, "Permission must be read or read+write", nil)
+	}
+	if req.Type == models.ACLTypeUser && req.ID == principal.UserID {
+		return models.NewAppError(http.StatusBadRequest, "Owners already have full access", nil)
+	}
+	return nil
+}
+
+// grantACL adds a grant, replacing an existing one for the same user or
+// group.
+func grantACL(entries []models.ACLEntry, req models.GrantPermissionRequest, principal models.Principal) []models.ACLEntry {
+	entries = slices.DeleteFunc(entries, func(e models.ACLEntry) bool {
+		return e.Type == req.Type && e.ID == req.ID
+	})
+	return append(entries, models.ACLEntry{
+		Type:       req.Type,
+		ID:         req.ID,
+		Permission: req.Permission,
+		GrantedBy:  principal.UserID,
+		GrantedAt:  time.Now().UTC(),
+	})
+}
+
+// revokeACL removes a grant and reports whether one existed.
+func revokeACL(entries []models.ACLEntry, granteeType, granteeID string) ([]models.ACLEntry, bool) {
+	before := len(entries)
+	entries = slices.DeleteFunc(entries, func(e models.ACLEntry) bool {
+		return e.Type == granteeType && e.ID == granteeID
+	})
+	return entries, len(entries) != before
+}
+
+// GrantPermission shares a file principal owns with a user or group.
+func (u *UploadService) GrantPermission(fileID string, principal models.Principal, req models.GrantPermissionRequest) ([]models.ACLEntry, *models.AppError) {
+	if appError := validateGrant(req, principal); appError != nil {
+		return nil, appError
+	}
+
+	tenant, appError := u.tenant(principal.TenantID)
+	if appError != nil {
+		return nil, appError
+	}
+
+	if _, appError := u.authorize(tenant, fileID, principal, accessOwner); appError != nil {
+		return nil, appError
+	}
+
+	tenant.contentMu.Lock()
+	defer tenant.contentMu.Unlock()
+
+	// Re-read under the lock so a concurrent change isn't lost
+	metadata, err := tenant.storage.GetMetadata(fileID)
+	if err != nil {
+		return nil, models.ErrFileNotFound
+	}
+
+	metadata.Permissions = grantACL(metadata.Permissions, req, principal)
+
+	if appError := u.saveMetadata(tenant, metadata, principal); appError != nil {
+		return nil, appError
+	}
+
+	u.logger.Info("File permission granted", map[string]interface{}{
+		"file_id":    fileID,
+		"user_id":    principal.UserID,
+		"grantee":    req.Type + ":" + req.ID,
+		"permission": req.Permission,
+	})
+
+	return metadata.Permissions, nil
+}
+
+// RevokePermission takes back a user's or group's access to a file principal owns.
+func (u *UploadService) RevokePermission(fileID string, principal models.Principal, granteeType, granteeID string) ([]models.ACLEntry, *models.AppError) {
+	tenant, appError := u.tenant(principal.TenantID)
+	if appError != nil {
+		return nil, appError
+	}
+
+	if _, appError := u.authorize(tenant, fileID, principal, accessOwner); appError != nil {
+		return nil, appError
+	}
+
+	tenant.contentMu.Lock()
+	defer tenant.contentMu.Unlock()
+
+	// Re-read under the lock so a concurrent change isn't lost
+	metadata, err := tenant.storage.GetMetadata(fileID)
+	if err != nil {
+		return nil, models.ErrFileNotFound
+	}
+
+	var found bool
+	metadata.Permissions, found = revokeACL(metadata.Permissions, granteeType, granteeID)
+	if !found {
+		return nil, models.NewAppError(http.StatusNotFound, "Permission not found", nil)
+	}
+
+	if appError := u.saveMetadata(tenant, metadata, principal); appError != nil {
+		return nil, appError
+	}
+
+	u.logger.Info("File permission revoked", map[string]interface{}{
+		"file_id": fileID,
+		"user_id": principal.UserID,
+		"grantee": granteeType + ":" + granteeID,
+	})
+
+	return metadata.Permissions, nil
+}
+
+// ListFiles returns the files owned by principal whose properties match
+// every filter.
+func (u *UploadService) ListFiles(principal models.Principal, filters ...models.PropertyFilter) ([]models.FileMetadata, *models.AppError) {
+	return u.listWhere(principal, filters, func(level accessLevel) bool {
+		return level == accessOwner
+	})
+}
+
+// ListSharedWithMe returns files other users have shared with principal,
+// directly or through one of its groups.
+func (u *UploadService) ListSharedWithMe(principal models.Principal, filters ...models.PropertyFilter) ([]models.FileMetadata, *models.AppError) {
+	return u.listWhere(principal, filters, func(level accessLevel) bool {
+		return level == accessRead || level == accessWrite
+	})
+}
+
+// ListTenantFiles returns every file in a tenant admin's tenant.
+func (u *UploadService) ListTenantFiles(principal models.Principal, filters ...models.PropertyFilter) ([]models.FileMetadata, *models.AppError) {
+	if !principal.TenantAdmin {
+		return nil, models.ErrForbidden
+	}
+	return u.listWhere(principal, filters, func(accessLevel) bool {
+		return true
+	})
+}
+
+func (u *UploadService) listWhere(principal models.Principal, filters []models.PropertyFilter, include func(accessLevel) bool) ([]models.FileMetadata, *models.AppError) {
+	tenant, appError := u.tenant(principal.TenantID)
+	if appError != nil {
+		return nil, appError
+	}
+
+	files, err := tenant.storage.List()
+	if err != nil {
+		u.logger.Error("Failed to list files", map[string]interface{}{
+			"user_id": principal.UserID,
+			"error":   err.Error(),
+		})
+		return nil, models.ErrInternalServer
+	}
+
+	result := make([]models.FileMetadata, 0)
+	for _, metadata := range files {
+		if metadata.DeletedAt == nil && include(accessFor(metadata, principal)) && matchesProperties(metadata.Properties, filters) {
+			result = append(result, visibleMetadata(metadata, principal))
+		}
+	}
+
+	slices.SortFunc(result, func(a, b models.FileMetadata) int {
+		return b.UploadTime.Compare(a.UploadTime)
+	})
+
+	return result, nil
+}
+
+func (u *UploadService) saveMetadata(tenant *tenantServices, metadata models.FileMetadata, principal models.Principal) *models.AppError {
+	if err := tenant.storage.UpdateMetadata(metadata.ID, metadata); err != nil {
+		u.logger.Error("Failed to update file metadata", map[string]interface{}{
+			"file_id": metadata.ID,
+			"user_id": principal.UserID,
+			"error":   err.Error(),
+		})
+		return models.ErrInternalServer
+	}
+	tenant.search.Index(metadata)
+	return nil
+}
@@ -0,0 +1,319 @@
+package services
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"image/color"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ebinskryfon/fileuploader/models"
+)
+
+// maxPropertiesText bounds the document text read to count words.
+const maxPropertiesText = 8 << 20
+
+// propertyExtractors read the properties of each content type they support.
+var propertyExtractors = map[string]func(data []byte) (*models.FileProperties, error){
+	"image/jpeg":      extractImageProperties("image/jpeg"),
+	"image/png":       extractImageProperties("image/png"),
+	"application/pdf": extractPDFProperties,
+	contentTypeDOCX:   extractDOCXProperties,
+}
+
+// ExtractProperties reads technical properties from content of the given
+// type. Types without an extractor have no properties.
+func ExtractProperties(contentType string, data []byte) (*models.FileProperties, error) {
+	extract, ok := propertyExtractors[baseContentType(contentType)]
+	if !ok {
+		return nil, nil
+	}
+	return extract(data)
+}
+
+// extractProperties reads the properties of content about to be stored as
+// metadata. Failures are logged, never returned: a file whose properties
+// can't be read is still a valid upload.
+func (u *UploadService) extractProperties(tenant *tenantServices, metadata models.FileMetadata, content []byte) *models.FileProperties {
+	properties, err := ExtractProperties(tenant.validation.Canonical(metadata.ContentType), content)
+	if err != nil {
+		u.logger.Warn("Failed to extract file properties", map[string]interface{}{
+			"file_id":      metadata.ID,
+			"tenant_id":    tenant.id,
+			"content_type": metadata.ContentType,
+			"error":        err.Error(),
+		})
+		return nil
+	}
+	return properties
+}
+
+func extractImageProperties(contentType string) func(data []byte) (*models.FileProperties, error) {
+	return func(data []byte) (*models.FileProperties, error) {
+		config, err := imageCodecs[contentType].decodeConfig(data)
+		if err != nil {
+			return nil, err
+		}
+		return &models.FileProperties{Image: &models.ImageProperties{
+			Width:      config.Width,
+			Height:     config.Height,
+			ColorModel: colorModelName(config.ColorModel),
+		}}, nil
+	}
+}
+
+func colorModelName(model color.Model) string {
+	switch model {
+	case color.YCbCrModel:
+		return "ycbcr"
+	case color.CMYKModel:
+		return "cmyk"
+	case color.GrayModel:
+		return "gray"
+	case color.Gray16Model:
+		return "gray16"
+	case color.RGBAModel:
+		return "rgba"
+	case color.RGBA64Model:
+		return "rgba64"
+	case color.NRGBAModel:
+		return "nrgba"
+	case color.NRGBA64Model:
+		return "nrgba64"
+	}
+	if _, ok := model.(color.Palette); ok {
+		return "paletted"
+	}
+	return "unknown"
+}
+
+// extractPDFProperties reads the page count from the page tree and the
+// title and author from the document information dictionary.
+func extractPDFProperties(data []byte) (*models.FileProperties, error) {
+	doc, err := parsePDF(data, newPDFBudget())
+	if err != nil {
+		return nil, err
+	}
+
+	properties := &models.PDFProperties{}
+	if _, ok := doc.trailer["Encrypt"]; ok {
+		properties.Encrypted = true
+	}
+
+	if catalog := doc.dict(doc.trailer["Root"]); catalog != nil {
+		if pages := doc.dict(catalog["Pages"]); pages != nil {
+			if count, ok := doc.resolve(pages["Count"]).(float64); ok && count >= 0 {
+				properties.Pages = int(count)
+			}
+		}
+	}
+	if properties.Pages == 0 {
+		// No usable page tree root; count the pages themselves
+		for _, object := range doc.objects {
+			if dict, ok := object.(pdfDict); ok && dict["Type"] == pdfName("Page") {
+				properties.Pages++
+			}
+		}
+	}
+
+	// Strings in an encrypted file are ciphertext
+	if info := doc.dict(doc.trailer["Info"]); info != nil && !properties.Encrypted {
+		properties.Title, _ = doc.resolve(info["Title"]).(string)
+		properties.Author, _ = doc.resolve(info["Author"]).(string)
+	}
+
+	return &models.FileProperties{PDF: properties}, nil
+}
+
+// docxCoreProperties is docProps/core.xml.
+type docxCoreProperties struct {
+	Title          string `xml:"title"`
+	Subject        string `xml:"subject"`
+	Creator        string `xml:"creator"`
+	Keywords       string `xml:"keywords"`
+	Description    string `xml:"description"`
+	LastModifiedBy string `xml:"lastModifiedBy"`
+	Revision       string `xml:"revision"`
+	Created        string `xml:"created"`
+	Modified       string `xml:"modified"`
+}
+
+// extractDOCXProperties reads the core properties and counts the words in
+// the document text.
+func extractDOCXProperties(data []byte) (*models.FileProperties, error) {
+	archive, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, err
+	}
+
+	properties := &models.DocumentProperties{}
+	for _, file := range archive.File {
+		if file.Name != "docProps/core.xml" {
+			continue
+		}
+		manifest, err := readZipEntry(file, maxContainerManifest)
+		if err != nil {
+			return nil, err
+		}
+		var core docxCoreProperties
+		if err := xml.Unmarshal(manifest, &core); err != nil {
+			return nil, fmt.Errorf("failed to parse core properties: %w", err)
+		}
+		properties.Title = strings.TrimSpace(core.Title)
+		properties.Subject = strings.TrimSpace(core.Subject)
+		properties.Creator = strings.TrimSpace(core.Creator)
+		properties.Keywords = strings.TrimSpace(core.Keywords)
+		properties.Description = strings.TrimSpace(core.Description)
+		properties.LastModifiedBy = strings.TrimSpace(core.LastModifiedBy)
+		properties.Revision = strings.TrimSpace(core.Revision)
+		properties.Created = parseDocxTime(core.Created)
+		properties.Modified = parseDocxTime(core.Modified)
+		break
+	}
+
+	text, err := extractDOCXText(data, maxPropertiesText)
+	if err != nil {
+		return nil, err
+	}
+	properties.Words = len(strings.Fields(text))
+
+	return &models.FileProperties{Document: properties}, nil
+}
+
+func parseDocxTime(value string) *time.Time {
+	parsed, err := time.Parse(time.RFC3339, strings.TrimSpace(value))
+	if err != nil {
+		return nil
+	}
+	parsed = parsed.UTC()
+	return &parsed
+}
+
+// propertyFields flattens properties into the names filters use, such as
+// "image.width".
+var propertyFields = map[string]func(p *models.FileProperties) (string, bool){
+	"image.width":       imageField(func(i *models.ImageProperties) string { return strconv.Itoa(i.Width) }),
+	"image.height":      imageField(func(i *models.ImageProperties) string { return strconv.Itoa(i.Height) }),
+	"image.color_model": imageField(func(i *models.ImageProperties) string { return i.ColorModel }),
+	"pdf.pages":         pdfField(func(d *models.PDFProperties) string { return strconv.Itoa(d.Pages) }),
+	"pdf.title":         pdfField(func(d *models.PDFProperties) string { return d.Title }),
+	"pdf.author":        pdfField(func(d *models.PDFProperties) string { return d.Author }),
+	"pdf.encrypted":     pdfField(func(d *models.PDFProperties) string { return strconv.FormatBool(d.Encrypted) }),
+	"document.title":    documentField(func(d *models.DocumentProperties) string { return d.Title }),
+	"document.subject":  documentField(func(d *models.DocumentProperties) string { return d.Subject }),
+	"document.creator":  documentField(func(d *models.DocumentProperties) string { return d.Creator }),
+	"document.keywords": documentField(func(d *models.DocumentProperties) string { return d.Keywords }),
+	"document.words":    documentField(func(d *models.DocumentProperties) string { return strconv.Itoa(d.Words) }),
+}
+
+func imageField(get func(*models.ImageProperties) string) func(*models.FileProperties) (string, bool) {
+	return func(p *models.FileProperties) (string, bool) {
+		if p == nil || p.Image == nil {
+			return "", false
+		}
+		return get(p.Image), true
+	}
+}
+
+func pdfField(get func(*models.PDFProperties) string) func(*models.FileProperties) (string, bool) {
+	return func(p *models.FileProperties) (string, bool) {
+		if p == nil || p.PDF == nil {
+			return "", false
+		}
+		return get(p.PDF), true
+	}
+}
+
+func documentField(get func(*models.DocumentProperties) string) func(*models.FileProperties) (string, bool) {
+	return func(p *models.FileProperties) (string, bool) {
+		if p == nil || p.Document == nil {
+			return "", false
+		}
+		return get(p.Document), true
+	}
+}
+
+// propertyOperators are tried longest first so ">=" isn't read as ">".
+var propertyOperators = []string{"!=", ">=", "<=", "=", ">", "<", "~"}
+
+// ParsePropertyFilter parses a filter such as "image.width>=800",
+// "pdf.encrypted=true" or "document.creator~smith".
+func ParsePropertyFilter(expression string) (models.PropertyFilter, *models.AppError) {
+	index := strings.IndexAny(expression, "!=<>~")
+	if index <= 0 {
+		return models.PropertyFilter{}, models.NewAppError(http.StatusBadRequest, "Invalid property filter "+strconv.Quote(expression), nil)
+	}
+
+	filter := models.PropertyFilter{Property: strings.TrimSpace(expression[:index])}
+	for _, operator := range propertyOperators {
+		if strings.HasPrefix(expression[index:], operator) {
+			filter.Operator = operator
+			filter.Value = strings.TrimSpace(expression[index+len(operator):])
+			break
+		}
+	}
+
+	if _, ok := propertyFields[filter.Property]; !ok {
+		return models.PropertyFilter{}, models.NewAppError(http.StatusBadRequest, "Unknown property "+strconv.Quote(filter.Property), nil)
+	}
+	if filter.Operator == "" {
+		return models.PropertyFilter{}, models.NewAppError(http.StatusBadRequest, "Invalid property filter "+strconv.Quote(expression), nil)
+	}
+	if strings.ContainsAny(filter.Operator, "<>") {
+		if _, err := strconv.ParseFloat(filter.Value, 64); err != nil {
+			return models.PropertyFilter{}, models.NewAppError(http.StatusBadRequest, "Property filter "+strconv.Quote(expression)+" needs a number", nil)
+		}
+	}
+	return filter, nil
+}
+
+// matchesProperties reports whether properties satisfy every filter. Files
+// without the property never match.
+func matchesProperties(properties *models.FileProperties, filters []models.PropertyFilter) bool {
+	for _, filter := range filters {
+		field, ok := propertyFields[filter.Property]
+		if !ok {
+			return false
+		}
+		value, ok := field(properties)
+		if !ok || !compareProperty(value, filter.Operator, filter.Value) {
+			return false
+		}
+	}
+	return true
+}
+
+func compareProperty(value, operator, operand string) bool {
+	switch operator {
+	case "=":
+		return strings.EqualFold(value, operand)
+	case "!=":
+		return !strings.EqualFold(value, operand)
+	case "~":
+		return strings.Contains(strings.ToLower(value), strings.ToLower(operand))
+	}
+
+	left, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return false
+	}
+	right, err := strconv.ParseFloat(operand, 64)
+	if err != nil {
+		return false
+	}
+	switch operator {
+	case "<":
+		return left < right
+	case "<=":
+		return left <= right
+	case ">":
+		return left > right
+	case ">=":
+		return left >= right
+	}
+	return false
+}
@@ -0,0 +1,263 @@
+package services
+
+import (
+	"errors"
+	"io"
+	"sync"
+
+	"github.com/ebinskryfon/fileuploader/config"
+	"github.com/ebinskryfon/fileuploader/models"
+	"github.com/ebinskryfon/fileuploader/storage"
+	"github.com/ebinskryfon/fileuploader/utils"
+)
+
+var errQuotaExceeded = errors.New("storage quota exceeded")
+
+type usage struct {
+	bytes int64
+	files int
+}
+
+// QuotaService tracks per-user storage usage and enforces the configured
+// limits. Usage is loaded from storage on first use and kept up to date as
+// files are stored and removed; in-flight uploads hold a reservation so that
+// concurrent uploads cannot jointly overrun a quota.
+type QuotaService struct {
+	storage   storage.StorageInterface
+	defaults  config.QuotaLimit
+	overrides map[string]config.QuotaLimit
+	logger    *utils.Logger
+
+	mu       sync.Mutex
+	loaded   bool
+	used     map[string]*usage
+	reserved map[string]*usage
+}
+
+// QuotaReservation holds capacity for a single upload until it is committed
+// or released.
+type QuotaReservation struct {
+	quota     *QuotaService
+	userID    string
+	size      int64
+	files     int
+	remaining int64
+	done      bool
+}
+
+func NewQuotaService(cfg *config.Config, storage storage.StorageInterface, logger *utils.Logger) *QuotaService {
+	return &QuotaService{
+		storage:   storage,
+		defaults:  cfg.Quota.Default,
+		overrides: cfg.Quota.Overrides,
+		logger:    logger,
+		used:      make(map[string]*usage),
+		reserved:  make(map[string]*usage),
+	}
+}
+
+func (q *QuotaService) Limit(userID string) config.QuotaLimit {
+	if limit, ok := q.overrides[userID]; ok {
+		return limit
+	}
+	return q.defaults
+}
+
+// Reserve checks that userID can store one more file of the given size and
+// holds that capacity until the reservation is committed or released.
+func (q *QuotaService) Reserve(userID string, size int64) (*QuotaReservation, *models.AppError) {
+	return q.reserve(userID, size, 1)
+}
+
+// ReserveBytes is like Reserve for content that doesn't add a file, such as
+// a new version of an existing one.
+func (q *QuotaService) ReserveBytes(userID string, size int64) (*QuotaReservation, *models.AppError) {
+	return q.reserve(userID, size, 0)
+}
+
+func (q *QuotaService) reserve(userID string, size int64, files int) (*QuotaReservation, *models.AppError) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if err := q.load(); err != nil {
+		q.logger.Error("Failed to load storage usage", map[string]interface{}{
+			"user_id": userID,
+			"error":   err.Error(),
+		})
+		return nil, models.ErrInternalServer
+	}
+
+	limit := q.Limit(userID)
+	used := q.entry(q.used, userID)
+	reserved := q.entry(q.reserved, userID)
+
+	if limit.MaxFiles > 0 && files > 0 && used.files+reserved.files+files > limit.MaxFiles {
+		return nil, models.ErrFileCountExceeded
+	}
+
+	remaining := int64(-1)
+	if limit.MaxBytes > 0 {
+		remaining = limit.MaxBytes - used.bytes - reserved.bytes
+		if size > remaining {
+			return nil, models.ErrQuotaExceeded
+		}
+	}
+
+	reserved.bytes += size
+	reserved.files += files
+
+	return &QuotaReservation{
+		quota:     q,
+		userID:    userID,
+		size:      size,
+		files:     files,
+		remaining: remaining,
+	}, nil
+}
+
+// Remove releases the usage of a file that has been removed from storage.
+func (q *QuotaService) Remove(userID string, size int64) {
+	q.release(userID, size, 1)
+}
+
+// RemoveBytes releases usage that didn't belong to a whole file, such as a
+// pruned version.
+func (q *QuotaService) RemoveBytes(userID string, size int64) {
+	q.release(userID, size, 0)
+}
+
+func (q *QuotaService) release(userID string, size int64, files int) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if !q.loaded {
+		return
+	}
+
+	used := q.entry(q.used, userID)
+	used.bytes -= size
+	used.files -= files
+	if used.bytes < 0 {
+		used.bytes = 0
+	}
+	if used.files < 0 {
+		used.files = 0
+	}
+}
+
+func (q *QuotaService) Usage(userID string) (*models.UsageResponse, *models.AppError) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if err := q.load(); err != nil {
+		q.logger.Error("Failed to load storage usage", map[string]interface{}{
+			"user_id": userID,
+			"error":   err.Error(),
+		})
+		return nil, models.ErrInternalServer
+	}
+
+	limit := q.Limit(userID)
+	used := q.entry(q.used, userID)
+
+	response := &models.UsageResponse{
+		UserID:         userID,
+		UsedBytes:      used.bytes,
+		RemainingBytes: -1,
+		MaxBytes:       limit.MaxBytes,
+		FileCount:      used.files,
+		RemainingFiles: -1,
+		MaxFiles:       limit.MaxFiles,
+	}
+	if limit.MaxBytes > 0 {
+		response.RemainingBytes = max(limit.MaxBytes-used.bytes, 0)
+	}
+	if limit.MaxFiles > 0 {
+		response.RemainingFiles = max(limit.MaxFiles-used.files, 0)
+	}
+
+	return response, nil
+}
+
+// load builds the usage table from storage the first time it is needed.
+// Callers must hold q.mu.
+func (q *QuotaService) load() error {
+	if q.loaded {
+		return nil
+	}
+
+	files, err := q.storage.List()
+	if err != nil {
+		return err
+	}
+
+	for _, file := range files {
+		used := q.entry(q.used, file.UserID)
+		used.bytes += file.StoredSize()
+		used.files++
+	}
+	q.loaded = true
+
+	return nil
+}
+
+func (q *QuotaService) entry(table map[string]*usage, userID string) *usage {
+	u, ok := table[userID]
+	if !ok {
+		u = &usage{}
+		table[userID] = u
+	}
+	return u
+}
+
+// Reader wraps r so that reading more bytes than the user has remaining
+// fails mid-stream, regardless of the size the client declared.
+func (r *QuotaReservation) Reader(reader io.Reader) io.Reader {
+	if r.remaining < 0 {
+		return reader
+	}
+	return &quotaReader{reader: reader, remaining: r.remaining}
+}
+
+// Commit converts the reservation into recorded usage of actualSize bytes.
+func (r *QuotaReservation) Commit(actualSize int64) {
+	r.finish(func(used *usage) {
+		used.bytes += actualSize
+		used.files += r.files
+	})
+}
+
+// Release drops the reservation without recording any usage.
+func (r *QuotaReservation) Release() {
+	r.finish(func(*usage) {})
+}
+
+func (r *QuotaReservation) finish(record func(*usage)) {
+	q := r.quota
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if r.done {
+		return
+	}
+	r.done = true
+
+	reserved := q.entry(q.reserved, r.userID)
+	reserved.bytes -= r.size
+	reserved.files -= r.files
+	record(q.entry(q.used, r.userID))
+}
+
+type quotaReader struct {
+	reader    io.Reader
+	remaining int64
+}
+
+func (r *quotaReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	r.remaining -= int64(n)
+	if r.remaining < 0 {
+		return n, errQuotaExceeded
+	}
+	return n, err
+}
@@ -0,0 +1,138 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/ebinskryfon/fileuploader/utils"
+)
+
+// RevocationStore records revoked tokens. Implementations backed by a
+// shared store let several replicas honour the same revocations.
+type RevocationStore interface {
+	// RevokeToken revokes a single token by its jti. expiresAt is when the
+	// token would have expired anyway, after which the entry may be dropped.
+	RevokeToken(jti string, expiresAt time.Time) error
+	// RevokeUser revokes every token for userID in tenantID issued before
+	// the cutoff. The same user ID in another tenant is a different user.
+	RevokeUser(tenantID, userID string, issuedBefore time.Time) error
+	IsRevoked(jti, tenantID, userID string, issuedAt time.Time) bool
+}
+
+// revocationState holds user cutoffs for the default tenant in Users and
+// for every other tenant in Tenants, by tenant ID.
+type revocationState struct {
+	Tokens  map[string]time.Time            `json:"tokens"`
+	Users   map[string]time.Time            `json:"users"`
+	Tenants map[string]map[string]time.Time `json:"tenants,omitempty"`
+}
+
+// FileRevocationStore keeps revocations in memory and persists them to a
+// JSON file so they survive restarts. Expired token entries are pruned on
+// each write.
+type FileRevocationStore struct {
+	path string
+
+	mu    sync.RWMutex
+	state revocationState
+}
+
+func NewFileRevocationStore(path string) (*FileRevocationStore, error) {
+	store := &FileRevocationStore{
+		path: path,
+		state: revocationState{
+			Tokens:  make(map[string]time.Time),
+			Users:   make(map[string]time.Time),
+			Tenants: make(map[string]map[string]time.Time),
+		},
+	}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return store, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read revocation store: %v", err)
+	}
+
+	if err := json.Unmarshal(data, &store.state); err != nil {
+		return nil, fmt.Errorf("failed to decode revocation store: %v", err)
+	}
+	if store.state.Tokens == nil {
+		store.state.Tokens = make(map[string]time.Time)
+	}
+	if store.state.Users == nil {
+		store.state.Users = make(map[string]time.Time)
+	}
+	if store.state.Tenants == nil {
+		store.state.Tenants = make(map[string]map[string]time.Time)
+	}
+
+	return store, nil
+}
+
+func (s *FileRevocationStore) RevokeToken(jti string, expiresAt time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.state.Tokens[jti] = expiresAt.UTC()
+	return s.persistLocked()
+}
+
+func (s *FileRevocationStore) RevokeUser(tenantID, userID string, issuedBefore time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	users := s.state.Users
+	if tenantID != "" {
+		if s.state.Tenants[tenantID] == nil {
+			s.state.Tenants[tenantID] = make(map[string]time.Time)
+		}
+		users = s.state.Tenants[tenantID]
+	}
+
+	// Never move an existing cutoff backwards
+	if current, ok := users[userID]; ok && current.After(issuedBefore) {
+		return nil
+	}
+	users[userID] = issuedBefore.UTC()
+	return s.persistLocked()
+}
+
+func (s *FileRevocationStore) IsRevoked(jti, tenantID, userID string, issuedAt time.Time) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if jti != "" {
+		if _, ok := s.state.Tokens[jti]; ok {
+			return true
+		}
+	}
+
+	users := s.state.Users
+	if tenantID != "" {
+		users = s.state.Tenants[tenantID]
+	}
+	cutoff, ok := users[userID]
+	return ok && issuedAt.Before(cutoff)
+}
+
+// persistLocked prunes expired token entries and rewrites the file. Callers
+// must hold s.mu.
+func (s *FileRevocationStore) persistLocked() error {
+	now := time.Now()
+	for jti, expiresAt := range s.state.Tokens {
+		if !expiresAt.IsZero() && expiresAt.Before(now) {
+			delete(s.state.Tokens, jti)
+		}
+	}
+
+	data, err := json.MarshalIndent(s.state, "", "  ")
+	if err != nil {
+		return err
+	}
+	return utils.WriteFileAtomic(s.path, data, 0600)
+}
@@ -0,0 +1,196 @@
+package services
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+
+	"github.com/ebinskryfon/fileuploader/models"
+	"github.com/ebinskryfon/fileuploader/utils"
+)
+
+var (
+	errInvalidJPEG = errors.New("malformed JPEG")
+	errInvalidPNG  = errors.New("malformed PNG")
+
+	pngSignature = []byte("\x89PNG\r\n\x1a\n")
+)
+
+// pngRenderingChunks are the ancillary PNG chunks that change how an image
+// looks. Every other ancillary chunk, such as tEXt, iTXt, zTXt, eXIf and
+// tIME, only describes the image and is stripped.
+var pngRenderingChunks = map[string]bool{
+	"tRNS": true, "gAMA": true, "cHRM": true, "sRGB": true, "iCCP": true,
+	"cICP": true, "mDCv": true, "cLLi": true, "sBIT": true, "bKGD": true,
+	"hIST": true, "pHYs": true, "sPLT": true,
+	// Animation
+	"acTL": true, "fcTL": true, "fdAT": true,
+}
+
+// metadataStrippers remove embedded metadata from images without touching
+// their pixel data.
+var metadataStrippers = map[string]func(data []byte) ([]byte, error){
+	"image/jpeg": stripJPEGMetadata,
+	"image/png":  stripPNGMetadata,
+}
+
+// StripImageMetadata removes EXIF, XMP, IPTC, comments and similar metadata
+// from a JPEG or PNG image, leaving the compressed pixels and the color
+// information needed to display them as they were. Other content types are
+// returned unchanged.
+func StripImageMetadata(contentType string, data []byte) ([]byte, error) {
+	strip, ok := metadataStrippers[baseContentType(contentType)]
+	if !ok {
+		return data, nil
+	}
+	return strip(data)
+}
+
+// sanitizeContent strips metadata from content when the tenant asks for it
+// for contentType. It returns the content to store and, if anything was
+// removed, the checksum of the content as uploaded.
+func (u *UploadService) sanitizeContent(tenant *tenantServices, contentType string, content []byte) ([]byte, string, *models.AppError) {
+	if !tenant.stripMetadata[tenant.validation.Canonical(contentType)] {
+		return content, "", nil
+	}
+
+	sanitized, err := StripImageMetadata(tenant.validation.Canonical(contentType), content)
+	if err != nil {
+		return nil, "", models.NewValidationError(models.ReasonInvalidImage, "Image is malformed and cannot be sanitized", nil)
+	}
+	if bytes.Equal(sanitized, content) {
+		return content, "", nil
+	}
+	return sanitized, utils.CalculateChecksum(content), nil
+}
+
+// stripJPEGMetadata drops the APPn and comment segments that carry metadata.
+// JFIF (APP0), ICC color profiles (APP2) and Adobe color transforms (APP14)
+// are kept and scans are copied verbatim. Like trailing PNG chunks, anything
+// after the end of the image, such as a second image with its own EXIF, is
+// dropped.
+func stripJPEGMetadata(data []byte) ([]byte, error) {
+	if len(data) < 4 || data[0] != 0xFF || data[1] != 0xD8 {
+		return nil, errInvalidJPEG
+	}
+
+	out := make([]byte, 0, len(data))
+	out = append(out, 0xFF, 0xD8)
+
+	for i := 2; ; {
+		if i >= len(data) || data[i] != 0xFF {
+			return nil, errInvalidJPEG
+		}
+		// Markers may be padded with any number of 0xFF fill bytes
+		for i < len(data) && data[i] == 0xFF {
+			i++
+		}
+		if i >= len(data) {
+			return nil, errInvalidJPEG
+		}
+		marker := data[i]
+		start := i - 1
+		i++
+
+		switch {
+		case marker == 0xD9:
+			// End of image without a scan
+			return append(out, 0xFF, 0xD9), nil
+		case marker == 0x01 || (marker >= 0xD0 && marker <= 0xD7):
+			// Standalone markers have no length
+			out = append(out, 0xFF, marker)
+			continue
+		}
+
+		if i+2 > len(data) {
+			return nil, errInvalidJPEG
+		}
+		end := i + int(binary.BigEndian.Uint16(data[i:]))
+		if end < i+2 || end > len(data) {
+			return nil, errInvalidJPEG
+		}
+		payload := data[i+2 : end]
+		i = end
+
+		if marker == 0xDA {
+			// Start of scan: entropy-coded data follows up to the next
+			// marker. A file cut off mid-scan is kept as it is.
+			next := jpegScanEnd(data, i)
+			out = append(out, data[start:next]...)
+			if next == len(data) {
+				return out, nil
+			}
+			i = next
+			continue
+		}
+		if keepJPEGSegment(marker, payload) {
+			out = append(out, data[start:end]...)
+		}
+	}
+}
+
+// jpegScanEnd returns the offset of the marker ending the entropy-coded
+// data at i, or len(data) if there is none. Within a scan 0xFF is followed
+// by a stuffed zero, a restart marker or fill.
+func jpegScanEnd(data []byte, i int) int {
+	for ; i+1 < len(data); i++ {
+		if data[i] != 0xFF {
+			continue
+		}
+		switch next := data[i+1]; {
+		case next == 0x00 || (next >= 0xD0 && next <= 0xD7):
+			i++
+		case next != 0xFF:
+			return i
+		}
+	}
+	return len(data)
+}
+
+func keepJPEGSegment(marker byte, payload []byte) bool {
+	switch {
+	case marker == 0xE0:
+		return bytes.HasPrefix(payload, []byte("JFIF\x00"))
+	case marker == 0xE2:
+		return bytes.HasPrefix(payload, []byte("ICC_PROFILE\x00"))
+	case marker == 0xEE:
+		return bytes.HasPrefix(payload, []byte("Adobe"))
+	case marker >= 0xE1 && marker <= 0xEF, marker == 0xFE:
+		// Other application segments and comments: EXIF and XMP (APP1),
+		// IPTC (APP13) and so on
+		return false
+	}
+	return true
+}
+
+// stripPNGMetadata keeps the critical chunks and those that affect
+// rendering, and drops anything after IEND.
+func stripPNGMetadata(data []byte) ([]byte, error) {
+	if !bytes.HasPrefix(data, pngSignature) {
+		return nil, errInvalidPNG
+	}
+
+	out := make([]byte, 0, len(data))
+	out = append(out, pngSignature...)
+
+	for i := len(pngSignature); ; {
+		if i+8 > len(data) {
+			return nil, errInvalidPNG
+		}
+		length := int(binary.BigEndian.Uint32(data[i:]))
+		chunkType := string(data[i+4 : i+8])
+		end := i + 12 + length
+		if length < 0 || end > len(data) || end < i {
+			return nil, errInvalidPNG
+		}
+
+		// Critical chunks have an upper-case first letter
+		if chunkType[0] >= 'A' && chunkType[0] <= 'Z' || pngRenderingChunks[chunkType] {
+			out = append(out, data[i:end]...)
+		}
+		if chunkType == "IEND" {
+			return out, nil
+		}
+		i = end
+	}
+}
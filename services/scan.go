@@ -0,0 +1,339 @@
+package services
+
+import (
+	"bytes"
+	"io"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/ebinskryfon/fileuploader/models"
+	"github.com/ebinskryfon/fileuploader/storage"
+	"github.com/ebinskryfon/fileuploader/utils"
+)
+
+const (
+	defaultScanQueueSize   = 1000
+	defaultSyncScanMaxSize = 10 * 1024 * 1024
+	// scanAttempts is how often a background scan is tried before the file
+	// is marked as failed.
+	scanAttempts = 3
+)
+
+// scanJob asks for one version of a file's content to be scanned.
+type scanJob struct {
+	tenant   *tenantServices
+	metadata models.FileMetadata
+}
+
+// scanOutcome is the scan state recorded for new content.
+type scanOutcome struct {
+	status    string
+	signature string
+	scannedAt *time.Time
+}
+
+func (o scanOutcome) apply(metadata *models.FileMetadata) {
+	metadata.ScanStatus = o.status
+	metadata.ScanSignature = o.signature
+	metadata.ScannedAt = o.scannedAt
+}
+
+// WithScanner scans every upload with scanner before it can be downloaded,
+// moving infected content to quarantine.
+func (u *UploadService) WithScanner(scanner Scanner, quarantine storage.StorageInterface) *UploadService {
+	u.scanner = scanner
+	u.quarantine = quarantine
+	return u
+}
+
+// scanBlock returns the error for downloading content with the given scan
+// status, or nil if it may be downloaded.
+func scanBlock(status string) *models.AppError {
+	switch status {
+	case models.ScanStatusPending:
+		return models.ErrScanPending
+	case models.ScanStatusFailed:
+		return models.ErrScanFailed
+	case models.ScanStatusInfected:
+		return models.ErrFileQuarantined
+	}
+	return nil
+}
+
+// scanContent scans content about to be stored as metadata. Content over
+// the synchronous size limit is left pending for the scan workers; infected
+// content is quarantined and rejected.
+func (u *UploadService) scanContent(tenant *tenantServices, metadata models.FileMetadata, content []byte) (scanOutcome, *models.AppError) {
+	if u.scanner == nil {
+		return scanOutcome{}, nil
+	}
+
+	syncMaxSize := u.config.Scan.SyncMaxSize
+	if syncMaxSize <= 0 {
+		syncMaxSize = defaultSyncScanMaxSize
+	}
+	if int64(len(content)) > syncMaxSize {
+		return scanOutcome{status: models.ScanStatusPending}, nil
+	}
+
+	result, err := u.scanner.Scan(bytes.NewReader(content))
+	if err != nil {
+		u.logger.Error("Virus scan failed", map[string]interface{}{
+			"file_id":   metadata.ID,
+			"tenant_id": tenant.id,
+			"error":     err.Error(),
+		})
+		return scanOutcome{}, models.ErrScanUnavailable
+	}
+
+	now := time.Now().UTC()
+	if !result.Infected {
+		return scanOutcome{status: models.ScanStatusClean, scannedAt: &now}, nil
+	}
+
+	outcome := scanOutcome{status: models.ScanStatusInfected, signature: result.Signature, scannedAt: &now}
+	outcome.apply(&metadata)
+	u.quarantineContent(tenant, metadata, bytes.NewReader(content))
+
+	return outcome, models.NewValidationError(models.ReasonInfected, "File is infected", map[string]string{
+		"signature": result.Signature,
+	})
+}
+
+// quarantineContent keeps a copy of infected content, keyed by file and
+// checksum, where it can't be downloaded.
+func (u *UploadService) quarantineContent(tenant *tenantServices, metadata models.FileMetadata, content io.Reader) {
+	u.logger.Warn("Infected file quarantined", map[string]interface{}{
+		"file_id":   metadata.ID,
+		"file_name": metadata.OriginalName,
+		"tenant_id": tenant.id,
+		"user_id":   metadata.UploadedBy,
+		"signature": metadata.ScanSignature,
+	})
+
+	if u.quarantine == nil {
+		return
+	}
+	namespace, err := u.quarantine.Namespace(tenant.id)
+	if err == nil {
+		err = namespace.Store(metadata.ID+"-"+metadata.Checksum, content, metadata)
+	}
+	if err != nil {
+		u.logger.Error("Failed to quarantine file", map[string]interface{}{
+			"file_id":   metadata.ID,
+			"tenant_id": tenant.id,
+			"error":     err.Error(),
+		})
+	}
+}
+
+// queueScan schedules a background scan without blocking. When the queue is
+// full the file stays pending until the workers next start.
+func (u *UploadService) queueScan(tenant *tenantServices, metadata models.FileMetadata) {
+	select {
+	case u.scans <- scanJob{tenant: tenant, metadata: metadata}:
+	default:
+		u.logger.Warn("Virus scan queue full, leaving file pending", map[string]interface{}{
+			"file_id":   metadata.ID,
+			"tenant_id": tenant.id,
+		})
+	}
+}
+
+// StartScanning runs workers that scan large uploads in the background
+// until the returned stop function is called. Files left pending or failed
+// by an earlier run are queued again.
+func (u *UploadService) StartScanning(workers int) (stop func()) {
+	if u.scanner == nil {
+		return func() {}
+	}
+
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case job := <-u.scans:
+					u.scanFile(job, done)
+				case <-done:
+					return
+				}
+			}
+		}()
+	}
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		u.requeueScans(done)
+	}()
+
+	return func() {
+		close(done)
+		wg.Wait()
+	}
+}
+
+// requeueScans queues every file and earlier version whose scan is pending
+// or failed.
+func (u *UploadService) requeueScans(done <-chan struct{}) {
+	tenants, err := u.allTenants()
+	if err != nil {
+		u.logger.Error("Failed to list tenants for virus scans", map[string]interface{}{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	for _, tenant := range tenants {
+		files, err := tenant.storage.List()
+		if err != nil {
+			u.logger.Error("Failed to list files for virus scans", map[string]interface{}{
+				"tenant_id": tenant.id,
+				"error":     err.Error(),
+			})
+			continue
+		}
+		for _, metadata := range files {
+			jobs := []models.FileMetadata{metadata}
+			for _, version := range metadata.Versions {
+				jobs = append(jobs, metadataAt(metadata, version))
+			}
+			for _, job := range jobs {
+				if job.ScanStatus != models.ScanStatusPending && job.ScanStatus != models.ScanStatusFailed {
+					continue
+				}
+				select {
+				case u.scans <- scanJob{tenant: tenant, metadata: job}:
+				case <-done:
+					return
+				}
+			}
+		}
+	}
+}
+
+// scanFile scans a job's content, retrying failures, and records the
+// outcome.
+func (u *UploadService) scanFile(job scanJob, done <-chan struct{}) {
+	content, ok := u.scanJobContent(job)
+	if !ok {
+		return
+	}
+
+	var result ScanResult
+	var err error
+	for attempt := 1; ; attempt++ {
+		if result, err = u.scanner.Scan(bytes.NewReader(content)); err == nil || attempt == scanAttempts {
+			break
+		}
+		select {
+		case <-time.After(time.Duration(attempt) * time.Second):
+		case <-done:
+			return
+		}
+	}
+
+	now := time.Now().UTC()
+	outcome := scanOutcome{status: models.ScanStatusClean, scannedAt: &now}
+	if err != nil {
+		u.logger.Error("Virus scan failed", map[string]interface{}{
+			"file_id":   job.metadata.ID,
+			"tenant_id": job.tenant.id,
+			"error":     err.Error(),
+		})
+		outcome.status = models.ScanStatusFailed
+	} else if result.Infected {
+		outcome = scanOutcome{status: models.ScanStatusInfected, signature: result.Signature, scannedAt: &now}
+	}
+
+	u.recordScan(job.tenant, job.metadata, content, outcome)
+}
+
+// scanJobContent reads the content a job was queued for, which may have
+// become an earlier version since.
+func (u *UploadService) scanJobContent(job scanJob) ([]byte, bool) {
+	metadata := job.metadata
+
+	if file, _, err := job.tenant.storage.Retrieve(metadata.ID); err == nil {
+		content, err := io.ReadAll(file)
+		file.Close()
+		if err == nil && utils.CalculateChecksum(content) == metadata.Checksum {
+			return content, true
+		}
+	}
+
+	file, err := job.tenant.storage.RetrieveVersion(metadata.ID, currentVersion(metadata).Version)
+	if err != nil {
+		// Most likely deleted since
+		return nil, false
+	}
+	defer file.Close()
+	content, err := io.ReadAll(file)
+	if err != nil || utils.CalculateChecksum(content) != metadata.Checksum {
+		return nil, false
+	}
+	return content, true
+}
+
+// recordScan saves a background scan's outcome on the version of the file
+// that was scanned.
+func (u *UploadService) recordScan(tenant *tenantServices, scanned models.FileMetadata, content []byte, outcome scanOutcome) {
+	tenant.contentMu.Lock()
+	defer tenant.contentMu.Unlock()
+
+	metadata, err := tenant.storage.GetMetadata(scanned.ID)
+	if err != nil {
+		return
+	}
+
+	version := currentVersion(scanned).Version
+	current := currentVersion(metadata).Version == version && metadata.Checksum == scanned.Checksum
+	if current {
+		outcome.apply(&metadata)
+		if outcome.status == models.ScanStatusInfected {
+			u.quarantineContent(tenant, metadata, bytes.NewReader(content))
+			// Only the metadata stays behind, so the owner can see why
+			err = tenant.storage.Store(metadata.ID, bytes.NewReader(nil), metadata)
+		} else {
+			err = tenant.storage.UpdateMetadata(metadata.ID, metadata)
+		}
+	} else {
+		index := slices.IndexFunc(metadata.Versions, func(v models.FileVersion) bool {
+			return v.Version == version && v.Checksum == scanned.Checksum
+		})
+		if index < 0 {
+			return
+		}
+		metadata.Versions[index].ScanStatus = outcome.status
+		if outcome.status == models.ScanStatusInfected {
+			quarantined := metadataAt(metadata, metadata.Versions[index])
+			outcome.apply(&quarantined)
+			u.quarantineContent(tenant, quarantined, bytes.NewReader(content))
+		}
+		err = tenant.storage.UpdateMetadata(metadata.ID, metadata)
+	}
+	if err != nil {
+		u.logger.Error("Failed to record virus scan", map[string]interface{}{
+			"file_id":   metadata.ID,
+			"tenant_id": tenant.id,
+			"error":     err.Error(),
+		})
+		return
+	}
+	if current {
+		u.queueThumbnails(tenant, metadata)
+	}
+
+	u.logger.Info("File scanned", map[string]interface{}{
+		"file_id":     metadata.ID,
+		"tenant_id":   tenant.id,
+		"version":     version,
+		"scan_status": outcome.status,
+	})
+}
@@ -0,0 +1,469 @@
+package services
+
+import (
+	"cmp"
+	"fmt"
+	"math"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"unicode"
+
+	"github.com/ebinskryfon/fileuploader/models"
+	"github.com/ebinskryfon/fileuploader/storage"
+)
+
+const (
+	defaultSearchLimit = 50
+	maxSearchLimit     = 200
+	// maxSearchTokens bounds the distinct words in q and in content. Each
+	// word is matched against every term in the index.
+	maxSearchTokens = 32
+)
+
+// searchField marks which fields of a file a term was found in.
+type searchField uint8
+
+const (
+	fieldName searchField = 1 << iota
+	fieldTags
+	fieldDescription
+)
+
+// weight scores a match in the most significant of fields.
+func (f searchField) weight() float64 {
+	switch {
+	case f&fieldName != 0:
+		return 3
+	case f&fieldTags != 0:
+		return 2
+	case f&fieldDescription != 0:
+		return 1
+	}
+	return 0
+}
+
+// SearchIndex is an in-memory inverted index over file names, tags and
+// descriptions, and over the text inside documents. It is built from storage
+// on first use and kept up to date as files change, so it can always be
+// rebuilt from what is stored. Trashed files are not indexed.
+//
+// Document text is extracted in the background: whenever a file is indexed
+// whose content hasn't been, extract is called to queue it, and the text
+// arrives later through IndexContent.
+type SearchIndex struct {
+	storage storage.StorageInterface
+	extract func(models.FileMetadata)
+
+	mu       sync.RWMutex
+	loaded   bool
+	files    map[string]models.FileMetadata
+	terms    map[string]map[string]searchField
+	contents map[string]indexedContent
+	// contentTerms maps each word to how often it occurs in each file.
+	contentTerms map[string]map[string]int
+}
+
+// indexedContent records the words extracted from one version of a file's
+// content, identified by its checksum.
+type indexedContent struct {
+	checksum string
+	terms    map[string]int
+}
+
+type searchMatch struct {
+	metadata models.FileMetadata
+	score    float64
+}
+
+// NewSearchIndex creates an index over storage. extract may be nil when
+// document text isn't indexed; it must not block.
+func NewSearchIndex(storage storage.StorageInterface, extract func(models.FileMetadata)) *SearchIndex {
+	return &SearchIndex{
+		storage:      storage,
+		extract:      extract,
+		files:        make(map[string]models.FileMetadata),
+		terms:        make(map[string]map[string]searchField),
+		contents:     make(map[string]indexedContent),
+		contentTerms: make(map[string]map[string]int),
+	}
+}
+
+// Index adds or updates a file, loading the index first if need be so the
+// file's text is extracted straight away.
+func (s *SearchIndex) Index(metadata models.FileMetadata) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.load(); err != nil {
+		// Loading is retried on the next search, and will pick this file up
+		return
+	}
+	s.removeTermsLocked(metadata.ID)
+	if metadata.DeletedAt != nil {
+		s.removeContentLocked(metadata.ID)
+		return
+	}
+	s.addLocked(metadata)
+}
+
+// Remove drops a file from the index.
+func (s *SearchIndex) Remove(fileID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.loaded {
+		s.removeTermsLocked(fileID)
+		s.removeContentLocked(fileID)
+	}
+}
+
+// IndexContent records the text extracted from a file's content. Text for
+// content that has since changed, or for a file no longer indexed, is
+// ignored.
+func (s *SearchIndex) IndexContent(fileID, checksum, text string) {
+	counts := make(map[string]int)
+	for _, token := range tokenize(text) {
+		counts[token]++
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if metadata, ok := s.files[fileID]; !ok || metadata.Checksum != checksum {
+		return
+	}
+	s.removeContentLocked(fileID)
+	s.contents[fileID] = indexedContent{checksum: checksum, terms: counts}
+	for term, count := range counts {
+		postings, ok := s.contentTerms[term]
+		if !ok {
+			postings = make(map[string]int)
+			s.contentTerms[term] = postings
+		}
+		postings[fileID] = count
+	}
+}
+
+// Rebuild discards the index and reads every file from storage again. It
+// returns the number of files indexed.
+func (s *SearchIndex) Rebuild() (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.loaded = false
+	if err := s.load(); err != nil {
+		return 0, err
+	}
+	return len(s.files), nil
+}
+
+// Search returns every file matching all of the words in query, found in
+// its name, tags or description, and all of the words in content, found in
+// its text. A word also matches words it is a prefix of, at half weight.
+// With no words at all every file matches with a score of zero.
+func (s *SearchIndex) Search(query, content string) ([]searchMatch, error) {
+	s.mu.Lock()
+	err := s.load()
+	s.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	scores := scoreTokens(uniqueTokens(query), s.terms, searchField.weight)
+	contentScores := scoreTokens(uniqueTokens(content), s.contentTerms, func(count int) float64 {
+		return 0.5 * (1 + math.Log(float64(count)))
+	})
+	switch {
+	case scores == nil:
+		scores = contentScores
+	case contentScores != nil:
+		for fileID, score := range scores {
+			if contentScore, ok := contentScores[fileID]; ok {
+				scores[fileID] = score + contentScore
+			} else {
+				delete(scores, fileID)
+			}
+		}
+	}
+
+	matches := make([]searchMatch, 0)
+	if scores == nil {
+		for _, metadata := range s.files {
+			matches = append(matches, searchMatch{metadata: metadata})
+		}
+	}
+	for fileID, score := range scores {
+		matches = append(matches, searchMatch{metadata: s.files[fileID], score: score})
+	}
+
+	slices.SortFunc(matches, func(a, b searchMatch) int {
+		if c := cmp.Compare(b.score, a.score); c != 0 {
+			return c
+		}
+		return b.metadata.UploadTime.Compare(a.metadata.UploadTime)
+	})
+	return matches, nil
+}
+
+// scoreTokens scores the files in which every token matches a term, summing
+// the best weight for each token. It returns nil when there are no tokens.
+func scoreTokens[V any](tokens []string, terms map[string]map[string]V, weight func(V) float64) map[string]float64 {
+	var scores map[string]float64
+	for _, token := range tokens {
+		tokenScores := make(map[string]float64)
+		for term, postings := range terms {
+			factor := 1.0
+			if term != token {
+				if !strings.HasPrefix(term, token) {
+					continue
+				}
+				factor = 0.5
+			}
+			for fileID, value := range postings {
+				tokenScores[fileID] = max(tokenScores[fileID], weight(value)*factor)
+			}
+		}
+
+		if scores == nil {
+			scores = tokenScores
+			continue
+		}
+		for fileID, score := range scores {
+			if tokenScore, ok := tokenScores[fileID]; ok {
+				scores[fileID] = score + tokenScore
+			} else {
+				delete(scores, fileID)
+			}
+		}
+	}
+	return scores
+}
+
+// load builds the index from storage the first time it is needed. Callers
+// must hold s.mu.
+func (s *SearchIndex) load() error {
+	if s.loaded {
+		return nil
+	}
+
+	files, err := s.storage.List()
+	if err != nil {
+		return err
+	}
+
+	s.files = make(map[string]models.FileMetadata, len(files))
+	s.terms = make(map[string]map[string]searchField)
+	s.contents = make(map[string]indexedContent)
+	s.contentTerms = make(map[string]map[string]int)
+	for _, metadata := range files {
+		if metadata.DeletedAt == nil {
+			s.addLocked(metadata)
+		}
+	}
+	s.loaded = true
+
+	return nil
+}
+
+func (s *SearchIndex) addLocked(metadata models.FileMetadata) {
+	s.files[metadata.ID] = metadata
+
+	add := func(text string, field searchField) {
+		for _, token := range tokenize(text) {
+			postings, ok := s.terms[token]
+			if !ok {
+				postings = make(map[string]searchField)
+				s.terms[token] = postings
+			}
+			postings[metadata.ID] |= field
+		}
+	}
+
+	add(metadata.OriginalName, fieldName)
+	for key, value := range metadata.Tags {
+		add(key, fieldTags)
+		add(value, fieldTags)
+	}
+	add(metadata.Description, fieldDescription)
+
+	if content, ok := s.contents[metadata.ID]; !ok || content.checksum != metadata.Checksum {
+		s.removeContentLocked(metadata.ID)
+		if s.extract != nil {
+			s.extract(metadata)
+		}
+	}
+}
+
+func (s *SearchIndex) removeContentLocked(fileID string) {
+	content, ok := s.contents[fileID]
+	if !ok {
+		return
+	}
+	delete(s.contents, fileID)
+
+	for term := range content.terms {
+		if postings, ok := s.contentTerms[term]; ok {
+			delete(postings, fileID)
+			if len(postings) == 0 {
+				delete(s.contentTerms, term)
+			}
+		}
+	}
+}
+
+// removeTermsLocked drops a file's metadata from the index, leaving any
+// extracted content in place for when it is re-added.
+func (s *SearchIndex) removeTermsLocked(fileID string) {
+	metadata, ok := s.files[fileID]
+	if !ok {
+		return
+	}
+	delete(s.files, fileID)
+
+	texts := []string{metadata.OriginalName, metadata.Description}
+	for key, value := range metadata.Tags {
+		texts = append(texts, key, value)
+	}
+	for _, text := range texts {
+		for _, token := range tokenize(text) {
+			if postings, ok := s.terms[token]; ok {
+				delete(postings, fileID)
+				if len(postings) == 0 {
+					delete(s.terms, token)
+				}
+			}
+		}
+	}
+}
+
+// tokenize splits text into lower-case words of letters and digits.
+func tokenize(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+func uniqueTokens(text string) []string {
+	tokens := tokenize(text)
+	slices.Sort(tokens)
+	return slices.Compact(tokens)
+}
+
+// Search finds files the caller can read by words in their name, tags and
+// description, best match first.
+func (u *UploadService) Search(principal models.Principal, query models.SearchQuery) (*models.SearchResponse, *models.AppError) {
+	if strings.TrimSpace(query.Query) == "" && strings.TrimSpace(query.Content) == "" && query.ContentType == "" && query.From == nil && query.To == nil &&
+		query.MinSize <= 0 && query.MaxSize <= 0 {
+		return nil, models.NewAppError(http.StatusBadRequest, "Specify q and/or a filter", nil)
+	}
+	if len(uniqueTokens(query.Query)) > maxSearchTokens || len(uniqueTokens(query.Content)) > maxSearchTokens {
+		return nil, models.NewAppError(http.StatusBadRequest, fmt.Sprintf("Search for at most %d different words", maxSearchTokens), nil)
+	}
+	if query.Limit <= 0 {
+		query.Limit = defaultSearchLimit
+	}
+	query.Limit = min(query.Limit, maxSearchLimit)
+
+	tenant, appError := u.tenant(principal.TenantID)
+	if appError != nil {
+		return nil, appError
+	}
+
+	matches, err := tenant.search.Search(query.Query, query.Content)
+	if err != nil {
+		u.logger.Error("Failed to search files", map[string]interface{}{
+			"user_id": principal.UserID,
+			"error":   err.Error(),
+		})
+		return nil, models.ErrInternalServer
+	}
+
+	// Folder access is resolved once per folder rather than once per file
+	folderAccess := make(map[string]accessLevel)
+	readable := func(metadata models.FileMetadata) bool {
+		if principal.TenantAdmin || accessFor(metadata, principal) >= accessRead {
+			return true
+		}
+		if metadata.FolderID == "" {
+			return false
+		}
+		level, ok := folderAccess[metadata.FolderID]
+		if !ok {
+			level = u.inheritedAccess(tenant, metadata.FolderID, principal)
+			folderAccess[metadata.FolderID] = level
+		}
+		return level >= accessRead
+	}
+
+	response := &models.SearchResponse{Results: make([]models.SearchResult, 0)}
+	for _, match := range matches {
+		if !matchesSearchFilters(match.metadata, query) || !readable(match.metadata) {
+			continue
+		}
+		response.Total++
+		if len(response.Results) < query.Limit {
+			response.Results = append(response.Results, models.SearchResult{
+				File:  visibleMetadata(match.metadata, principal),
+				Score: match.score,
+			})
+		}
+	}
+
+	return response, nil
+}
+
+func matchesSearchFilters(metadata models.FileMetadata, query models.SearchQuery) bool {
+	if query.ContentType != "" && !matchesContentType(query.ContentType, metadata.ContentType) {
+		return false
+	}
+	if query.From != nil && metadata.UploadTime.Before(*query.From) {
+		return false
+	}
+	if query.To != nil && metadata.UploadTime.After(*query.To) {
+		return false
+	}
+	if query.MinSize > 0 && metadata.Size < query.MinSize {
+		return false
+	}
+	if query.MaxSize > 0 && metadata.Size > query.MaxSize {
+		return false
+	}
+	return true
+}
+
+// RebuildSearchIndex rebuilds every tenant's search index from storage and
+// returns the number of files indexed.
+func (u *UploadService) RebuildSearchIndex() (int, *models.AppError) {
+	tenants, err := u.allTenants()
+	if err != nil {
+		u.logger.Error("Failed to list tenants", map[string]interface{}{
+			"error": err.Error(),
+		})
+		return 0, models.ErrInternalServer
+	}
+
+	// Rebuilding queues every document for text extraction again
+	indexed := 0
+	for _, tenant := range tenants {
+		count, err := tenant.search.Rebuild()
+		if err != nil {
+			u.logger.Error("Failed to rebuild search index", map[string]interface{}{
+				"tenant_id": tenant.id,
+				"error":     err.Error(),
+			})
+			return indexed, models.ErrInternalServer
+		}
+		indexed += count
+	}
+
+	u.logger.Info("Search index rebuilt", map[string]interface{}{
+		"indexed": indexed,
+	})
+
+	return indexed, nil
+}
@@ -0,0 +1,326 @@
+package services
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/binary"
+	"encoding/xml"
+	"io"
+	"maps"
+	"slices"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	contentTypeOctetStream = "application/octet-stream"
+	contentTypeZip         = "application/zip"
+	contentTypeOLE         = "application/x-ole-storage"
+
+	// sniffLength is how much of a file is read to identify it. Text is
+	// judged on this prefix too.
+	sniffLength = 8192
+	// maxContainerManifest bounds how much of a container's manifest is
+	// read, so a crafted archive can't make inspection expensive.
+	maxContainerManifest = 1 << 20
+)
+
+// defaultContentTypeAliases are alternative names clients commonly send.
+var defaultContentTypeAliases = map[string]string{
+	"image/jpg":                    "image/jpeg",
+	"image/pjpeg":                  "image/jpeg",
+	"image/x-png":                  "image/png",
+	"application/x-pdf":            "application/pdf",
+	"application/acrobat":          "application/pdf",
+	"application/x-zip-compressed": "application/zip",
+	"application/vnd.ms-word":      "application/msword",
+}
+
+// magicSignature identifies a format by bytes at a fixed offset. Weak
+// signatures are short enough to begin ordinary text, so they are only
+// tried once content has turned out not to be text.
+type magicSignature struct {
+	contentType string
+	offset      int
+	magic       []byte
+	weak        bool
+}
+
+var magicSignatures = []magicSignature{
+	{"image/jpeg", 0, []byte{0xFF, 0xD8, 0xFF}, false},
+	{"image/png", 0, []byte("\x89PNG\r\n\x1a\n"), false},
+	{"image/gif", 0, []byte("GIF87a"), false},
+	{"image/gif", 0, []byte("GIF89a"), false},
+	{"image/webp", 8, []byte("WEBP"), false},
+	{"image/tiff", 0, []byte("II*\x00"), false},
+	{"image/tiff", 0, []byte("MM\x00*"), false},
+	{"application/pdf", 0, []byte("%PDF-"), false},
+	{contentTypeZip, 0, []byte("PK\x03\x04"), false},
+	{contentTypeZip, 0, []byte("PK\x05\x06"), false},
+	{contentTypeOLE, 0, []byte{0xD0, 0xCF, 0x11, 0xE0, 0xA1, 0xB1, 0x1A, 0xE1}, false},
+	{"application/x-executable", 0, []byte("\x7fELF"), false},
+	{"image/bmp", 0, []byte("BM"), true},
+	{"application/gzip", 0, []byte{0x1F, 0x8B}, true},
+	{"application/x-msdownload", 0, []byte("MZ"), true},
+}
+
+// bmpHeaderSizes are the sizes of the BMP info header versions, which
+// follow the 14-byte file header.
+var bmpHeaderSizes = []uint32{12, 16, 40, 52, 56, 64, 108, 124}
+
+// ooxmlMainParts maps the content type of an OOXML package's main part to
+// the package's content type.
+var ooxmlMainParts = map[string]string{
+	"application/vnd.openxmlformats-officedocument.wordprocessingml.document.main+xml":   contentTypeDOCX,
+	"application/vnd.ms-word.document.macroEnabled.main+xml":                             "application/vnd.ms-word.document.macroEnabled.12",
+	"application/vnd.openxmlformats-officedocument.wordprocessingml.template.main+xml":   "application/vnd.openxmlformats-officedocument.wordprocessingml.template",
+	"application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml":         "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet",
+	"application/vnd.ms-excel.sheet.macroEnabled.main+xml":                               "application/vnd.ms-excel.sheet.macroEnabled.12",
+	"application/vnd.openxmlformats-officedocument.presentationml.presentation.main+xml": "application/vnd.openxmlformats-officedocument.presentationml.presentation",
+	"application/vnd.ms-powerpoint.presentation.macroEnabled.main+xml":                   "application/vnd.ms-powerpoint.presentation.macroEnabled.12",
+}
+
+// oleStreams maps the UTF-16 name of a stream found in an OLE2 compound
+// file to the legacy Office format it identifies.
+var oleStreams = []struct {
+	name        string
+	contentType string
+}{
+	{"WordDocument", "application/msword"},
+	{"Workbook", "application/vnd.ms-excel"},
+	{"PowerPoint Document", "application/vnd.ms-powerpoint"},
+}
+
+// SniffResult is what a file's content turned out to be. Charset is only set
+// for text.
+type SniffResult struct {
+	ContentType string
+	Charset     string
+}
+
+// ContentSniffer identifies files from their content rather than their
+// declared type, looking inside ZIP and OLE2 containers to tell Office
+// documents apart.
+type ContentSniffer struct {
+	aliases map[string]string
+}
+
+// NewContentSniffer creates a sniffer resolving the built-in aliases plus
+// aliases, which take precedence.
+func NewContentSniffer(aliases map[string]string) *ContentSniffer {
+	resolved := maps.Clone(defaultContentTypeAliases)
+	for alias, canonical := range aliases {
+		resolved[strings.ToLower(alias)] = strings.ToLower(canonical)
+	}
+	return &ContentSniffer{aliases: resolved}
+}
+
+// Canonical strips parameters from a content type and resolves aliases.
+func (s *ContentSniffer) Canonical(contentType string) string {
+	contentType = baseContentType(contentType)
+	if canonical, ok := s.aliases[contentType]; ok {
+		return canonical
+	}
+	return contentType
+}
+
+// Sniff identifies the content of r, which is size bytes long.
+func (s *ContentSniffer) Sniff(r io.ReaderAt, size int64) (SniffResult, error) {
+	head := make([]byte, min(size, sniffLength))
+	n, err := r.ReadAt(head, 0)
+	if err != nil && err != io.EOF {
+		return SniffResult{}, err
+	}
+	head = head[:n]
+
+	for _, signature := range magicSignatures {
+		if signature.weak || !signature.matches(head) {
+			continue
+		}
+		switch signature.contentType {
+		case "image/webp":
+			if !bytes.HasPrefix(head, []byte("RIFF")) {
+				continue
+			}
+		case contentTypeZip:
+			return SniffResult{ContentType: inspectZip(r, size)}, nil
+		case contentTypeOLE:
+			return SniffResult{ContentType: inspectOLE(r, size)}, nil
+		}
+		return SniffResult{ContentType: signature.contentType}, nil
+	}
+
+	if charset := detectCharset(head, size > int64(len(head))); charset != "" {
+		return SniffResult{ContentType: "text/plain", Charset: charset}, nil
+	}
+
+	for _, signature := range magicSignatures {
+		if !signature.weak || !signature.matches(head) {
+			continue
+		}
+		switch signature.contentType {
+		case "image/bmp":
+			// Reserved bytes, then the info header's own size
+			if len(head) < 18 || binary.LittleEndian.Uint32(head[6:]) != 0 ||
+				!slices.Contains(bmpHeaderSizes, binary.LittleEndian.Uint32(head[14:])) {
+				continue
+			}
+		case "application/gzip":
+			// Deflate is the only compression method defined
+			if len(head) < 3 || head[2] != 8 {
+				continue
+			}
+		}
+		return SniffResult{ContentType: signature.contentType}, nil
+	}
+	return SniffResult{ContentType: contentTypeOctetStream}, nil
+}
+
+func (s magicSignature) matches(head []byte) bool {
+	return len(head) >= s.offset+len(s.magic) &&
+		bytes.Equal(head[s.offset:s.offset+len(s.magic)], s.magic)
+}
+
+// inspectZip identifies an OOXML or OpenDocument package from its manifest,
+// falling back to plain ZIP.
+func inspectZip(r io.ReaderAt, size int64) string {
+	archive, err := zip.NewReader(r, size)
+	if err != nil {
+		return contentTypeZip
+	}
+
+	for _, file := range archive.File {
+		switch file.Name {
+		case "[Content_Types].xml":
+			if contentType := ooxmlContentType(file); contentType != "" {
+				return contentType
+			}
+		case "mimetype":
+			// OpenDocument stores its type as the first, uncompressed entry
+			if data, err := readZipEntry(file, 256); err == nil && file.Method == zip.Store {
+				if contentType := strings.TrimSpace(string(data)); strings.HasPrefix(contentType, "application/vnd.oasis.opendocument.") {
+					return contentType
+				}
+			}
+		}
+	}
+	return contentTypeZip
+}
+
+// ooxmlContentType reads an OOXML [Content_Types].xml and returns the type
+// of the package its main part belongs to.
+func ooxmlContentType(manifest *zip.File) string {
+	data, err := readZipEntry(manifest, maxContainerManifest)
+	if err != nil {
+		return ""
+	}
+
+	var types struct {
+		Overrides []struct {
+			PartName    string `xml:"PartName,attr"`
+			ContentType string `xml:"ContentType,attr"`
+		} `xml:"Override"`
+	}
+	if err := xml.Unmarshal(data, &types); err != nil {
+		return ""
+	}
+	for _, override := range types.Overrides {
+		if contentType, ok := ooxmlMainParts[override.ContentType]; ok {
+			return contentType
+		}
+	}
+	return ""
+}
+
+func readZipEntry(file *zip.File, limit int64) ([]byte, error) {
+	reader, err := file.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	return io.ReadAll(io.LimitReader(reader, limit))
+}
+
+// inspectOLE identifies a legacy Office document by the streams named in
+// its compound file directory.
+func inspectOLE(r io.ReaderAt, size int64) string {
+	data := make([]byte, min(size, maxContainerManifest))
+	n, _ := r.ReadAt(data, 0)
+	data = data[:n]
+
+	for _, stream := range oleStreams {
+		if bytes.Contains(data, utf16LE(stream.name)) {
+			return stream.contentType
+		}
+	}
+	return contentTypeOLE
+}
+
+func utf16LE(s string) []byte {
+	encoded := make([]byte, 0, 2*len(s))
+	for _, c := range []byte(s) {
+		encoded = append(encoded, c, 0)
+	}
+	return encoded
+}
+
+// detectCharset returns the charset of head if it looks like text, or ""
+// for binary content. truncated means head is only a prefix of the file, so
+// a multi-byte character may be cut off at its end.
+func detectCharset(head []byte, truncated bool) string {
+	switch {
+	case bytes.HasPrefix(head, []byte{0xEF, 0xBB, 0xBF}):
+		return "utf-8"
+	case bytes.HasPrefix(head, []byte{0xFF, 0xFE}):
+		return "utf-16le"
+	case bytes.HasPrefix(head, []byte{0xFE, 0xFF}):
+		return "utf-16be"
+	}
+
+	for _, c := range head {
+		// Control characters other than whitespace and escape mean binary
+		if c < 0x20 && c != '\t' && c != '\n' && c != '\r' && c != '\f' && c != 0x1B {
+			return ""
+		}
+	}
+
+	if truncated {
+		// Drop a character cut off by the end of the prefix
+		for i := 0; i < utf8.UTFMax && len(head) > 0; i++ {
+			if r, _ := utf8.DecodeLastRune(head); r != utf8.RuneError {
+				break
+			}
+			head = head[:len(head)-1]
+		}
+	}
+	if !utf8.Valid(head) {
+		return "iso-8859-1"
+	}
+	for _, c := range head {
+		if c >= 0x80 {
+			return "utf-8"
+		}
+	}
+	return "us-ascii"
+}
+
+// charsetCompatible reports whether text detected as detected can be read as
+// the declared charset.
+func charsetCompatible(declared, detected string) bool {
+	declared = strings.ToLower(declared)
+	switch {
+	case declared == "" || declared == detected:
+		return true
+	case detected == "us-ascii":
+		// ASCII is a subset of every charset we detect other than UTF-16
+		return !strings.HasPrefix(declared, "utf-16")
+	case declared == "utf-16":
+		return strings.HasPrefix(detected, "utf-16")
+	case detected == "iso-8859-1":
+		// Any 8-bit text is valid in the single-byte Latin charsets
+		return declared == "latin1" || declared == "windows-1252" || declared == "cp1252" || declared == "iso-8859-15"
+	case detected == "utf-8":
+		return declared == "utf8"
+	}
+	return false
+}
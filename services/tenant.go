@@ -0,0 +1,257 @@
+package services
+
+import (
+	"errors"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/ebinskryfon/fileuploader/config"
+	"github.com/ebinskryfon/fileuploader/models"
+	"github.com/ebinskryfon/fileuploader/storage"
+)
+
+// tenantServices bundles the storage namespace and tenant-specific settings
+// every file operation runs against.
+type tenantServices struct {
+	id         string
+	storage    storage.StorageInterface
+	validation *ValidationService
+	quota      *QuotaService
+	search     *SearchIndex
+	retention  time.Duration
+	trash      time.Duration
+	// minRetention and retentionRules decide how long new content is
+	// held before it may be deleted or replaced.
+	minRetention   time.Duration
+	retentionRules []config.RetentionRule
+	// stripMetadata holds the canonical content types whose embedded
+	// metadata is removed before storing.
+	stripMetadata map[string]bool
+	// pdfPolicy decides what happens to risky PDF content.
+	pdfPolicy *PDFPolicy
+
+	// folderMu serializes folder changes so name and cycle checks hold.
+	folderMu sync.Mutex
+	// contentMu serializes content changes so version numbers stay
+	// sequential.
+	contentMu sync.Mutex
+	// expiryBlocked holds, by file ID, why expiring a file was last
+	// refused, so each block is audited once rather than on every pass.
+	expiryMu      sync.Mutex
+	expiryBlocked map[string]string
+}
+
+// tenant returns the services for tenantID, creating them on first use. The
+// empty tenant ID is the default tenant.
+func (u *UploadService) tenant(tenantID string) (*tenantServices, *models.AppError) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	if tenant, ok := u.tenants[tenantID]; ok {
+		return tenant, nil
+	}
+
+	namespace, err := u.storage.Namespace(tenantID)
+	if err != nil {
+		u.logger.Error("Failed to open tenant storage", map[string]interface{}{
+			"tenant_id": tenantID,
+			"error":     err.Error(),
+		})
+		return nil, models.NewAppError(http.StatusForbidden, "Invalid tenant", err)
+	}
+
+	cfg := u.config.ForTenant(tenantID)
+	tenant := &tenantServices{
+		id:         tenantID,
+		storage:    namespace,
+		validation: NewValidationService(cfg),
+		quota:      NewQuotaService(cfg, namespace, u.logger),
+		retention:  cfg.Upload.Retention,
+		trash:      cfg.Upload.TrashRetention,
+
+		minRetention:   cfg.Compliance.MinRetention,
+		retentionRules: cfg.Compliance.RetentionRules,
+		pdfPolicy:      NewPDFPolicy(cfg.Upload.PDFPolicy, cfg.Upload.PDFFindingPolicies),
+		expiryBlocked:  make(map[string]string),
+	}
+	tenant.stripMetadata = make(map[string]bool)
+	for _, contentType := range cfg.Upload.StripMetadataTypes {
+		tenant.stripMetadata[tenant.validation.Canonical(contentType)] = true
+	}
+	tenant.search = NewSearchIndex(namespace, func(metadata models.FileMetadata) {
+		u.queueExtraction(tenant, metadata)
+	})
+	u.tenants[tenantID] = tenant
+
+	return tenant, nil
+}
+
+// allTenants returns the services for the default tenant and every tenant
+// namespace present in storage.
+func (u *UploadService) allTenants() ([]*tenantServices, error) {
+	names, err := u.storage.Namespaces()
+	if err != nil {
+		return nil, err
+	}
+
+	tenants := make([]*tenantServices, 0, len(names)+1)
+	for _, name := range append([]string{""}, names...) {
+		tenant, appError := u.tenant(name)
+		if appError != nil {
+			return nil, appError
+		}
+		tenants = append(tenants, tenant)
+	}
+	return tenants, nil
+}
+
+// PurgeExpired permanently removes files whose retention has lapsed and
+// trashed files past their purge time, across all tenants, and returns how
+// many were removed.
+func (u *UploadService) PurgeExpired() (int, error) {
+	tenants, err := u.allTenants()
+	if err != nil {
+		return 0, err
+	}
+
+	now := time.Now()
+	purged := 0
+	for _, tenant := range tenants {
+		n, err := u.purgeExpired(tenant, now)
+		purged += n
+		if err != nil {
+			return purged, err
+		}
+	}
+
+	return purged, nil
+}
+
+// purgeDue reports whether a file's retention or time in the trash has run
+// out at now.
+func purgeDue(metadata models.FileMetadata, now time.Time) (expired, trashed bool) {
+	expired = metadata.ExpiresAt != nil && !metadata.ExpiresAt.After(now)
+	trashed = metadata.PurgeAt != nil && !metadata.PurgeAt.After(now)
+	return expired, trashed
+}
+
+// purgeExpired does PurgeExpired's work for one tenant.
+func (u *UploadService) purgeExpired(tenant *tenantServices, now time.Time) (int, error) {
+	files, err := tenant.storage.List()
+	if err != nil {
+		return 0, err
+	}
+
+	tenant.expiryMu.Lock()
+	defer tenant.expiryMu.Unlock()
+
+	purged := 0
+	for _, metadata := range files {
+		if expired, trashed := purgeDue(metadata, now); !expired && !trashed {
+			delete(tenant.expiryBlocked, metadata.ID)
+			continue
+		}
+		removed, err := u.removeFile(tenant, metadata.ID, func(current models.FileMetadata) bool {
+			expired, trashed := purgeDue(current, now)
+			return expired || trashed
+		})
+		var locked *models.AppError
+		switch {
+		case errors.As(err, &locked):
+			// Purged on a later pass once retention lapses or the hold
+			// is released
+			u.expiryBlockedBy(tenant, metadata.ID, locked)
+			continue
+		case errors.Is(err, errFileChanged):
+			delete(tenant.expiryBlocked, metadata.ID)
+			continue
+		case err != nil:
+			u.logger.Error("Failed to purge expired file", map[string]interface{}{
+				"file_id":   metadata.ID,
+				"tenant_id": tenant.id,
+				"error":     err.Error(),
+			})
+			continue
+		}
+
+		delete(tenant.expiryBlocked, metadata.ID)
+		purged++
+		reason := "expired"
+		if _, trashed := purgeDue(removed, now); trashed {
+			reason = "trash"
+		}
+		u.recordAudit(models.AuditEvent{
+			Action:   models.AuditFilePurged,
+			FileID:   removed.ID,
+			TenantID: tenant.id,
+			Details: map[string]string{
+				"reason": reason,
+				"owner":  removed.UserID,
+			},
+		})
+	}
+
+	// Forget blocks on files removed some other way
+	for fileID := range tenant.expiryBlocked {
+		if !slices.ContainsFunc(files, func(metadata models.FileMetadata) bool {
+			return metadata.ID == fileID
+		}) {
+			delete(tenant.expiryBlocked, fileID)
+		}
+	}
+	return purged, nil
+}
+
+// expiryBlockedBy audits that appError kept a file from expiring. Each
+// block is audited once, and again only if its reason changes, however
+// many passes it lasts. The caller must hold the tenant's expiryMu.
+func (u *UploadService) expiryBlockedBy(tenant *tenantServices, fileID string, appError *models.AppError) {
+	if tenant.expiryBlocked[fileID] == appError.Message {
+		u.logger.Debug("Expired file kept under retention", map[string]interface{}{
+			"file_id":   fileID,
+			"tenant_id": tenant.id,
+			"reason":    appError.Message,
+		})
+		return
+	}
+	tenant.expiryBlocked[fileID] = appError.Message
+	u.recordAudit(models.AuditEvent{
+		Action:   models.AuditChangeBlocked,
+		FileID:   fileID,
+		TenantID: tenant.id,
+		Details: map[string]string{
+			"operation": "expire",
+			"reason":    appError.Message,
+		},
+	})
+}
+
+// StartExpiry purges expired files and trash every interval until the returned stop
+// function is called.
+func (u *UploadService) StartExpiry(interval time.Duration) (stop func()) {
+	done := make(chan struct{})
+	if interval <= 0 {
+		return func() {}
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if _, err := u.PurgeExpired(); err != nil {
+					u.logger.Error("Failed to purge expired files", map[string]interface{}{
+						"error": err.Error(),
+					})
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	return func() { close(done) }
+}
@@ -0,0 +1,295 @@
+package services
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ebinskryfon/fileuploader/models"
+	"github.com/ebinskryfon/fileuploader/utils"
+)
+
+const defaultThumbnailQueueSize = 1000
+
+// thumbnailJob asks for thumbnails of one version of a file's content.
+type thumbnailJob struct {
+	tenant   *tenantServices
+	metadata models.FileMetadata
+}
+
+// thumbnail is a generated thumbnail waiting to be stored.
+type thumbnail struct {
+	object models.DerivedObject
+	data   []byte
+}
+
+func thumbnailName(size int) string {
+	return "thumbnail-" + strconv.Itoa(size)
+}
+
+// thumbnailSizes returns the configured sizes, smallest first.
+func (u *UploadService) thumbnailSizes() []int {
+	var sizes []int
+	for _, size := range u.config.Images.ThumbnailSizes {
+		if size > 0 && !slices.Contains(sizes, size) {
+			sizes = append(sizes, size)
+		}
+	}
+	slices.Sort(sizes)
+	return sizes
+}
+
+// queueThumbnails schedules thumbnail generation for an image without
+// blocking. When the queue is full the job is dropped; thumbnails are then
+// generated when first requested.
+func (u *UploadService) queueThumbnails(tenant *tenantServices, metadata models.FileMetadata) {
+	if !CanProcessImage(tenant.validation.Canonical(metadata.ContentType)) || len(u.thumbnailSizes()) == 0 {
+		return
+	}
+	// Content still waiting for a scan is queued again once it is clean
+	if scanBlock(metadata.ScanStatus) != nil {
+		return
+	}
+
+	select {
+	case u.thumbnails <- thumbnailJob{tenant: tenant, metadata: metadata}:
+	default:
+		u.logger.Warn("Thumbnail queue full, skipping file", map[string]interface{}{
+			"file_id":   metadata.ID,
+			"tenant_id": tenant.id,
+		})
+	}
+}
+
+// StartThumbnails runs workers that generate image thumbnails until the
+// returned stop function is called.
+func (u *UploadService) StartThumbnails(workers int) (stop func()) {
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case job := <-u.thumbnails:
+					u.generateThumbnailJob(job)
+				case <-done:
+					return
+				}
+			}
+		}()
+	}
+
+	return func() {
+		close(done)
+		wg.Wait()
+	}
+}
+
+// generateThumbnailJob generates and stores the thumbnails of a job's file,
+// provided its content hasn't changed since the job was queued.
+func (u *UploadService) generateThumbnailJob(job thumbnailJob) {
+	metadata := job.metadata
+	file, _, err := job.tenant.storage.Retrieve(metadata.ID)
+	if err != nil {
+		// Most likely deleted since
+		return
+	}
+	data, err := io.ReadAll(file)
+	file.Close()
+	if err != nil || utils.CalculateChecksum(data) != metadata.Checksum {
+		// Replaced since; the new content has its own job
+		return
+	}
+
+	thumbnails, err := u.generateThumbnails(job.tenant, metadata, data)
+	if err != nil {
+		u.logger.Warn("Failed to generate thumbnails", map[string]interface{}{
+			"file_id":   metadata.ID,
+			"tenant_id": job.tenant.id,
+			"error":     err.Error(),
+		})
+		return
+	}
+	u.storeThumbnails(job.tenant, metadata, thumbnails)
+}
+
+// generateThumbnails scales an image's content to each configured size.
+// Images smaller than a size are re-encoded at their own size.
+func (u *UploadService) generateThumbnails(tenant *tenantServices, metadata models.FileMetadata, data []byte) ([]thumbnail, error) {
+	contentType := tenant.validation.Canonical(metadata.ContentType)
+	img, err := DecodeImage(contentType, data, u.config.Images.MaxPixels)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	bounds := img.Bounds()
+	var thumbnails []thumbnail
+	for _, size := range u.thumbnailSizes() {
+		width, height := fitWithin(bounds.Dx(), bounds.Dy(), size, size)
+		encoded, err := EncodeImage(resizeImage(img, width, height), contentType, defaultJPEGQuality)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode thumbnail: %w", err)
+		}
+		thumbnails = append(thumbnails, thumbnail{
+			object: models.DerivedObject{
+				Name:           thumbnailName(size),
+				Kind:           models.DerivedKindThumbnail,
+				ContentType:    contentType,
+				Size:           int64(len(encoded)),
+				Width:          width,
+				Height:         height,
+				SourceChecksum: metadata.Checksum,
+				CreatedAt:      now,
+			},
+			data: encoded,
+		})
+	}
+	return thumbnails, nil
+}
+
+// storeThumbnails saves thumbnails and links them to the file, unless its
+// content changed while they were being generated.
+func (u *UploadService) storeThumbnails(tenant *tenantServices, source models.FileMetadata, thumbnails []thumbnail) {
+	tenant.contentMu.Lock()
+	defer tenant.contentMu.Unlock()
+
+	metadata, err := tenant.storage.GetMetadata(source.ID)
+	if err != nil || metadata.Checksum != source.Checksum {
+		return
+	}
+
+	for _, t := range thumbnails {
+		if err = tenant.storage.StoreDerived(metadata.ID, t.object.Name, bytes.NewReader(t.data)); err != nil {
+			break
+		}
+		metadata.Derived = slices.DeleteFunc(metadata.Derived, func(d models.DerivedObject) bool {
+			return d.Name == t.object.Name
+		})
+		metadata.Derived = append(metadata.Derived, t.object)
+	}
+	if err == nil {
+		err = tenant.storage.UpdateMetadata(metadata.ID, metadata)
+	}
+	if err != nil {
+		u.logger.Error("Failed to store thumbnails", map[string]interface{}{
+			"file_id":   metadata.ID,
+			"tenant_id": tenant.id,
+			"error":     err.Error(),
+		})
+		return
+	}
+
+	u.logger.Debug("Thumbnails generated", map[string]interface{}{
+		"file_id":    metadata.ID,
+		"tenant_id":  tenant.id,
+		"thumbnails": len(thumbnails),
+	})
+}
+
+// deleteDerived removes a file's derived objects from storage.
+func (u *UploadService) deleteDerived(tenant *tenantServices, metadata models.FileMetadata) error {
+	for _, derived := range metadata.Derived {
+		if err := tenant.storage.DeleteDerived(metadata.ID, derived.Name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetThumbnail returns a thumbnail of an image no larger than size pixels
+// on its longest edge, where size is one of the configured thumbnail sizes
+// or zero for the smallest. Missing or stale thumbnails are generated on
+// the spot.
+func (u *UploadService) GetThumbnail(fileID string, size int, principal models.Principal) (io.ReadCloser, models.DerivedObject, *models.AppError) {
+	tenant, appError := u.tenant(principal.TenantID)
+	if appError != nil {
+		return nil, models.DerivedObject{}, appError
+	}
+
+	metadata, appError := u.authorize(tenant, fileID, principal, accessRead)
+	if appError != nil {
+		return nil, models.DerivedObject{}, appError
+	}
+
+	if appError := scanBlock(metadata.ScanStatus); appError != nil {
+		return nil, models.DerivedObject{}, appError
+	}
+
+	sizes := u.thumbnailSizes()
+	if !CanProcessImage(tenant.validation.Canonical(metadata.ContentType)) || len(sizes) == 0 {
+		return nil, models.DerivedObject{}, models.ErrNoThumbnail
+	}
+	if size == 0 {
+		size = sizes[0]
+	}
+	if !slices.Contains(sizes, size) {
+		allowed := make([]string, len(sizes))
+		for i, s := range sizes {
+			allowed[i] = strconv.Itoa(s)
+		}
+		return nil, models.DerivedObject{}, models.NewAppError(http.StatusBadRequest, "Thumbnail size must be one of "+strings.Join(allowed, ", "), nil)
+	}
+
+	name := thumbnailName(size)
+	index := slices.IndexFunc(metadata.Derived, func(d models.DerivedObject) bool {
+		return d.Name == name && d.SourceChecksum == metadata.Checksum
+	})
+	if index >= 0 {
+		file, err := tenant.storage.RetrieveDerived(fileID, name)
+		if err == nil {
+			return file, metadata.Derived[index], nil
+		}
+		u.logger.Warn("Stored thumbnail missing, regenerating", map[string]interface{}{
+			"file_id":   fileID,
+			"tenant_id": tenant.id,
+			"error":     err.Error(),
+		})
+	}
+
+	file, _, err := tenant.storage.Retrieve(fileID)
+	if err != nil {
+		return nil, models.DerivedObject{}, models.ErrInternalServer
+	}
+	data, err := io.ReadAll(file)
+	file.Close()
+	if err != nil {
+		return nil, models.DerivedObject{}, models.ErrInternalServer
+	}
+
+	thumbnails, err := u.generateThumbnails(tenant, metadata, data)
+	if err != nil {
+		u.logger.Warn("Failed to generate thumbnails", map[string]interface{}{
+			"file_id":   fileID,
+			"tenant_id": tenant.id,
+			"error":     err.Error(),
+		})
+		return nil, models.DerivedObject{}, imageError(err)
+	}
+	u.storeThumbnails(tenant, metadata, thumbnails)
+
+	for _, t := range thumbnails {
+		if t.object.Name == name {
+			return io.NopCloser(bytes.NewReader(t.data)), t.object, nil
+		}
+	}
+	return nil, models.DerivedObject{}, models.ErrInternalServer
+}
+
+// imageError describes why an image could not be processed.
+func imageError(err error) *models.AppError {
+	if errors.Is(err, errImageTooLarge) {
+		return models.ErrImageTooLarge
+	}
+	return models.NewAppError(http.StatusUnprocessableEntity, "Image cannot be decoded", err)
+}
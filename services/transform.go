@@ -0,0 +1,248 @@
+package services
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/ebinskryfon/fileuploader/models"
+)
+
+const defaultMaxImageDimension = 4096
+
+// transformFormats maps the format names a transform may ask for to content
+// types.
+var transformFormats = map[string]string{
+	"jpeg": "image/jpeg",
+	"jpg":  "image/jpeg",
+	"png":  "image/png",
+}
+
+// WithTransformCache keeps rendered image transforms in cache instead of
+// rendering them for every request.
+func (u *UploadService) WithTransformCache(cache *TransformCache) *UploadService {
+	u.transformCache = cache
+	return u
+}
+
+// TransformImage renders a JPEG or PNG file resized, cropped or converted as
+// transform asks. Rendered variants are cached by the file's checksum and
+// the transform.
+func (u *UploadService) TransformImage(fileID string, transform models.ImageTransform, principal models.Principal) (io.ReadCloser, models.DerivedObject, *models.AppError) {
+	tenant, appError := u.tenant(principal.TenantID)
+	if appError != nil {
+		return nil, models.DerivedObject{}, appError
+	}
+
+	metadata, appError := u.authorize(tenant, fileID, principal, accessRead)
+	if appError != nil {
+		return nil, models.DerivedObject{}, appError
+	}
+
+	if appError := scanBlock(metadata.ScanStatus); appError != nil {
+		return nil, models.DerivedObject{}, appError
+	}
+
+	sourceType := tenant.validation.Canonical(metadata.ContentType)
+	if !CanProcessImage(sourceType) {
+		return nil, models.DerivedObject{}, models.ErrNotAnImage
+	}
+	contentType, appError := u.checkTransform(transform, sourceType)
+	if appError != nil {
+		return nil, models.DerivedObject{}, appError
+	}
+
+	key := transformCacheKey(tenant.id, metadata.Checksum, transform, contentType)
+	derived := models.DerivedObject{
+		Name:           key,
+		Kind:           models.DerivedKindTransform,
+		ContentType:    contentType,
+		SourceChecksum: metadata.Checksum,
+	}
+
+	if u.transformCache != nil {
+		if data, ok := u.transformCache.Get(key); ok {
+			derived.Size = int64(len(data))
+			if config, err := imageCodecs[contentType].decodeConfig(data); err == nil {
+				derived.Width, derived.Height = config.Width, config.Height
+			}
+			return io.NopCloser(bytes.NewReader(data)), derived, nil
+		}
+	}
+
+	file, _, err := tenant.storage.Retrieve(fileID)
+	if err != nil {
+		return nil, models.DerivedObject{}, models.ErrInternalServer
+	}
+	source, err := io.ReadAll(file)
+	file.Close()
+	if err != nil {
+		return nil, models.DerivedObject{}, models.ErrInternalServer
+	}
+
+	img, err := DecodeImage(sourceType, source, u.config.Images.MaxPixels)
+	if err != nil {
+		u.logger.Warn("Failed to decode image for transform", map[string]interface{}{
+			"file_id":   fileID,
+			"tenant_id": tenant.id,
+			"error":     err.Error(),
+		})
+		return nil, models.DerivedObject{}, imageError(err)
+	}
+
+	rendered := renderTransform(img, transform, u.maxImageDimension())
+	if contentType == "image/jpeg" {
+		rendered = flattenImage(rendered)
+	}
+	data, err := EncodeImage(rendered, contentType, transform.Quality)
+	if err != nil {
+		return nil, models.DerivedObject{}, models.ErrInternalServer
+	}
+
+	if u.transformCache != nil {
+		if err := u.transformCache.Put(key, data); err != nil {
+			u.logger.Warn("Failed to cache image transform", map[string]interface{}{
+				"file_id":   fileID,
+				"tenant_id": tenant.id,
+				"error":     err.Error(),
+			})
+		}
+	}
+
+	derived.Size = int64(len(data))
+	derived.Width, derived.Height = rendered.Bounds().Dx(), rendered.Bounds().Dy()
+	return io.NopCloser(bytes.NewReader(data)), derived, nil
+}
+
+func (u *UploadService) maxImageDimension() int {
+	if u.config.Images.MaxDimension > 0 {
+		return u.config.Images.MaxDimension
+	}
+	return defaultMaxImageDimension
+}
+
+// checkTransform validates a transform and returns the content type it
+// renders to.
+func (u *UploadService) checkTransform(transform models.ImageTransform, sourceType string) (string, *models.AppError) {
+	maxDimension := u.maxImageDimension()
+	if transform.Width < 0 || transform.Height < 0 || transform.Width > maxDimension || transform.Height > maxDimension {
+		return "", models.NewAppError(http.StatusBadRequest, "Width and height must be between 1 and "+strconv.Itoa(maxDimension), nil)
+	}
+
+	switch transform.Fit {
+	case "", models.FitContain, models.FitCover, models.FitFill:
+	default:
+		return "", models.NewAppError(http.StatusBadRequest, "Fit must be contain, cover or fill", nil)
+	}
+
+	if transform.Quality < 0 || transform.Quality > 100 {
+		return "", models.NewAppError(http.StatusBadRequest, "Quality must be between 1 and 100", nil)
+	}
+
+	if transform.Format == "" {
+		return sourceType, nil
+	}
+	contentType, ok := transformFormats[transform.Format]
+	if !ok {
+		return "", models.NewAppError(http.StatusBadRequest, "Format must be jpeg or png", nil)
+	}
+	return contentType, nil
+}
+
+// transformCacheKey identifies a rendered variant. Tenants never share
+// variants, even of identical content.
+func transformCacheKey(tenantID, checksum string, transform models.ImageTransform, contentType string) string {
+	fit := transform.Fit
+	if fit == "" {
+		fit = models.FitContain
+	}
+	quality := transform.Quality
+	if contentType != "image/jpeg" {
+		quality = 0
+	} else if quality == 0 {
+		quality = defaultJPEGQuality
+	}
+
+	hash := sha256.Sum256([]byte(fmt.Sprintf("%s\x00%s\x00w=%d,h=%d,fit=%s,type=%s,q=%d",
+		tenantID, checksum, transform.Width, transform.Height, fit, contentType, quality)))
+	return hex.EncodeToString(hash[:])
+}
+
+// renderTransform resizes and crops img as transform asks, never producing
+// an image wider or taller than maxDimension.
+func renderTransform(img image.Image, transform models.ImageTransform, maxDimension int) image.Image {
+	bounds := img.Bounds()
+	srcWidth, srcHeight := bounds.Dx(), bounds.Dy()
+	width, height := transform.Width, transform.Height
+
+	switch {
+	case width == 0 && height == 0:
+		width, height = srcWidth, srcHeight
+	case height == 0:
+		height = max(1, srcHeight*width/srcWidth)
+	case width == 0:
+		width = max(1, srcWidth*height/srcHeight)
+	case transform.Fit == models.FitCover:
+		img = cropToAspect(img, width, height)
+	case transform.Fit == models.FitFill:
+	default:
+		// Contain: the side that reaches the box first decides the scale
+		if srcWidth*height > srcHeight*width {
+			height = max(1, srcHeight*width/srcWidth)
+		} else {
+			width = max(1, srcWidth*height/srcHeight)
+		}
+	}
+	width, height = fitWithin(width, height, maxDimension, maxDimension)
+
+	if width == srcWidth && height == srcHeight && img.Bounds() == bounds {
+		return img
+	}
+	return resizeImage(img, width, height)
+}
+
+// cropToAspect crops the centre of img to the aspect ratio of width by
+// height.
+func cropToAspect(img image.Image, width, height int) image.Image {
+	bounds := img.Bounds()
+	srcWidth, srcHeight := bounds.Dx(), bounds.Dy()
+
+	crop := bounds
+	if srcWidth*height > srcHeight*width {
+		cropWidth := max(1, srcHeight*width/height)
+		crop.Min.X += (srcWidth - cropWidth) / 2
+		crop.Max.X = crop.Min.X + cropWidth
+	} else {
+		cropHeight := max(1, srcWidth*height/width)
+		crop.Min.Y += (srcHeight - cropHeight) / 2
+		crop.Max.Y = crop.Min.Y + cropHeight
+	}
+
+	if sub, ok := img.(interface {
+		SubImage(r image.Rectangle) image.Image
+	}); ok {
+		return sub.SubImage(crop)
+	}
+	cropped := image.NewRGBA(image.Rect(0, 0, crop.Dx(), crop.Dy()))
+	draw.Draw(cropped, cropped.Bounds(), img, crop.Min, draw.Src)
+	return cropped
+}
+
+// flattenImage draws an image with transparency onto white, since JPEG has
+// no alpha channel.
+func flattenImage(img image.Image) image.Image {
+	if opaque, ok := img.(interface{ Opaque() bool }); ok && opaque.Opaque() {
+		return img
+	}
+	flat := image.NewRGBA(image.Rect(0, 0, img.Bounds().Dx(), img.Bounds().Dy()))
+	draw.Draw(flat, flat.Bounds(), image.NewUniform(color.White), image.Point{}, draw.Src)
+	draw.Draw(flat, flat.Bounds(), img, img.Bounds().Min, draw.Over)
+	return flat
+}
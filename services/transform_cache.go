@@ -0,0 +1,187 @@
+package services
+
+import (
+	"container/list"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+)
+
+// TransformCache keeps rendered image variants on disk, evicting the least
+// recently used once they take more than its size limit.
+type TransformCache struct {
+	dir      string
+	maxBytes int64
+
+	mu sync.Mutex
+	// order holds cache keys, most recently used first
+	order   *list.List
+	entries map[string]*list.Element
+	sizes   map[string]int64
+	size    int64
+}
+
+// NewTransformCache opens the cache in dir, picking up variants rendered by
+// earlier runs in the order they were last used.
+func NewTransformCache(dir string, maxBytes int64) (*TransformCache, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create image cache directory: %w", err)
+	}
+
+	c := &TransformCache{
+		dir:      dir,
+		maxBytes: maxBytes,
+		order:    list.New(),
+		entries:  make(map[string]*list.Element),
+		sizes:    make(map[string]int64),
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read image cache directory: %w", err)
+	}
+	type cached struct {
+		key     string
+		size    int64
+		modTime time.Time
+	}
+	var existing []cached
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil || !info.Mode().IsRegular() || !isTransformCacheKey(entry.Name()) {
+			continue
+		}
+		existing = append(existing, cached{key: entry.Name(), size: info.Size(), modTime: info.ModTime()})
+	}
+	slices.SortFunc(existing, func(a, b cached) int {
+		return b.modTime.Compare(a.modTime)
+	})
+	for _, entry := range existing {
+		c.entries[entry.key] = c.order.PushBack(entry.key)
+		c.sizes[entry.key] = entry.size
+		c.size += entry.size
+	}
+
+	c.mu.Lock()
+	c.evict()
+	c.mu.Unlock()
+
+	return c, nil
+}
+
+// Get returns the variant cached under key.
+func (c *TransformCache) Get(key string) ([]byte, bool) {
+	if !isTransformCacheKey(key) {
+		return nil, false
+	}
+
+	c.mu.Lock()
+	element, ok := c.entries[key]
+	if ok {
+		c.order.MoveToFront(element)
+	}
+	c.mu.Unlock()
+	if !ok {
+		return nil, false
+	}
+
+	path := filepath.Join(c.dir, key)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		// Evicted meanwhile, or removed from disk
+		c.mu.Lock()
+		if c.entries[key] == element {
+			c.remove(key)
+		}
+		c.mu.Unlock()
+		return nil, false
+	}
+	// Remember the use across restarts
+	now := time.Now()
+	os.Chtimes(path, now, now)
+	return data, true
+}
+
+// Put caches data under key. Variants larger than the whole cache are not
+// kept.
+func (c *TransformCache) Put(key string, data []byte) error {
+	if !isTransformCacheKey(key) {
+		return fmt.Errorf("invalid image cache key")
+	}
+	if int64(len(data)) > c.maxBytes {
+		return nil
+	}
+
+	// Write to a temporary file first so readers never see a partial variant
+	file, err := os.CreateTemp(c.dir, ".tmp-*")
+	if err != nil {
+		return fmt.Errorf("failed to create cached image: %w", err)
+	}
+	defer os.Remove(file.Name())
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return fmt.Errorf("failed to write cached image: %w", err)
+	}
+	if err := file.Close(); err != nil {
+		return fmt.Errorf("failed to write cached image: %w", err)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := os.Rename(file.Name(), filepath.Join(c.dir, key)); err != nil {
+		return fmt.Errorf("failed to store cached image: %w", err)
+	}
+	c.remove(key)
+	c.entries[key] = c.order.PushFront(key)
+	c.sizes[key] = int64(len(data))
+	c.size += int64(len(data))
+	c.evict()
+	return nil
+}
+
+// Size returns the bytes currently cached.
+func (c *TransformCache) Size() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.size
+}
+
+// evict deletes least recently used variants until the cache fits its
+// limit. The caller must hold mu.
+func (c *TransformCache) evict() {
+	for c.size > c.maxBytes && c.order.Len() > 0 {
+		key := c.order.Back().Value.(string)
+		os.Remove(filepath.Join(c.dir, key))
+		c.remove(key)
+	}
+}
+
+// remove forgets key. The caller must hold mu.
+func (c *TransformCache) remove(key string) {
+	element, ok := c.entries[key]
+	if !ok {
+		return
+	}
+	c.order.Remove(element)
+	c.size -= c.sizes[key]
+	delete(c.entries, key)
+	delete(c.sizes, key)
+}
+
+// isTransformCacheKey accepts the hex SHA-256 keys the cache is used with,
+// which are also safe file names.
+func isTransformCacheKey(key string) bool {
+	if len(key) != 64 {
+		return false
+	}
+	for _, r := range key {
+		if (r < '0' || r > '9') && (r < 'a' || r > 'f') {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,235 @@
+package services
+
+import (
+	"errors"
+	"io/fs"
+	"slices"
+	"time"
+
+	"github.com/ebinskryfon/fileuploader/models"
+)
+
+// deleteFile moves a file to its owner's trash, or removes it outright when
+// the tenant keeps no trash. A file found under retention or legal hold is
+// kept and the error is the *models.AppError saying why.
+func (u *UploadService) deleteFile(tenant *tenantServices, fileID string, principal models.Principal) error {
+	if tenant.trash <= 0 {
+		_, err := u.removeFile(tenant, fileID, nil)
+		return err
+	}
+
+	tenant.contentMu.Lock()
+	defer tenant.contentMu.Unlock()
+
+	// Re-read under the lock so a concurrent change isn't lost, or written
+	// back over the deletion
+	metadata, err := tenant.storage.GetMetadata(fileID)
+	if err != nil {
+		return err
+	}
+	if metadata.DeletedAt != nil {
+		return nil
+	}
+
+	now := time.Now().UTC()
+	if appError := fileLock(metadata, now); appError != nil {
+		return appError
+	}
+	purgeAt := now.Add(tenant.trash)
+	metadata.DeletedAt = &now
+	metadata.DeletedBy = principal.UserID
+	metadata.PurgeAt = &purgeAt
+	if err := tenant.storage.UpdateMetadata(metadata.ID, metadata); err != nil {
+		return err
+	}
+	tenant.search.Remove(metadata.ID)
+	return nil
+}
+
+// inTrash reports whether a file is in the trash.
+func inTrash(metadata models.FileMetadata) bool {
+	return metadata.DeletedAt != nil
+}
+
+// trashed loads a file from principal's trash. Only the owner can see a
+// file once it has been trashed.
+func (u *UploadService) trashed(tenant *tenantServices, fileID string, principal models.Principal) (models.FileMetadata, *models.AppError) {
+	if !tenant.storage.Exists(fileID) {
+		return models.FileMetadata{}, models.ErrFileNotFound
+	}
+
+	metadata, err := tenant.storage.GetMetadata(fileID)
+	if err != nil {
+		u.logger.Error("Failed to get file metadata", map[string]interface{}{
+			"file_id": fileID,
+			"user_id": principal.UserID,
+			"error":   err.Error(),
+		})
+		return models.FileMetadata{}, models.ErrFileNotFound
+	}
+	if metadata.DeletedAt == nil || metadata.UserID != principal.UserID {
+		return models.FileMetadata{}, models.ErrFileNotFound
+	}
+
+	return metadata, nil
+}
+
+// ListTrash returns the files in principal's trash, most recently deleted
+// first.
+func (u *UploadService) ListTrash(principal models.Principal) ([]models.FileMetadata, *models.AppError) {
+	tenant, appError := u.tenant(principal.TenantID)
+	if appError != nil {
+		return nil, appError
+	}
+
+	files, err := tenant.storage.List()
+	if err != nil {
+		u.logger.Error("Failed to list files", map[string]interface{}{
+			"user_id": principal.UserID,
+			"error":   err.Error(),
+		})
+		return nil, models.ErrInternalServer
+	}
+
+	result := make([]models.FileMetadata, 0)
+	for _, metadata := range files {
+		if metadata.DeletedAt != nil && metadata.UserID == principal.UserID {
+			result = append(result, metadata)
+		}
+	}
+
+	slices.SortFunc(result, func(a, b models.FileMetadata) int {
+		return b.DeletedAt.Compare(*a.DeletedAt)
+	})
+
+	return result, nil
+}
+
+// RestoreFromTrash puts a trashed file back. Files whose folder has since
+// been deleted are restored to the owner's root.
+func (u *UploadService) RestoreFromTrash(fileID string, principal models.Principal) (*models.FileMetadata, *models.AppError) {
+	tenant, appError := u.tenant(principal.TenantID)
+	if appError != nil {
+		return nil, appError
+	}
+
+	if _, appError := u.trashed(tenant, fileID, principal); appError != nil {
+		return nil, appError
+	}
+
+	tenant.contentMu.Lock()
+	defer tenant.contentMu.Unlock()
+
+	// Re-read under the lock so a concurrent change isn't lost
+	metadata, appError := u.trashed(tenant, fileID, principal)
+	if appError != nil {
+		return nil, appError
+	}
+
+	if metadata.FolderID != "" {
+		if _, err := tenant.storage.GetFolder(metadata.FolderID); errors.Is(err, fs.ErrNotExist) {
+			metadata.FolderID = ""
+		}
+	}
+	metadata.DeletedAt = nil
+	metadata.DeletedBy = ""
+	metadata.PurgeAt = nil
+
+	if appError := u.saveMetadata(tenant, metadata, principal); appError != nil {
+		return nil, appError
+	}
+
+	u.logger.Info("File restored from trash", map[string]interface{}{
+		"file_id":   fileID,
+		"user_id":   principal.UserID,
+		"folder_id": metadata.FolderID,
+	})
+
+	return &metadata, nil
+}
+
+// PurgeFromTrash permanently deletes a trashed file.
+func (u *UploadService) PurgeFromTrash(fileID string, principal models.Principal) *models.AppError {
+	tenant, appError := u.tenant(principal.TenantID)
+	if appError != nil {
+		return appError
+	}
+
+	metadata, appError := u.trashed(tenant, fileID, principal)
+	if appError != nil {
+		return appError
+	}
+
+	if appError := u.checkLock(tenant, metadata, principal, "purge"); appError != nil {
+		return appError
+	}
+
+	_, err := u.removeFile(tenant, fileID, inTrash)
+	var locked *models.AppError
+	switch {
+	case errors.As(err, &locked):
+		// Held since the check above
+		return locked
+	case errors.Is(err, errFileChanged):
+		// Restored since the check above
+		return models.ErrFileNotFound
+	case err != nil:
+		u.logger.Error("Failed to purge file", map[string]interface{}{
+			"file_id": fileID,
+			"user_id": principal.UserID,
+			"error":   err.Error(),
+		})
+		return models.ErrInternalServer
+	}
+
+	u.logger.Info("File purged from trash", map[string]interface{}{
+		"file_id": fileID,
+		"user_id": principal.UserID,
+	})
+
+	return nil
+}
+
+// EmptyTrash permanently deletes every file in principal's trash and returns
+// how many were removed. Files under legal hold stay in the trash.
+func (u *UploadService) EmptyTrash(principal models.Principal) (int, *models.AppError) {
+	files, appError := u.ListTrash(principal)
+	if appError != nil {
+		return 0, appError
+	}
+
+	tenant, appError := u.tenant(principal.TenantID)
+	if appError != nil {
+		return 0, appError
+	}
+
+	now := time.Now()
+	purged := 0
+	for _, metadata := range files {
+		if fileLock(metadata, now) != nil {
+			continue
+		}
+		_, err := u.removeFile(tenant, metadata.ID, inTrash)
+		var locked *models.AppError
+		if errors.As(err, &locked) || errors.Is(err, errFileChanged) {
+			// Held or restored since the listing
+			continue
+		}
+		if err != nil {
+			u.logger.Error("Failed to purge file", map[string]interface{}{
+				"file_id": metadata.ID,
+				"user_id": principal.UserID,
+				"error":   err.Error(),
+			})
+			return purged, models.ErrInternalServer
+		}
+		purged++
+	}
+
+	u.logger.Info("Trash emptied", map[string]interface{}{
+		"user_id": principal.UserID,
+		"purged":  purged,
+	})
+
+	return purged, nil
+}
@@ -2,8 +2,10 @@ package services
 
 import (
 	"bytes"
+	"errors"
 	"io"
 	"mime/multipart"
+	"sync"
 	"time"
 
 	"github.com/ebinskryfon/fileuploader/config"
@@ -12,23 +14,75 @@ import (
 	"github.com/ebinskryfon/fileuploader/utils"
 )
 
+// UploadService stores and serves files. Each tenant gets its own storage
+// namespace and its own validation, quota and retention settings.
 type UploadService struct {
-	storage    storage.StorageInterface
-	validation *ValidationService
-	logger     *utils.Logger
+	config  *config.Config
+	storage storage.StorageInterface
+	logger  *utils.Logger
+	audit   *AuditLog
+
+	// scanner checks uploads for malware, moving infected content to
+	// quarantine; see WithScanner.
+	scanner    Scanner
+	quarantine storage.StorageInterface
+	// transformCache keeps rendered image transforms; see
+	// WithTransformCache.
+	transformCache *TransformCache
+
+	mu      sync.Mutex
+	tenants map[string]*tenantServices
+
+	// extraction queues documents for text extraction; see StartExtraction.
+	extraction chan extractionJob
+	// scans queues large uploads for virus scanning; see StartScanning.
+	scans chan scanJob
+	// thumbnails queues images for thumbnail generation; see
+	// StartThumbnails.
+	thumbnails chan thumbnailJob
 }
 
 func NewUploadService(cfg *config.Config, storage storage.StorageInterface, logger *utils.Logger) *UploadService {
+	queueSize := cfg.Search.ExtractionQueueSize
+	if queueSize <= 0 {
+		queueSize = defaultExtractionQueueSize
+	}
+	scanQueueSize := cfg.Scan.QueueSize
+	if scanQueueSize <= 0 {
+		scanQueueSize = defaultScanQueueSize
+	}
+	thumbnailQueueSize := cfg.Images.ThumbnailQueueSize
+	if thumbnailQueueSize <= 0 {
+		thumbnailQueueSize = defaultThumbnailQueueSize
+	}
+
 	return &UploadService{
+		config:     cfg,
 		storage:    storage,
-		validation: NewValidationService(cfg),
 		logger:     logger,
+		tenants:    make(map[string]*tenantServices),
+		extraction: make(chan extractionJob, queueSize),
+		scans:      make(chan scanJob, scanQueueSize),
+		thumbnails: make(chan thumbnailJob, thumbnailQueueSize),
 	}
 }
 
-func (u *UploadService) UploadFile(fileHeader *multipart.FileHeader, userID string) (*models.UploadResponse, *models.AppError) {
+// WithAuditLog records retention and legal hold changes, and changes they
+// block, to audit.
+func (u *UploadService) WithAuditLog(audit *AuditLog) *UploadService {
+	u.audit = audit
+	return u
+}
+
+func (u *UploadService) UploadFile(fileHeader *multipart.FileHeader, principal models.Principal, options models.UploadOptions) (*models.UploadResponse, *models.AppError) {
+	userID := principal.UserID
+	tenant, appError := u.tenant(principal.TenantID)
+	if appError != nil {
+		return nil, appError
+	}
+
 	// Validate file
-	if err := u.validation.ValidateFile(fileHeader); err != nil {
+	if err := tenant.validation.ValidateFile(fileHeader); err != nil {
 		u.logger.Warn("File validation failed", map[string]interface{}{
 			"file_name": fileHeader.Filename,
 			"file_size": fileHeader.Size,
@@ -38,37 +92,47 @@ func (u *UploadService) UploadFile(fileHeader *multipart.FileHeader, userID stri
 		return nil, err
 	}
 
-	// Open file
-	file, err := fileHeader.Open()
-	if err != nil {
-		u.logger.Error("Failed to open uploaded file", map[string]interface{}{
+	fileName, appError := tenant.validation.ValidateFileName(utils.SanitizeFileName(fileHeader.Filename), fileHeader.Header.Get("Content-Type"))
+	if appError != nil {
+		u.logger.Warn("File name rejected", map[string]interface{}{
 			"file_name": fileHeader.Filename,
 			"user_id":   userID,
-			"error":     err.Error(),
+			"error":     appError.Message,
 		})
-		return nil, models.NewAppError(500, "Failed to process file", err)
+		return nil, appError
 	}
-	defer file.Close()
 
-	// Validate file content
-	if validationErr := u.validation.ValidateFileContent(file, fileHeader.Header.Get("Content-Type")); validationErr != nil {
-		u.logger.Warn("File content validation failed", map[string]interface{}{
-			"file_name": fileHeader.Filename,
-			"user_id":   userID,
-			"error":     validationErr.Message,
-		})
-		return nil, validationErr
+	if appError := validateUploadOptions(options); appError != nil {
+		return nil, appError
 	}
 
-	// Read file content for checksum calculation
-	fileContent, err := io.ReadAll(file)
-	if err != nil {
-		u.logger.Error("Failed to read file content", map[string]interface{}{
+	target, appError := u.resolveUploadTarget(tenant, principal, options)
+	if appError != nil {
+		return nil, appError
+	}
+
+	// Reserve quota before reading any content
+	reservation, quotaErr := tenant.quota.Reserve(userID, fileHeader.Size)
+	if quotaErr != nil {
+		u.logger.Warn("Upload rejected by quota", map[string]interface{}{
 			"file_name": fileHeader.Filename,
+			"file_size": fileHeader.Size,
 			"user_id":   userID,
-			"error":     err.Error(),
+			"error":     quotaErr.Message,
 		})
-		return nil, models.NewAppError(500, "Failed to process file", err)
+		return nil, quotaErr
+	}
+	defer reservation.Release()
+
+	fileContent, appError := u.readUpload(tenant, fileHeader, reservation, userID)
+	if appError != nil {
+		return nil, appError
+	}
+
+	// Strip image metadata before the checksum and scan see the content
+	fileContent, originalChecksum, appError := u.sanitizeContent(tenant, fileHeader.Header.Get("Content-Type"), fileContent)
+	if appError != nil {
+		return nil, appError
 	}
 
 	// Generate file ID and metadata
@@ -76,19 +140,51 @@ func (u *UploadService) UploadFile(fileHeader *multipart.FileHeader, userID stri
 	checksum := utils.CalculateChecksum(fileContent)
 
 	metadata := models.FileMetadata{
-		ID:           fileID,
-		OriginalName: utils.SanitizeFileName(fileHeader.Filename),
-		Size:         fileHeader.Size,
-		ContentType:  fileHeader.Header.Get("Content-Type"),
-		UploadTime:   time.Now().UTC(),
-		URL:          "/files/" + fileID,
-		Checksum:     checksum,
-		UserID:       userID,
+		ID:               fileID,
+		OriginalName:     fileName,
+		Size:             int64(len(fileContent)),
+		ContentType:      fileHeader.Header.Get("Content-Type"),
+		UploadTime:       time.Now().UTC(),
+		URL:              "/files/" + fileID,
+		Checksum:         checksum,
+		UserID:           userID,
+		OriginalChecksum: originalChecksum,
+		Description:      options.Description,
+		Tags:             options.Tags,
+		Metadata:         options.Metadata,
+		TenantID:         principal.TenantID,
+		FolderID:         target.folderID,
+		Version:          1,
+		UploadedBy:       userID,
+	}
+	if tenant.retention > 0 {
+		expiresAt := metadata.UploadTime.Add(tenant.retention)
+		metadata.ExpiresAt = &expiresAt
+	}
+	metadata.RetainUntil = tenant.retainUntil(metadata.ContentType, metadata.UploadTime)
+
+	findings, appError := u.inspectContent(tenant, metadata, fileContent)
+	if appError != nil {
+		return nil, appError
+	}
+	metadata.Findings = findings
+
+	scan, appError := u.scanContent(tenant, metadata, fileContent)
+	if appError != nil {
+		return nil, appError
+	}
+	scan.apply(&metadata)
+	metadata.Properties = u.extractProperties(tenant, metadata, fileContent)
+
+	// Folders along the path are only created for accepted uploads
+	metadata.FolderID, appError = u.uploadFolder(tenant, principal, target)
+	if appError != nil {
+		return nil, appError
 	}
 
 	// Store file
 	reader := bytes.NewReader(fileContent)
-	if err := u.storage.Store(fileID, reader, metadata); err != nil {
+	if err := tenant.storage.Store(fileID, reader, metadata); err != nil {
 		u.logger.Error("Failed to store file", map[string]interface{}{
 			"file_id":   fileID,
 			"file_name": fileHeader.Filename,
@@ -97,68 +193,219 @@ func (u *UploadService) UploadFile(fileHeader *multipart.FileHeader, userID stri
 		})
 		return nil, models.NewAppError(500, "Failed to store file", err)
 	}
+	reservation.Commit(metadata.Size)
+	tenant.contentMu.Lock()
+	u.recordManifest(tenant, &metadata, fileContent)
+	tenant.contentMu.Unlock()
+	tenant.search.Index(metadata)
+	if metadata.ScanStatus == models.ScanStatusPending {
+		u.queueScan(tenant, metadata)
+	}
+	u.queueThumbnails(tenant, metadata)
 
 	u.logger.Info("File uploaded successfully", map[string]interface{}{
 		"file_id":      fileID,
 		"file_name":    fileHeader.Filename,
-		"file_size":    fileHeader.Size,
+		"file_size":    metadata.Size,
 		"content_type": metadata.ContentType,
 		"user_id":      userID,
+		"tenant_id":    principal.TenantID,
 		"checksum":     checksum,
 	})
 
 	response := &models.UploadResponse{
-		ID:          fileID,
-		URL:         metadata.URL,
-		Size:        metadata.Size,
-		ContentType: metadata.ContentType,
-		UploadTime:  metadata.UploadTime,
-		Checksum:    checksum,
+		ID:               fileID,
+		URL:              metadata.URL,
+		Size:             metadata.Size,
+		ContentType:      metadata.ContentType,
+		UploadTime:       metadata.UploadTime,
+		Checksum:         checksum,
+		FolderID:         metadata.FolderID,
+		Version:          metadata.Version,
+		ScanStatus:       metadata.ScanStatus,
+		OriginalChecksum: originalChecksum,
+		Findings:         metadata.Findings,
 	}
 
 	return response, nil
 }
 
-func (u *UploadService) GetFile(fileID, userID string) (io.ReadCloser, models.FileMetadata, *models.AppError) {
-	if !u.storage.Exists(fileID) {
-		return nil, models.FileMetadata{}, models.ErrFileNotFound
+// readUpload validates an uploaded file's content and reads it, failing if
+// it turns out larger than the quota reservation allows.
+func (u *UploadService) readUpload(tenant *tenantServices, fileHeader *multipart.FileHeader, reservation *QuotaReservation, userID string) ([]byte, *models.AppError) {
+	// Open file
+	file, err := fileHeader.Open()
+	if err != nil {
+		u.logger.Error("Failed to open uploaded file", map[string]interface{}{
+			"file_name": fileHeader.Filename,
+			"user_id":   userID,
+			"error":     err.Error(),
+		})
+		return nil, models.NewAppError(500, "Failed to process file", err)
 	}
+	defer file.Close()
 
-	// Get metadata first to check ownership
-	metadata, err := u.storage.GetMetadata(fileID)
-	if err != nil {
-		u.logger.Error("Failed to get file metadata", map[string]interface{}{
-			"file_id": fileID,
-			"user_id": userID,
-			"error":   err.Error(),
+	// Validate file content
+	if validationErr := tenant.validation.ValidateFileContent(file, fileHeader.Header.Get("Content-Type")); validationErr != nil {
+		u.logger.Warn("File content validation failed", map[string]interface{}{
+			"file_name": fileHeader.Filename,
+			"user_id":   userID,
+			"error":     validationErr.Message,
 		})
-		return nil, models.FileMetadata{}, models.ErrFileNotFound
+		return nil, validationErr
 	}
 
-	// Check if user owns the file
-	if metadata.UserID != userID {
-		u.logger.Warn("Unauthorized file access attempt", map[string]interface{}{
-			"file_id":    fileID,
-			"user_id":    userID,
-			"file_owner": metadata.UserID,
+	// Read file content for checksum calculation
+	fileContent, err := io.ReadAll(reservation.Reader(file))
+	if errors.Is(err, errQuotaExceeded) {
+		u.logger.Warn("Upload exceeded quota while streaming", map[string]interface{}{
+			"file_name": fileHeader.Filename,
+			"user_id":   userID,
 		})
-		return nil, models.FileMetadata{}, models.ErrFileNotFound // Don't reveal file exists
+		return nil, models.ErrQuotaExceeded
+	}
+	if err != nil {
+		u.logger.Error("Failed to read file content", map[string]interface{}{
+			"file_name": fileHeader.Filename,
+			"user_id":   userID,
+			"error":     err.Error(),
+		})
+		return nil, models.NewAppError(500, "Failed to process file", err)
 	}
 
-	file, _, err2 := u.storage.Retrieve(fileID)
-	if err2 != nil {
+	return fileContent, nil
+}
+
+func (u *UploadService) GetFile(fileID string, principal models.Principal) (io.ReadCloser, models.FileMetadata, *models.AppError) {
+	tenant, appError := u.tenant(principal.TenantID)
+	if appError != nil {
+		return nil, models.FileMetadata{}, appError
+	}
+
+	metadata, appError := u.authorize(tenant, fileID, principal, accessRead)
+	if appError != nil {
+		return nil, models.FileMetadata{}, appError
+	}
+
+	if appError := scanBlock(metadata.ScanStatus); appError != nil {
+		return nil, models.FileMetadata{}, appError
+	}
+
+	file, _, err := tenant.storage.Retrieve(fileID)
+	if err != nil {
 		u.logger.Error("Failed to retrieve file", map[string]interface{}{
 			"file_id": fileID,
-			"user_id": userID,
-			"error":   err2.Error(),
+			"user_id": principal.UserID,
+			"error":   err.Error(),
 		})
 		return nil, models.FileMetadata{}, models.ErrInternalServer
 	}
 
 	u.logger.Info("File retrieved successfully", map[string]interface{}{
 		"file_id": fileID,
-		"user_id": userID,
+		"user_id": principal.UserID,
 	})
 
-	return file, metadata, nil
+	return file, visibleMetadata(metadata, principal), nil
+}
+
+// GetFileMetadata returns a file's metadata. Unlike GetFile it works while
+// the content is waiting for or has failed a virus scan.
+func (u *UploadService) GetFileMetadata(fileID string, principal models.Principal) (models.FileMetadata, *models.AppError) {
+	tenant, appError := u.tenant(principal.TenantID)
+	if appError != nil {
+		return models.FileMetadata{}, appError
+	}
+
+	metadata, appError := u.authorize(tenant, fileID, principal, accessRead)
+	if appError != nil {
+		return models.FileMetadata{}, appError
+	}
+
+	return visibleMetadata(metadata, principal), nil
+}
+
+func (u *UploadService) DeleteFile(fileID string, principal models.Principal) *models.AppError {
+	tenant, appError := u.tenant(principal.TenantID)
+	if appError != nil {
+		return appError
+	}
+
+	metadata, appError := u.authorize(tenant, fileID, principal, accessWrite)
+	if appError != nil {
+		return appError
+	}
+
+	if appError := u.checkLock(tenant, metadata, principal, "delete"); appError != nil {
+		return appError
+	}
+
+	if err := u.deleteFile(tenant, fileID, principal); err != nil {
+		// Locked since the check above
+		var appError *models.AppError
+		if errors.As(err, &appError) {
+			return appError
+		}
+		u.logger.Error("Failed to delete file", map[string]interface{}{
+			"file_id": fileID,
+			"user_id": principal.UserID,
+			"error":   err.Error(),
+		})
+		return models.ErrInternalServer
+	}
+
+	u.logger.Info("File deleted successfully", map[string]interface{}{
+		"file_id":    fileID,
+		"user_id":    principal.UserID,
+		"file_owner": metadata.UserID,
+	})
+
+	return nil
+}
+
+func (u *UploadService) GetUsage(principal models.Principal) (*models.UsageResponse, *models.AppError) {
+	tenant, appError := u.tenant(principal.TenantID)
+	if appError != nil {
+		return nil, appError
+	}
+	return tenant.quota.Usage(principal.UserID)
+}
+
+// removeFile deletes a file from storage and releases its quota usage. Every
+// path that permanently removes a file goes through here so usage stays
+// consistent with what is actually stored.
+//
+// The file is re-read under the content lock, so a hold placed or a change
+// made since the caller looked at it isn't missed. If due is set and no
+// longer holds for the file, it fails with errFileChanged; a file under
+// retention or legal hold is kept and the error is the *models.AppError
+// saying why.
+func (u *UploadService) removeFile(tenant *tenantServices, fileID string, due func(models.FileMetadata) bool) (models.FileMetadata, error) {
+	tenant.contentMu.Lock()
+	defer tenant.contentMu.Unlock()
+
+	metadata, err := tenant.storage.GetMetadata(fileID)
+	if err != nil {
+		return models.FileMetadata{}, err
+	}
+	if due != nil && !due(metadata) {
+		return metadata, errFileChanged
+	}
+	if appError := fileLock(metadata, time.Now()); appError != nil {
+		return metadata, appError
+	}
+	for _, version := range metadata.Versions {
+		if err := tenant.storage.DeleteVersion(metadata.ID, version.Version); err != nil {
+			return metadata, err
+		}
+	}
+	if err := u.deleteDerived(tenant, metadata); err != nil {
+		return metadata, err
+	}
+	if err := tenant.storage.Delete(metadata.ID); err != nil {
+		return metadata, err
+	}
+	tenant.quota.Remove(metadata.UserID, metadata.StoredSize())
+	tenant.search.Remove(metadata.ID)
+	return metadata, nil
 }
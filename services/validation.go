@@ -1,8 +1,9 @@
 package services
 
 import (
+	"io"
+	"mime"
 	"mime/multipart"
-	"net/http"
 	"strings"
 
 	"github.com/ebinskryfon/fileuploader/config"
@@ -12,17 +13,25 @@ import (
 type ValidationService struct {
 	maxFileSize  int64
 	allowedTypes map[string]bool
+	sniffer      *ContentSniffer
+	extensions   *ExtensionPolicy
+	archives     ArchiveLimits
 }
 
 func NewValidationService(cfg *config.Config) *ValidationService {
+	sniffer := NewContentSniffer(cfg.Upload.ContentTypeAliases)
+
 	allowedTypes := make(map[string]bool)
 	for _, t := range cfg.Upload.AllowedTypes {
-		allowedTypes[t] = true
+		allowedTypes[sniffer.Canonical(t)] = true
 	}
 
 	return &ValidationService{
 		maxFileSize:  cfg.Upload.MaxFileSize,
 		allowedTypes: allowedTypes,
+		sniffer:      sniffer,
+		extensions:   NewExtensionPolicy(cfg.Upload.ExtensionPolicy, cfg.Upload.TypeExtensions, cfg.Upload.BlockedExtensions),
+		archives:     NewArchiveLimits(cfg),
 	}
 }
 
@@ -39,69 +48,97 @@ func (v *ValidationService) ValidateFile(header *multipart.FileHeader) *models.A
 		contentType = v.detectContentTypeFromFilename(header.Filename)
 	}
 
-	if !v.allowedTypes[contentType] {
+	if !v.allowedTypes[v.sniffer.Canonical(contentType)] {
 		return models.ErrInvalidFileType
 	}
 
 	return nil
 }
 
+// Canonical strips parameters from a content type and resolves aliases.
+func (v *ValidationService) Canonical(contentType string) string {
+	return v.sniffer.Canonical(contentType)
+}
+
+// ValidateFileContent checks that a file's content is what its declared
+// content type says it is, leaving the file positioned at its start.
 func (v *ValidationService) ValidateFileContent(file multipart.File, contentType string) *models.AppError {
-	// Read first 512 bytes to detect actual content type
-	buffer := make([]byte, 512)
-	n, err := file.Read(buffer)
-	if err != nil && n == 0 {
-		return models.NewAppError(http.StatusBadRequest, "Cannot read file", err)
+	size, err := file.Seek(0, io.SeekEnd)
+	if err == nil {
+		_, err = file.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		return unreadableFile(err)
 	}
 
-	// Reset file position
-	file.Seek(0, 0)
+	sniffed, err := v.sniffer.Sniff(file, size)
+	if err != nil {
+		return unreadableFile(err)
+	}
 
-	// Detect content type
-	detectedType := http.DetectContentType(buffer[:n])
+	declared := v.sniffer.Canonical(contentType)
+	details := map[string]string{"declared": declared, "detected": sniffed.ContentType}
+	switch {
+	case declared == "":
+		// Nothing was declared, so the content alone decides
+		if !v.allowedTypes[sniffed.ContentType] {
+			return models.NewValidationError(models.ReasonTypeNotAllowed, "Invalid file type", details)
+		}
+	case sniffed.ContentType == contentTypeOctetStream && declared != contentTypeOctetStream:
+		return models.NewValidationError(models.ReasonUnrecognizedContent, "File content could not be identified", details)
+	case sniffed.ContentType == contentTypeZip && isZipContainerType(declared):
+		return models.NewValidationError(models.ReasonInvalidContainer, "File is an archive but not a valid "+declared+" document", details)
+	case sniffed.Charset != "" && strings.HasPrefix(declared, "text/"):
+		// Text formats are all plain text as far as the content can show
+		declaredCharset := ""
+		if _, params, err := mime.ParseMediaType(contentType); err == nil {
+			declaredCharset = params["charset"]
+		}
+		if !charsetCompatible(declaredCharset, sniffed.Charset) {
+			details["declared_charset"] = strings.ToLower(declaredCharset)
+			details["detected_charset"] = sniffed.Charset
+			return models.NewValidationError(models.ReasonCharsetMismatch, "File content does not match the declared charset", details)
+		}
+	case declared != sniffed.ContentType:
+		return models.NewValidationError(models.ReasonContentMismatch, "File content does not match its declared type", details)
+	}
 
-	// Check if detected type matches or is compatible with declared type
-	if !v.isCompatibleContentType(contentType, detectedType) {
-		return models.ErrInvalidFileType
+	// ZIP archives, Office documents included, must be safe to expand
+	if isZipFamily(sniffed.ContentType) {
+		if _, appError := InspectArchive(file, size, v.archives); appError != nil {
+			return appError
+		}
 	}
 
 	return nil
 }
 
-func (v *ValidationService) detectContentTypeFromFilename(filename string) string {
-	ext := strings.ToLower(filename)
-	switch {
-	case strings.HasSuffix(ext, ".jpg") || strings.HasSuffix(ext, ".jpeg"):
-		return "image/jpeg"
-	case strings.HasSuffix(ext, ".png"):
-		return "image/png"
-	case strings.HasSuffix(ext, ".pdf"):
-		return "application/pdf"
-	default:
-		return "application/octet-stream"
+// ValidateFileName applies the extension policy to a file named name with
+// the declared contentType, returning the name to store it under.
+func (v *ValidationService) ValidateFileName(name, contentType string) (string, *models.AppError) {
+	if contentType == "" {
+		contentType = v.detectContentTypeFromFilename(name)
 	}
+	return v.extensions.Check(name, v.sniffer.Canonical(contentType))
 }
 
-func (v *ValidationService) isCompatibleContentType(declared, detected string) bool {
-	// Exact match
-	if declared == detected {
-		return true
-	}
-
-	// Handle common variations
-	compatibilityMap := map[string][]string{
-		"image/jpeg": {"image/jpg"},
-		"image/jpg":  {"image/jpeg"},
-	}
+func unreadableFile(err error) *models.AppError {
+	appError := models.NewValidationError(models.ReasonUnreadable, "Cannot read file", nil)
+	appError.Err = err
+	return appError
+}
 
-	if compatible, exists := compatibilityMap[declared]; exists {
-		for _, c := range compatible {
-			if c == detected {
-				return true
-			}
+// isZipContainerType reports whether contentType is a format stored as a ZIP
+// archive, which a plain ZIP detection means is missing its manifest.
+func isZipContainerType(contentType string) bool {
+	for _, container := range ooxmlMainParts {
+		if container == contentType {
+			return true
 		}
 	}
+	return strings.HasPrefix(contentType, "application/vnd.oasis.opendocument.")
+}
 
-	
-	return false
+func (v *ValidationService) detectContentTypeFromFilename(filename string) string {
+	return v.extensions.TypeFor(filename)
 }
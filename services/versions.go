@@ -0,0 +1,448 @@
+package services
+
+import (
+	"bytes"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/ebinskryfon/fileuploader/models"
+	"github.com/ebinskryfon/fileuploader/utils"
+)
+
+// currentVersion describes a file's current content as a version. Files
+// stored before versioning existed are version 1, uploaded by their owner.
+func currentVersion(metadata models.FileMetadata) models.FileVersion {
+	version := models.FileVersion{
+		Version:          max(metadata.Version, 1),
+		Size:             metadata.Size,
+		ContentType:      metadata.ContentType,
+		Checksum:         metadata.Checksum,
+		UploadedBy:       metadata.UploadedBy,
+		UploadTime:       metadata.UploadTime,
+		ScanStatus:       metadata.ScanStatus,
+		OriginalChecksum: metadata.OriginalChecksum,
+		Properties:       metadata.Properties,
+		Findings:         metadata.Findings,
+	}
+	if version.UploadedBy == "" {
+		version.UploadedBy = metadata.UserID
+	}
+	return version
+}
+
+// ReplaceContent stores new content under an existing file ID, keeping the
+// previous content as an earlier version. The new bytes count against the
+// owner's quota, whoever uploads them.
+func (u *UploadService) ReplaceContent(fileID string, fileHeader *multipart.FileHeader, principal models.Principal) (*models.UploadResponse, *models.AppError) {
+	tenant, appError := u.tenant(principal.TenantID)
+	if appError != nil {
+		return nil, appError
+	}
+
+	metadata, appError := u.authorize(tenant, fileID, principal, accessWrite)
+	if appError != nil {
+		return nil, appError
+	}
+
+	if appError := u.checkLock(tenant, metadata, principal, "replace"); appError != nil {
+		return nil, appError
+	}
+
+	if err := tenant.validation.ValidateFile(fileHeader); err != nil {
+		u.logger.Warn("File validation failed", map[string]interface{}{
+			"file_id":   fileID,
+			"file_name": fileHeader.Filename,
+			"file_size": fileHeader.Size,
+			"user_id":   principal.UserID,
+			"error":     err.Message,
+		})
+		return nil, err
+	}
+
+	reservation, appError := tenant.quota.ReserveBytes(metadata.UserID, fileHeader.Size)
+	if appError != nil {
+		u.logger.Warn("New version rejected by quota", map[string]interface{}{
+			"file_id":    fileID,
+			"file_size":  fileHeader.Size,
+			"user_id":    principal.UserID,
+			"file_owner": metadata.UserID,
+			"error":      appError.Message,
+		})
+		return nil, appError
+	}
+	defer reservation.Release()
+
+	content, appError := u.readUpload(tenant, fileHeader, reservation, principal.UserID)
+	if appError != nil {
+		return nil, appError
+	}
+
+	return u.storeVersion(tenant, fileID, principal, reservation, content, fileHeader.Header.Get("Content-Type"), "")
+}
+
+// ListVersions returns every version of a file, newest first.
+func (u *UploadService) ListVersions(fileID string, principal models.Principal) ([]models.FileVersion, *models.AppError) {
+	tenant, appError := u.tenant(principal.TenantID)
+	if appError != nil {
+		return nil, appError
+	}
+
+	metadata, appError := u.authorize(tenant, fileID, principal, accessRead)
+	if appError != nil {
+		return nil, appError
+	}
+
+	versions := append(slices.Clone(metadata.Versions), currentVersion(metadata))
+	slices.Reverse(versions)
+	return versions, nil
+}
+
+// GetVersion returns the content of one version of a file along with the
+// file's metadata as it was at that version.
+func (u *UploadService) GetVersion(fileID string, version int, principal models.Principal) (io.ReadCloser, models.FileMetadata, *models.AppError) {
+	tenant, appError := u.tenant(principal.TenantID)
+	if appError != nil {
+		return nil, models.FileMetadata{}, appError
+	}
+
+	metadata, appError := u.authorize(tenant, fileID, principal, accessRead)
+	if appError != nil {
+		return nil, models.FileMetadata{}, appError
+	}
+
+	if version == currentVersion(metadata).Version {
+		return u.GetFile(fileID, principal)
+	}
+
+	index := slices.IndexFunc(metadata.Versions, func(v models.FileVersion) bool {
+		return v.Version == version
+	})
+	if index < 0 {
+		return nil, models.FileMetadata{}, models.ErrVersionNotFound
+	}
+	if appError := scanBlock(metadata.Versions[index].ScanStatus); appError != nil {
+		return nil, models.FileMetadata{}, appError
+	}
+
+	file, err := tenant.storage.RetrieveVersion(fileID, version)
+	if err != nil {
+		u.logger.Error("Failed to retrieve file version", map[string]interface{}{
+			"file_id": fileID,
+			"version": version,
+			"user_id": principal.UserID,
+			"error":   err.Error(),
+		})
+		return nil, models.FileMetadata{}, models.ErrInternalServer
+	}
+
+	return file, metadataAt(visibleMetadata(metadata, principal), metadata.Versions[index]), nil
+}
+
+// RestoreVersion makes an earlier version current again. The restored
+// content becomes a new version, so no history is lost.
+func (u *UploadService) RestoreVersion(fileID string, version int, principal models.Principal) (*models.UploadResponse, *models.AppError) {
+	tenant, appError := u.tenant(principal.TenantID)
+	if appError != nil {
+		return nil, appError
+	}
+
+	metadata, appError := u.authorize(tenant, fileID, principal, accessWrite)
+	if appError != nil {
+		return nil, appError
+	}
+
+	if appError := u.checkLock(tenant, metadata, principal, "replace"); appError != nil {
+		return nil, appError
+	}
+
+	index := slices.IndexFunc(metadata.Versions, func(v models.FileVersion) bool {
+		return v.Version == version
+	})
+	if index < 0 {
+		return nil, models.ErrVersionNotFound
+	}
+	restored := metadata.Versions[index]
+	if restored.ScanStatus == models.ScanStatusInfected {
+		return nil, models.ErrFileQuarantined
+	}
+
+	reservation, appError := tenant.quota.ReserveBytes(metadata.UserID, restored.Size)
+	if appError != nil {
+		return nil, appError
+	}
+	defer reservation.Release()
+
+	file, err := tenant.storage.RetrieveVersion(fileID, version)
+	if err != nil {
+		u.logger.Error("Failed to retrieve file version", map[string]interface{}{
+			"file_id": fileID,
+			"version": version,
+			"user_id": principal.UserID,
+			"error":   err.Error(),
+		})
+		return nil, models.ErrInternalServer
+	}
+	content, err := io.ReadAll(file)
+	file.Close()
+	if err != nil {
+		u.logger.Error("Failed to read file version", map[string]interface{}{
+			"file_id": fileID,
+			"version": version,
+			"user_id": principal.UserID,
+			"error":   err.Error(),
+		})
+		return nil, models.ErrInternalServer
+	}
+
+	return u.storeVersion(tenant, fileID, principal, reservation, content, restored.ContentType, restored.OriginalChecksum)
+}
+
+// PruneVersions deletes earlier versions beyond the newest keep, and those
+// uploaded longer than olderThan ago. A negative keep or zero olderThan
+// disables that criterion. The current version is never pruned.
+func (u *UploadService) PruneVersions(fileID string, principal models.Principal, keep int, olderThan time.Duration) ([]models.FileVersion, *models.AppError) {
+	if keep < 0 && olderThan <= 0 {
+		return nil, models.NewAppError(http.StatusBadRequest, "Specify keep and/or older_than", nil)
+	}
+
+	tenant, appError := u.tenant(principal.TenantID)
+	if appError != nil {
+		return nil, appError
+	}
+
+	if _, appError := u.authorize(tenant, fileID, principal, accessWrite); appError != nil {
+		return nil, appError
+	}
+
+	tenant.contentMu.Lock()
+	defer tenant.contentMu.Unlock()
+
+	// Re-read under the lock so concurrent changes aren't lost
+	metadata, err := tenant.storage.GetMetadata(fileID)
+	if err != nil {
+		return nil, models.ErrFileNotFound
+	}
+
+	if appError := u.checkLock(tenant, metadata, principal, "prune_versions"); appError != nil {
+		return nil, appError
+	}
+
+	cutoff := time.Now().Add(-olderThan)
+	var kept, pruned []models.FileVersion
+	for i, version := range metadata.Versions {
+		newerCount := len(metadata.Versions) - 1 - i
+		if (keep >= 0 && newerCount >= keep) || (olderThan > 0 && version.UploadTime.Before(cutoff)) {
+			pruned = append(pruned, version)
+			continue
+		}
+		kept = append(kept, version)
+	}
+
+	var freed int64
+	for i, version := range pruned {
+		if err := tenant.storage.DeleteVersion(fileID, version.Version); err != nil {
+			u.logger.Error("Failed to delete file version", map[string]interface{}{
+				"file_id": fileID,
+				"version": version.Version,
+				"user_id": principal.UserID,
+				"error":   err.Error(),
+			})
+			// Keep records of versions that are still on disk
+			kept = append(kept, pruned[i:]...)
+			pruned = pruned[:i]
+			slices.SortFunc(kept, func(a, b models.FileVersion) int {
+				return a.Version - b.Version
+			})
+			break
+		}
+		freed += version.Size
+	}
+
+	metadata.Versions = kept
+	if appError := u.saveMetadata(tenant, metadata, principal); appError != nil {
+		return nil, appError
+	}
+	tenant.quota.RemoveBytes(metadata.UserID, freed)
+
+	u.logger.Info("File versions pruned", map[string]interface{}{
+		"file_id":     fileID,
+		"user_id":     principal.UserID,
+		"pruned":      len(pruned),
+		"freed_bytes": freed,
+	})
+
+	versions := append(slices.Clone(kept), currentVersion(metadata))
+	slices.Reverse(versions)
+	return versions, nil
+}
+
+// storeVersion archives a file's current content and stores content as the
+// next version, committing the reservation on success. originalChecksum
+// carries over the checksum of restored content that was sanitized before.
+func (u *UploadService) storeVersion(tenant *tenantServices, fileID string, principal models.Principal, reservation *QuotaReservation, content []byte, contentType, originalChecksum string) (*models.UploadResponse, *models.AppError) {
+	content, stripped, appError := u.sanitizeContent(tenant, contentType, content)
+	if appError != nil {
+		return nil, appError
+	}
+	if stripped != "" {
+		originalChecksum = stripped
+	}
+
+	// Scan before taking the lock so a slow scan doesn't hold up the tenant
+	scanned, err := tenant.storage.GetMetadata(fileID)
+	if err != nil {
+		return nil, models.ErrFileNotFound
+	}
+	scanned.ContentType = contentType
+	scanned.Size = int64(len(content))
+	scanned.Checksum = utils.CalculateChecksum(content)
+	scanned.UploadedBy = principal.UserID
+	findings, appError := u.inspectContent(tenant, scanned, content)
+	if appError != nil {
+		return nil, appError
+	}
+	scan, appError := u.scanContent(tenant, scanned, content)
+	if appError != nil {
+		return nil, appError
+	}
+
+	tenant.contentMu.Lock()
+	defer tenant.contentMu.Unlock()
+
+	// Re-read under the lock so concurrent uploads get distinct versions
+	metadata, err := tenant.storage.GetMetadata(fileID)
+	if err != nil {
+		return nil, models.ErrFileNotFound
+	}
+
+	// A hold may have been placed since the caller checked
+	if appError := u.checkLock(tenant, metadata, principal, "replace"); appError != nil {
+		return nil, appError
+	}
+
+	// The name must suit the new content, which may be a different type
+	name, appError := tenant.validation.ValidateFileName(metadata.OriginalName, contentType)
+	if appError != nil {
+		return nil, appError
+	}
+
+	original := metadata
+	previous := currentVersion(metadata)
+	if err := tenant.storage.ArchiveVersion(fileID, previous.Version); err != nil {
+		u.logger.Error("Failed to archive file version", map[string]interface{}{
+			"file_id": fileID,
+			"version": previous.Version,
+			"user_id": principal.UserID,
+			"error":   err.Error(),
+		})
+		return nil, models.ErrInternalServer
+	}
+
+	metadata.Versions = append(metadata.Versions, previous)
+	metadata.Version = previous.Version + 1
+	metadata.Size = int64(len(content))
+	metadata.ContentType = contentType
+	metadata.OriginalName = name
+	metadata.Checksum = utils.CalculateChecksum(content)
+	metadata.OriginalChecksum = originalChecksum
+	metadata.UploadedBy = principal.UserID
+	metadata.UploadTime = time.Now().UTC()
+	scan.apply(&metadata)
+	metadata.Properties = u.extractProperties(tenant, metadata, content)
+	metadata.Findings = findings
+	// Derived objects describe the old content
+	metadata.Derived = nil
+	// New content is retained like a new upload, never for less time than
+	// the file already was
+	if retainUntil := tenant.retainUntil(contentType, metadata.UploadTime); retainUntil != nil &&
+		(metadata.RetainUntil == nil || retainUntil.After(*metadata.RetainUntil)) {
+		metadata.RetainUntil = retainUntil
+	}
+
+	if err := tenant.storage.Store(fileID, bytes.NewReader(content), metadata); err != nil {
+		u.logger.Error("Failed to store file version", map[string]interface{}{
+			"file_id": fileID,
+			"version": metadata.Version,
+			"user_id": principal.UserID,
+			"error":   err.Error(),
+		})
+		u.unarchiveVersion(tenant, original, previous.Version)
+		return nil, models.NewAppError(500, "Failed to store file", err)
+	}
+	reservation.Commit(metadata.Size)
+	tenant.search.Index(metadata)
+	if metadata.ScanStatus == models.ScanStatusPending {
+		u.queueScan(tenant, metadata)
+	}
+	if err := u.deleteDerived(tenant, original); err != nil {
+		u.logger.Error("Failed to delete derived objects", map[string]interface{}{
+			"file_id": fileID,
+			"error":   err.Error(),
+		})
+	}
+	u.recordManifest(tenant, &metadata, content)
+	u.queueThumbnails(tenant, metadata)
+
+	u.logger.Info("File version stored", map[string]interface{}{
+		"file_id":    fileID,
+		"version":    metadata.Version,
+		"file_size":  metadata.Size,
+		"user_id":    principal.UserID,
+		"file_owner": metadata.UserID,
+		"checksum":   metadata.Checksum,
+	})
+
+	return &models.UploadResponse{
+		ID:               fileID,
+		URL:              metadata.URL,
+		Size:             metadata.Size,
+		ContentType:      metadata.ContentType,
+		UploadTime:       metadata.UploadTime,
+		Checksum:         metadata.Checksum,
+		FolderID:         metadata.FolderID,
+		Version:          metadata.Version,
+		ScanStatus:       metadata.ScanStatus,
+		OriginalChecksum: metadata.OriginalChecksum,
+		Findings:         metadata.Findings,
+	}, nil
+}
+
+// unarchiveVersion puts archived content back as the current content after a
+// failed store.
+func (u *UploadService) unarchiveVersion(tenant *tenantServices, metadata models.FileMetadata, version int) {
+	file, err := tenant.storage.RetrieveVersion(metadata.ID, version)
+	if err == nil {
+		err = tenant.storage.Store(metadata.ID, file, metadata)
+		file.Close()
+	}
+	if err == nil {
+		err = tenant.storage.DeleteVersion(metadata.ID, version)
+	}
+	if err != nil {
+		u.logger.Error("Failed to restore file content after failed version store", map[string]interface{}{
+			"file_id": metadata.ID,
+			"version": version,
+			"error":   err.Error(),
+		})
+	}
+}
+
+// metadataAt returns metadata describing the file as of version.
+func metadataAt(metadata models.FileMetadata, version models.FileVersion) models.FileMetadata {
+	metadata.Version = version.Version
+	metadata.Size = version.Size
+	metadata.ContentType = version.ContentType
+	metadata.Checksum = version.Checksum
+	metadata.UploadedBy = version.UploadedBy
+	metadata.UploadTime = version.UploadTime
+	metadata.ScanStatus = version.ScanStatus
+	metadata.OriginalChecksum = version.OriginalChecksum
+	metadata.Properties = version.Properties
+	metadata.Findings = version.Findings
+	metadata.ScanSignature = ""
+	metadata.ScannedAt = nil
+	return metadata
+}
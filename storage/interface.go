@@ -11,4 +11,25 @@ type StorageInterface interface {
 	Delete(fileID string) error
 	Exists(fileID string) bool
 	GetMetadata(fileID string) (models.FileMetadata, error)
+	UpdateMetadata(fileID string, metadata models.FileMetadata) error
+	List() ([]models.FileMetadata, error)
+	// ArchiveVersion keeps a file's current content as the given version,
+	// ready for Store to write new content under the same ID.
+	ArchiveVersion(fileID string, version int) error
+	RetrieveVersion(fileID string, version int) (io.ReadCloser, error)
+	DeleteVersion(fileID string, version int) error
+	// StoreDerived saves an object generated from a file's content, such as
+	// a thumbnail, under a name unique to that file.
+	StoreDerived(fileID, name string, reader io.Reader) error
+	RetrieveDerived(fileID, name string) (io.ReadCloser, error)
+	DeleteDerived(fileID, name string) error
+	StoreFolder(folder models.Folder) error
+	GetFolder(folderID string) (models.Folder, error)
+	DeleteFolder(folderID string) error
+	ListFolders() ([]models.Folder, error)
+	// Namespace returns an isolated storage area for a tenant. The empty
+	// name is the default namespace.
+	Namespace(name string) (StorageInterface, error)
+	// Namespaces lists the tenant namespaces that currently hold data.
+	Namespaces() ([]string, error)
 }
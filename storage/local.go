@@ -6,11 +6,20 @@ import (
 	"io"
 	"os"
 	"path/filepath"
+	"regexp"
+	"strings"
 
 	"github.com/ebinskryfon/fileuploader/models"
 	"github.com/ebinskryfon/fileuploader/utils"
 )
 
+// tenantsDir is the subdirectory of the base path holding tenant namespaces.
+const tenantsDir = "tenants"
+
+var namespacePattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+var derivedNamePattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
 type LocalStorage struct {
 	basePath string
 }
@@ -21,36 +30,52 @@ func NewLocalStorage(basePath string) *LocalStorage {
 	}
 }
 
+// filePath returns the path of an object stored directly in the base path.
+// IDs that would reach into a subdirectory, such as a tenant namespace, are
+// rejected.
+func (ls *LocalStorage) filePath(id string) (string, bool) {
+	if id == "" || id == "." || id == ".." || strings.ContainsAny(id, `/\`) {
+		return "", false
+	}
+
+	path := filepath.Join(ls.basePath, id)
+	return path, utils.IsAllowedPath(ls.basePath, path)
+}
+
 func (ls *LocalStorage) Store(fileID string, reader io.Reader, metadata models.FileMetadata) error {
 	// Ensure the file path is safe
-	filePath := filepath.Join(ls.basePath, fileID)
-	if !utils.IsAllowedPath(ls.basePath, filePath) {
+	filePath, ok := ls.filePath(fileID)
+	if !ok {
 		return fmt.Errorf("invalid file path")
 	}
 
-	// Create file
-	file, err := os.Create(filePath)
+	// Write to a temporary file first so readers never find the file
+	// missing or partial, and archived versions keep the old content
+	file, err := os.CreateTemp(ls.basePath, fileID+".tmp*")
 	if err != nil {
 		return fmt.Errorf("failed to create file: %v", err)
 	}
-	defer file.Close()
+	defer os.Remove(file.Name())
 
-	// Copy data to file
-	_, err = io.Copy(file, reader)
-	if err != nil {
+	if _, err := io.Copy(file, reader); err != nil {
+		file.Close()
+		return fmt.Errorf("failed to write file: %v", err)
+	}
+	if err := file.Close(); err != nil {
 		return fmt.Errorf("failed to write file: %v", err)
 	}
+	if err := os.Rename(file.Name(), filePath); err != nil {
+		return fmt.Errorf("failed to store file: %v", err)
+	}
 
-	// Store metadata
-	metadataPath := filePath + ".meta"
-	metadataFile, err := os.Create(metadataPath)
+	// Store metadata atomically; readers outside the content lock may be
+	// loading it while a new version is written
+	data, err := json.Marshal(metadata)
 	if err != nil {
-		return fmt.Errorf("failed to create metadata file: %v", err)
+		return fmt.Errorf("failed to encode metadata: %v", err)
 	}
-	defer metadataFile.Close()
 
-	encoder := json.NewEncoder(metadataFile)
-	if err := encoder.Encode(metadata); err != nil {
+	if err := utils.WriteFileAtomic(filePath+".meta", append(data, '\n'), 0644); err != nil {
 		return fmt.Errorf("failed to write metadata: %v", err)
 	}
 
@@ -60,8 +85,8 @@ func (ls *LocalStorage) Store(fileID string, reader io.Reader, metadata models.F
 func (ls *LocalStorage) Retrieve(fileID string) (io.ReadCloser, models.FileMetadata, error) {
 	var metadata models.FileMetadata
 
-	filePath := filepath.Join(ls.basePath, fileID)
-	if !utils.IsAllowedPath(ls.basePath, filePath) {
+	filePath, ok := ls.filePath(fileID)
+	if !ok {
 		return nil, metadata, fmt.Errorf("invalid file path")
 	}
 
@@ -81,8 +106,8 @@ func (ls *LocalStorage) Retrieve(fileID string) (io.ReadCloser, models.FileMetad
 }
 
 func (ls *LocalStorage) Delete(fileID string) error {
-	filePath := filepath.Join(ls.basePath, fileID)
-	if !utils.IsAllowedPath(ls.basePath, filePath) {
+	filePath, ok := ls.filePath(fileID)
+	if !ok {
 		return fmt.Errorf("invalid file path")
 	}
 
@@ -101,8 +126,8 @@ func (ls *LocalStorage) Delete(fileID string) error {
 }
 
 func (ls *LocalStorage) Exists(fileID string) bool {
-	filePath := filepath.Join(ls.basePath, fileID)
-	if !utils.IsAllowedPath(ls.basePath, filePath) {
+	filePath, ok := ls.filePath(fileID)
+	if !ok {
 		return false
 	}
 
@@ -113,8 +138,8 @@ func (ls *LocalStorage) Exists(fileID string) bool {
 func (ls *LocalStorage) GetMetadata(fileID string) (models.FileMetadata, error) {
 	var metadata models.FileMetadata
 
-	filePath := filepath.Join(ls.basePath, fileID)
-	if !utils.IsAllowedPath(ls.basePath, filePath) {
+	filePath, ok := ls.filePath(fileID)
+	if !ok {
 		return metadata, fmt.Errorf("invalid file path")
 	}
 
@@ -132,3 +157,269 @@ func (ls *LocalStorage) GetMetadata(fileID string) (models.FileMetadata, error)
 
 	return metadata, nil
 }
+
+func (ls *LocalStorage) UpdateMetadata(fileID string, metadata models.FileMetadata) error {
+	filePath, ok := ls.filePath(fileID)
+	if !ok {
+		return fmt.Errorf("invalid file path")
+	}
+
+	if _, err := os.Stat(filePath); err != nil {
+		return fmt.Errorf("failed to stat file: %v", err)
+	}
+
+	data, err := json.Marshal(metadata)
+	if err != nil {
+		return fmt.Errorf("failed to encode metadata: %v", err)
+	}
+
+	if err := utils.WriteFileAtomic(filePath+".meta", append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("failed to write metadata: %v", err)
+	}
+
+	return nil
+}
+
+func (ls *LocalStorage) List() ([]models.FileMetadata, error) {
+	entries, err := os.ReadDir(ls.basePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read storage directory: %v", err)
+	}
+
+	var files []models.FileMetadata
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".meta") {
+			continue
+		}
+
+		metadata, err := ls.GetMetadata(strings.TrimSuffix(entry.Name(), ".meta"))
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, metadata)
+	}
+
+	return files, nil
+}
+
+func (ls *LocalStorage) ArchiveVersion(fileID string, version int) error {
+	filePath, ok := ls.filePath(fileID)
+	if !ok {
+		return fmt.Errorf("invalid file path")
+	}
+
+	// Link rather than move, so the file stays readable until Store
+	// replaces it
+	archived := versionPath(filePath, version)
+	if err := os.Remove(archived); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to archive version: %v", err)
+	}
+	if err := os.Link(filePath, archived); err != nil {
+		return fmt.Errorf("failed to archive version: %v", err)
+	}
+
+	return nil
+}
+
+func (ls *LocalStorage) RetrieveVersion(fileID string, version int) (io.ReadCloser, error) {
+	filePath, ok := ls.filePath(fileID)
+	if !ok {
+		return nil, fmt.Errorf("invalid file path")
+	}
+
+	file, err := os.Open(versionPath(filePath, version))
+	if err != nil {
+		return nil, fmt.Errorf("failed to open version: %v", err)
+	}
+
+	return file, nil
+}
+
+func (ls *LocalStorage) DeleteVersion(fileID string, version int) error {
+	filePath, ok := ls.filePath(fileID)
+	if !ok {
+		return fmt.Errorf("invalid file path")
+	}
+
+	if err := os.Remove(versionPath(filePath, version)); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to delete version: %v", err)
+	}
+
+	return nil
+}
+
+func versionPath(filePath string, version int) string {
+	return fmt.Sprintf("%s.v%d", filePath, version)
+}
+
+func (ls *LocalStorage) StoreDerived(fileID, name string, reader io.Reader) error {
+	path, ok := ls.derivedPath(fileID, name)
+	if !ok {
+		return fmt.Errorf("invalid file path")
+	}
+
+	// Write to a temporary file first so readers never see a partial object
+	file, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return fmt.Errorf("failed to create derived object: %v", err)
+	}
+	defer os.Remove(file.Name())
+
+	if _, err := io.Copy(file, reader); err != nil {
+		file.Close()
+		return fmt.Errorf("failed to write derived object: %v", err)
+	}
+	if err := file.Close(); err != nil {
+		return fmt.Errorf("failed to write derived object: %v", err)
+	}
+	if err := os.Rename(file.Name(), path); err != nil {
+		return fmt.Errorf("failed to store derived object: %v", err)
+	}
+
+	return nil
+}
+
+func (ls *LocalStorage) RetrieveDerived(fileID, name string) (io.ReadCloser, error) {
+	path, ok := ls.derivedPath(fileID, name)
+	if !ok {
+		return nil, fmt.Errorf("invalid file path")
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open derived object: %v", err)
+	}
+
+	return file, nil
+}
+
+func (ls *LocalStorage) DeleteDerived(fileID, name string) error {
+	path, ok := ls.derivedPath(fileID, name)
+	if !ok {
+		return fmt.Errorf("invalid file path")
+	}
+
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to delete derived object: %v", err)
+	}
+
+	return nil
+}
+
+func (ls *LocalStorage) derivedPath(fileID, name string) (string, bool) {
+	if !derivedNamePattern.MatchString(name) {
+		return "", false
+	}
+	filePath, ok := ls.filePath(fileID)
+	if !ok {
+		return "", false
+	}
+	return fmt.Sprintf("%s.d-%s", filePath, name), true
+}
+
+func (ls *LocalStorage) StoreFolder(folder models.Folder) error {
+	folderPath, ok := ls.filePath(folder.ID + ".folder")
+	if !ok {
+		return fmt.Errorf("invalid folder path")
+	}
+
+	data, err := json.Marshal(folder)
+	if err != nil {
+		return fmt.Errorf("failed to encode folder: %v", err)
+	}
+
+	if err := utils.WriteFileAtomic(folderPath, append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("failed to write folder: %v", err)
+	}
+
+	return nil
+}
+
+func (ls *LocalStorage) GetFolder(folderID string) (models.Folder, error) {
+	var folder models.Folder
+
+	folderPath, ok := ls.filePath(folderID + ".folder")
+	if !ok {
+		return folder, fmt.Errorf("invalid folder path")
+	}
+
+	data, err := os.ReadFile(folderPath)
+	if err != nil {
+		return folder, fmt.Errorf("failed to read folder: %w", err)
+	}
+
+	if err := json.Unmarshal(data, &folder); err != nil {
+		return folder, fmt.Errorf("failed to decode folder: %v", err)
+	}
+
+	return folder, nil
+}
+
+func (ls *LocalStorage) DeleteFolder(folderID string) error {
+	folderPath, ok := ls.filePath(folderID + ".folder")
+	if !ok {
+		return fmt.Errorf("invalid folder path")
+	}
+
+	if err := os.Remove(folderPath); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to delete folder: %v", err)
+	}
+
+	return nil
+}
+
+func (ls *LocalStorage) ListFolders() ([]models.Folder, error) {
+	entries, err := os.ReadDir(ls.basePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read storage directory: %v", err)
+	}
+
+	var folders []models.Folder
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".folder") {
+			continue
+		}
+
+		folder, err := ls.GetFolder(strings.TrimSuffix(entry.Name(), ".folder"))
+		if err != nil {
+			return nil, err
+		}
+		folders = append(folders, folder)
+	}
+
+	return folders, nil
+}
+
+func (ls *LocalStorage) Namespace(name string) (StorageInterface, error) {
+	if name == "" {
+		return ls, nil
+	}
+	if !namespacePattern.MatchString(name) {
+		return nil, fmt.Errorf("invalid namespace: %q", name)
+	}
+
+	path := filepath.Join(ls.basePath, tenantsDir, name)
+	if err := os.MkdirAll(path, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create namespace: %v", err)
+	}
+
+	return NewLocalStorage(path), nil
+}
+
+func (ls *LocalStorage) Namespaces() ([]string, error) {
+	entries, err := os.ReadDir(filepath.Join(ls.basePath, tenantsDir))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read namespaces: %v", err)
+	}
+
+	var names []string
+	for _, entry := range entries {
+		if entry.IsDir() && namespacePattern.MatchString(entry.Name()) {
+			names = append(names, entry.Name())
+		}
+	}
+	return names, nil
+}
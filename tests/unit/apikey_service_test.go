@@ -0,0 +1,70 @@
+package unit
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ebinskryfon/fileuploader/config"
+	"github.com/ebinskryfon/fileuploader/models"
+	"github.com/ebinskryfon/fileuploader/services"
+	"github.com/ebinskryfon/fileuploader/utils"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newAPIKeyConfig(t *testing.T) *config.Config {
+	cfg := &config.Config{}
+	cfg.Auth.APIKeyStorePath = filepath.Join(t.TempDir(), "apikeys.json")
+	return cfg
+}
+
+func TestAPIKeyService_CreateAndValidate(t *testing.T) {
+	cfg := newAPIKeyConfig(t)
+	apiKeys, err := services.NewAPIKeyService(cfg, utils.NewLogger())
+	require.NoError(t, err)
+
+	created, appErr := apiKeys.Create(models.CreateAPIKeyRequest{
+		Name:   "nightly-export",
+		UserID: "batch-user",
+		Scopes: []string{services.ScopeFilesRead},
+	}, "admin-user")
+	require.Nil(t, appErr)
+	assert.True(t, strings.HasPrefix(created.Key, "fu_"+created.Prefix+"_"))
+
+	key, err := apiKeys.Validate(created.Key)
+	require.NoError(t, err)
+	assert.Equal(t, "batch-user", key.UserID)
+	assert.Equal(t, []string{services.ScopeFilesRead}, key.Scopes)
+	assert.NotNil(t, key.LastUsedAt)
+
+	// A wrong secret with a valid prefix is rejected
+	_, err = apiKeys.Validate("fu_" + created.Prefix + "_not-the-secret")
+	assert.Error(t, err)
+
+	// Keys survive a restart, stored only as hashes
+	reloaded, err := services.NewAPIKeyService(cfg, utils.NewLogger())
+	require.NoError(t, err)
+	_, err = reloaded.Validate(created.Key)
+	require.NoError(t, err)
+
+	require.Nil(t, reloaded.Revoke(created.ID))
+	_, err = reloaded.Validate(created.Key)
+	assert.Error(t, err)
+}
+
+func TestAPIKeyService_RejectsInvalidRequests(t *testing.T) {
+	apiKeys, err := services.NewAPIKeyService(newAPIKeyConfig(t), utils.NewLogger())
+	require.NoError(t, err)
+
+	_, appErr := apiKeys.Create(models.CreateAPIKeyRequest{Name: "k", UserID: "u", Scopes: []string{"files:everything"}}, "admin")
+	assert.NotNil(t, appErr)
+
+	past := time.Now().Add(-time.Hour)
+	_, appErr = apiKeys.Create(models.CreateAPIKeyRequest{Name: "k", UserID: "u", Scopes: []string{services.ScopeFilesRead}, ExpiresAt: &past}, "admin")
+	assert.NotNil(t, appErr)
+
+	assert.NotNil(t, apiKeys.Revoke("missing"))
+}
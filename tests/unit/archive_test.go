@@ -0,0 +1,166 @@
+package unit
+
+import (
+	"archive/zip"
+	"bytes"
+	"io"
+	"io/fs"
+	"net/http"
+	"testing"
+
+	"github.com/ebinskryfon/fileuploader/config"
+	"github.com/ebinskryfon/fileuploader/models"
+	"github.com/ebinskryfon/fileuploader/services"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// buildZip archives name/content pairs, deflating each member.
+func buildZip(t *testing.T, members ...string) []byte {
+	var buf bytes.Buffer
+	archive := zip.NewWriter(&buf)
+	for i := 0; i+1 < len(members); i += 2 {
+		w, err := archive.Create(members[i])
+		require.NoError(t, err)
+		_, err = w.Write([]byte(members[i+1]))
+		require.NoError(t, err)
+	}
+	require.NoError(t, archive.Close())
+	return buf.Bytes()
+}
+
+func inspectArchive(data []byte, limits services.ArchiveLimits) ([]models.ArchiveEntry, *models.AppError) {
+	return services.InspectArchive(bytes.NewReader(data), int64(len(data)), limits)
+}
+
+func TestInspectArchive(t *testing.T) {
+	limits := services.NewArchiveLimits(&config.Config{})
+
+	entries, appErr := inspectArchive(buildZip(t, "docs/readme.txt", "hello", "data.csv", "a,b\n"), limits)
+	require.Nil(t, appErr)
+	require.Len(t, entries, 2)
+	assert.Equal(t, "docs/readme.txt", entries[0].Name)
+	assert.Equal(t, int64(5), entries[0].Size)
+
+	_, appErr = inspectArchive(buildZip(t, "a", "1", "b", "2", "c", "3"), services.ArchiveLimits{MaxEntries: 2, MaxUncompressedSize: 1 << 20, MaxCompressionRatio: 100})
+	require.NotNil(t, appErr)
+	assert.Equal(t, models.ReasonArchiveLimit, appErr.Reason)
+	assert.Equal(t, "entries", appErr.Details["limit"])
+
+	_, appErr = inspectArchive(buildZip(t, "a", "0123456789"), services.ArchiveLimits{MaxEntries: 10, MaxUncompressedSize: 8, MaxCompressionRatio: 100})
+	require.NotNil(t, appErr)
+	assert.Equal(t, "uncompressed_size", appErr.Details["limit"])
+
+	// Megabytes of zeros deflate to almost nothing
+	_, appErr = inspectArchive(buildZip(t, "zeros", string(make([]byte, 4<<20))), limits)
+	require.NotNil(t, appErr)
+	assert.Equal(t, models.ReasonArchiveLimit, appErr.Reason)
+	assert.Equal(t, "compression_ratio", appErr.Details["limit"])
+
+	// Small archives are exempt from the ratio
+	_, appErr = inspectArchive(buildZip(t, "zeros", string(make([]byte, 64<<10))), limits)
+	assert.Nil(t, appErr)
+
+	for _, name := range []string{"../evil.sh", "docs/../../evil.sh", "/etc/passwd", `..\evil.bat`, "C:/evil.bat"} {
+		_, appErr = inspectArchive(buildZip(t, name, "x"), limits)
+		require.NotNil(t, appErr, name)
+		assert.Equal(t, models.ReasonUnsafeArchiveEntry, appErr.Reason, name)
+	}
+
+	var buf bytes.Buffer
+	archive := zip.NewWriter(&buf)
+	header := &zip.FileHeader{Name: "link"}
+	header.SetMode(fs.ModeSymlink | 0o777)
+	w, err := archive.CreateHeader(header)
+	require.NoError(t, err)
+	w.Write([]byte("/etc/passwd"))
+	require.NoError(t, archive.Close())
+	_, appErr = inspectArchive(buf.Bytes(), limits)
+	require.NotNil(t, appErr)
+	assert.Equal(t, models.ReasonUnsafeArchiveEntry, appErr.Reason)
+	assert.Equal(t, "link", appErr.Details["entry"])
+
+	_, appErr = inspectArchive([]byte("PK\x03\x04 not really"), limits)
+	require.NotNil(t, appErr)
+	assert.Equal(t, models.ReasonInvalidContainer, appErr.Reason)
+}
+
+func TestValidationService_InspectsDOCXArchives(t *testing.T) {
+	validation := newValidationService(nil)
+
+	docx := textDOCX(t, "Hello")
+	reader, err := zip.NewReader(bytes.NewReader(docx), int64(len(docx)))
+	require.NoError(t, err)
+	var buf bytes.Buffer
+	archive := zip.NewWriter(&buf)
+	for _, member := range reader.File {
+		require.NoError(t, archive.Copy(member))
+	}
+	w, err := archive.Create("../../evil.sh")
+	require.NoError(t, err)
+	w.Write([]byte("rm -rf /"))
+	require.NoError(t, archive.Close())
+
+	file, err := uploadFileHeader(t, "report.docx", docxType, buf.Bytes()).Open()
+	require.NoError(t, err)
+	defer file.Close()
+	appErr := validation.ValidateFileContent(file, docxType)
+	require.NotNil(t, appErr)
+	assert.Equal(t, models.ReasonUnsafeArchiveEntry, appErr.Reason)
+}
+
+func TestUploadService_ArchiveEntries(t *testing.T) {
+	uploads := newUploadService(t, func(cfg *config.Config) {
+		cfg.Upload.AllowedTypes = []string{"application/zip", "text/plain"}
+	})
+	alice := models.Principal{UserID: "alice"}
+	bob := models.Principal{UserID: "bob"}
+
+	uploaded, appErr := uploads.UploadFile(uploadFileHeader(t, "bundle.zip", "application/zip",
+		buildZip(t, "docs/readme.txt", "hello zip", "data.csv", "a,b\n")), alice, models.UploadOptions{})
+	require.Nil(t, appErr)
+
+	metadata, appErr := uploads.GetFileMetadata(uploaded.ID, alice)
+	require.Nil(t, appErr)
+	require.Len(t, metadata.Derived, 1)
+	assert.Equal(t, models.DerivedKindManifest, metadata.Derived[0].Kind)
+
+	entries, appErr := uploads.ListArchiveEntries(uploaded.ID, alice)
+	require.Nil(t, appErr)
+	require.Len(t, entries, 2)
+	assert.Equal(t, "data.csv", entries[1].Name)
+
+	reader, entry, appErr := uploads.GetArchiveEntry(uploaded.ID, "docs/readme.txt", alice)
+	require.Nil(t, appErr)
+	content, err := io.ReadAll(reader)
+	require.NoError(t, err)
+	require.NoError(t, reader.Close())
+	assert.Equal(t, "hello zip", string(content))
+	assert.Equal(t, int64(9), entry.Size)
+
+	_, _, appErr = uploads.GetArchiveEntry(uploaded.ID, "docs/missing.txt", alice)
+	assert.Equal(t, models.ErrEntryNotFound, appErr)
+	_, _, appErr = uploads.GetArchiveEntry(uploaded.ID, "docs/readme.txt", bob)
+	assert.Equal(t, models.ErrFileNotFound, appErr)
+
+	// New content gets a fresh manifest
+	_, appErr = uploads.ReplaceContent(uploaded.ID, uploadFileHeader(t, "bundle.zip", "application/zip", buildZip(t, "v2.txt", "two")), alice)
+	require.Nil(t, appErr)
+	entries, appErr = uploads.ListArchiveEntries(uploaded.ID, alice)
+	require.Nil(t, appErr)
+	require.Len(t, entries, 1)
+	assert.Equal(t, "v2.txt", entries[0].Name)
+
+	text, appErr := uploads.UploadFile(uploadFileHeader(t, "notes.txt", "text/plain", []byte("hello\n")), alice, models.UploadOptions{})
+	require.Nil(t, appErr)
+	_, appErr = uploads.ListArchiveEntries(text.ID, alice)
+	require.NotNil(t, appErr)
+	assert.Equal(t, http.StatusBadRequest, appErr.Code)
+	assert.Equal(t, models.ErrNotAnArchive, appErr)
+
+	_, appErr = uploads.UploadFile(uploadFileHeader(t, "bomb.zip", "application/zip",
+		buildZip(t, "zeros", string(make([]byte, 4<<20)))), alice, models.UploadOptions{})
+	require.NotNil(t, appErr)
+	assert.Equal(t, models.ReasonArchiveLimit, appErr.Reason)
+}
@@ -1,12 +1,14 @@
 package unit
 
 import (
+	"path/filepath"
 	"testing"
 	"time"
 
-	"fileuploader/internal/config"
-	"fileuploader/internal/services"
+	"github.com/ebinskryfon/fileuploader/config"
+	"github.com/ebinskryfon/fileuploader/services"
 
+	"github.com/golang-jwt/jwt/v5"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -216,3 +218,159 @@ func TestAuthService_DifferentSecrets(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Equal(t, userID, claims.UserID)
 }
+
+func TestAuthService_IssuerAndAudience(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.Auth.JWTSecret = "test-secret-key"
+	cfg.Auth.TokenExpiration = 1 * time.Hour
+	cfg.Auth.Issuers = []string{"fileuploader-service", "legacy-issuer"}
+	cfg.Auth.Audience = "fileuploader"
+	authService := services.NewAuthService(cfg)
+
+	token, err := authService.GenerateToken("user")
+	require.NoError(t, err)
+	claims, err := authService.ValidateToken(token)
+	require.NoError(t, err)
+	assert.Equal(t, "fileuploader-service", claims.Issuer)
+
+	otherCfg := &config.Config{}
+	otherCfg.Auth.JWTSecret = "test-secret-key"
+	otherCfg.Auth.TokenExpiration = 1 * time.Hour
+	otherCfg.Auth.Issuers = []string{"someone-else"}
+	otherCfg.Auth.Audience = "another-service"
+	foreignToken, err := services.NewAuthService(otherCfg).GenerateToken("user")
+	require.NoError(t, err)
+
+	_, err = authService.ValidateToken(foreignToken)
+	assert.Error(t, err)
+
+	// Same issuer, wrong audience
+	otherCfg.Auth.Issuers = []string{"legacy-issuer"}
+	wrongAudience, err := services.NewAuthService(otherCfg).GenerateToken("user")
+	require.NoError(t, err)
+	_, err = authService.ValidateToken(wrongAudience)
+	assert.Error(t, err)
+}
+
+func TestAuthService_Leeway(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.Auth.JWTSecret = "test-secret-key"
+	cfg.Auth.TokenExpiration = -10 * time.Second
+	token, err := services.NewAuthService(cfg).GenerateToken("user")
+	require.NoError(t, err)
+
+	cfg.Auth.Leeway = time.Minute
+	_, err = services.NewAuthService(cfg).ValidateToken(token)
+	assert.NoError(t, err)
+
+	cfg.Auth.Leeway = 0
+	_, err = services.NewAuthService(cfg).ValidateToken(token)
+	assert.Error(t, err)
+}
+
+func TestAuthService_Scopes(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.Auth.JWTSecret = "test-secret-key"
+	cfg.Auth.TokenExpiration = 1 * time.Hour
+	cfg.Auth.DefaultScopes = []string{services.ScopeFilesRead}
+	authService := services.NewAuthService(cfg)
+
+	token, err := authService.GenerateToken("reader", services.ScopeFilesRead)
+	require.NoError(t, err)
+	claims, err := authService.ValidateToken(token)
+	require.NoError(t, err)
+
+	scopes := authService.Scopes(claims)
+	assert.True(t, services.HasScope(scopes, services.ScopeFilesRead))
+	assert.False(t, services.HasScope(scopes, services.ScopeFilesWrite))
+
+	// Array-form permissions are honoured too
+	assert.Equal(t, []string{"files:write"}, authService.Scopes(&services.Claims{Permissions: []string{"files:write"}}))
+
+	// Tokens without any scope claim fall back to the defaults
+	assert.Equal(t, []string{services.ScopeFilesRead}, authService.Scopes(&services.Claims{}))
+
+	// Admin satisfies every scope
+	assert.True(t, services.HasScope([]string{services.ScopeAdmin}, services.ScopeFilesDelete))
+}
+
+func TestAuthService_Revocation(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.Auth.JWTSecret = "test-secret-key"
+	cfg.Auth.TokenExpiration = 1 * time.Hour
+	storePath := filepath.Join(t.TempDir(), "revocations.json")
+
+	store, err := services.NewFileRevocationStore(storePath)
+	require.NoError(t, err)
+	authService := services.NewAuthService(cfg).WithRevocations(store)
+
+	first, err := authService.GenerateToken("alice")
+	require.NoError(t, err)
+	second, err := authService.GenerateToken("alice")
+	require.NoError(t, err)
+
+	claims, err := authService.ValidateToken(first)
+	require.NoError(t, err)
+	require.NotEmpty(t, claims.ID)
+
+	// Revoking one token leaves the other valid
+	require.NoError(t, authService.RevokeToken(claims.ID, claims.ExpiresAt.Time))
+	_, err = authService.ValidateToken(first)
+	assert.Error(t, err)
+	_, err = authService.ValidateToken(second)
+	assert.NoError(t, err)
+
+	// Revocations persist across restarts
+	reloaded, err := services.NewFileRevocationStore(storePath)
+	require.NoError(t, err)
+	restarted := services.NewAuthService(cfg).WithRevocations(reloaded)
+	_, err = restarted.ValidateToken(first)
+	assert.Error(t, err)
+
+	// A user-wide cutoff revokes every earlier token
+	require.NoError(t, restarted.RevokeUserTokens("", "alice", time.Now().Add(time.Second)))
+	_, err = restarted.ValidateToken(second)
+	assert.Error(t, err)
+}
+
+func TestAuthService_RevocationByTenant(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.Auth.JWTSecret = "test-secret-key"
+	cfg.Auth.TokenExpiration = 1 * time.Hour
+	storePath := filepath.Join(t.TempDir(), "revocations.json")
+
+	store, err := services.NewFileRevocationStore(storePath)
+	require.NoError(t, err)
+	authService := services.NewAuthService(cfg).WithRevocations(store)
+
+	tenantToken := func(tenantID string) string {
+		claims := &services.Claims{
+			UserID:   "alice",
+			TenantID: tenantID,
+			RegisteredClaims: jwt.RegisteredClaims{
+				ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+				IssuedAt:  jwt.NewNumericDate(time.Now()),
+			},
+		}
+		token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(cfg.Auth.JWTSecret))
+		require.NoError(t, err)
+		return token
+	}
+	acme, globex, unscoped := tenantToken("acme"), tenantToken("globex"), tenantToken("")
+
+	// Cutting off alice in one tenant leaves the alices of other tenants
+	require.NoError(t, authService.RevokeUserTokens("acme", "alice", time.Now().Add(time.Second)))
+	_, err = authService.ValidateToken(acme)
+	assert.Error(t, err)
+	_, err = authService.ValidateToken(globex)
+	assert.NoError(t, err)
+	_, err = authService.ValidateToken(unscoped)
+	assert.NoError(t, err)
+
+	// Including across restarts
+	reloaded, err := services.NewFileRevocationStore(storePath)
+	require.NoError(t, err)
+	assert.True(t, reloaded.IsRevoked("", "acme", "alice", time.Now()))
+	assert.False(t, reloaded.IsRevoked("", "globex", "alice", time.Now()))
+	assert.False(t, reloaded.IsRevoked("", "", "alice", time.Now()))
+}
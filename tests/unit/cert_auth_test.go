@@ -0,0 +1,68 @@
+package unit
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net/url"
+	"testing"
+
+	"github.com/ebinskryfon/fileuploader/config"
+	"github.com/ebinskryfon/fileuploader/services"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCertAuthService_Identify(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.Auth.CertRules = []config.CertRule{
+		{Match: "uri:spiffe://mesh/ns/batch/*", UserID: "{value}", Scopes: []string{"files:read", "files:write"}},
+		{Match: "cn:reporting-*", UserID: "svc-{value}", Scopes: []string{"files:read"}},
+		{Match: "dns:*.internal", UserID: "internal", Scopes: []string{"admin"}},
+	}
+	certAuth := services.NewCertAuthService(cfg)
+
+	spiffe, _ := url.Parse("spiffe://mesh/ns/batch/exporter")
+	tests := []struct {
+		name       string
+		cert       *x509.Certificate
+		wantOK     bool
+		wantUserID string
+		wantScopes []string
+	}{
+		{
+			name:       "URI SAN",
+			cert:       &x509.Certificate{URIs: []*url.URL{spiffe}},
+			wantOK:     true,
+			wantUserID: "spiffe://mesh/ns/batch/exporter",
+			wantScopes: []string{"files:read", "files:write"},
+		},
+		{
+			name:       "common name",
+			cert:       &x509.Certificate{Subject: pkix.Name{CommonName: "reporting-eu"}},
+			wantOK:     true,
+			wantUserID: "svc-reporting-eu",
+			wantScopes: []string{"files:read"},
+		},
+		{
+			name:       "DNS SAN",
+			cert:       &x509.Certificate{DNSNames: []string{"other.example", "api.internal"}},
+			wantOK:     true,
+			wantUserID: "internal",
+			wantScopes: []string{"admin"},
+		},
+		{
+			name:   "no matching rule",
+			cert:   &x509.Certificate{Subject: pkix.Name{CommonName: "unknown"}},
+			wantOK: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			userID, scopes, ok := certAuth.Identify(tt.cert)
+			assert.Equal(t, tt.wantOK, ok)
+			assert.Equal(t, tt.wantUserID, userID)
+			assert.Equal(t, tt.wantScopes, scopes)
+		})
+	}
+}
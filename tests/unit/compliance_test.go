@@ -0,0 +1,184 @@
+package unit
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ebinskryfon/fileuploader/config"
+	"github.com/ebinskryfon/fileuploader/models"
+	"github.com/ebinskryfon/fileuploader/services"
+	"github.com/ebinskryfon/fileuploader/utils"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// withAuditLog sends the audit events of uploads to a fresh log file and
+// returns its path.
+func withAuditLog(t *testing.T, uploads *services.UploadService) string {
+	auditPath := filepath.Join(t.TempDir(), "audit.log")
+	uploads.WithAuditLog(services.NewAuditLog(auditPath, utils.NewLogger()))
+	return auditPath
+}
+
+func readAuditLog(t *testing.T, path string) []models.AuditEvent {
+	file, err := os.Open(path)
+	require.NoError(t, err)
+	defer file.Close()
+
+	var events []models.AuditEvent
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var event models.AuditEvent
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &event))
+		events = append(events, event)
+	}
+	return events
+}
+
+func TestUploadService_RetentionRules(t *testing.T) {
+	uploads := newUploadService(t, func(cfg *config.Config) {
+		cfg.Compliance.RetentionRules = []config.RetentionRule{
+			{ContentType: "application/*", Duration: time.Hour},
+			{ContentType: "application/pdf", Duration: 24 * time.Hour},
+		}
+	})
+	auditPath := withAuditLog(t, uploads)
+	alice := models.Principal{UserID: "alice"}
+
+	uploaded, appErr := uploads.UploadFile(pdfFileHeader(t), alice, models.UploadOptions{})
+	require.Nil(t, appErr)
+
+	// The longest matching rule wins
+	files, appErr := uploads.ListFiles(alice)
+	require.Nil(t, appErr)
+	require.Len(t, files, 1)
+	require.NotNil(t, files[0].RetainUntil)
+	assert.WithinDuration(t, time.Now().Add(24*time.Hour), *files[0].RetainUntil, time.Minute)
+
+	appErr = uploads.DeleteFile(uploaded.ID, alice)
+	require.NotNil(t, appErr)
+	assert.Equal(t, http.StatusLocked, appErr.Code)
+	assert.Contains(t, appErr.Message, "retention")
+
+	_, appErr = uploads.ReplaceContent(uploaded.ID, pdfFileHeader(t), alice)
+	require.NotNil(t, appErr)
+	assert.Equal(t, http.StatusLocked, appErr.Code)
+
+	// Retention can be extended but not shortened
+	_, appErr = uploads.SetRetention(uploaded.ID, time.Now().Add(time.Hour), alice)
+	require.NotNil(t, appErr)
+	assert.Equal(t, http.StatusConflict, appErr.Code)
+	extended, appErr := uploads.SetRetention(uploaded.ID, time.Now().Add(48*time.Hour), alice)
+	require.Nil(t, appErr)
+	assert.WithinDuration(t, time.Now().Add(48*time.Hour), *extended.RetainUntil, time.Minute)
+
+	events := readAuditLog(t, auditPath)
+	require.Len(t, events, 3)
+	assert.Equal(t, models.AuditChangeBlocked, events[0].Action)
+	assert.Equal(t, "delete", events[0].Details["operation"])
+	assert.Equal(t, models.AuditChangeBlocked, events[1].Action)
+	assert.Equal(t, models.AuditRetentionSet, events[2].Action)
+	assert.Equal(t, "alice", events[2].UserID)
+}
+
+func TestUploadService_LegalHold(t *testing.T) {
+	uploads := newUploadService(t, func(cfg *config.Config) {
+		cfg.Upload.TrashRetention = time.Hour
+	})
+	auditPath := withAuditLog(t, uploads)
+	alice := models.Principal{UserID: "alice"}
+	admin := models.Principal{UserID: "root"}
+
+	uploaded, appErr := uploads.UploadFile(pdfFileHeader(t), alice, models.UploadOptions{})
+	require.Nil(t, appErr)
+
+	held, appErr := uploads.PlaceLegalHold(uploaded.ID, "litigation", admin)
+	require.Nil(t, appErr)
+	require.NotNil(t, held.LegalHold)
+	assert.Equal(t, "root", held.LegalHold.PlacedBy)
+
+	_, appErr = uploads.PlaceLegalHold(uploaded.ID, "again", admin)
+	require.NotNil(t, appErr)
+	assert.Equal(t, http.StatusConflict, appErr.Code)
+
+	assert.Equal(t, models.ErrLegalHold, uploads.DeleteFile(uploaded.ID, alice))
+	_, appErr = uploads.PruneVersions(uploaded.ID, alice, 0, 0)
+	assert.Equal(t, models.ErrLegalHold, appErr)
+
+	_, appErr = uploads.ReleaseLegalHold(uploaded.ID, admin)
+	require.Nil(t, appErr)
+	require.Nil(t, uploads.DeleteFile(uploaded.ID, alice))
+
+	// A hold placed on a trashed file keeps it from being purged
+	_, appErr = uploads.PlaceLegalHold(uploaded.ID, "litigation", admin)
+	require.Nil(t, appErr)
+	assert.Equal(t, models.ErrLegalHold, uploads.PurgeFromTrash(uploaded.ID, alice))
+	purged, appErr := uploads.EmptyTrash(alice)
+	require.Nil(t, appErr)
+	assert.Zero(t, purged)
+
+	actions := []string{}
+	for _, event := range readAuditLog(t, auditPath) {
+		actions = append(actions, event.Action)
+	}
+	assert.Equal(t, []string{
+		models.AuditLegalHoldPlaced,
+		models.AuditChangeBlocked,
+		models.AuditChangeBlocked,
+		models.AuditLegalHoldReleased,
+		models.AuditLegalHoldPlaced,
+		models.AuditChangeBlocked,
+	}, actions)
+}
+
+func TestUploadService_PurgeExpiredAudit(t *testing.T) {
+	uploads := newUploadService(t, func(cfg *config.Config) {
+		cfg.Upload.Retention = time.Nanosecond
+		cfg.Upload.TrashRetention = time.Nanosecond
+	})
+	auditPath := withAuditLog(t, uploads)
+	alice := models.Principal{UserID: "alice"}
+	admin := models.Principal{UserID: "root"}
+
+	expiring, appErr := uploads.UploadFile(pdfFileHeader(t), alice, models.UploadOptions{})
+	require.Nil(t, appErr)
+	trashed, appErr := uploads.UploadFile(pdfFileHeader(t), alice, models.UploadOptions{})
+	require.Nil(t, appErr)
+	held, appErr := uploads.UploadFile(pdfFileHeader(t), alice, models.UploadOptions{})
+	require.Nil(t, appErr)
+	require.Nil(t, uploads.DeleteFile(trashed.ID, alice))
+	_, appErr = uploads.PlaceLegalHold(held.ID, "litigation", admin)
+	require.Nil(t, appErr)
+
+	purged, err := uploads.PurgeExpired()
+	require.NoError(t, err)
+	assert.Equal(t, 2, purged)
+
+	// The hold is still in place, but it was already audited
+	purged, err = uploads.PurgeExpired()
+	require.NoError(t, err)
+	assert.Zero(t, purged)
+
+	reasons := map[string]string{}
+	var blocked []models.AuditEvent
+	for _, event := range readAuditLog(t, auditPath) {
+		switch event.Action {
+		case models.AuditFilePurged:
+			assert.Equal(t, "alice", event.Details["owner"])
+			reasons[event.FileID] = event.Details["reason"]
+		case models.AuditChangeBlocked:
+			blocked = append(blocked, event)
+		}
+	}
+	assert.Equal(t, map[string]string{expiring.ID: "expired", trashed.ID: "trash"}, reasons)
+	require.Len(t, blocked, 1)
+	assert.Equal(t, held.ID, blocked[0].FileID)
+	assert.Equal(t, "expire", blocked[0].Details["operation"])
+	assert.Empty(t, blocked[0].UserID)
+}
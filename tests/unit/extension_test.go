@@ -0,0 +1,99 @@
+package unit
+
+import (
+	"testing"
+
+	"github.com/ebinskryfon/fileuploader/config"
+	"github.com/ebinskryfon/fileuploader/models"
+	"github.com/ebinskryfon/fileuploader/services"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExtensionPolicy_Check(t *testing.T) {
+	reject := services.NewExtensionPolicy(services.ExtensionPolicyReject, map[string][]string{"text/csv": {".csv", "TSV"}}, nil)
+	rewrite := services.NewExtensionPolicy(services.ExtensionPolicyRewrite, nil, nil)
+	allow := services.NewExtensionPolicy(services.ExtensionPolicyAllow, nil, nil)
+
+	tests := []struct {
+		name        string
+		policy      *services.ExtensionPolicy
+		fileName    string
+		contentType string
+		want        string
+		reason      string
+	}{
+		{"matching", reject, "invoice.pdf", "application/pdf", "invoice.pdf", ""},
+		{"case insensitive", reject, "Photo.JPEG", "image/jpeg", "Photo.JPEG", ""},
+		{"configured extensions", reject, "data.tsv", "text/csv", "data.tsv", ""},
+		{"no extension", reject, "README", "text/plain", "README", ""},
+		{"unmapped type", reject, "data.json", "application/json", "data.json", ""},
+		{"mismatch", reject, "photo.png", "application/pdf", "", models.ReasonExtensionMismatch},
+		{"blocked", reject, "invoice.exe", "application/pdf", "", models.ReasonExtensionBlocked},
+		{"blocked with trailing dot", reject, "invoice.exe.", "application/pdf", "", models.ReasonExtensionBlocked},
+		{"double extension", reject, "invoice.pdf.exe", "application/pdf", "", models.ReasonDoubleExtension},
+		{"inner executable", reject, "setup.exe.pdf", "application/pdf", "", models.ReasonDoubleExtension},
+		{"rewrite mismatch", rewrite, "photo.png", "application/pdf", "photo.pdf", ""},
+		{"rewrite adds extension", rewrite, "scan", "image/jpeg", "scan.jpg", ""},
+		{"rewrite extension longer lower-cased", rewrite, "a.ȺȺȺȺȺ", "application/pdf", "a.pdf", ""},
+		{"rewrite still blocks", rewrite, "invoice.exe", "application/pdf", "", models.ReasonExtensionBlocked},
+		{"allow mismatch", allow, "photo.png", "application/pdf", "photo.png", ""},
+		{"allow still blocks", allow, "invoice.pdf.exe", "application/pdf", "", models.ReasonDoubleExtension},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			name, appErr := tt.policy.Check(tt.fileName, tt.contentType)
+			if tt.reason != "" {
+				require.NotNil(t, appErr)
+				assert.Equal(t, tt.reason, appErr.Reason)
+				assert.Equal(t, 400, appErr.Code)
+				return
+			}
+			require.Nil(t, appErr)
+			assert.Equal(t, tt.want, name)
+		})
+	}
+}
+
+func TestExtensionPolicy_TypeFor(t *testing.T) {
+	policy := services.NewExtensionPolicy("", nil, nil)
+
+	assert.Equal(t, "image/jpeg", policy.TypeFor("photo.JPG"))
+	assert.Equal(t, "application/vnd.openxmlformats-officedocument.wordprocessingml.document", policy.TypeFor("letter.docx"))
+	assert.Equal(t, "application/octet-stream", policy.TypeFor("archive.rar"))
+	assert.Equal(t, "application/octet-stream", policy.TypeFor("Makefile"))
+}
+
+func TestUploadService_ExtensionPolicy(t *testing.T) {
+	uploads := newUploadService(t, func(cfg *config.Config) {
+		cfg.Upload.AllowedTypes = []string{"application/pdf", "image/png"}
+		cfg.Upload.ExtensionPolicy = services.ExtensionPolicyRewrite
+	})
+	alice := models.Principal{UserID: "alice"}
+
+	_, appErr := uploads.UploadFile(uploadFileHeader(t, "invoice.pdf.exe", "application/pdf", testPDF), alice, models.UploadOptions{})
+	require.NotNil(t, appErr)
+	assert.Equal(t, models.ReasonDoubleExtension, appErr.Reason)
+
+	uploaded, appErr := uploads.UploadFile(uploadFileHeader(t, "invoice.png", "application/pdf", testPDF), alice, models.UploadOptions{})
+	require.Nil(t, appErr)
+	file, metadata, appErr := uploads.GetFile(uploaded.ID, alice)
+	require.Nil(t, appErr)
+	file.Close()
+	assert.Equal(t, "invoice.pdf", metadata.OriginalName)
+
+	// New content of another type renames the file to match
+	_, appErr = uploads.ReplaceContent(uploaded.ID, uploadFileHeader(t, "scan.png", "image/png", testPNG), alice)
+	require.Nil(t, appErr)
+	file, metadata, appErr = uploads.GetFile(uploaded.ID, alice)
+	require.Nil(t, appErr)
+	file.Close()
+	assert.Equal(t, "invoice.png", metadata.OriginalName)
+
+	// Renames can't sneak an executable extension in either
+	_, appErr = uploads.UpdateFile(uploaded.ID, alice, models.UpdateFileRequest{Name: stringPtr("invoice.exe")})
+	require.NotNil(t, appErr)
+	assert.Equal(t, models.ReasonExtensionBlocked, appErr.Reason)
+}
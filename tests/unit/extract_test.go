@@ -0,0 +1,153 @@
+package unit
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/ebinskryfon/fileuploader/models"
+	"github.com/ebinskryfon/fileuploader/services"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// textPDF builds a PDF whose single page draws content, compressed or not.
+func textPDF(t *testing.T, content string, compress bool) []byte {
+	stream, filter := []byte(content), ""
+	if compress {
+		var buf bytes.Buffer
+		w := zlib.NewWriter(&buf)
+		_, err := w.Write(stream)
+		require.NoError(t, err)
+		require.NoError(t, w.Close())
+		stream, filter = buf.Bytes(), " /Filter /FlateDecode"
+	}
+
+	var pdf bytes.Buffer
+	pdf.WriteString("%PDF-1.4\n1 0 obj\n<< /Type /Catalog >>\nendobj\n")
+	fmt.Fprintf(&pdf, "4 0 obj\n<< /Length %d%s >>\nstream\n", len(stream), filter)
+	pdf.Write(stream)
+	pdf.WriteString("\nendstream\nendobj\ntrailer\n<<>>\n%%EOF\n")
+	return pdf.Bytes()
+}
+
+func textDOCX(t *testing.T, paragraphs ...string) []byte {
+	var buf bytes.Buffer
+	archive := zip.NewWriter(&buf)
+	w, err := archive.Create("[Content_Types].xml")
+	require.NoError(t, err)
+	fmt.Fprint(w, `<?xml version="1.0" encoding="UTF-8"?><Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types">`+
+		`<Override PartName="/word/document.xml" ContentType="application/vnd.openxmlformats-officedocument.wordprocessingml.document.main+xml"/></Types>`)
+
+	w, err = archive.Create("word/document.xml")
+	require.NoError(t, err)
+
+	fmt.Fprint(w, `<?xml version="1.0" encoding="UTF-8"?><w:document xmlns:w="http://schemas.openxmlformats.org/wordprocessingml/2006/main"><w:body>`)
+	for _, paragraph := range paragraphs {
+		fmt.Fprintf(w, `<w:p><w:r><w:t>%s</w:t></w:r></w:p>`, paragraph)
+	}
+	fmt.Fprint(w, `</w:body></w:document>`)
+	require.NoError(t, archive.Close())
+	return buf.Bytes()
+}
+
+func TestExtractText(t *testing.T) {
+	const docx = "application/vnd.openxmlformats-officedocument.wordprocessingml.document"
+
+	tests := []struct {
+		name        string
+		contentType string
+		data        []byte
+		want        string
+	}{
+		{"plain text", "text/plain; charset=utf-8", []byte("hello world"), "hello world"},
+		{"pdf", "application/pdf", textPDF(t, "BT /F1 12 Tf 72 712 Td (Quarterly \\(Q3\\)) Tj ET", false), "Quarterly (Q3)"},
+		{"compressed pdf", "application/pdf", textPDF(t, "BT [(Hello) -300 (w) 20 (orld)] TJ T* <4F6B> Tj ET", true), "Hello world Ok"},
+		{"docx", docx, textDOCX(t, "First line", "Second line"), "First line\nSecond line"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.True(t, services.CanExtractText(tt.contentType))
+			text, err := services.ExtractText(tt.contentType, tt.data, 1024)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, text)
+		})
+	}
+
+	text, err := services.ExtractText("text/plain", []byte("truncated text"), 9)
+	require.NoError(t, err)
+	assert.Equal(t, "truncated", text)
+
+	assert.False(t, services.CanExtractText("image/png"))
+	_, err = services.ExtractText("image/png", []byte("png"), 1024)
+	assert.Error(t, err)
+}
+
+func TestExtractText_PDFDecompressionBudget(t *testing.T) {
+	bombs := func(count int) []byte {
+		var packed bytes.Buffer
+		w := zlib.NewWriter(&packed)
+		_, err := w.Write(make([]byte, 16<<20))
+		require.NoError(t, err)
+		require.NoError(t, w.Close())
+
+		objects := []string{"<< /Type /Catalog >>"}
+		for range count {
+			objects = append(objects, fmt.Sprintf("<< /Filter /FlateDecode /Length %d >>\nstream\n%s\nendstream", packed.Len(), packed.String()))
+		}
+		content := "BT (Secret) Tj ET"
+		objects = append(objects, fmt.Sprintf("<< /Length %d >>\nstream\n%s\nendstream", len(content), content))
+		return buildPDF(objects...)
+	}
+
+	// Each stream is within the per-stream limit, but together they
+	// decompress to more than the document may
+	text, err := services.ExtractText("application/pdf", bombs(5), 1<<20)
+	require.NoError(t, err)
+	assert.NotContains(t, text, "Secret")
+
+	text, err = services.ExtractText("application/pdf", bombs(3), 1<<20)
+	require.NoError(t, err)
+	assert.Contains(t, text, "Secret")
+}
+
+func TestUploadService_ContentSearch(t *testing.T) {
+	uploads := newUploadService(t, nil)
+	stop := uploads.StartExtraction(1)
+	defer stop()
+
+	alice := models.Principal{UserID: "alice"}
+	invoice, appErr := uploads.UploadFile(uploadFileHeader(t, "scan.pdf", "application/pdf",
+		textPDF(t, "BT (Invoice for consulting services) Tj ET", true)), alice, models.UploadOptions{})
+	require.Nil(t, appErr)
+	_, appErr = uploads.UploadFile(uploadFileHeader(t, "other.pdf", "application/pdf",
+		textPDF(t, "BT (Meeting minutes) Tj ET", true)), alice, models.UploadOptions{})
+	require.Nil(t, appErr)
+
+	// Extraction runs in the background
+	require.Eventually(t, func() bool {
+		response, appErr := uploads.Search(alice, models.SearchQuery{Content: "consult invoice"})
+		return appErr == nil && response.Total == 1 && response.Results[0].File.ID == invoice.ID
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// Name and content terms must both match
+	response, appErr := uploads.Search(alice, models.SearchQuery{Query: "other", Content: "invoice"})
+	require.Nil(t, appErr)
+	assert.Zero(t, response.Total)
+
+	// Replaced content is re-extracted
+	_, appErr = uploads.ReplaceContent(invoice.ID, uploadFileHeader(t, "scan.pdf", "application/pdf",
+		textPDF(t, "BT (Receipt) Tj ET", false)), alice)
+	require.Nil(t, appErr)
+	require.Eventually(t, func() bool {
+		response, appErr := uploads.Search(alice, models.SearchQuery{Content: "receipt"})
+		return appErr == nil && response.Total == 1
+	}, 5*time.Second, 10*time.Millisecond)
+	response, appErr = uploads.Search(alice, models.SearchQuery{Content: "invoice"})
+	require.Nil(t, appErr)
+	assert.Zero(t, response.Total)
+}
@@ -0,0 +1,92 @@
+package unit
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/ebinskryfon/fileuploader/models"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func stringPtr(s string) *string {
+	return &s
+}
+
+func TestUploadService_UpdateFile(t *testing.T) {
+	uploads := newUploadService(t, nil)
+	alice := models.Principal{UserID: "alice"}
+	bob := models.Principal{UserID: "bob"}
+
+	uploaded, appErr := uploads.UploadFile(pdfFileHeader(t), alice, models.UploadOptions{
+		Description: "draft",
+		Tags:        map[string]string{"project": "apollo", "draft": "yes"},
+	})
+	require.Nil(t, appErr)
+
+	updated, appErr := uploads.UpdateFile(uploaded.ID, alice, models.UpdateFileRequest{
+		Name:     stringPtr("../q3 report.pdf"),
+		Tags:     map[string]*string{"draft": nil, "year": stringPtr("2026")},
+		Metadata: map[string]*string{"invoice": stringPtr("INV-1")},
+	})
+	require.Nil(t, appErr)
+	assert.Equal(t, "q3 report.pdf", updated.OriginalName)
+	assert.Equal(t, "draft", updated.Description)
+	assert.Equal(t, map[string]string{"project": "apollo", "year": "2026"}, updated.Tags)
+	assert.Equal(t, map[string]string{"invoice": "INV-1"}, updated.Metadata)
+
+	// Changes are persisted
+	files, appErr := uploads.ListFiles(alice)
+	require.Nil(t, appErr)
+	require.Len(t, files, 1)
+	assert.Equal(t, "q3 report.pdf", files[0].OriginalName)
+	assert.Equal(t, updated.Tags, files[0].Tags)
+
+	// Readers can't change metadata
+	_, appErr = uploads.GrantPermission(uploaded.ID, alice, models.GrantPermissionRequest{
+		Type: models.ACLTypeUser, ID: "bob", Permission: models.PermissionRead,
+	})
+	require.Nil(t, appErr)
+	_, appErr = uploads.UpdateFile(uploaded.ID, bob, models.UpdateFileRequest{Description: stringPtr("mine")})
+	assert.Equal(t, models.ErrPermissionDenied, appErr)
+}
+
+func TestUploadService_UpdateFileLimits(t *testing.T) {
+	uploads := newUploadService(t, nil)
+	alice := models.Principal{UserID: "alice"}
+
+	uploaded, appErr := uploads.UploadFile(pdfFileHeader(t), alice, models.UploadOptions{})
+	require.Nil(t, appErr)
+
+	tooManyTags := make(map[string]*string)
+	for i := 0; i < 51; i++ {
+		tooManyTags["tag"+strings.Repeat("x", i)] = stringPtr("v")
+	}
+
+	tests := []struct {
+		name string
+		req  models.UpdateFileRequest
+	}{
+		{"empty name", models.UpdateFileRequest{Name: stringPtr("")}},
+		{"long name", models.UpdateFileRequest{Name: stringPtr(strings.Repeat("a", 256))}},
+		{"long description", models.UpdateFileRequest{Description: stringPtr(strings.Repeat("a", 2049))}},
+		{"too many tags", models.UpdateFileRequest{Tags: tooManyTags}},
+		{"invalid tag key", models.UpdateFileRequest{Tags: map[string]*string{"bad key": stringPtr("v")}}},
+		{"long metadata value", models.UpdateFileRequest{Metadata: map[string]*string{"k": stringPtr(strings.Repeat("a", 1025))}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, appErr := uploads.UpdateFile(uploaded.ID, alice, tt.req)
+			require.NotNil(t, appErr)
+			assert.Equal(t, http.StatusBadRequest, appErr.Code)
+		})
+	}
+
+	_, appErr = uploads.UploadFile(pdfFileHeader(t), alice, models.UploadOptions{
+		Metadata: map[string]string{"": "v"},
+	})
+	require.NotNil(t, appErr)
+	assert.Equal(t, http.StatusBadRequest, appErr.Code)
+}
@@ -0,0 +1,174 @@
+package unit
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/ebinskryfon/fileuploader/models"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUploadService_FolderPaths(t *testing.T) {
+	uploads := newUploadService(t, nil)
+	alice := models.Principal{UserID: "alice"}
+
+	uploaded, appErr := uploads.UploadFile(pdfFileHeader(t), alice, models.UploadOptions{Path: "reports/2026"})
+	require.Nil(t, appErr)
+	require.NotEmpty(t, uploaded.FolderID)
+
+	folderID, fileID, appErr := uploads.ResolvePath(alice, "/reports/2026/doc.pdf")
+	require.Nil(t, appErr)
+	assert.Empty(t, folderID)
+	assert.Equal(t, uploaded.ID, fileID)
+
+	folderID, _, appErr = uploads.ResolvePath(alice, "reports/2026")
+	require.Nil(t, appErr)
+	assert.Equal(t, uploaded.FolderID, folderID)
+
+	// Uploading along the same path reuses the existing folders
+	again, appErr := uploads.UploadFile(pdfFileHeader(t), alice, models.UploadOptions{Path: "reports/2026"})
+	require.Nil(t, appErr)
+	assert.Equal(t, uploaded.FolderID, again.FolderID)
+
+	// Paths are relative to each user's own root
+	_, _, appErr = uploads.ResolvePath(models.Principal{UserID: "bob"}, "reports/2026/doc.pdf")
+	assert.Equal(t, models.ErrFileNotFound, appErr)
+
+	_, appErr = uploads.UploadFile(pdfFileHeader(t), alice, models.UploadOptions{FolderID: folderID, Path: "reports"})
+	require.NotNil(t, appErr)
+	assert.Equal(t, http.StatusBadRequest, appErr.Code)
+
+	// Rejected uploads leave no folders behind
+	_, appErr = uploads.UploadFile(uploadFileHeader(t, "doc.pdf", "application/pdf", testPNG), alice, models.UploadOptions{Path: "drafts/2026"})
+	require.NotNil(t, appErr)
+	_, _, appErr = uploads.ResolvePath(alice, "drafts")
+	assert.Equal(t, models.ErrFileNotFound, appErr)
+}
+
+func TestUploadService_FolderPermissionsInherit(t *testing.T) {
+	uploads := newUploadService(t, nil)
+	alice := models.Principal{UserID: "alice"}
+	bob := models.Principal{UserID: "bob", Groups: []string{"finance"}}
+
+	reports, appErr := uploads.CreateFolder(alice, models.CreateFolderRequest{Name: "reports"})
+	require.Nil(t, appErr)
+	q3, appErr := uploads.CreateFolder(alice, models.CreateFolderRequest{Name: "q3", ParentID: reports.ID})
+	require.Nil(t, appErr)
+	uploaded, appErr := uploads.UploadFile(pdfFileHeader(t), alice, models.UploadOptions{FolderID: q3.ID})
+	require.Nil(t, appErr)
+
+	_, _, appErr = uploads.GetFile(uploaded.ID, bob)
+	assert.Equal(t, models.ErrFileNotFound, appErr)
+	_, appErr = uploads.UploadFile(pdfFileHeader(t), bob, models.UploadOptions{FolderID: q3.ID})
+	assert.Equal(t, models.ErrFolderNotFound, appErr)
+
+	_, appErr = uploads.GrantFolderPermission(reports.ID, alice, models.GrantPermissionRequest{
+		Type: models.ACLTypeGroup, ID: "finance", Permission: models.PermissionRead,
+	})
+	require.Nil(t, appErr)
+
+	// A grant on an ancestor reaches files nested below it
+	file, _, appErr := uploads.GetFile(uploaded.ID, bob)
+	require.Nil(t, appErr)
+	file.Close()
+
+	contents, appErr := uploads.GetFolderContents(q3.ID, bob)
+	require.Nil(t, appErr)
+	require.Len(t, contents.Files, 1)
+
+	// Read access does not allow changes
+	assert.Equal(t, models.ErrPermissionDenied, uploads.DeleteFile(uploaded.ID, bob))
+	assert.Equal(t, models.ErrPermissionDenied, uploads.DeleteFolder(q3.ID, bob))
+	_, appErr = uploads.UploadFile(pdfFileHeader(t), bob, models.UploadOptions{FolderID: q3.ID})
+	assert.Equal(t, models.ErrPermissionDenied, appErr)
+
+	shared, appErr := uploads.ListSharedFolders(bob)
+	require.Nil(t, appErr)
+	require.Len(t, shared, 1)
+	assert.Equal(t, reports.ID, shared[0].ID)
+	assert.Nil(t, shared[0].Permissions)
+}
+
+func TestUploadService_FolderRenameMoveDelete(t *testing.T) {
+	uploads := newUploadService(t, nil)
+	alice := models.Principal{UserID: "alice"}
+
+	a, appErr := uploads.CreateFolder(alice, models.CreateFolderRequest{Name: "a"})
+	require.Nil(t, appErr)
+	b, appErr := uploads.CreateFolder(alice, models.CreateFolderRequest{Name: "b", ParentID: a.ID})
+	require.Nil(t, appErr)
+	_, appErr = uploads.CreateFolder(alice, models.CreateFolderRequest{Name: "a"})
+	assert.Equal(t, models.ErrFolderExists, appErr)
+	_, appErr = uploads.CreateFolder(alice, models.CreateFolderRequest{Name: "../x"})
+	require.NotNil(t, appErr)
+	assert.Equal(t, http.StatusBadRequest, appErr.Code)
+
+	// A folder can't be moved beneath itself
+	_, appErr = uploads.UpdateFolder(a.ID, alice, models.UpdateFolderRequest{ParentID: &b.ID})
+	require.NotNil(t, appErr)
+	assert.Equal(t, http.StatusBadRequest, appErr.Code)
+
+	root, name := "", "archive"
+	moved, appErr := uploads.UpdateFolder(b.ID, alice, models.UpdateFolderRequest{Name: &name, ParentID: &root})
+	require.Nil(t, appErr)
+	assert.Empty(t, moved.ParentID)
+	assert.Equal(t, "archive", moved.Name)
+
+	uploaded, appErr := uploads.UploadFile(pdfFileHeader(t), alice, models.UploadOptions{Path: "archive/2025"})
+	require.Nil(t, appErr)
+
+	require.Nil(t, uploads.DeleteFolder(b.ID, alice))
+
+	_, _, appErr = uploads.GetFile(uploaded.ID, alice)
+	assert.Equal(t, models.ErrFileNotFound, appErr)
+	usage, appErr := uploads.GetUsage(alice)
+	require.Nil(t, appErr)
+	assert.Zero(t, usage.FileCount)
+
+	contents, appErr := uploads.GetFolderContents("", alice)
+	require.Nil(t, appErr)
+	require.Len(t, contents.Folders, 1)
+	assert.Equal(t, a.ID, contents.Folders[0].ID)
+}
+
+func TestUploadService_FolderDepthLimit(t *testing.T) {
+	uploads := newUploadService(t, nil)
+	alice := models.Principal{UserID: "alice"}
+	segments := func(n int) string {
+		return strings.TrimSuffix(strings.Repeat("d/", n), "/")
+	}
+
+	_, appErr := uploads.UploadFile(pdfFileHeader(t), alice, models.UploadOptions{Path: segments(65)})
+	assert.Equal(t, models.ErrFolderTooDeep, appErr)
+	folders, appErr := uploads.GetFolderContents("", alice)
+	require.Nil(t, appErr)
+	assert.Empty(t, folders.Folders)
+
+	// The deepest allowed folder stays reachable
+	uploaded, appErr := uploads.UploadFile(pdfFileHeader(t), alice, models.UploadOptions{Path: segments(64)})
+	require.Nil(t, appErr)
+	contents, appErr := uploads.GetFolderContents(uploaded.FolderID, alice)
+	require.Nil(t, appErr)
+	assert.Len(t, contents.Files, 1)
+
+	_, appErr = uploads.CreateFolder(alice, models.CreateFolderRequest{Name: "deeper", ParentID: uploaded.FolderID})
+	assert.Equal(t, models.ErrFolderTooDeep, appErr)
+
+	// Moving a folder counts its subfolders too
+	parent, appErr := uploads.CreateFolder(alice, models.CreateFolderRequest{Name: "parent"})
+	require.Nil(t, appErr)
+	_, appErr = uploads.CreateFolder(alice, models.CreateFolderRequest{Name: "child", ParentID: parent.ID})
+	require.Nil(t, appErr)
+	folderID, _, appErr := uploads.ResolvePath(alice, segments(63))
+	require.Nil(t, appErr)
+	_, appErr = uploads.UpdateFolder(parent.ID, alice, models.UpdateFolderRequest{ParentID: &folderID})
+	assert.Equal(t, models.ErrFolderTooDeep, appErr)
+
+	folderID, _, appErr = uploads.ResolvePath(alice, segments(62))
+	require.Nil(t, appErr)
+	_, appErr = uploads.UpdateFolder(parent.ID, alice, models.UpdateFolderRequest{ParentID: &folderID})
+	assert.Nil(t, appErr)
+}
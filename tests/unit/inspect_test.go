@@ -0,0 +1,248 @@
+package unit
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/ebinskryfon/fileuploader/config"
+	"github.com/ebinskryfon/fileuploader/models"
+	"github.com/ebinskryfon/fileuploader/services"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// buildPDF numbers objects from 1, with the catalog first, and ends the file
+// with a correct cross-reference table.
+func buildPDF(objects ...string) []byte {
+	var pdf bytes.Buffer
+	pdf.WriteString("%PDF-1.4\n")
+	offsets := make([]int, len(objects))
+	for i, object := range objects {
+		offsets[i] = pdf.Len()
+		fmt.Fprintf(&pdf, "%d 0 obj\n%s\nendobj\n", i+1, object)
+	}
+
+	xref := pdf.Len()
+	fmt.Fprintf(&pdf, "xref\n0 %d\n0000000000 65535 f \n", len(objects)+1)
+	for _, offset := range offsets {
+		fmt.Fprintf(&pdf, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(&pdf, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(objects)+1, xref)
+	return pdf.Bytes()
+}
+
+// xrefStreamPDF ends the file with a compressed cross-reference stream
+// using the PNG Up predictor, as most current writers do. offsetShift
+// corrupts the offset recorded for object 2.
+func xrefStreamPDF(t *testing.T, offsetShift int, objects ...string) []byte {
+	var pdf bytes.Buffer
+	pdf.WriteString("%PDF-1.5\n")
+	offsets := make([]int, len(objects))
+	for i, object := range objects {
+		offsets[i] = pdf.Len()
+		fmt.Fprintf(&pdf, "%d 0 obj\n%s\nendobj\n", i+1, object)
+	}
+	offsets[1] += offsetShift
+
+	// Rows are type, 4-byte offset and generation; the first is object 0
+	var rows [][]byte
+	rows = append(rows, []byte{0, 0, 0, 0, 0, 0xff})
+	for _, offset := range offsets {
+		row := []byte{1, 0, 0, 0, 0, 0}
+		binary.BigEndian.PutUint32(row[1:5], uint32(offset))
+		rows = append(rows, row)
+	}
+	xref := pdf.Len()
+	rows = append(rows, []byte{1, 0, 0, 0, 0, 0})
+	binary.BigEndian.PutUint32(rows[len(rows)-1][1:5], uint32(xref))
+
+	var packed bytes.Buffer
+	w := zlib.NewWriter(&packed)
+	previous := make([]byte, 6)
+	for _, row := range rows {
+		encoded := []byte{2}
+		for i := range row {
+			encoded = append(encoded, row[i]-previous[i])
+		}
+		w.Write(encoded)
+		previous = row
+	}
+	require.NoError(t, w.Close())
+
+	fmt.Fprintf(&pdf, "%d 0 obj\n<< /Type /XRef /Size %d /W [1 4 1] /Root 1 0 R /Filter /FlateDecode"+
+		" /DecodeParms << /Columns 6 /Predictor 12 >> /Length %d >>\nstream\n", len(objects)+1, len(rows), packed.Len())
+	pdf.Write(packed.Bytes())
+	fmt.Fprintf(&pdf, "\nendstream\nendobj\nstartxref\n%d\n%%%%EOF\n", xref)
+	return pdf.Bytes()
+}
+
+func findingKinds(findings []models.ContentFinding) []string {
+	var kinds []string
+	for _, finding := range findings {
+		kinds = append(kinds, finding.Kind)
+	}
+	return kinds
+}
+
+func TestInspectPDF(t *testing.T) {
+	pages := "<< /Type /Pages /Kids [] /Count 0 >>"
+
+	assert.Empty(t, services.InspectPDF(buildPDF("<< /Type /Catalog /Pages 2 0 R >>", pages)))
+	assert.Empty(t, services.InspectPDF(xrefStreamPDF(t, 0, "<< /Type /Catalog /Pages 2 0 R >>", pages)))
+
+	findings := services.InspectPDF(buildPDF(
+		"<< /Type /Catalog /Pages 2 0 R /OpenAction 3 0 R >>", pages,
+		"<< /S /JavaScript /JS (app.alert\\(1\\)) >>"))
+	assert.Equal(t, []models.ContentFinding{
+		{Kind: models.FindingOpenAction, Object: 1},
+		{Kind: models.FindingJavaScript, Object: 3},
+	}, findings)
+
+	for name, test := range map[string]struct {
+		object string
+		kind   string
+	}{
+		"launch":          {"<< /Type /Annot /A << /S /Launch /F (cmd.exe) >> >>", models.FindingLaunch},
+		"embedded file":   {"<< /Type /Filespec /F (a.exe) /EF << /F 4 0 R >> >>", models.FindingEmbeddedFile},
+		"name tree":       {"<< /EmbeddedFiles << /Names [] >> >>", models.FindingEmbeddedFile},
+		"xfa":             {"<< /Fields [] /XFA 4 0 R >>", models.FindingXFA},
+		"escaped name":    {"<< /S /J#61vaScript >>", models.FindingJavaScript},
+		"document script": {"<< /JavaScript << /Names [(x) 4 0 R] >> >>", models.FindingJavaScript},
+	} {
+		findings := services.InspectPDF(buildPDF("<< /Type /Catalog /Pages 2 0 R >>", pages, test.object))
+		assert.Equal(t, []string{test.kind}, findingKinds(findings), name)
+	}
+
+	// Scripts hidden in a compressed object stream are found too
+	var packed bytes.Buffer
+	w := zlib.NewWriter(&packed)
+	fmt.Fprint(w, "4 0 << /S /JavaScript /JS (x) >>")
+	require.NoError(t, w.Close())
+	findings = services.InspectPDF(buildPDF("<< /Type /Catalog /Pages 2 0 R >>", pages,
+		fmt.Sprintf("<< /Type /ObjStm /N 1 /First 4 /Filter /FlateDecode /Length %d >>\nstream\n%s\nendstream", packed.Len(), packed.String())))
+	assert.Equal(t, []models.ContentFinding{{Kind: models.FindingJavaScript, Object: 4}}, findings)
+
+	// Broken cross-reference tables
+	clean := buildPDF("<< /Type /Catalog /Pages 2 0 R >>", pages)
+	offset := bytes.Index(clean, []byte("2 0 obj"))
+	shifted := bytes.Replace(clean, []byte(fmt.Sprintf("%010d 00000 n", offset)), []byte(fmt.Sprintf("%010d 00000 n", offset+1)), 1)
+	findings = services.InspectPDF(shifted)
+	require.Len(t, findings, 1)
+	assert.Equal(t, models.FindingMalformedXref, findings[0].Kind)
+	assert.Contains(t, findings[0].Detail, "object 2 is not at offset")
+
+	findings = services.InspectPDF(xrefStreamPDF(t, 3, "<< /Type /Catalog /Pages 2 0 R >>", pages))
+	assert.Equal(t, []string{models.FindingMalformedXref}, findingKinds(findings))
+
+	assert.Equal(t, []models.ContentFinding{{Kind: models.FindingMalformedXref, Detail: "no startxref"}},
+		services.InspectPDF(textPDF(t, "BT (Hello) Tj ET", false)))
+	assert.Equal(t, []string{models.FindingMalformedXref}, findingKinds(services.InspectPDF([]byte("%PDF-1.4\n%%EOF\n"))))
+}
+
+// bombPDF has count object streams that each stay within the per-stream
+// limit, but five of which inflate past what one file may decompress to.
+// extra objects follow the catalog and page tree.
+func bombPDF(t *testing.T, count int, extra ...string) []byte {
+	var packed bytes.Buffer
+	w := zlib.NewWriter(&packed)
+	_, err := w.Write(make([]byte, 16<<20))
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+	stream := fmt.Sprintf("<< /Type /ObjStm /N 1 /First 4 /Filter /FlateDecode /Length %d >>\nstream\n%s\nendstream", packed.Len(), packed.String())
+
+	objects := append([]string{"<< /Type /Catalog /Pages 2 0 R >>", "<< /Type /Pages /Kids [] /Count 0 >>"}, extra...)
+	for range count {
+		objects = append(objects, stream)
+	}
+	return buildPDF(objects...)
+}
+
+func TestInspectPDF_DecompressionBudget(t *testing.T) {
+	findings := services.InspectPDF(bombPDF(t, 5))
+	require.Len(t, findings, 1)
+	assert.Equal(t, models.FindingLimitExceeded, findings[0].Kind)
+	assert.Contains(t, findings[0].Detail, "decompress to more than")
+
+	// What was read before the limit is still reported
+	findings = services.InspectPDF(bombPDF(t, 5, "<< /S /JavaScript /JS (x) >>"))
+	assert.Equal(t, []string{models.FindingJavaScript, models.FindingLimitExceeded}, findingKinds(findings))
+
+	// Staying under the budget is fine
+	assert.Empty(t, services.InspectPDF(bombPDF(t, 3)))
+}
+
+func TestUploadService_PDFPolicy(t *testing.T) {
+	newUploads := func(policy string, overrides map[string]string) *services.UploadService {
+		return newUploadService(t, func(cfg *config.Config) {
+			cfg.Upload.PDFPolicy = policy
+			cfg.Upload.PDFFindingPolicies = overrides
+		})
+	}
+	alice := models.Principal{UserID: "alice"}
+	pages := "<< /Type /Pages /Kids [] /Count 0 >>"
+	script := buildPDF("<< /Type /Catalog /Pages 2 0 R /OpenAction << /S /JavaScript /JS (x) >> >>", pages)
+	clean := buildPDF("<< /Type /Catalog /Pages 2 0 R >>", pages)
+
+	// Flagging is the default
+	uploads := newUploads("", nil)
+	uploaded, appErr := uploads.UploadFile(uploadFileHeader(t, "script.pdf", "application/pdf", script), alice, models.UploadOptions{})
+	require.Nil(t, appErr)
+	assert.Equal(t, []string{models.FindingOpenAction, models.FindingJavaScript}, findingKinds(uploaded.Findings))
+	metadata, appErr := uploads.GetFileMetadata(uploaded.ID, alice)
+	require.Nil(t, appErr)
+	assert.Equal(t, uploaded.Findings, metadata.Findings)
+
+	// Clean content clears the findings, which stay with the old version
+	_, appErr = uploads.ReplaceContent(uploaded.ID, uploadFileHeader(t, "script.pdf", "application/pdf", clean), alice)
+	require.Nil(t, appErr)
+	metadata, appErr = uploads.GetFileMetadata(uploaded.ID, alice)
+	require.Nil(t, appErr)
+	assert.Empty(t, metadata.Findings)
+	require.Len(t, metadata.Versions, 1)
+	assert.Len(t, metadata.Versions[0].Findings, 2)
+
+	// Overrides reject some kinds while flagging the rest
+	uploads = newUploads(services.PDFPolicyFlag, map[string]string{models.FindingJavaScript: services.PDFPolicyReject})
+	_, appErr = uploads.UploadFile(uploadFileHeader(t, "script.pdf", "application/pdf", script), alice, models.UploadOptions{})
+	require.NotNil(t, appErr)
+	assert.Equal(t, http.StatusBadRequest, appErr.Code)
+	assert.Equal(t, models.ReasonActiveContent, appErr.Reason)
+	assert.Equal(t, "javascript", appErr.Details["findings"])
+
+	uploaded, appErr = uploads.UploadFile(uploadFileHeader(t, "report.pdf", "application/pdf", textPDF(t, "BT (Hello) Tj ET", false)), alice, models.UploadOptions{})
+	require.Nil(t, appErr)
+	assert.Equal(t, []string{models.FindingMalformedXref}, findingKinds(uploaded.Findings))
+
+	_, appErr = uploads.ReplaceContent(uploaded.ID, uploadFileHeader(t, "report.pdf", "application/pdf", script), alice)
+	require.NotNil(t, appErr)
+	assert.Equal(t, models.ReasonActiveContent, appErr.Reason)
+
+	uploads = newUploads(services.PDFPolicyAllow, nil)
+	uploaded, appErr = uploads.UploadFile(uploadFileHeader(t, "script.pdf", "application/pdf", script), alice, models.UploadOptions{})
+	require.Nil(t, appErr)
+	assert.Empty(t, uploaded.Findings)
+
+	// Running out of budget rejects the file even where malformed files
+	// are allowed, so a bomb can't hide what follows it
+	uploads = newUploads(services.PDFPolicyReject, map[string]string{models.FindingMalformedXref: services.PDFPolicyFlag})
+	_, appErr = uploads.UploadFile(uploadFileHeader(t, "bomb.pdf", "application/pdf", bombPDF(t, 5, "<< /S /JavaScript /JS (x) >>")), alice, models.UploadOptions{})
+	require.NotNil(t, appErr)
+	assert.Equal(t, models.ReasonActiveContent, appErr.Reason)
+	assert.Equal(t, "javascript,limit_exceeded", appErr.Details["findings"])
+
+	uploads = newUploads(services.PDFPolicyFlag, nil)
+	_, appErr = uploads.UploadFile(uploadFileHeader(t, "bomb.pdf", "application/pdf", bombPDF(t, 5)), alice, models.UploadOptions{})
+	require.NotNil(t, appErr)
+	assert.Equal(t, "limit_exceeded", appErr.Details["findings"])
+
+	// A policy that isn't recognized fails closed
+	uploads = newUploads("rejct", nil)
+	_, appErr = uploads.UploadFile(uploadFileHeader(t, "script.pdf", "application/pdf", script), alice, models.UploadOptions{})
+	require.NotNil(t, appErr)
+	assert.Equal(t, models.ReasonActiveContent, appErr.Reason)
+}
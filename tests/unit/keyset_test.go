@@ -0,0 +1,301 @@
+package unit
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ebinskryfon/fileuploader/config"
+	"github.com/ebinskryfon/fileuploader/services"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type jwksServer struct {
+	mu       sync.Mutex
+	keys     []map[string]string
+	requests int
+	// status, when set, fails requests; gate, when set, holds them until
+	// it is closed.
+	status int
+	gate   chan struct{}
+}
+
+func (s *jwksServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	s.requests++
+	keys, status, gate := s.keys, s.status, s.gate
+	s.mu.Unlock()
+
+	if gate != nil {
+		<-gate
+	}
+	if status != 0 {
+		w.WriteHeader(status)
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{"keys": keys})
+}
+
+func (s *jwksServer) setKeys(keys ...map[string]string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.keys = keys
+}
+
+func (s *jwksServer) requestCount() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.requests
+}
+
+func rsaJWK(kid string, key *rsa.PrivateKey) map[string]string {
+	return map[string]string{
+		"kty": "RSA",
+		"kid": kid,
+		"use": "sig",
+		"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+		"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+	}
+}
+
+func ecJWK(kid string, key *ecdsa.PrivateKey) map[string]string {
+	return map[string]string{
+		"kty": "EC",
+		"kid": kid,
+		"crv": "P-256",
+		"x":   base64.RawURLEncoding.EncodeToString(key.X.FillBytes(make([]byte, 32))),
+		"y":   base64.RawURLEncoding.EncodeToString(key.Y.FillBytes(make([]byte, 32))),
+	}
+}
+
+func signToken(t *testing.T, method jwt.SigningMethod, kid string, key interface{}, userID string) string {
+	claims := &services.Claims{
+		UserID: userID,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+		},
+	}
+	token := jwt.NewWithClaims(method, claims)
+	if kid != "" {
+		token.Header["kid"] = kid
+	}
+	signed, err := token.SignedString(key)
+	require.NoError(t, err)
+	return signed
+}
+
+func newJWKSAuthService(t *testing.T, url string) *services.AuthService {
+	cfg := &config.Config{}
+	cfg.Auth.JWTSecret = "test-secret-key"
+	cfg.Auth.TokenExpiration = time.Hour
+	cfg.Auth.JWKSURL = url
+	cfg.Auth.JWKSRefreshInterval = time.Hour
+
+	keySet, err := services.NewKeySet(cfg)
+	require.NoError(t, err)
+	return services.NewAuthService(cfg).WithKeySet(keySet)
+}
+
+func TestAuthService_JWKS(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	jwksSrv := &jwksServer{}
+	jwksSrv.setKeys(rsaJWK("rsa-1", rsaKey), ecJWK("ec-1", ecKey))
+	server := httptest.NewServer(jwksSrv)
+	defer server.Close()
+
+	authService := newJWKSAuthService(t, server.URL)
+
+	t.Run("RS256 with kid", func(t *testing.T) {
+		claims, err := authService.ValidateToken(signToken(t, jwt.SigningMethodRS256, "rsa-1", rsaKey, "rsa-user"))
+		require.NoError(t, err)
+		assert.Equal(t, "rsa-user", claims.UserID)
+	})
+
+	t.Run("ES256 with kid", func(t *testing.T) {
+		claims, err := authService.ValidateToken(signToken(t, jwt.SigningMethodES256, "ec-1", ecKey, "ec-user"))
+		require.NoError(t, err)
+		assert.Equal(t, "ec-user", claims.UserID)
+	})
+
+	t.Run("without kid tries matching keys", func(t *testing.T) {
+		claims, err := authService.ValidateToken(signToken(t, jwt.SigningMethodRS256, "", rsaKey, "no-kid"))
+		require.NoError(t, err)
+		assert.Equal(t, "no-kid", claims.UserID)
+	})
+
+	t.Run("kid pointing at wrong key type", func(t *testing.T) {
+		_, err := authService.ValidateToken(signToken(t, jwt.SigningMethodRS256, "ec-1", rsaKey, "mismatch"))
+		assert.Error(t, err)
+	})
+
+	t.Run("HS256 still accepted", func(t *testing.T) {
+		token, err := authService.GenerateToken("hmac-user")
+		require.NoError(t, err)
+		claims, err := authService.ValidateToken(token)
+		require.NoError(t, err)
+		assert.Equal(t, "hmac-user", claims.UserID)
+	})
+}
+
+func TestAuthService_JWKSRotation(t *testing.T) {
+	oldKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	newKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	jwksSrv := &jwksServer{}
+	jwksSrv.setKeys(rsaJWK("old", oldKey))
+	server := httptest.NewServer(jwksSrv)
+	defer server.Close()
+
+	authService := newJWKSAuthService(t, server.URL)
+
+	// The provider starts publishing both keys; an unknown kid triggers a refetch
+	jwksSrv.setKeys(rsaJWK("old", oldKey), rsaJWK("new", newKey))
+
+	_, err = authService.ValidateToken(signToken(t, jwt.SigningMethodRS256, "new", newKey, "user"))
+	require.NoError(t, err)
+	_, err = authService.ValidateToken(signToken(t, jwt.SigningMethodRS256, "old", oldKey, "user"))
+	require.NoError(t, err)
+
+	// Unknown kids are throttled rather than refetched on every request
+	requests := jwksSrv.requestCount()
+	_, err = authService.ValidateToken(signToken(t, jwt.SigningMethodRS256, "bogus", newKey, "user"))
+	assert.Error(t, err)
+	assert.Equal(t, requests, jwksSrv.requestCount())
+}
+
+func newRefreshingKeySet(t *testing.T, url string) *services.KeySet {
+	cfg := &config.Config{}
+	cfg.Auth.JWKSURL = url
+	cfg.Auth.JWKSRefreshInterval = time.Nanosecond
+
+	keySet, err := services.NewKeySet(cfg)
+	require.NoError(t, err)
+	return keySet
+}
+
+func TestKeySet_RefreshDoesNotBlockLookups(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	jwksSrv := &jwksServer{}
+	jwksSrv.setKeys(rsaJWK("rsa-1", key))
+	server := httptest.NewServer(jwksSrv)
+	defer server.Close()
+	keySet := newRefreshingKeySet(t, server.URL)
+
+	// The provider hangs, but cached keys are served without waiting for it
+	release := make(chan struct{})
+	jwksSrv.mu.Lock()
+	jwksSrv.gate = release
+	jwksSrv.mu.Unlock()
+	defer close(release)
+
+	start := time.Now()
+	for i := 0; i < 20; i++ {
+		keys, err := keySet.Lookup("rsa-1")
+		require.NoError(t, err)
+		assert.Len(t, keys, 1)
+	}
+	assert.Less(t, time.Since(start), time.Second)
+
+	// Concurrent refreshes share a single fetch
+	require.Eventually(t, func() bool { return jwksSrv.requestCount() == 2 }, time.Second, 5*time.Millisecond)
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, 2, jwksSrv.requestCount())
+}
+
+func TestKeySet_FailedRefreshBacksOff(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	jwksSrv := &jwksServer{}
+	jwksSrv.setKeys(rsaJWK("rsa-1", key))
+	server := httptest.NewServer(jwksSrv)
+	defer server.Close()
+	keySet := newRefreshingKeySet(t, server.URL)
+
+	jwksSrv.mu.Lock()
+	jwksSrv.status = http.StatusServiceUnavailable
+	jwksSrv.mu.Unlock()
+
+	_, err = keySet.Lookup("rsa-1")
+	require.NoError(t, err)
+	require.Eventually(t, func() bool { return jwksSrv.requestCount() == 2 }, time.Second, 5*time.Millisecond)
+
+	// The cached keys keep working, and neither stale keys nor unknown kids
+	// refetch until the backoff passes
+	time.Sleep(20 * time.Millisecond)
+	for i := 0; i < 20; i++ {
+		_, err := keySet.Lookup("rsa-1")
+		require.NoError(t, err)
+	}
+	_, err = keySet.Lookup("rotated")
+	assert.Error(t, err)
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, 2, jwksSrv.requestCount())
+}
+
+func TestKeySet_RejectsOversizedJWKS(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"keys": [], "padding": "`))
+		w.Write(bytes.Repeat([]byte("x"), 2<<20))
+		w.Write([]byte(`"}`))
+	}))
+	defer server.Close()
+
+	cfg := &config.Config{}
+	cfg.Auth.JWKSURL = server.URL
+	_, err := services.NewKeySet(cfg)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "larger than")
+}
+
+func TestAuthService_StaticPEMKeys(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	require.NoError(t, err)
+	path := filepath.Join(t.TempDir(), "idp-2026.pem")
+	require.NoError(t, os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), 0600))
+
+	cfg := &config.Config{}
+	cfg.Auth.JWTSecret = "test-secret-key"
+	cfg.Auth.PublicKeyFiles = []string{path}
+	keySet, err := services.NewKeySet(cfg)
+	require.NoError(t, err)
+	authService := services.NewAuthService(cfg).WithKeySet(keySet)
+
+	claims, err := authService.ValidateToken(signToken(t, jwt.SigningMethodRS256, "idp-2026", key, "pem-user"))
+	require.NoError(t, err)
+	assert.Equal(t, "pem-user", claims.UserID)
+
+	// Asymmetric tokens are rejected when no keys are configured
+	plain := services.NewAuthService(cfg)
+	_, err = plain.ValidateToken(signToken(t, jwt.SigningMethodRS256, "idp-2026", key, "pem-user"))
+	assert.Error(t, err)
+}
@@ -0,0 +1,183 @@
+package unit
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"testing"
+
+	"github.com/ebinskryfon/fileuploader/models"
+	"github.com/ebinskryfon/fileuploader/services"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func grant(t *testing.T, uploads *services.UploadService, fileID string, owner models.Principal, granteeType, granteeID, permission string) {
+	_, appErr := uploads.GrantPermission(fileID, owner, models.GrantPermissionRequest{
+		Type: granteeType, ID: granteeID, Permission: permission,
+	})
+	require.Nil(t, appErr)
+}
+
+func TestUploadService_FilePermissions(t *testing.T) {
+	uploads := newUploadService(t, nil)
+	alice := models.Principal{UserID: "alice"}
+	bob := models.Principal{UserID: "bob"}
+	carol := models.Principal{UserID: "carol", Groups: []string{"finance"}}
+	dave := models.Principal{UserID: "dave", Groups: []string{"auditors"}}
+	mallory := models.Principal{UserID: "mallory", Groups: []string{"sales"}}
+
+	uploaded, appErr := uploads.UploadFile(pdfFileHeader(t), alice, models.UploadOptions{})
+	require.Nil(t, appErr)
+	grant(t, uploads, uploaded.ID, alice, models.ACLTypeUser, "bob", models.PermissionRead)
+	grant(t, uploads, uploaded.ID, alice, models.ACLTypeGroup, "finance", models.PermissionReadWrite)
+	grant(t, uploads, uploaded.ID, alice, models.ACLTypeGroup, "auditors", models.PermissionRead)
+
+	// Every grantee can read, users and groups alike, but only the owner
+	// sees the ACL
+	file, metadata, appErr := uploads.GetFile(uploaded.ID, alice)
+	require.Nil(t, appErr)
+	file.Close()
+	assert.Len(t, metadata.Permissions, 3)
+	for _, principal := range []models.Principal{bob, carol, dave} {
+		file, metadata, appErr := uploads.GetFile(uploaded.ID, principal)
+		require.Nil(t, appErr, principal.UserID)
+		file.Close()
+		assert.Empty(t, metadata.Permissions, principal.UserID)
+	}
+
+	// Read-only grantees are refused changes; strangers don't see the file
+	for _, principal := range []models.Principal{bob, dave} {
+		assert.Equal(t, models.ErrPermissionDenied, uploads.DeleteFile(uploaded.ID, principal), principal.UserID)
+	}
+	_, _, appErr = uploads.GetFile(uploaded.ID, mallory)
+	assert.Equal(t, models.ErrFileNotFound, appErr)
+	assert.Equal(t, models.ErrFileNotFound, uploads.DeleteFile(uploaded.ID, mallory))
+
+	// Group members with read+write still can't share the file
+	_, appErr = uploads.GrantPermission(uploaded.ID, carol, models.GrantPermissionRequest{
+		Type: models.ACLTypeUser, ID: "mallory", Permission: models.PermissionRead,
+	})
+	assert.Equal(t, models.ErrPermissionDenied, appErr)
+	_, appErr = uploads.RevokePermission(uploaded.ID, carol, models.ACLTypeGroup, "auditors")
+	assert.Equal(t, models.ErrPermissionDenied, appErr)
+
+	// Re-granting replaces the earlier permission
+	grant(t, uploads, uploaded.ID, alice, models.ACLTypeUser, "bob", models.PermissionReadWrite)
+	_, metadata, appErr = uploads.GetFile(uploaded.ID, alice)
+	require.Nil(t, appErr)
+	assert.Len(t, metadata.Permissions, 3)
+
+	// Revoking takes access away again
+	permissions, appErr := uploads.RevokePermission(uploaded.ID, alice, models.ACLTypeUser, "bob")
+	require.Nil(t, appErr)
+	assert.Len(t, permissions, 2)
+	_, _, appErr = uploads.GetFile(uploaded.ID, bob)
+	assert.Equal(t, models.ErrFileNotFound, appErr)
+
+	_, appErr = uploads.RevokePermission(uploaded.ID, alice, models.ACLTypeUser, "bob")
+	require.NotNil(t, appErr)
+	assert.Equal(t, http.StatusNotFound, appErr.Code)
+
+	// Write access lets a group member delete the file
+	require.Nil(t, uploads.DeleteFile(uploaded.ID, carol))
+	_, _, appErr = uploads.GetFile(uploaded.ID, alice)
+	assert.Equal(t, models.ErrFileNotFound, appErr)
+}
+
+func TestUploadService_GrantPermissionValidation(t *testing.T) {
+	uploads := newUploadService(t, nil)
+	alice := models.Principal{UserID: "alice"}
+
+	uploaded, appErr := uploads.UploadFile(pdfFileHeader(t), alice, models.UploadOptions{})
+	require.Nil(t, appErr)
+
+	for name, req := range map[string]models.GrantPermissionRequest{
+		"unknown type":       {Type: "role", ID: "admins", Permission: models.PermissionRead},
+		"unknown permission": {Type: models.ACLTypeUser, ID: "bob", Permission: "write"},
+		"owner":              {Type: models.ACLTypeUser, ID: "alice", Permission: models.PermissionRead},
+	} {
+		_, appErr := uploads.GrantPermission(uploaded.ID, alice, req)
+		require.NotNil(t, appErr, name)
+		assert.Equal(t, http.StatusBadRequest, appErr.Code, name)
+	}
+
+	_, appErr = uploads.GrantPermission(uploaded.ID, models.Principal{UserID: "bob"}, models.GrantPermissionRequest{
+		Type: models.ACLTypeUser, ID: "carol", Permission: models.PermissionRead,
+	})
+	assert.Equal(t, models.ErrFileNotFound, appErr)
+}
+
+func TestUploadService_ListSharedWithMe(t *testing.T) {
+	uploads := newUploadService(t, nil)
+	alice := models.Principal{UserID: "alice"}
+	bob := models.Principal{UserID: "bob", Groups: []string{"finance"}}
+
+	direct, appErr := uploads.UploadFile(pdfFileHeader(t), alice, models.UploadOptions{})
+	require.Nil(t, appErr)
+	viaGroup, appErr := uploads.UploadFile(pdfFileHeader(t), alice, models.UploadOptions{})
+	require.Nil(t, appErr)
+	_, appErr = uploads.UploadFile(pdfFileHeader(t), alice, models.UploadOptions{})
+	require.Nil(t, appErr)
+	own, appErr := uploads.UploadFile(pdfFileHeader(t), bob, models.UploadOptions{})
+	require.Nil(t, appErr)
+
+	grant(t, uploads, direct.ID, alice, models.ACLTypeUser, "bob", models.PermissionRead)
+	grant(t, uploads, viaGroup.ID, alice, models.ACLTypeGroup, "finance", models.PermissionReadWrite)
+
+	shared, appErr := uploads.ListSharedWithMe(bob)
+	require.Nil(t, appErr)
+	ids := make([]string, 0, len(shared))
+	for _, file := range shared {
+		ids = append(ids, file.ID)
+		assert.Empty(t, file.Permissions)
+	}
+	assert.ElementsMatch(t, []string{direct.ID, viaGroup.ID}, ids)
+
+	// Bob's own files are listed separately
+	files, appErr := uploads.ListFiles(bob)
+	require.Nil(t, appErr)
+	require.Len(t, files, 1)
+	assert.Equal(t, own.ID, files[0].ID)
+
+	shared, appErr = uploads.ListSharedWithMe(alice)
+	require.Nil(t, appErr)
+	assert.Empty(t, shared)
+}
+
+func TestUploadService_GrantDuringReplace(t *testing.T) {
+	uploads := newUploadService(t, nil)
+	alice := models.Principal{UserID: "alice"}
+
+	uploaded, appErr := uploads.UploadFile(pdfFileHeader(t), alice, models.UploadOptions{})
+	require.Nil(t, appErr)
+
+	// Neither change may write back metadata the other has replaced
+	const rounds = 20
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for range rounds {
+			_, appErr := uploads.ReplaceContent(uploaded.ID, pdfFileHeader(t), alice)
+			assert.Nil(t, appErr)
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := range rounds {
+			_, appErr := uploads.GrantPermission(uploaded.ID, alice, models.GrantPermissionRequest{
+				Type: models.ACLTypeUser, ID: fmt.Sprintf("user-%d", i), Permission: models.PermissionRead,
+			})
+			assert.Nil(t, appErr)
+		}
+	}()
+	wg.Wait()
+
+	metadata, appErr := uploads.GetFileMetadata(uploaded.ID, alice)
+	require.Nil(t, appErr)
+	assert.Equal(t, rounds+1, metadata.Version)
+	assert.Len(t, metadata.Versions, rounds)
+	assert.Len(t, metadata.Permissions, rounds)
+}
@@ -0,0 +1,189 @@
+package unit
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/ebinskryfon/fileuploader/config"
+	"github.com/ebinskryfon/fileuploader/models"
+	"github.com/ebinskryfon/fileuploader/services"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// pagedPDF has a page tree of pages pages and an information dictionary.
+// The page tree root is packed in a compressed object stream, as PDF 1.5
+// writers do.
+func pagedPDF(t *testing.T, pages int, trailer string) []byte {
+	var kids, objects bytes.Buffer
+	for i := 0; i < pages; i++ {
+		fmt.Fprintf(&kids, "%d 0 R ", 10+i)
+		fmt.Fprintf(&objects, "%d 0 obj\n<< /Type /Page /Parent 2 0 R >>\nendobj\n", 10+i)
+	}
+
+	tree := fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", kids.String(), pages)
+	var packed bytes.Buffer
+	w := zlib.NewWriter(&packed)
+	fmt.Fprintf(w, "2 0 %s", tree)
+	require.NoError(t, w.Close())
+
+	var pdf bytes.Buffer
+	pdf.WriteString("%PDF-1.5\n1 0 obj\n<< /Type /Catalog /Pages 2 0 R >>\nendobj\n")
+	pdf.WriteString("3 0 obj\n<< /Title (Quarterly report) /Author <FEFF0041006E006E0065> >>\nendobj\n")
+	fmt.Fprintf(&pdf, "5 0 obj\n<< /Type /ObjStm /N 1 /First 4 /Length %d /Filter /FlateDecode >>\nstream\n", packed.Len())
+	pdf.Write(packed.Bytes())
+	pdf.WriteString("\nendstream\nendobj\n")
+	pdf.Write(objects.Bytes())
+	fmt.Fprintf(&pdf, "trailer\n<< /Root 1 0 R /Info 3 0 R %s >>\n%%%%EOF\n", trailer)
+	return pdf.Bytes()
+}
+
+func propertiesDOCX(t *testing.T) []byte {
+	var buf bytes.Buffer
+	archive := zip.NewWriter(&buf)
+	w, err := archive.Create("[Content_Types].xml")
+	require.NoError(t, err)
+	fmt.Fprint(w, `<?xml version="1.0" encoding="UTF-8"?><Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types">`+
+		`<Override PartName="/word/document.xml" ContentType="application/vnd.openxmlformats-officedocument.wordprocessingml.document.main+xml"/></Types>`)
+
+	w, err = archive.Create("docProps/core.xml")
+	require.NoError(t, err)
+	fmt.Fprint(w, `<?xml version="1.0" encoding="UTF-8"?><cp:coreProperties`+
+		` xmlns:cp="http://schemas.openxmlformats.org/package/2006/metadata/core-properties"`+
+		` xmlns:dc="http://purl.org/dc/elements/1.1/" xmlns:dcterms="http://purl.org/dc/terms/"`+
+		` xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance">`+
+		`<dc:title>Minutes</dc:title><dc:creator>Jane Smith</dc:creator><cp:keywords>board, 2024</cp:keywords>`+
+		`<cp:lastModifiedBy>Bob</cp:lastModifiedBy><cp:revision>3</cp:revision>`+
+		`<dcterms:created xsi:type="dcterms:W3CDTF">2024-03-01T09:30:00Z</dcterms:created>`+
+		`</cp:coreProperties>`)
+
+	w, err = archive.Create("word/document.xml")
+	require.NoError(t, err)
+	fmt.Fprint(w, `<?xml version="1.0" encoding="UTF-8"?><w:document xmlns:w="http://schemas.openxmlformats.org/wordprocessingml/2006/main"><w:body>`+
+		`<w:p><w:r><w:t>The board met</w:t></w:r></w:p><w:p><w:r><w:t>at nine.</w:t></w:r></w:p></w:body></w:document>`)
+	require.NoError(t, archive.Close())
+	return buf.Bytes()
+}
+
+func grayJPEG(t *testing.T, width, height int) []byte {
+	var buf bytes.Buffer
+	require.NoError(t, jpeg.Encode(&buf, image.NewGray(image.Rect(0, 0, width, height)), nil))
+	return buf.Bytes()
+}
+
+func TestExtractProperties(t *testing.T) {
+	properties, err := services.ExtractProperties("image/png", encodePNG(t))
+	require.NoError(t, err)
+	assert.Equal(t, &models.ImageProperties{Width: 8, Height: 8, ColorModel: "rgba"}, properties.Image)
+
+	properties, err = services.ExtractProperties("image/jpeg", grayJPEG(t, 40, 20))
+	require.NoError(t, err)
+	assert.Equal(t, &models.ImageProperties{Width: 40, Height: 20, ColorModel: "gray"}, properties.Image)
+
+	properties, err = services.ExtractProperties("application/pdf", pagedPDF(t, 3, ""))
+	require.NoError(t, err)
+	assert.Equal(t, &models.PDFProperties{Pages: 3, Title: "Quarterly report", Author: "Anne"}, properties.PDF)
+
+	// Encrypted strings aren't readable
+	properties, err = services.ExtractProperties("application/pdf", pagedPDF(t, 2, "/Encrypt 9 0 R"))
+	require.NoError(t, err)
+	assert.Equal(t, &models.PDFProperties{Pages: 2, Encrypted: true}, properties.PDF)
+
+	properties, err = services.ExtractProperties("application/pdf", textPDF(t, "BT (Hello) Tj ET", false))
+	require.NoError(t, err)
+	assert.Equal(t, &models.PDFProperties{}, properties.PDF)
+
+	properties, err = services.ExtractProperties(docxType, propertiesDOCX(t))
+	require.NoError(t, err)
+	created := time.Date(2024, 3, 1, 9, 30, 0, 0, time.UTC)
+	assert.Equal(t, &models.DocumentProperties{
+		Title:          "Minutes",
+		Creator:        "Jane Smith",
+		Keywords:       "board, 2024",
+		LastModifiedBy: "Bob",
+		Revision:       "3",
+		Created:        &created,
+		Words:          5,
+	}, properties.Document)
+
+	properties, err = services.ExtractProperties("text/plain", []byte("hello"))
+	require.NoError(t, err)
+	assert.Nil(t, properties)
+
+	_, err = services.ExtractProperties("image/png", []byte("not a png"))
+	assert.Error(t, err)
+}
+
+func TestExtractProperties_UnterminatedStreams(t *testing.T) {
+	// Streams whose length is wrong and which never end used to send the
+	// parser searching to the end of the file once per stream
+	data := append([]byte("%PDF-1.4\n"), bytes.Repeat([]byte("1 0 obj << /Length 5 >>\nstream\n"), 16000)...)
+
+	start := time.Now()
+	_, err := services.ExtractProperties("application/pdf", data)
+	assert.Error(t, err)
+	services.InspectPDF(data)
+	assert.Less(t, time.Since(start), 2*time.Second)
+}
+
+func TestUploadService_ListFilesByProperty(t *testing.T) {
+	uploads := newUploadService(t, func(cfg *config.Config) {
+		cfg.Upload.AllowedTypes = []string{"image/jpeg", "application/pdf"}
+	})
+	alice := models.Principal{UserID: "alice"}
+
+	wide, appErr := uploads.UploadFile(uploadFileHeader(t, "wide.jpg", "image/jpeg", grayJPEG(t, 64, 16)), alice, models.UploadOptions{})
+	require.Nil(t, appErr)
+	_, appErr = uploads.UploadFile(uploadFileHeader(t, "small.jpg", "image/jpeg", grayJPEG(t, 16, 16)), alice, models.UploadOptions{})
+	require.Nil(t, appErr)
+	_, appErr = uploads.UploadFile(uploadFileHeader(t, "report.pdf", "application/pdf", pagedPDF(t, 3, "")), alice, models.UploadOptions{})
+	require.Nil(t, appErr)
+
+	// Content whose properties can't be read is still accepted
+	broken, appErr := uploads.UploadFile(uploadFileHeader(t, "broken.pdf", "application/pdf", []byte("%PDF-1.4\n%%EOF\n")), alice, models.UploadOptions{})
+	require.Nil(t, appErr)
+
+	metadata, appErr := uploads.GetFileMetadata(wide.ID, alice)
+	require.Nil(t, appErr)
+	assert.Equal(t, &models.ImageProperties{Width: 64, Height: 16, ColorModel: "gray"}, metadata.Properties.Image)
+	metadata, appErr = uploads.GetFileMetadata(broken.ID, alice)
+	require.Nil(t, appErr)
+	assert.Nil(t, metadata.Properties)
+
+	list := func(expressions ...string) []string {
+		var filters []models.PropertyFilter
+		for _, expression := range expressions {
+			filter, appErr := services.ParsePropertyFilter(expression)
+			require.Nil(t, appErr)
+			filters = append(filters, filter)
+		}
+		files, appErr := uploads.ListFiles(alice, filters...)
+		require.Nil(t, appErr)
+		var names []string
+		for _, file := range files {
+			names = append(names, file.OriginalName)
+		}
+		return names
+	}
+
+	assert.Len(t, list(), 4)
+	assert.Equal(t, []string{"wide.jpg"}, list("image.width>=32"))
+	assert.ElementsMatch(t, []string{"wide.jpg", "small.jpg"}, list("image.height=16", "image.color_model=GRAY"))
+	assert.Equal(t, []string{"report.pdf"}, list("pdf.pages>2", "pdf.title~quarterly"))
+	assert.Equal(t, []string{"report.pdf"}, list("pdf.encrypted=false"))
+	assert.Empty(t, list("document.words>0"))
+
+	for _, expression := range []string{"image.width", "=16", "image.depth=8", "image.width>wide"} {
+		_, appErr := services.ParsePropertyFilter(expression)
+		require.NotNil(t, appErr, expression)
+		assert.Equal(t, http.StatusBadRequest, appErr.Code)
+	}
+}
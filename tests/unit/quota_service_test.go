@@ -0,0 +1,110 @@
+package unit
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"testing"
+
+	"github.com/ebinskryfon/fileuploader/config"
+	"github.com/ebinskryfon/fileuploader/models"
+	"github.com/ebinskryfon/fileuploader/services"
+	"github.com/ebinskryfon/fileuploader/storage"
+	"github.com/ebinskryfon/fileuploader/utils"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newQuotaService(t *testing.T, limit config.QuotaLimit) (*services.QuotaService, *storage.LocalStorage) {
+	cfg := &config.Config{}
+	cfg.Quota.Default = limit
+	cfg.Quota.Overrides = map[string]config.QuotaLimit{
+		"vip": {MaxBytes: 0, MaxFiles: 0},
+	}
+
+	localStorage := storage.NewLocalStorage(t.TempDir())
+	return services.NewQuotaService(cfg, localStorage, utils.NewLogger()), localStorage
+}
+
+func TestQuotaService_LoadsExistingUsage(t *testing.T) {
+	quota, localStorage := newQuotaService(t, config.QuotaLimit{MaxBytes: 100, MaxFiles: 5})
+
+	require.NoError(t, localStorage.Store("file-1", bytes.NewReader(make([]byte, 40)), models.FileMetadata{
+		ID: "file-1", UserID: "alice", Size: 40,
+	}))
+
+	usage, appErr := quota.Usage("alice")
+	require.Nil(t, appErr)
+	assert.Equal(t, int64(40), usage.UsedBytes)
+	assert.Equal(t, int64(60), usage.RemainingBytes)
+	assert.Equal(t, 1, usage.FileCount)
+	assert.Equal(t, 4, usage.RemainingFiles)
+}
+
+func TestQuotaService_Reserve(t *testing.T) {
+	quota, _ := newQuotaService(t, config.QuotaLimit{MaxBytes: 100, MaxFiles: 2})
+
+	first, appErr := quota.Reserve("alice", 60)
+	require.Nil(t, appErr)
+
+	// The pending reservation counts against the quota
+	_, appErr = quota.Reserve("alice", 50)
+	require.NotNil(t, appErr)
+	assert.Equal(t, http.StatusInsufficientStorage, appErr.Code)
+
+	first.Commit(60)
+
+	second, appErr := quota.Reserve("alice", 40)
+	require.Nil(t, appErr)
+	second.Commit(40)
+
+	_, appErr = quota.Reserve("alice", 0)
+	assert.Equal(t, models.ErrFileCountExceeded, appErr)
+
+	quota.Remove("alice", 60)
+	usage, appErr := quota.Usage("alice")
+	require.Nil(t, appErr)
+	assert.Equal(t, int64(40), usage.UsedBytes)
+	assert.Equal(t, 1, usage.FileCount)
+}
+
+func TestQuotaService_ReleaseDropsReservation(t *testing.T) {
+	quota, _ := newQuotaService(t, config.QuotaLimit{MaxBytes: 100})
+
+	reservation, appErr := quota.Reserve("alice", 80)
+	require.Nil(t, appErr)
+	reservation.Release()
+	reservation.Commit(80) // no-op after release
+
+	usage, appErr := quota.Usage("alice")
+	require.Nil(t, appErr)
+	assert.Equal(t, int64(0), usage.UsedBytes)
+	assert.Equal(t, -1, usage.RemainingFiles)
+}
+
+func TestQuotaService_ReaderStopsAtRemaining(t *testing.T) {
+	quota, _ := newQuotaService(t, config.QuotaLimit{MaxBytes: 10})
+
+	// Declared size fits, but the stream carries more than the quota allows
+	reservation, appErr := quota.Reserve("alice", 5)
+	require.Nil(t, appErr)
+	defer reservation.Release()
+
+	_, err := io.ReadAll(reservation.Reader(bytes.NewReader(make([]byte, 11))))
+	assert.Error(t, err)
+}
+
+func TestQuotaService_Overrides(t *testing.T) {
+	quota, _ := newQuotaService(t, config.QuotaLimit{MaxBytes: 10, MaxFiles: 1})
+
+	assert.Equal(t, config.QuotaLimit{MaxBytes: 10, MaxFiles: 1}, quota.Limit("alice"))
+
+	reservation, appErr := quota.Reserve("vip", 1000)
+	require.Nil(t, appErr)
+	reservation.Commit(1000)
+
+	usage, appErr := quota.Usage("vip")
+	require.Nil(t, appErr)
+	assert.Equal(t, int64(-1), usage.RemainingBytes)
+}
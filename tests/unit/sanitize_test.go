@@ -0,0 +1,177 @@
+package unit
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"testing"
+
+	"github.com/ebinskryfon/fileuploader/config"
+	"github.com/ebinskryfon/fileuploader/models"
+	"github.com/ebinskryfon/fileuploader/services"
+	"github.com/ebinskryfon/fileuploader/utils"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testImage() image.Image {
+	img := image.NewRGBA(image.Rect(0, 0, 8, 8))
+	for x := 0; x < 8; x++ {
+		for y := 0; y < 8; y++ {
+			img.Set(x, y, color.RGBA{uint8(x * 32), uint8(y * 32), 128, 255})
+		}
+	}
+	return img
+}
+
+func encodeJPEG(t *testing.T) []byte {
+	var buf bytes.Buffer
+	require.NoError(t, jpeg.Encode(&buf, testImage(), nil))
+	return buf.Bytes()
+}
+
+func encodePNG(t *testing.T) []byte {
+	var buf bytes.Buffer
+	require.NoError(t, png.Encode(&buf, testImage()))
+	return buf.Bytes()
+}
+
+func jpegSegment(marker byte, payload string) []byte {
+	segment := []byte{0xFF, marker, 0, 0}
+	binary.BigEndian.PutUint16(segment[2:], uint16(len(payload)+2))
+	return append(segment, payload...)
+}
+
+// withJPEGMetadata inserts EXIF with GPS, XMP, IPTC and a comment after the
+// start of image marker.
+func withJPEGMetadata(clean []byte) []byte {
+	var tagged bytes.Buffer
+	tagged.Write(clean[:2])
+	tagged.Write(jpegSegment(0xE1, "Exif\x00\x00MM\x00*GPSLatitude=52.37;SerialNumber=X100"))
+	tagged.Write(jpegSegment(0xE1, "http://ns.adobe.com/xap/1.0/\x00<x:xmpmeta/>"))
+	tagged.Write(jpegSegment(0xED, "Photoshop 3.0\x008BIM"))
+	tagged.Write(jpegSegment(0xFE, "taken at home"))
+	tagged.Write(clean[2:])
+	return tagged.Bytes()
+}
+
+func pngChunk(chunkType, data string) []byte {
+	chunk := make([]byte, 8, 12+len(data))
+	binary.BigEndian.PutUint32(chunk, uint32(len(data)))
+	copy(chunk[4:], chunkType)
+	chunk = append(chunk, data...)
+	return binary.BigEndian.AppendUint32(chunk, crc32.ChecksumIEEE(chunk[4:]))
+}
+
+// withPNGMetadata inserts text and EXIF chunks before IEND, plus trailing
+// data after it.
+func withPNGMetadata(clean []byte) []byte {
+	iend := len(clean) - 12
+	var tagged bytes.Buffer
+	tagged.Write(clean[:iend])
+	tagged.Write(pngChunk("tEXt", "Author\x00Alice"))
+	tagged.Write(pngChunk("eXIf", "MM\x00*GPS"))
+	tagged.Write(pngChunk("tIME", "\x07\xea\x0a\x12\x0c\x00\x00"))
+	tagged.Write(clean[iend:])
+	tagged.WriteString("trailing")
+	return tagged.Bytes()
+}
+
+func TestStripImageMetadata(t *testing.T) {
+	cleanJPEG, cleanPNG := encodeJPEG(t), encodePNG(t)
+
+	stripped, err := services.StripImageMetadata("image/jpeg", withJPEGMetadata(cleanJPEG))
+	require.NoError(t, err)
+	assert.Equal(t, cleanJPEG, stripped)
+
+	stripped, err = services.StripImageMetadata("image/png", withPNGMetadata(cleanPNG))
+	require.NoError(t, err)
+	assert.Equal(t, cleanPNG, stripped)
+
+	// A second image after the end of the first goes, EXIF and all
+	stripped, err = services.StripImageMetadata("image/jpeg", append(bytes.Clone(cleanJPEG), withJPEGMetadata(cleanJPEG)...))
+	require.NoError(t, err)
+	assert.Equal(t, cleanJPEG, stripped)
+
+	// A file cut off mid-scan is kept as it is
+	truncated := cleanJPEG[:len(cleanJPEG)-2]
+	stripped, err = services.StripImageMetadata("image/jpeg", truncated)
+	require.NoError(t, err)
+	assert.Equal(t, truncated, stripped)
+
+	// Color information is kept
+	withICC := append(append(bytes.Clone(cleanJPEG[:2]), jpegSegment(0xE2, "ICC_PROFILE\x00\x01\x01profile")...), cleanJPEG[2:]...)
+	stripped, err = services.StripImageMetadata("image/jpeg", withICC)
+	require.NoError(t, err)
+	assert.Equal(t, withICC, stripped)
+
+	// Other types pass through untouched
+	stripped, err = services.StripImageMetadata("application/pdf", testPDF)
+	require.NoError(t, err)
+	assert.Equal(t, testPDF, stripped)
+
+	_, err = services.StripImageMetadata("image/jpeg", []byte{0xFF, 0xD8, 0xFF, 0xE1, 0xFF, 0xFF, 0x00})
+	assert.Error(t, err)
+	_, err = services.StripImageMetadata("image/png", cleanPNG[:len(cleanPNG)-20])
+	assert.Error(t, err)
+}
+
+func TestUploadService_StripsImageMetadata(t *testing.T) {
+	uploads := newUploadService(t, func(cfg *config.Config) {
+		cfg.Upload.AllowedTypes = []string{"image/jpeg", "image/png"}
+		cfg.Upload.StripMetadataTypes = []string{"image/jpeg"}
+		cfg.Tenants = map[string]config.TenantConfig{
+			"studio": {StripMetadataTypes: []string{}},
+		}
+	})
+	alice := models.Principal{UserID: "alice"}
+
+	clean := encodeJPEG(t)
+	tagged := withJPEGMetadata(clean)
+
+	uploaded, appErr := uploads.UploadFile(uploadFileHeader(t, "photo.jpg", "image/jpeg", tagged), alice, models.UploadOptions{})
+	require.Nil(t, appErr)
+	assert.Equal(t, utils.CalculateChecksum(clean), uploaded.Checksum)
+	assert.Equal(t, utils.CalculateChecksum(tagged), uploaded.OriginalChecksum)
+	assert.Equal(t, int64(len(clean)), uploaded.Size)
+
+	file, metadata, appErr := uploads.GetFile(uploaded.ID, alice)
+	require.Nil(t, appErr)
+	content, err := io.ReadAll(file)
+	file.Close()
+	require.NoError(t, err)
+	assert.Equal(t, clean, content)
+	assert.Equal(t, utils.CalculateChecksum(tagged), metadata.OriginalChecksum)
+
+	// Replaced content is sanitized too, and versions remember both checksums
+	_, appErr = uploads.ReplaceContent(uploaded.ID, uploadFileHeader(t, "photo.jpg", "image/jpeg", tagged), alice)
+	require.Nil(t, appErr)
+	versions, appErr := uploads.ListVersions(uploaded.ID, alice)
+	require.Nil(t, appErr)
+	for _, version := range versions {
+		assert.Equal(t, utils.CalculateChecksum(tagged), version.OriginalChecksum)
+	}
+
+	// Types not listed are stored as uploaded
+	taggedPNG := withPNGMetadata(encodePNG(t))
+	uploaded, appErr = uploads.UploadFile(uploadFileHeader(t, "image.png", "image/png", taggedPNG), alice, models.UploadOptions{})
+	require.Nil(t, appErr)
+	assert.Equal(t, utils.CalculateChecksum(taggedPNG), uploaded.Checksum)
+	assert.Empty(t, uploaded.OriginalChecksum)
+
+	// A tenant can turn stripping off
+	uploaded, appErr = uploads.UploadFile(uploadFileHeader(t, "photo.jpg", "image/jpeg", tagged), models.Principal{UserID: "bob", TenantID: "studio"}, models.UploadOptions{})
+	require.Nil(t, appErr)
+	assert.Equal(t, utils.CalculateChecksum(tagged), uploaded.Checksum)
+
+	// Images that can't be parsed aren't stored with their metadata intact
+	_, appErr = uploads.UploadFile(uploadFileHeader(t, "broken.jpg", "image/jpeg", []byte{0xFF, 0xD8, 0xFF, 0xE1, 0xFF, 0xFF, 0x00}), alice, models.UploadOptions{})
+	require.NotNil(t, appErr)
+	assert.Equal(t, models.ReasonInvalidImage, appErr.Reason)
+}
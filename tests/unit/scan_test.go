@@ -0,0 +1,259 @@
+package unit
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ebinskryfon/fileuploader/config"
+	"github.com/ebinskryfon/fileuploader/models"
+	"github.com/ebinskryfon/fileuploader/services"
+	"github.com/ebinskryfon/fileuploader/storage"
+	"github.com/ebinskryfon/fileuploader/utils"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// infectedMarker makes the fake clamd report content as infected.
+const infectedMarker = "TEST-MALWARE-MARKER"
+
+// serveFakeClamd answers INSTREAM requests on listener, finding a virus in
+// content containing infectedMarker, or replying with reply when it is set.
+func serveFakeClamd(t *testing.T, listener net.Listener, reply string) {
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				reader := bufio.NewReader(conn)
+				if command, err := reader.ReadString(0); err != nil || command != "zINSTREAM\x00" {
+					return
+				}
+
+				var content bytes.Buffer
+				for {
+					var length uint32
+					if err := binary.Read(reader, binary.BigEndian, &length); err != nil {
+						return
+					}
+					if length == 0 {
+						break
+					}
+					if _, err := io.CopyN(&content, reader, int64(length)); err != nil {
+						return
+					}
+				}
+
+				switch {
+				case reply != "":
+					io.WriteString(conn, reply+"\x00")
+				case strings.Contains(content.String(), infectedMarker):
+					io.WriteString(conn, "stream: Test.Malware FOUND\x00")
+				default:
+					io.WriteString(conn, "stream: OK\x00")
+				}
+			}()
+		}
+	}()
+}
+
+func startFakeClamd(t *testing.T, reply string) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	serveFakeClamd(t, listener, reply)
+	return "tcp://" + listener.Addr().String()
+}
+
+// withScanner scans the uploads of uploads with the clamd at address and
+// returns the quarantine infected content goes to.
+func withScanner(t *testing.T, uploads *services.UploadService, address string) *storage.LocalStorage {
+	quarantine := storage.NewLocalStorage(t.TempDir())
+	uploads.WithScanner(services.NewClamdScanner(address, 5*time.Second), quarantine)
+	return quarantine
+}
+
+func infectedPDF() []byte {
+	return append(bytes.Clone(testPDF), []byte("% "+infectedMarker+"\n")...)
+}
+
+func TestClamdScanner_Scan(t *testing.T) {
+	scanner := services.NewClamdScanner(startFakeClamd(t, ""), 5*time.Second)
+
+	result, err := scanner.Scan(bytes.NewReader(testPDF))
+	require.NoError(t, err)
+	assert.False(t, result.Infected)
+
+	// Content spanning several INSTREAM chunks
+	large := append(bytes.Repeat([]byte("a"), 200*1024), infectedMarker...)
+	result, err = scanner.Scan(bytes.NewReader(large))
+	require.NoError(t, err)
+	assert.True(t, result.Infected)
+	assert.Equal(t, "Test.Malware", result.Signature)
+}
+
+func TestClamdScanner_UnixSocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "clamd.sock")
+	listener, err := net.Listen("unix", path)
+	require.NoError(t, err)
+	serveFakeClamd(t, listener, "")
+
+	result, err := services.NewClamdScanner("unix://"+path, 5*time.Second).Scan(strings.NewReader(infectedMarker))
+	require.NoError(t, err)
+	assert.True(t, result.Infected)
+}
+
+func TestClamdScanner_Errors(t *testing.T) {
+	_, err := services.NewClamdScanner(startFakeClamd(t, "INSTREAM size limit exceeded. ERROR"), 5*time.Second).
+		Scan(bytes.NewReader(testPDF))
+	assert.ErrorContains(t, err, "size limit exceeded")
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	address := listener.Addr().String()
+	listener.Close()
+	_, err = services.NewClamdScanner(address, time.Second).Scan(bytes.NewReader(testPDF))
+	assert.Error(t, err)
+}
+
+func TestUploadService_SynchronousScan(t *testing.T) {
+	uploads := newUploadService(t, nil)
+	quarantine := withScanner(t, uploads, startFakeClamd(t, ""))
+	alice := models.Principal{UserID: "alice"}
+
+	uploaded, appErr := uploads.UploadFile(pdfFileHeader(t), alice, models.UploadOptions{})
+	require.Nil(t, appErr)
+	assert.Equal(t, models.ScanStatusClean, uploaded.ScanStatus)
+
+	file, metadata, appErr := uploads.GetFile(uploaded.ID, alice)
+	require.Nil(t, appErr)
+	file.Close()
+	assert.Equal(t, models.ScanStatusClean, metadata.ScanStatus)
+	assert.NotNil(t, metadata.ScannedAt)
+
+	// Infected uploads are rejected and kept only in quarantine
+	_, appErr = uploads.UploadFile(uploadFileHeader(t, "invoice.pdf", "application/pdf", infectedPDF()), alice, models.UploadOptions{})
+	require.NotNil(t, appErr)
+	assert.Equal(t, models.ReasonInfected, appErr.Reason)
+	assert.Equal(t, "Test.Malware", appErr.Details["signature"])
+
+	files, appErr := uploads.ListFiles(alice)
+	require.Nil(t, appErr)
+	assert.Len(t, files, 1)
+
+	quarantined, err := quarantine.List()
+	require.NoError(t, err)
+	require.Len(t, quarantined, 1)
+	assert.Equal(t, models.ScanStatusInfected, quarantined[0].ScanStatus)
+	assert.Equal(t, "invoice.pdf", quarantined[0].OriginalName)
+
+	// Replacing content with an infected version is rejected too
+	_, appErr = uploads.ReplaceContent(uploaded.ID, uploadFileHeader(t, "doc.pdf", "application/pdf", infectedPDF()), alice)
+	require.NotNil(t, appErr)
+	assert.Equal(t, models.ReasonInfected, appErr.Reason)
+}
+
+func TestUploadService_ScannerUnavailable(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	address := listener.Addr().String()
+	listener.Close()
+
+	uploads := newUploadService(t, nil)
+	withScanner(t, uploads, address)
+	_, appErr := uploads.UploadFile(pdfFileHeader(t), models.Principal{UserID: "alice"}, models.UploadOptions{})
+	assert.Equal(t, models.ErrScanUnavailable, appErr)
+}
+
+func TestUploadService_BackgroundScan(t *testing.T) {
+	uploads := newUploadService(t, func(cfg *config.Config) {
+		cfg.Scan.SyncMaxSize = 10
+	})
+	quarantine := withScanner(t, uploads, startFakeClamd(t, ""))
+	alice := models.Principal{UserID: "alice"}
+
+	clean, appErr := uploads.UploadFile(pdfFileHeader(t), alice, models.UploadOptions{})
+	require.Nil(t, appErr)
+	assert.Equal(t, models.ScanStatusPending, clean.ScanStatus)
+	infected, appErr := uploads.UploadFile(uploadFileHeader(t, "invoice.pdf", "application/pdf", infectedPDF()), alice, models.UploadOptions{})
+	require.Nil(t, appErr)
+
+	// Nothing can be downloaded until scanned, but metadata is visible
+	_, _, appErr = uploads.GetFile(clean.ID, alice)
+	assert.Equal(t, models.ErrScanPending, appErr)
+	metadata, appErr := uploads.GetFileMetadata(clean.ID, alice)
+	require.Nil(t, appErr)
+	assert.Equal(t, models.ScanStatusPending, metadata.ScanStatus)
+
+	stop := uploads.StartScanning(1)
+	defer stop()
+
+	require.Eventually(t, func() bool {
+		metadata, _ := uploads.GetFileMetadata(infected.ID, alice)
+		return metadata.ScanStatus == models.ScanStatusInfected
+	}, 5*time.Second, 10*time.Millisecond)
+	require.Eventually(t, func() bool {
+		metadata, _ := uploads.GetFileMetadata(clean.ID, alice)
+		return metadata.ScanStatus == models.ScanStatusClean
+	}, 5*time.Second, 10*time.Millisecond)
+
+	file, _, appErr := uploads.GetFile(clean.ID, alice)
+	require.Nil(t, appErr)
+	file.Close()
+
+	_, _, appErr = uploads.GetFile(infected.ID, alice)
+	assert.Equal(t, models.ErrFileQuarantined, appErr)
+	metadata, appErr = uploads.GetFileMetadata(infected.ID, alice)
+	require.Nil(t, appErr)
+	assert.Equal(t, "Test.Malware", metadata.ScanSignature)
+
+	namespace, err := quarantine.Namespace("")
+	require.NoError(t, err)
+	quarantined, _, err := namespace.Retrieve(infected.ID + "-" + infected.Checksum)
+	require.NoError(t, err)
+	content, err := io.ReadAll(quarantined)
+	quarantined.Close()
+	require.NoError(t, err)
+	assert.Equal(t, infectedPDF(), content)
+}
+
+func TestUploadService_RequeueVersionScans(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.Upload.MaxFileSize = 1 << 20
+	cfg.Upload.AllowedTypes = []string{"application/pdf"}
+	cfg.Scan.SyncMaxSize = 10
+	dir := t.TempDir()
+	address := startFakeClamd(t, "")
+	alice := models.Principal{UserID: "alice"}
+
+	// Both versions are left pending when the server stops
+	uploads := services.NewUploadService(cfg, storage.NewLocalStorage(dir), utils.NewLogger())
+	withScanner(t, uploads, address)
+	uploaded, appErr := uploads.UploadFile(uploadFileHeader(t, "invoice.pdf", "application/pdf", infectedPDF()), alice, models.UploadOptions{})
+	require.Nil(t, appErr)
+	_, appErr = uploads.ReplaceContent(uploaded.ID, pdfFileHeader(t), alice)
+	require.Nil(t, appErr)
+
+	restarted := services.NewUploadService(cfg, storage.NewLocalStorage(dir), utils.NewLogger())
+	withScanner(t, restarted, address)
+	stop := restarted.StartScanning(1)
+	defer stop()
+
+	require.Eventually(t, func() bool {
+		versions, _ := restarted.ListVersions(uploaded.ID, alice)
+		return len(versions) == 2 &&
+			versions[0].ScanStatus == models.ScanStatusClean &&
+			versions[1].ScanStatus == models.ScanStatusInfected
+	}, 5*time.Second, 10*time.Millisecond)
+}
@@ -0,0 +1,136 @@
+package unit
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ebinskryfon/fileuploader/config"
+	"github.com/ebinskryfon/fileuploader/models"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func searchIDs(t *testing.T, response *models.SearchResponse) []string {
+	ids := make([]string, 0, len(response.Results))
+	for _, result := range response.Results {
+		ids = append(ids, result.File.ID)
+	}
+	return ids
+}
+
+func TestUploadService_Search(t *testing.T) {
+	uploads := newUploadService(t, func(cfg *config.Config) {
+		cfg.Upload.TrashRetention = time.Hour
+	})
+	alice := models.Principal{UserID: "alice"}
+	bob := models.Principal{UserID: "bob", Groups: []string{"finance"}}
+
+	upload := func(name string, options models.UploadOptions) string {
+		uploaded, appErr := uploads.UploadFile(uploadFileHeader(t, name, "application/pdf", testPDF), alice, options)
+		require.Nil(t, appErr)
+		return uploaded.ID
+	}
+	report := upload("quarterly-report.pdf", models.UploadOptions{})
+	tagged := upload("notes.pdf", models.UploadOptions{Tags: map[string]string{"kind": "report"}})
+	described := upload("misc.pdf", models.UploadOptions{Description: "Includes the annual report"})
+	upload("invoice.pdf", models.UploadOptions{})
+
+	// Name matches outrank tags, which outrank descriptions
+	response, appErr := uploads.Search(alice, models.SearchQuery{Query: "report"})
+	require.Nil(t, appErr)
+	assert.Equal(t, 3, response.Total)
+	assert.Equal(t, []string{report, tagged, described}, searchIDs(t, response))
+
+	// Prefixes match and every term must match
+	response, appErr = uploads.Search(alice, models.SearchQuery{Query: "quarter rep"})
+	require.Nil(t, appErr)
+	assert.Equal(t, []string{report}, searchIDs(t, response))
+
+	// Results are limited to files the caller can read
+	response, appErr = uploads.Search(bob, models.SearchQuery{Query: "report"})
+	require.Nil(t, appErr)
+	assert.Zero(t, response.Total)
+	_, appErr = uploads.GrantPermission(tagged, alice, models.GrantPermissionRequest{
+		Type: models.ACLTypeGroup, ID: "finance", Permission: models.PermissionRead,
+	})
+	require.Nil(t, appErr)
+	response, appErr = uploads.Search(bob, models.SearchQuery{Query: "report"})
+	require.Nil(t, appErr)
+	assert.Equal(t, []string{tagged}, searchIDs(t, response))
+
+	// The index follows renames and deletes
+	_, appErr = uploads.UpdateFile(report, alice, models.UpdateFileRequest{Name: stringPtr("summary.pdf")})
+	require.Nil(t, appErr)
+	require.Nil(t, uploads.DeleteFile(described, alice))
+	response, appErr = uploads.Search(alice, models.SearchQuery{Query: "report"})
+	require.Nil(t, appErr)
+	assert.Equal(t, []string{tagged}, searchIDs(t, response))
+
+	// Rebuilding from storage gives the same results
+	indexed, appErr := uploads.RebuildSearchIndex()
+	require.Nil(t, appErr)
+	assert.Equal(t, 3, indexed)
+	response, appErr = uploads.Search(alice, models.SearchQuery{Query: "summary"})
+	require.Nil(t, appErr)
+	assert.Equal(t, []string{report}, searchIDs(t, response))
+}
+
+func TestUploadService_SearchFilters(t *testing.T) {
+	uploads := newUploadService(t, nil)
+	alice := models.Principal{UserID: "alice"}
+
+	uploaded, appErr := uploads.UploadFile(pdfFileHeader(t), alice, models.UploadOptions{})
+	require.Nil(t, appErr)
+
+	_, appErr = uploads.Search(alice, models.SearchQuery{})
+	require.NotNil(t, appErr)
+	assert.Equal(t, http.StatusBadRequest, appErr.Code)
+
+	past := time.Now().Add(-time.Hour)
+	future := time.Now().Add(time.Hour)
+	tests := []struct {
+		name    string
+		query   models.SearchQuery
+		matches bool
+	}{
+		{"type family", models.SearchQuery{ContentType: "application/*"}, true},
+		{"other type", models.SearchQuery{ContentType: "image/*"}, false},
+		{"uploaded since", models.SearchQuery{From: &past}, true},
+		{"uploaded later", models.SearchQuery{From: &future}, false},
+		{"uploaded before", models.SearchQuery{To: &past}, false},
+		{"min size", models.SearchQuery{MinSize: uploaded.Size}, true},
+		{"max size", models.SearchQuery{MaxSize: uploaded.Size - 1}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			response, appErr := uploads.Search(alice, tt.query)
+			require.Nil(t, appErr)
+			assert.Equal(t, tt.matches, response.Total == 1)
+		})
+	}
+}
+
+func TestUploadService_SearchWordLimit(t *testing.T) {
+	uploads := newUploadService(t, nil)
+	alice := models.Principal{UserID: "alice"}
+
+	// Repeated words count once, but too many different ones are refused
+	var words []string
+	for i := range 33 {
+		words = append(words, fmt.Sprintf("w%d", i))
+	}
+	_, appErr := uploads.Search(alice, models.SearchQuery{Query: strings.Repeat("doc ", 100)})
+	require.Nil(t, appErr)
+	_, appErr = uploads.Search(alice, models.SearchQuery{Query: strings.Join(words[:32], " ")})
+	require.Nil(t, appErr)
+	_, appErr = uploads.Search(alice, models.SearchQuery{Query: strings.Join(words, " ")})
+	require.NotNil(t, appErr)
+	assert.Equal(t, http.StatusBadRequest, appErr.Code)
+	_, appErr = uploads.Search(alice, models.SearchQuery{Content: strings.Join(words, " ")})
+	require.NotNil(t, appErr)
+	assert.Equal(t, http.StatusBadRequest, appErr.Code)
+}
@@ -0,0 +1,138 @@
+package unit
+
+import (
+	"bytes"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"testing"
+	"time"
+
+	"github.com/ebinskryfon/fileuploader/config"
+	"github.com/ebinskryfon/fileuploader/models"
+	"github.com/ebinskryfon/fileuploader/services"
+	"github.com/ebinskryfon/fileuploader/storage"
+	"github.com/ebinskryfon/fileuploader/utils"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var testPDF = []byte("%PDF-1.4\n1 0 obj\n<<>>\nendobj\ntrailer\n<<>>\n%%EOF\n")
+
+// newUploadService builds an upload service over fresh local storage that
+// accepts PDFs of up to 1MB. configure, if set, adjusts the configuration
+// before the service is built.
+func newUploadService(t *testing.T, configure func(cfg *config.Config)) *services.UploadService {
+	cfg := &config.Config{}
+	cfg.Upload.MaxFileSize = 1 << 20
+	cfg.Upload.AllowedTypes = []string{"application/pdf"}
+	if configure != nil {
+		configure(cfg)
+	}
+	return services.NewUploadService(cfg, storage.NewLocalStorage(t.TempDir()), utils.NewLogger())
+}
+
+func pdfFileHeader(t *testing.T) *multipart.FileHeader {
+	return uploadFileHeader(t, "doc.pdf", "application/pdf", testPDF)
+}
+
+func uploadFileHeader(t *testing.T, name, contentType string, data []byte) *multipart.FileHeader {
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	header := textproto.MIMEHeader{}
+	header.Set("Content-Disposition", `form-data; name="file"; filename="`+name+`"`)
+	header.Set("Content-Type", contentType)
+	part, err := writer.CreatePart(header)
+	require.NoError(t, err)
+	_, err = part.Write(data)
+	require.NoError(t, err)
+	require.NoError(t, writer.Close())
+
+	form, err := multipart.NewReader(&body, writer.Boundary()).ReadForm(1 << 20)
+	require.NoError(t, err)
+	return form.File["file"][0]
+}
+
+func TestUploadService_TenantIsolation(t *testing.T) {
+	uploads := newUploadService(t, func(cfg *config.Config) {
+		cfg.Tenants = map[string]config.TenantConfig{"acme": {Retention: time.Hour}}
+	})
+	alice := models.Principal{UserID: "alice", TenantID: "acme"}
+
+	uploaded, appErr := uploads.UploadFile(pdfFileHeader(t), alice, models.UploadOptions{})
+	require.Nil(t, appErr)
+
+	// Same user ID and even a tenant admin elsewhere can't see the file
+	for _, principal := range []models.Principal{
+		{UserID: "alice", TenantID: "globex"},
+		{UserID: "root", TenantID: "globex", TenantAdmin: true},
+		{UserID: "alice"},
+	} {
+		_, _, appErr := uploads.GetFile(uploaded.ID, principal)
+		assert.Equal(t, models.ErrFileNotFound, appErr, principal)
+	}
+
+	// Nor can the default tenant reach into a namespace by path
+	_, _, appErr = uploads.GetFile("tenants/acme/"+uploaded.ID, models.Principal{UserID: "alice"})
+	assert.Equal(t, models.ErrFileNotFound, appErr)
+
+	admin := models.Principal{UserID: "root", TenantID: "acme", TenantAdmin: true}
+	file, _, appErr := uploads.GetFile(uploaded.ID, admin)
+	require.Nil(t, appErr)
+	file.Close()
+
+	// Tenant admins can read but not delete other users' files
+	assert.Equal(t, models.ErrPermissionDenied, uploads.DeleteFile(uploaded.ID, admin))
+
+	files, appErr := uploads.ListTenantFiles(admin)
+	require.Nil(t, appErr)
+	require.Len(t, files, 1)
+	assert.Equal(t, "acme", files[0].TenantID)
+
+	_, appErr = uploads.ListTenantFiles(alice)
+	assert.Equal(t, models.ErrForbidden, appErr)
+
+	_, appErr = uploads.UploadFile(pdfFileHeader(t), models.Principal{UserID: "alice", TenantID: "../acme"}, models.UploadOptions{})
+	require.NotNil(t, appErr)
+	assert.Equal(t, http.StatusForbidden, appErr.Code)
+}
+
+func TestUploadService_TenantOverrides(t *testing.T) {
+	uploads := newUploadService(t, func(cfg *config.Config) {
+		cfg.Tenants = map[string]config.TenantConfig{"tiny": {MaxFileSize: 10}}
+	})
+
+	_, appErr := uploads.UploadFile(pdfFileHeader(t), models.Principal{UserID: "alice", TenantID: "tiny"}, models.UploadOptions{})
+	assert.Equal(t, models.ErrFileTooLarge, appErr)
+
+	_, appErr = uploads.UploadFile(pdfFileHeader(t), models.Principal{UserID: "alice"}, models.UploadOptions{})
+	assert.Nil(t, appErr)
+}
+
+func TestUploadService_PurgeExpired(t *testing.T) {
+	uploads := newUploadService(t, func(cfg *config.Config) {
+		cfg.Tenants = map[string]config.TenantConfig{"acme": {Retention: time.Nanosecond}}
+	})
+
+	alice := models.Principal{UserID: "alice", TenantID: "acme"}
+	expiring, appErr := uploads.UploadFile(pdfFileHeader(t), alice, models.UploadOptions{})
+	require.Nil(t, appErr)
+	kept, appErr := uploads.UploadFile(pdfFileHeader(t), models.Principal{UserID: "alice"}, models.UploadOptions{})
+	require.Nil(t, appErr)
+
+	purged, err := uploads.PurgeExpired()
+	require.NoError(t, err)
+	assert.Equal(t, 1, purged)
+
+	_, _, appErr = uploads.GetFile(expiring.ID, alice)
+	assert.Equal(t, models.ErrFileNotFound, appErr)
+
+	usage, appErr := uploads.GetUsage(alice)
+	require.Nil(t, appErr)
+	assert.Equal(t, int64(0), usage.UsedBytes)
+
+	file, _, appErr := uploads.GetFile(kept.ID, models.Principal{UserID: "alice"})
+	require.Nil(t, appErr)
+	file.Close()
+}
@@ -0,0 +1,146 @@
+package unit
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/ebinskryfon/fileuploader/config"
+	"github.com/ebinskryfon/fileuploader/models"
+	"github.com/ebinskryfon/fileuploader/services"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// checkeredPNG alternates black and white pixels, so a properly averaged
+// thumbnail comes out a flat grey.
+func checkeredPNG(t *testing.T, width, height int) []byte {
+	img := image.NewGray(image.Rect(0, 0, width, height))
+	for x := 0; x < width; x++ {
+		for y := 0; y < height; y++ {
+			if (x+y)%2 == 0 {
+				img.SetGray(x, y, color.Gray{Y: 255})
+			}
+		}
+	}
+	var buf bytes.Buffer
+	require.NoError(t, png.Encode(&buf, img))
+	return buf.Bytes()
+}
+
+func readThumbnail(t *testing.T, uploads *services.UploadService, fileID string, size int, principal models.Principal) (image.Image, models.DerivedObject) {
+	file, thumbnail, appErr := uploads.GetThumbnail(fileID, size, principal)
+	require.Nil(t, appErr)
+	defer file.Close()
+	data, err := io.ReadAll(file)
+	require.NoError(t, err)
+	assert.Equal(t, thumbnail.Size, int64(len(data)))
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	require.NoError(t, err)
+	return img, thumbnail
+}
+
+func TestUploadService_GetThumbnail(t *testing.T) {
+	uploads := newUploadService(t, func(cfg *config.Config) {
+		cfg.Upload.AllowedTypes = []string{"image/png", "application/pdf"}
+		cfg.Images.ThumbnailSizes = []int{128, 16}
+	})
+	alice := models.Principal{UserID: "alice"}
+
+	uploaded, appErr := uploads.UploadFile(uploadFileHeader(t, "grid.png", "image/png", checkeredPNG(t, 64, 32)), alice, models.UploadOptions{})
+	require.Nil(t, appErr)
+
+	// Without a size the smallest is served, scaled to fit and averaged
+	img, thumbnail := readThumbnail(t, uploads, uploaded.ID, 0, alice)
+	assert.Equal(t, image.Rect(0, 0, 16, 8), img.Bounds())
+	assert.Equal(t, "image/png", thumbnail.ContentType)
+	assert.Equal(t, uploaded.Checksum, thumbnail.SourceChecksum)
+	r, g, b, _ := img.At(5, 5).RGBA()
+	assert.InDelta(t, 0x7fff, r, 0x200)
+	assert.Equal(t, r, g)
+	assert.Equal(t, r, b)
+
+	// Images are never enlarged
+	img, _ = readThumbnail(t, uploads, uploaded.ID, 128, alice)
+	assert.Equal(t, image.Rect(0, 0, 64, 32), img.Bounds())
+
+	// Generated thumbnails are linked to the file
+	metadata, appErr := uploads.GetFileMetadata(uploaded.ID, alice)
+	require.Nil(t, appErr)
+	require.Len(t, metadata.Derived, 2)
+	for _, derived := range metadata.Derived {
+		assert.Equal(t, models.DerivedKindThumbnail, derived.Kind)
+	}
+
+	_, _, appErr = uploads.GetThumbnail(uploaded.ID, 20, alice)
+	require.NotNil(t, appErr)
+	assert.Equal(t, http.StatusBadRequest, appErr.Code)
+
+	// Thumbnails follow the file's permissions
+	_, _, appErr = uploads.GetThumbnail(uploaded.ID, 0, models.Principal{UserID: "mallory"})
+	assert.Equal(t, models.ErrFileNotFound, appErr)
+
+	pdf, appErr := uploads.UploadFile(pdfFileHeader(t), alice, models.UploadOptions{})
+	require.Nil(t, appErr)
+	_, _, appErr = uploads.GetThumbnail(pdf.ID, 0, alice)
+	assert.Equal(t, models.ErrNoThumbnail, appErr)
+}
+
+func TestUploadService_ThumbnailsGeneratedInBackground(t *testing.T) {
+	uploads := newUploadService(t, func(cfg *config.Config) {
+		cfg.Upload.AllowedTypes = []string{"image/jpeg"}
+		cfg.Images.ThumbnailSizes = []int{128, 16}
+	})
+	stop := uploads.StartThumbnails(1)
+	defer stop()
+	alice := models.Principal{UserID: "alice"}
+
+	uploaded, appErr := uploads.UploadFile(uploadFileHeader(t, "photo.jpg", "image/jpeg", encodeJPEG(t)), alice, models.UploadOptions{})
+	require.Nil(t, appErr)
+
+	require.Eventually(t, func() bool {
+		metadata, appErr := uploads.GetFileMetadata(uploaded.ID, alice)
+		return appErr == nil && len(metadata.Derived) == 2
+	}, 5*time.Second, 10*time.Millisecond)
+
+	_, thumbnail := readThumbnail(t, uploads, uploaded.ID, 16, alice)
+	assert.Equal(t, "image/jpeg", thumbnail.ContentType)
+	assert.Equal(t, 8, thumbnail.Width)
+
+	// New content replaces the old thumbnails
+	var portrait bytes.Buffer
+	require.NoError(t, jpeg.Encode(&portrait, image.NewGray(image.Rect(0, 0, 32, 64)), nil))
+	replaced, appErr := uploads.ReplaceContent(uploaded.ID, uploadFileHeader(t, "photo.jpg", "image/jpeg", portrait.Bytes()), alice)
+	require.Nil(t, appErr)
+
+	require.Eventually(t, func() bool {
+		metadata, appErr := uploads.GetFileMetadata(uploaded.ID, alice)
+		return appErr == nil && len(metadata.Derived) == 2 && metadata.Derived[0].SourceChecksum == replaced.Checksum
+	}, 5*time.Second, 10*time.Millisecond)
+
+	img, _ := readThumbnail(t, uploads, uploaded.ID, 16, alice)
+	assert.Equal(t, image.Rect(0, 0, 8, 16), img.Bounds())
+}
+
+func TestUploadService_ThumbnailRejectsDecompressionBomb(t *testing.T) {
+	uploads := newUploadService(t, func(cfg *config.Config) {
+		cfg.Upload.AllowedTypes = []string{"image/png"}
+		cfg.Images.ThumbnailSizes = []int{16}
+		cfg.Images.MaxPixels = 1000
+	})
+	alice := models.Principal{UserID: "alice"}
+
+	uploaded, appErr := uploads.UploadFile(uploadFileHeader(t, "big.png", "image/png", checkeredPNG(t, 64, 32)), alice, models.UploadOptions{})
+	require.Nil(t, appErr)
+
+	_, _, appErr = uploads.GetThumbnail(uploaded.ID, 0, alice)
+	assert.Equal(t, models.ErrImageTooLarge, appErr)
+}
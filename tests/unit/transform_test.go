@@ -0,0 +1,199 @@
+package unit
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/ebinskryfon/fileuploader/config"
+	"github.com/ebinskryfon/fileuploader/models"
+	"github.com/ebinskryfon/fileuploader/services"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	red  = color.NRGBA{R: 255, A: 255}
+	grey = color.NRGBA{R: 128, G: 128, B: 128, A: 255}
+	blue = color.NRGBA{B: 255, A: 255}
+)
+
+// bandedPNG is 64x32: a red band, a grey middle and a blue band, with the
+// top left corner transparent.
+func bandedPNG(t *testing.T) []byte {
+	img := image.NewNRGBA(image.Rect(0, 0, 64, 32))
+	for x := 0; x < 64; x++ {
+		for y := 0; y < 32; y++ {
+			switch {
+			case x < 16:
+				img.SetNRGBA(x, y, red)
+			case x < 48:
+				img.SetNRGBA(x, y, grey)
+			default:
+				img.SetNRGBA(x, y, blue)
+			}
+		}
+	}
+	draw.Draw(img, image.Rect(0, 0, 8, 8), image.Transparent, image.Point{}, draw.Src)
+	var buf bytes.Buffer
+	require.NoError(t, png.Encode(&buf, img))
+	return buf.Bytes()
+}
+
+func transformImage(t *testing.T, uploads *services.UploadService, fileID string, transform models.ImageTransform) (image.Image, string, []byte) {
+	file, variant, appErr := uploads.TransformImage(fileID, transform, models.Principal{UserID: "alice"})
+	require.Nil(t, appErr)
+	defer file.Close()
+	data, err := io.ReadAll(file)
+	require.NoError(t, err)
+
+	img, format, err := image.Decode(bytes.NewReader(data))
+	require.NoError(t, err)
+	assert.Equal(t, "image/"+format, variant.ContentType)
+	assert.Equal(t, image.Rect(0, 0, variant.Width, variant.Height), img.Bounds())
+	return img, format, data
+}
+
+func TestUploadService_TransformImage(t *testing.T) {
+	uploads := newUploadService(t, func(cfg *config.Config) {
+		cfg.Upload.AllowedTypes = []string{"image/png", "application/pdf"}
+		cfg.Images.MaxDimension = 48
+	})
+	alice := models.Principal{UserID: "alice"}
+
+	uploaded, appErr := uploads.UploadFile(uploadFileHeader(t, "bands.png", "image/png", bandedPNG(t)), alice, models.UploadOptions{})
+	require.Nil(t, appErr)
+
+	// Contain keeps the aspect ratio within the box
+	img, format, _ := transformImage(t, uploads, uploaded.ID, models.ImageTransform{Width: 16, Height: 16})
+	assert.Equal(t, "png", format)
+	assert.Equal(t, image.Rect(0, 0, 16, 8), img.Bounds())
+
+	// Cover crops the sides, leaving only the grey middle
+	img, _, _ = transformImage(t, uploads, uploaded.ID, models.ImageTransform{Width: 16, Height: 16, Fit: models.FitCover})
+	assert.Equal(t, image.Rect(0, 0, 16, 16), img.Bounds())
+	for _, x := range []int{0, 8, 15} {
+		assert.Equal(t, grey, color.NRGBAModel.Convert(img.At(x, 8)))
+	}
+
+	img, _, _ = transformImage(t, uploads, uploaded.ID, models.ImageTransform{Width: 16, Height: 16, Fit: models.FitFill})
+	assert.Equal(t, image.Rect(0, 0, 16, 16), img.Bounds())
+
+	img, _, _ = transformImage(t, uploads, uploaded.ID, models.ImageTransform{Width: 32})
+	assert.Equal(t, image.Rect(0, 0, 32, 16), img.Bounds())
+
+	// Output never exceeds the dimension cap, even without a size
+	img, _, _ = transformImage(t, uploads, uploaded.ID, models.ImageTransform{Format: "png"})
+	assert.Equal(t, image.Rect(0, 0, 48, 24), img.Bounds())
+
+	// Converting to JPEG puts transparency on white, and quality shows in the size
+	img, format, large := transformImage(t, uploads, uploaded.ID, models.ImageTransform{Width: 32, Format: "jpeg", Quality: 100})
+	assert.Equal(t, "jpeg", format)
+	r, g, b, _ := img.At(1, 1).RGBA()
+	assert.Greater(t, r, uint32(0xe000))
+	assert.Greater(t, g, uint32(0xe000))
+	assert.Greater(t, b, uint32(0xe000))
+	_, _, small := transformImage(t, uploads, uploaded.ID, models.ImageTransform{Width: 32, Format: "jpeg", Quality: 10})
+	assert.Less(t, len(small), len(large))
+
+	for _, transform := range []models.ImageTransform{
+		{Width: 49},
+		{Width: 16, Fit: "squash"},
+		{Format: "gif"},
+		{Quality: 101},
+	} {
+		_, _, appErr = uploads.TransformImage(uploaded.ID, transform, alice)
+		require.NotNil(t, appErr, "%+v", transform)
+		assert.Equal(t, http.StatusBadRequest, appErr.Code)
+	}
+
+	_, _, appErr = uploads.TransformImage(uploaded.ID, models.ImageTransform{Width: 16}, models.Principal{UserID: "mallory"})
+	assert.Equal(t, models.ErrFileNotFound, appErr)
+
+	pdf, appErr := uploads.UploadFile(pdfFileHeader(t), alice, models.UploadOptions{})
+	require.Nil(t, appErr)
+	_, _, appErr = uploads.TransformImage(pdf.ID, models.ImageTransform{Width: 16}, alice)
+	assert.Equal(t, models.ErrNotAnImage, appErr)
+}
+
+func TestUploadService_TransformImageIsCached(t *testing.T) {
+	dir := t.TempDir()
+	cache, err := services.NewTransformCache(dir, 1<<20)
+	require.NoError(t, err)
+	uploads := newUploadService(t, func(cfg *config.Config) {
+		cfg.Upload.AllowedTypes = []string{"image/png"}
+	}).WithTransformCache(cache)
+	alice := models.Principal{UserID: "alice"}
+
+	uploaded, appErr := uploads.UploadFile(uploadFileHeader(t, "bands.png", "image/png", bandedPNG(t)), alice, models.UploadOptions{})
+	require.Nil(t, appErr)
+
+	transform := models.ImageTransform{Width: 16, Height: 16, Fit: models.FitCover, Format: "jpeg", Quality: 80}
+	_, _, first := transformImage(t, uploads, uploaded.ID, transform)
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	assert.Equal(t, int64(len(first)), cache.Size())
+
+	// The cached variant is served as is
+	require.NoError(t, os.WriteFile(dir+"/"+entries[0].Name(), append(first, "cached"...), 0644))
+	_, _, second := transformImage(t, uploads, uploaded.ID, transform)
+	assert.True(t, strings.HasSuffix(string(second), "cached"))
+
+	// Decompression bombs are refused before decoding
+	bombs := newUploadService(t, func(cfg *config.Config) {
+		cfg.Upload.AllowedTypes = []string{"image/png"}
+		cfg.Images.MaxPixels = 1000
+	})
+	uploaded, appErr = bombs.UploadFile(uploadFileHeader(t, "bands.png", "image/png", bandedPNG(t)), alice, models.UploadOptions{})
+	require.Nil(t, appErr)
+	_, _, appErr = bombs.TransformImage(uploaded.ID, models.ImageTransform{Width: 16}, alice)
+	assert.Equal(t, models.ErrImageTooLarge, appErr)
+}
+
+func TestTransformCache_EvictsLeastRecentlyUsed(t *testing.T) {
+	dir := t.TempDir()
+	cache, err := services.NewTransformCache(dir, 12)
+	require.NoError(t, err)
+
+	key := func(c string) string { return strings.Repeat(c, 64) }
+	require.NoError(t, cache.Put(key("a"), []byte("aaaa")))
+	require.NoError(t, cache.Put(key("b"), []byte("bbbb")))
+	require.NoError(t, cache.Put(key("c"), []byte("cccc")))
+
+	_, ok := cache.Get(key("a"))
+	require.True(t, ok)
+	require.NoError(t, cache.Put(key("d"), []byte("dddd")))
+
+	_, ok = cache.Get(key("b"))
+	assert.False(t, ok)
+	for _, c := range []string{"a", "c", "d"} {
+		data, ok := cache.Get(key(c))
+		assert.True(t, ok)
+		assert.Equal(t, strings.Repeat(c, 4), string(data))
+	}
+	assert.Equal(t, int64(12), cache.Size())
+
+	// Variants bigger than the cache are not kept
+	require.NoError(t, cache.Put(key("e"), []byte("eeeeeeeeeeeee")))
+	_, ok = cache.Get(key("e"))
+	assert.False(t, ok)
+
+	// Keys must be hex digests, so they can't name other files
+	assert.Error(t, cache.Put("../escape", []byte("x")))
+
+	// A restart picks up what is on disk
+	reopened, err := services.NewTransformCache(dir, 12)
+	require.NoError(t, err)
+	assert.Equal(t, int64(12), reopened.Size())
+	_, ok = reopened.Get(key("d"))
+	assert.True(t, ok)
+}
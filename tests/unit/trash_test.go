@@ -0,0 +1,139 @@
+package unit
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ebinskryfon/fileuploader/config"
+	"github.com/ebinskryfon/fileuploader/models"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUploadService_Trash(t *testing.T) {
+	uploads := newUploadService(t, func(cfg *config.Config) {
+		cfg.Upload.TrashRetention = time.Hour
+	})
+	alice := models.Principal{UserID: "alice"}
+	bob := models.Principal{UserID: "bob"}
+
+	uploaded, appErr := uploads.UploadFile(pdfFileHeader(t), alice, models.UploadOptions{Path: "reports"})
+	require.Nil(t, appErr)
+	_, appErr = uploads.GrantPermission(uploaded.ID, alice, models.GrantPermissionRequest{
+		Type: models.ACLTypeUser, ID: "bob", Permission: models.PermissionReadWrite,
+	})
+	require.Nil(t, appErr)
+
+	// A collaborator's delete lands in the owner's trash
+	require.Nil(t, uploads.DeleteFile(uploaded.ID, bob))
+
+	_, _, appErr = uploads.GetFile(uploaded.ID, alice)
+	assert.Equal(t, models.ErrFileNotFound, appErr)
+	files, appErr := uploads.ListFiles(alice)
+	require.Nil(t, appErr)
+	assert.Empty(t, files)
+	_, _, appErr = uploads.ResolvePath(alice, "reports/doc.pdf")
+	assert.Equal(t, models.ErrFileNotFound, appErr)
+
+	trash, appErr := uploads.ListTrash(alice)
+	require.Nil(t, appErr)
+	require.Len(t, trash, 1)
+	assert.Equal(t, "bob", trash[0].DeletedBy)
+	require.NotNil(t, trash[0].PurgeAt)
+	assert.WithinDuration(t, time.Now().Add(time.Hour), *trash[0].PurgeAt, time.Minute)
+
+	trash, appErr = uploads.ListTrash(bob)
+	require.Nil(t, appErr)
+	assert.Empty(t, trash)
+	assert.Equal(t, models.ErrFileNotFound, uploads.PurgeFromTrash(uploaded.ID, bob))
+
+	// Trashed files still count against quota
+	usage, appErr := uploads.GetUsage(alice)
+	require.Nil(t, appErr)
+	assert.Equal(t, 1, usage.FileCount)
+
+	restored, appErr := uploads.RestoreFromTrash(uploaded.ID, alice)
+	require.Nil(t, appErr)
+	assert.Nil(t, restored.DeletedAt)
+	assert.Equal(t, uploaded.FolderID, restored.FolderID)
+
+	_, fileID, appErr := uploads.ResolvePath(alice, "reports/doc.pdf")
+	require.Nil(t, appErr)
+	assert.Equal(t, uploaded.ID, fileID)
+
+	// Deleting the folder trashes its files; restoring then goes to the root
+	require.Nil(t, uploads.DeleteFolder(uploaded.FolderID, alice))
+	restored, appErr = uploads.RestoreFromTrash(uploaded.ID, alice)
+	require.Nil(t, appErr)
+	assert.Empty(t, restored.FolderID)
+
+	require.Nil(t, uploads.DeleteFile(uploaded.ID, alice))
+	require.Nil(t, uploads.PurgeFromTrash(uploaded.ID, alice))
+	usage, appErr = uploads.GetUsage(alice)
+	require.Nil(t, appErr)
+	assert.Zero(t, usage.FileCount)
+}
+
+func TestUploadService_TrashExpires(t *testing.T) {
+	uploads := newUploadService(t, func(cfg *config.Config) {
+		cfg.Upload.TrashRetention = time.Nanosecond
+	})
+	alice := models.Principal{UserID: "alice"}
+
+	uploaded, appErr := uploads.UploadFile(pdfFileHeader(t), alice, models.UploadOptions{})
+	require.Nil(t, appErr)
+	kept, appErr := uploads.UploadFile(pdfFileHeader(t), alice, models.UploadOptions{})
+	require.Nil(t, appErr)
+	require.Nil(t, uploads.DeleteFile(uploaded.ID, alice))
+
+	purged, err := uploads.PurgeExpired()
+	require.NoError(t, err)
+	assert.Equal(t, 1, purged)
+
+	trash, appErr := uploads.ListTrash(alice)
+	require.Nil(t, appErr)
+	assert.Empty(t, trash)
+
+	file, _, appErr := uploads.GetFile(kept.ID, alice)
+	require.Nil(t, appErr)
+	file.Close()
+}
+
+func TestUploadService_TrashDuringUpdate(t *testing.T) {
+	uploads := newUploadService(t, func(cfg *config.Config) {
+		cfg.Upload.TrashRetention = time.Hour
+	})
+	alice := models.Principal{UserID: "alice"}
+	description := "quarterly figures"
+
+	// Neither a deletion nor a concurrent metadata change may write back
+	// what the other has replaced
+	for range 50 {
+		uploaded, appErr := uploads.UploadFile(pdfFileHeader(t), alice, models.UploadOptions{})
+		require.Nil(t, appErr)
+
+		var wg sync.WaitGroup
+		var updateErr *models.AppError
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			assert.Nil(t, uploads.DeleteFile(uploaded.ID, alice))
+		}()
+		go func() {
+			defer wg.Done()
+			// Fails once the file is in the trash
+			_, updateErr = uploads.UpdateFile(uploaded.ID, alice, models.UpdateFileRequest{Description: &description})
+		}()
+		wg.Wait()
+
+		trash, appErr := uploads.ListTrash(alice)
+		require.Nil(t, appErr)
+		require.NotEmpty(t, trash)
+		assert.Equal(t, uploaded.ID, trash[0].ID)
+		if updateErr == nil {
+			assert.Equal(t, description, trash[0].Description)
+		}
+	}
+}
@@ -0,0 +1,159 @@
+package unit
+
+import (
+	"archive/zip"
+	"bytes"
+	"testing"
+
+	"github.com/ebinskryfon/fileuploader/config"
+	"github.com/ebinskryfon/fileuploader/models"
+	"github.com/ebinskryfon/fileuploader/services"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const docxType = "application/vnd.openxmlformats-officedocument.wordprocessingml.document"
+
+var testPNG = []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR\x00\x00\x00\x01\x00\x00\x00\x01\x08\x06\x00\x00\x00")
+
+func newValidationService(aliases map[string]string) *services.ValidationService {
+	cfg := &config.Config{}
+	cfg.Upload.MaxFileSize = 1 << 20
+	cfg.Upload.AllowedTypes = []string{"image/jpeg", "image/png", "application/pdf", "text/plain", "text/csv", docxType}
+	cfg.Upload.ContentTypeAliases = aliases
+	return services.NewValidationService(cfg)
+}
+
+func plainZip(t *testing.T) []byte {
+	var buf bytes.Buffer
+	archive := zip.NewWriter(&buf)
+	w, err := archive.Create("notes.txt")
+	require.NoError(t, err)
+	_, err = w.Write([]byte("hello"))
+	require.NoError(t, err)
+	require.NoError(t, archive.Close())
+	return buf.Bytes()
+}
+
+func TestContentSniffer_Sniff(t *testing.T) {
+	sniffer := services.NewContentSniffer(nil)
+
+	tests := []struct {
+		name        string
+		data        []byte
+		contentType string
+		charset     string
+	}{
+		{"jpeg", []byte{0xFF, 0xD8, 0xFF, 0xE0, 0x00, 0x10, 'J', 'F', 'I', 'F'}, "image/jpeg", ""},
+		{"png", testPNG, "image/png", ""},
+		{"gif", []byte("GIF89a\x01\x00\x01\x00"), "image/gif", ""},
+		{"webp", []byte("RIFF\x24\x00\x00\x00WEBPVP8 "), "image/webp", ""},
+		{"pdf", testPDF, "application/pdf", ""},
+		{"docx", textDOCX(t, "Hello"), docxType, ""},
+		{"zip", plainZip(t), "application/zip", ""},
+		{"ascii", []byte("name,total\nalice,3\n"), "text/plain", "us-ascii"},
+		{"utf-8", []byte("Grüße aus Köln\n"), "text/plain", "utf-8"},
+		{"utf-16", []byte("\xFF\xFEh\x00i\x00"), "text/plain", "utf-16le"},
+		{"latin-1", []byte("Gr\xFC\xDFe\n"), "text/plain", "iso-8859-1"},
+		{"binary", []byte{0x00, 0x01, 0x02, 0x03, 0x04}, "application/octet-stream", ""},
+		{"bmp", []byte("BM\x46\x00\x00\x00\x00\x00\x00\x00\x36\x00\x00\x00\x28\x00\x00\x00\x01\x00"), "image/bmp", ""},
+		{"gzip", []byte{0x1F, 0x8B, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x03}, "application/gzip", ""},
+		{"exe", []byte("MZ\x90\x00\x03\x00\x00\x00\x04\x00"), "application/x-msdownload", ""},
+		// Short signatures that ordinary text can begin with
+		{"text starting BM", []byte("BMW quarterly figures\n"), "text/plain", "us-ascii"},
+		{"text starting MZ", []byte("MZ-2024 report\n"), "text/plain", "us-ascii"},
+		{"bmp without info header", []byte("BM\x46\x00\x00\x00\x00\x00\x00\x00\x36\x00\x00\x00\x07\x00\x00\x00"), "application/octet-stream", ""},
+		{"gzip without deflate", []byte{0x1F, 0x8B, 0x00, 0x00}, "application/octet-stream", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := sniffer.Sniff(bytes.NewReader(tt.data), int64(len(tt.data)))
+			require.NoError(t, err)
+			assert.Equal(t, tt.contentType, result.ContentType)
+			assert.Equal(t, tt.charset, result.Charset)
+		})
+	}
+}
+
+func TestContentSniffer_TruncatedUTF8(t *testing.T) {
+	// A multi-byte character straddling the sniffed prefix is still UTF-8
+	data := append([]byte("é"), bytes.Repeat([]byte("a"), 8189)...)
+	data = append(data, []byte("é and more")...)
+	result, err := services.NewContentSniffer(nil).Sniff(bytes.NewReader(data), int64(len(data)))
+	require.NoError(t, err)
+	assert.Equal(t, "text/plain", result.ContentType)
+	assert.Equal(t, "utf-8", result.Charset)
+}
+
+func TestContentSniffer_Canonical(t *testing.T) {
+	sniffer := services.NewContentSniffer(map[string]string{"application/x-csv": "text/csv"})
+
+	assert.Equal(t, "image/jpeg", sniffer.Canonical("image/jpg"))
+	assert.Equal(t, "text/plain", sniffer.Canonical("text/plain; charset=UTF-8"))
+	assert.Equal(t, "text/csv", sniffer.Canonical("Application/X-CSV"))
+	assert.Equal(t, "application/pdf", sniffer.Canonical("application/pdf"))
+}
+
+func TestValidationService_ValidateFileContent(t *testing.T) {
+	validation := newValidationService(map[string]string{"application/x-csv": "text/csv"})
+
+	tests := []struct {
+		name        string
+		contentType string
+		data        []byte
+		reason      string
+	}{
+		{"matching type", "application/pdf", testPDF, ""},
+		{"alias", "image/jpg", []byte{0xFF, 0xD8, 0xFF, 0xE0}, ""},
+		{"docx", docxType, textDOCX(t, "Hello"), ""},
+		{"text with charset", "text/plain; charset=utf-8", []byte("Grüße\n"), ""},
+		{"ascii as utf-8", "text/plain; charset=utf-8", []byte("hello\n"), ""},
+		{"configured alias for text", "application/x-csv", []byte("a,b\n1,2\n"), ""},
+		{"mismatch", "application/pdf", testPNG, models.ReasonContentMismatch},
+		{"text declared for image", "text/plain", testPNG, models.ReasonContentMismatch},
+		{"binary", "image/png", []byte{0x00, 0x01, 0x02, 0x03}, models.ReasonUnrecognizedContent},
+		{"zip declared as docx", docxType, plainZip(t), models.ReasonInvalidContainer},
+		{"wrong charset", "text/plain; charset=utf-8", []byte("Gr\xFC\xDFe\n"), models.ReasonCharsetMismatch},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			file, err := uploadFileHeader(t, "upload", tt.contentType, tt.data).Open()
+			require.NoError(t, err)
+			defer file.Close()
+
+			appErr := validation.ValidateFileContent(file, tt.contentType)
+			if tt.reason == "" {
+				assert.Nil(t, appErr)
+				return
+			}
+			require.NotNil(t, appErr)
+			assert.Equal(t, 400, appErr.Code)
+			assert.Equal(t, tt.reason, appErr.Reason)
+			assert.NotEmpty(t, appErr.Details["detected"])
+		})
+	}
+}
+
+func TestValidationService_ValidateFileContentRewinds(t *testing.T) {
+	file, err := pdfFileHeader(t).Open()
+	require.NoError(t, err)
+	defer file.Close()
+
+	require.Nil(t, newValidationService(nil).ValidateFileContent(file, "application/pdf"))
+
+	data := make([]byte, len(testPDF))
+	_, err = file.Read(data)
+	require.NoError(t, err)
+	assert.Equal(t, testPDF, data)
+}
+
+func TestValidationService_ValidateFileCanonicalizesType(t *testing.T) {
+	validation := newValidationService(nil)
+
+	assert.Nil(t, validation.ValidateFile(uploadFileHeader(t, "photo.jpg", "image/pjpeg", []byte{0xFF, 0xD8, 0xFF})))
+	assert.Nil(t, validation.ValidateFile(uploadFileHeader(t, "notes.txt", "text/plain; charset=utf-8", []byte("hi"))))
+	assert.Equal(t, models.ErrInvalidFileType, validation.ValidateFile(uploadFileHeader(t, "page.html", "text/html", []byte("<p>"))))
+}
@@ -0,0 +1,123 @@
+package unit
+
+import (
+	"io"
+	"testing"
+	"time"
+
+	"github.com/ebinskryfon/fileuploader/models"
+	"github.com/ebinskryfon/fileuploader/services"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func pdfRevision(revision string) []byte {
+	return append([]byte("%PDF-1.4\n% "+revision+"\n"), testPDF[len("%PDF-1.4\n"):]...)
+}
+
+func readVersion(t *testing.T, uploads *services.UploadService, fileID string, version int, principal models.Principal) []byte {
+	file, _, appErr := uploads.GetVersion(fileID, version, principal)
+	require.Nil(t, appErr)
+	defer file.Close()
+	data, err := io.ReadAll(file)
+	require.NoError(t, err)
+	return data
+}
+
+func TestUploadService_Versions(t *testing.T) {
+	uploads := newUploadService(t, nil)
+	alice := models.Principal{UserID: "alice"}
+	bob := models.Principal{UserID: "bob"}
+
+	v1 := pdfRevision("v1")
+	uploaded, appErr := uploads.UploadFile(uploadFileHeader(t, "contract.pdf", "application/pdf", v1), alice, models.UploadOptions{})
+	require.Nil(t, appErr)
+	assert.Equal(t, 1, uploaded.Version)
+
+	_, appErr = uploads.GrantPermission(uploaded.ID, alice, models.GrantPermissionRequest{
+		Type: models.ACLTypeUser, ID: "bob", Permission: models.PermissionReadWrite,
+	})
+	require.Nil(t, appErr)
+
+	// A collaborator uploads a correction under the same ID
+	v2 := pdfRevision("v2-corrected")
+	updated, appErr := uploads.ReplaceContent(uploaded.ID, uploadFileHeader(t, "contract.pdf", "application/pdf", v2), bob)
+	require.Nil(t, appErr)
+	assert.Equal(t, uploaded.ID, updated.ID)
+	assert.Equal(t, 2, updated.Version)
+	assert.NotEqual(t, uploaded.Checksum, updated.Checksum)
+
+	versions, appErr := uploads.ListVersions(uploaded.ID, alice)
+	require.Nil(t, appErr)
+	require.Len(t, versions, 2)
+	assert.Equal(t, 2, versions[0].Version)
+	assert.Equal(t, "bob", versions[0].UploadedBy)
+	assert.Equal(t, 1, versions[1].Version)
+	assert.Equal(t, "alice", versions[1].UploadedBy)
+	assert.Equal(t, uploaded.Checksum, versions[1].Checksum)
+
+	assert.Equal(t, v1, readVersion(t, uploads, uploaded.ID, 1, alice))
+	assert.Equal(t, v2, readVersion(t, uploads, uploaded.ID, 2, alice))
+	_, _, appErr = uploads.GetVersion(uploaded.ID, 7, alice)
+	assert.Equal(t, models.ErrVersionNotFound, appErr)
+
+	// Every version counts against the owner's quota
+	usage, appErr := uploads.GetUsage(alice)
+	require.Nil(t, appErr)
+	assert.Equal(t, int64(len(v1)+len(v2)), usage.UsedBytes)
+	assert.Equal(t, 1, usage.FileCount)
+
+	restored, appErr := uploads.RestoreVersion(uploaded.ID, 1, alice)
+	require.Nil(t, appErr)
+	assert.Equal(t, 3, restored.Version)
+	assert.Equal(t, uploaded.Checksum, restored.Checksum)
+
+	file, _, appErr := uploads.GetFile(uploaded.ID, alice)
+	require.Nil(t, appErr)
+	current, err := io.ReadAll(file)
+	file.Close()
+	require.NoError(t, err)
+	assert.Equal(t, v1, current)
+
+	// Keep only the newest earlier version
+	versions, appErr = uploads.PruneVersions(uploaded.ID, alice, 1, 0)
+	require.Nil(t, appErr)
+	require.Len(t, versions, 2)
+	assert.Equal(t, []int{3, 2}, []int{versions[0].Version, versions[1].Version})
+	_, _, appErr = uploads.GetVersion(uploaded.ID, 1, alice)
+	assert.Equal(t, models.ErrVersionNotFound, appErr)
+
+	usage, appErr = uploads.GetUsage(alice)
+	require.Nil(t, appErr)
+	assert.Equal(t, int64(len(v1)+len(v2)), usage.UsedBytes)
+
+	versions, appErr = uploads.PruneVersions(uploaded.ID, alice, -1, time.Nanosecond)
+	require.Nil(t, appErr)
+	require.Len(t, versions, 1)
+
+	require.Nil(t, uploads.DeleteFile(uploaded.ID, alice))
+	usage, appErr = uploads.GetUsage(alice)
+	require.Nil(t, appErr)
+	assert.Zero(t, usage.UsedBytes)
+}
+
+func TestUploadService_ReplaceContentRequiresWrite(t *testing.T) {
+	uploads := newUploadService(t, nil)
+	alice := models.Principal{UserID: "alice"}
+
+	uploaded, appErr := uploads.UploadFile(pdfFileHeader(t), alice, models.UploadOptions{})
+	require.Nil(t, appErr)
+	_, appErr = uploads.GrantPermission(uploaded.ID, alice, models.GrantPermissionRequest{
+		Type: models.ACLTypeUser, ID: "bob", Permission: models.PermissionRead,
+	})
+	require.Nil(t, appErr)
+
+	_, appErr = uploads.ReplaceContent(uploaded.ID, pdfFileHeader(t), models.Principal{UserID: "bob"})
+	assert.Equal(t, models.ErrPermissionDenied, appErr)
+	_, appErr = uploads.ReplaceContent(uploaded.ID, pdfFileHeader(t), models.Principal{UserID: "carol"})
+	assert.Equal(t, models.ErrFileNotFound, appErr)
+
+	_, appErr = uploads.ReplaceContent(uploaded.ID, uploadFileHeader(t, "x.png", "image/png", testPDF), alice)
+	assert.Equal(t, models.ErrInvalidFileType, appErr)
+}